@@ -5,7 +5,9 @@ package ao
 import (
 	"context"
 	"io"
+	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/pkg/errors"
@@ -69,6 +71,114 @@ func SetLogOutput(w io.Writer) {
 	log.SetOutput(w)
 }
 
+// PayloadStats is the number of bytes of the messages sent to the AppOptics
+// collector since the start, by the message types. The sizes are of the
+// serialized messages before compression.
+type PayloadStats struct {
+	EventBytes  int64
+	MetricBytes int64
+	StatusBytes int64
+}
+
+// ReporterStats returns the bytes sent to the collector since the start,
+// which can be used to budget the network usage of a service. The per-interval
+// values are reported as the EventBytesSent, MetricBytesSent and
+// StatusBytesSent metrics too.
+func ReporterStats() PayloadStats {
+	s := reporter.GetStats()
+	return PayloadStats{
+		EventBytes:  s.EventBytes,
+		MetricBytes: s.MetricBytes,
+		StatusBytes: s.StatusBytes,
+	}
+}
+
+// AgentStats is the state of the agent internals: the depth of the event
+// queue, the events sent and dropped, the reconnections to the collector and
+// the age of the settings.
+type AgentStats = reporter.AgentStats
+
+// GetAgentStats returns the state of the agent internals, so the health of the
+// agent can be monitored, see the aoexpvar and aoprometheus packages. The
+// counters are since the start and only maintained by the SSL reporter.
+func GetAgentStats() AgentStats {
+	return reporter.GetAgentStats()
+}
+
+// RegisterInitMessageCallback registers a callback to provide the metadata of
+// the application, e.g., the deployment ID or feature flags, as extra KVs of
+// the init message, which identifies the agent to the AppOptics backend. The
+// KVs prefixed with "__" or "Go." are reserved and skipped.
+//
+// The init message is sent again on each registration, so it's better to
+// provide all the KVs with a single callback at startup.
+func RegisterInitMessageCallback(cb func() map[string]interface{}) {
+	reporter.AddInitMessageCallback(cb)
+}
+
+// Settings is the sampling settings of the agent, which are received from the
+// collector and merged with the local configuration.
+type Settings struct {
+	// Layer is the span name the settings apply to, empty for the default ones
+	Layer string
+	// SampleRate is the sample rate out of 1,000,000
+	SampleRate int
+	// Flags is the comma-separated flags received from the collector, e.g.,
+	// "SAMPLE_START,SAMPLE_THROUGH_ALWAYS,TRIGGER_TRACE"
+	Flags string
+	// Tracing is if new traces can be started
+	Tracing bool
+	// TriggerTrace is if the trigger traces are enabled
+	TriggerTrace bool
+	// TTL is how long the settings are valid without being refreshed
+	TTL time.Duration
+
+	// The capacities and the rates per second of the token buckets limiting
+	// the traces started by the sampling and by the trigger traces
+	BucketCapacity                    float64
+	BucketRate                        float64
+	TriggerTraceRelaxedBucketCapacity float64
+	TriggerTraceRelaxedBucketRate     float64
+	TriggerTraceStrictBucketCapacity  float64
+	TriggerTraceStrictBucketRate      float64
+}
+
+// OnSettingsChange registers a callback which is called whenever the settings
+// received from the collector are different from the previous ones, e.g., to
+// log the changes of the sample rate or to mirror the settings into another
+// system. It's called with the current settings upon registration, if the
+// agent has received any.
+//
+// The callback is called synchronously by the agent when the settings are
+// updated, so it should return quickly.
+func OnSettingsChange(fn func(Settings)) {
+	if fn == nil {
+		return
+	}
+	reporter.OnSettingsChange(func(s reporter.Settings) {
+		fn(Settings(s))
+	})
+}
+
+// SetTracingMode changes the tracing mode at runtime, which is the
+// programmatic equivalent of APPOPTICS_TRACING_MODE. The mode is one of
+// "enabled", "disabled", "dry-run", in which the requests are traced as if
+// it's enabled but nothing is sent except the settings requests, and the
+// messages which would be sent are logged, or "metrics-only", in which the
+// requests are never traced but their metrics are still sent.
+//
+// Switching between enabled, dry-run and metrics-only takes effect
+// immediately, while the other changes take effect when the settings are
+// refreshed from the collector.
+func SetTracingMode(mode string) error {
+	return config.SetTracingMode(config.TracingMode(mode))
+}
+
+// GetTracingMode returns the current tracing mode.
+func GetTracingMode() string {
+	return string(config.GetTracingMode())
+}
+
 // SetServiceKey sets the service key of the agent
 func SetServiceKey(key string) {
 	reporter.SetServiceKey(key)
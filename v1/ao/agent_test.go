@@ -9,7 +9,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +32,19 @@ func TestSetGetLogLevel(t *testing.T) {
 	SetLogLevel(oldLevel)
 }
 
+func TestSetTracingMode(t *testing.T) {
+	defer config.Load()
+
+	assert.NoError(t, SetTracingMode("dry-run"))
+	assert.Equal(t, "dry-run", GetTracingMode())
+	assert.NoError(t, SetTracingMode("metrics-only"))
+	assert.Equal(t, "metrics-only", GetTracingMode())
+	assert.NoError(t, SetTracingMode("always"))
+	assert.Equal(t, "enabled", GetTracingMode())
+	assert.Error(t, SetTracingMode("INVALID"))
+	assert.Equal(t, "enabled", GetTracingMode())
+}
+
 func TestShutdown(t *testing.T) {
 	Shutdown(context.Background())
 	assert.True(t, Closed())
@@ -52,3 +67,33 @@ func TestSetLogOutput(t *testing.T) {
 	log.Info("hello world")
 	assert.True(t, strings.Contains(buf.String(), "hello world"))
 }
+
+func TestOnSettingsChange(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	// called with the current settings upon registration
+	var got []Settings
+	OnSettingsChange(func(s Settings) { got = append(got, s) })
+	assert.Equal(t, []Settings{{
+		SampleRate:                        1000000,
+		Flags:                             "SAMPLE_START,SAMPLE_THROUGH_ALWAYS,TRIGGER_TRACE",
+		Tracing:                           true,
+		TriggerTrace:                      true,
+		TTL:                               120 * time.Second,
+		BucketCapacity:                    1000000,
+		BucketRate:                        1000000,
+		TriggerTraceRelaxedBucketCapacity: 1000000,
+		TriggerTraceRelaxedBucketRate:     1000000,
+		TriggerTraceStrictBucketCapacity:  1000000,
+		TriggerTraceStrictBucketRate:      1000000,
+	}}, got)
+
+	got = nil
+	r2 := reporter.SetTestReporter(reporter.TestReporterSettingType(reporter.NoTriggerTraceST))
+	defer r2.Close(0)
+	assert.Len(t, got, 1)
+	assert.True(t, got[0].Tracing)
+	assert.False(t, got[0].TriggerTrace)
+	assert.Zero(t, got[0].TriggerTraceStrictBucketCapacity)
+}
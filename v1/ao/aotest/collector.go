@@ -0,0 +1,387 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aotest provides a mock AppOptics collector for the end-to-end tests
+// of the instrumentation. Unlike the in-memory test reporter used by this
+// repository, the events go through the full reporter path: the gRPC
+// connection, the settings negotiation, the batching and the encoding.
+//
+//   func TestHandler(t *testing.T) {
+//       c := aotest.NewCollector()
+//       defer c.Close()
+//       c.Attach()
+//       ao.WaitForReady(context.Background())
+//
+//       // ... make a traced request ...
+//
+//       ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//       defer cancel()
+//       events, err := c.WaitForEvents(ctx, 2)
+//       // ... check the events ...
+//   }
+//
+// Attach replaces the global reporter, so the tests using it must not run in
+// parallel.
+package aotest
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestServiceKey is the service key set by Attach if APPOPTICS_SERVICE_KEY is
+// not set.
+const TestServiceKey = "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:aotest"
+
+// Result is the result code returned by the collector.
+type Result int
+
+// The result codes of the collector
+const (
+	// ResultOK accepts the requests.
+	ResultOK Result = iota
+	// ResultTryLater asks the agent to retry the requests later.
+	ResultTryLater
+	// ResultInvalidAPIKey rejects the service key, which shuts the agent down.
+	ResultInvalidAPIKey
+	// ResultLimitExceeded rejects the requests as the limit is exceeded.
+	ResultLimitExceeded
+)
+
+func (r Result) code() pb.ResultCode {
+	switch r {
+	case ResultTryLater:
+		return pb.ResultCode_TRY_LATER
+	case ResultInvalidAPIKey:
+		return pb.ResultCode_INVALID_API_KEY
+	case ResultLimitExceeded:
+		return pb.ResultCode_LIMIT_EXCEEDED
+	default:
+		return pb.ResultCode_OK
+	}
+}
+
+// Settings are the default sampling settings returned by the collector. The
+// zero values of the optional fields are not sent.
+type Settings struct {
+	// SampleRate is the sample rate out of 1000000.
+	SampleRate int64
+	// Flags are the comma separated sampling flags, e.g.,
+	// "SAMPLE_START,SAMPLE_THROUGH_ALWAYS,TRIGGER_TRACE".
+	Flags string
+	// TTL is the number of seconds the settings are valid for.
+	TTL int64
+	// BucketCapacity and BucketRate bound the traces started per second, the
+	// trigger trace buckets are set to the same values.
+	BucketCapacity float64
+	BucketRate     float64
+	// EventsFlushInterval is the number of seconds between the flushes of
+	// the events, optional.
+	EventsFlushInterval int32
+	// MetricsFlushInterval is the number of seconds between the flushes of
+	// the metrics, optional.
+	MetricsFlushInterval int32
+	// SignatureKey is the key of the trigger trace signatures, optional.
+	SignatureKey string
+}
+
+// DefaultSettings samples all the requests and flushes the events every
+// second, so the tests don't wait long for them.
+var DefaultSettings = Settings{
+	SampleRate:          1000000,
+	Flags:               "SAMPLE_START,SAMPLE_THROUGH_ALWAYS,TRIGGER_TRACE",
+	TTL:                 120,
+	BucketCapacity:      1000,
+	BucketRate:          1000,
+	EventsFlushInterval: 1,
+}
+
+func (s Settings) oboeSetting() *pb.OboeSetting {
+	args := map[string][]byte{
+		"BucketCapacity":               float64Bytes(s.BucketCapacity),
+		"BucketRate":                   float64Bytes(s.BucketRate),
+		"TriggerRelaxedBucketCapacity": float64Bytes(s.BucketCapacity),
+		"TriggerRelaxedBucketRate":     float64Bytes(s.BucketRate),
+		"TriggerStrictBucketCapacity":  float64Bytes(s.BucketCapacity),
+		"TriggerStrictBucketRate":      float64Bytes(s.BucketRate),
+	}
+	if s.EventsFlushInterval > 0 {
+		args["EventsFlushInterval"] = int32Bytes(s.EventsFlushInterval)
+	}
+	if s.MetricsFlushInterval > 0 {
+		args["MetricsFlushInterval"] = int32Bytes(s.MetricsFlushInterval)
+	}
+	if s.SignatureKey != "" {
+		args["SignatureKey"] = []byte(s.SignatureKey)
+	}
+	return &pb.OboeSetting{
+		Type:      pb.OboeSettingType_DEFAULT_SAMPLE_RATE,
+		Flags:     []byte(s.Flags),
+		Value:     s.SampleRate,
+		Ttl:       s.TTL,
+		Arguments: args,
+	}
+}
+
+func float64Bytes(f float64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
+
+func int32Bytes(i int32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(i))
+	return b
+}
+
+// Message is a message received by the collector, decoded from BSON.
+type Message map[string]interface{}
+
+// Collector is a mock collector serving the gRPC API of AppOptics on a local
+// port, which records the messages received.
+type Collector struct {
+	pb.UnimplementedTraceCollectorServer
+
+	addr     string
+	certFile string
+	server   *grpc.Server
+
+	lock             sync.Mutex
+	settings         Settings
+	result           Result
+	events           []Message
+	eventBufs        [][]byte
+	metrics          []Message
+	status           []Message
+	settingsRequests int
+	pings            int
+	received         chan struct{} // closed and replaced on each message received
+	env              map[string]*string
+}
+
+// NewCollector starts a Collector with DefaultSettings. It panics if the
+// Collector can't be started, the caller should call Close when finished.
+func NewCollector() *Collector {
+	certFile, cert, err := newCertificate()
+	if err != nil {
+		panic("aotest: failed to create the certificate: " + err.Error())
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		os.Remove(certFile)
+		panic("aotest: failed to listen on a port: " + err.Error())
+	}
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+
+	c := &Collector{
+		// the host name is verified against the certificate
+		addr:     net.JoinHostPort("localhost", port),
+		certFile: certFile,
+		server:   grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&cert))),
+		settings: DefaultSettings,
+		received: make(chan struct{}),
+	}
+	pb.RegisterTraceCollectorServer(c.server, c)
+	go c.server.Serve(lis)
+	return c
+}
+
+// Addr returns the address of the Collector, to be used as
+// APPOPTICS_COLLECTOR.
+func (c *Collector) Addr() string { return c.addr }
+
+// CertFile returns the path of the certificate of the Collector, to be used
+// as APPOPTICS_TRUSTEDPATH.
+func (c *Collector) CertFile() string { return c.certFile }
+
+// Attach points the agent to the Collector by setting APPOPTICS_COLLECTOR,
+// APPOPTICS_TRUSTEDPATH and APPOPTICS_REPORTER, and APPOPTICS_SERVICE_KEY if
+// it's not set, and then replacing the global reporter. Close restores them.
+func (c *Collector) Attach() {
+	c.lock.Lock()
+	env := map[string]string{
+		"APPOPTICS_COLLECTOR":   c.addr,
+		"APPOPTICS_TRUSTEDPATH": c.certFile,
+		"APPOPTICS_REPORTER":    "ssl",
+	}
+	if os.Getenv("APPOPTICS_SERVICE_KEY") == "" {
+		env["APPOPTICS_SERVICE_KEY"] = TestServiceKey
+	}
+	if c.env == nil {
+		c.env = make(map[string]*string)
+		for k := range env {
+			if v, ok := os.LookupEnv(k); ok {
+				c.env[k] = &v
+			} else {
+				c.env[k] = nil
+			}
+		}
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	c.lock.Unlock()
+	reporter.ReloadReporter()
+}
+
+// Close restores the environment variables and the reporter replaced by
+// Attach, if it's called, and then stops the Collector.
+func (c *Collector) Close() {
+	c.lock.Lock()
+	env := c.env
+	c.env = nil
+	c.lock.Unlock()
+	if env != nil {
+		for k, v := range env {
+			if v != nil {
+				os.Setenv(k, *v)
+			} else {
+				os.Unsetenv(k)
+			}
+		}
+		reporter.ReloadReporter()
+	}
+	c.server.Stop()
+	os.Remove(c.certFile)
+}
+
+// SetSettings sets the settings returned to the following settings requests.
+func (c *Collector) SetSettings(s Settings) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.settings = s
+}
+
+// SetResult sets the result code returned to the following requests. The
+// messages of the requests not accepted are not recorded.
+func (c *Collector) SetResult(r Result) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.result = r
+}
+
+// Events returns the events received.
+func (c *Collector) Events() []Message { return c.messages(&c.events) }
+
+// EventBufs returns the BSON encoded events received, e.g., for AssertGraph.
+func (c *Collector) EventBufs() [][]byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([][]byte(nil), c.eventBufs...)
+}
+
+// Metrics returns the metrics messages received.
+func (c *Collector) Metrics() []Message { return c.messages(&c.metrics) }
+
+// Status returns the status messages received, e.g., the init message.
+func (c *Collector) Status() []Message { return c.messages(&c.status) }
+
+func (c *Collector) messages(ms *[]Message) []Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]Message(nil), *ms...)
+}
+
+// SettingsRequests returns the number of the settings requests received.
+func (c *Collector) SettingsRequests() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.settingsRequests
+}
+
+// Pings returns the number of the pings received.
+func (c *Collector) Pings() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.pings
+}
+
+// WaitForEvents waits until at least n events are received and returns them,
+// or returns the events received so far with the error of the context if it's
+// done before that.
+func (c *Collector) WaitForEvents(ctx context.Context, n int) ([]Message, error) {
+	for {
+		c.lock.Lock()
+		events := append([]Message(nil), c.events...)
+		received := c.received
+		c.lock.Unlock()
+		if len(events) >= n {
+			return events, nil
+		}
+		select {
+		case <-received:
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+}
+
+// record decodes and appends the messages of an accepted request, and the
+// encoded ones to bufs if it's not nil.
+func (c *Collector) record(ms *[]Message, bufs *[][]byte, req *pb.MessageRequest) *pb.MessageResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.result != ResultOK {
+		return &pb.MessageResult{Result: c.result.code()}
+	}
+	for _, b := range req.Messages {
+		m := bson.M{}
+		if err := bson.Unmarshal(b, &m); err != nil {
+			continue
+		}
+		*ms = append(*ms, Message(m))
+		if bufs != nil {
+			*bufs = append(*bufs, b)
+		}
+	}
+	close(c.received)
+	c.received = make(chan struct{})
+	return &pb.MessageResult{Result: pb.ResultCode_OK}
+}
+
+// PostEvents implements the collector's gRPC API.
+func (c *Collector) PostEvents(ctx context.Context, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	return c.record(&c.events, &c.eventBufs, req), nil
+}
+
+// PostMetrics implements the collector's gRPC API.
+func (c *Collector) PostMetrics(ctx context.Context, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	return c.record(&c.metrics, nil, req), nil
+}
+
+// PostStatus implements the collector's gRPC API.
+func (c *Collector) PostStatus(ctx context.Context, req *pb.MessageRequest) (*pb.MessageResult, error) {
+	return c.record(&c.status, nil, req), nil
+}
+
+// GetSettings implements the collector's gRPC API.
+func (c *Collector) GetSettings(ctx context.Context, req *pb.SettingsRequest) (*pb.SettingsResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.settingsRequests++
+	if c.result != ResultOK {
+		return &pb.SettingsResult{Result: c.result.code()}, nil
+	}
+	return &pb.SettingsResult{
+		Result:   pb.ResultCode_OK,
+		Settings: []*pb.OboeSetting{c.settings.oboeSetting()},
+	}, nil
+}
+
+// Ping implements the collector's gRPC API.
+func (c *Collector) Ping(ctx context.Context, req *pb.PingRequest) (*pb.MessageResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pings++
+	return &pb.MessageResult{Result: c.result.code()}, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aotest_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	assert.Equal(t, c.Addr(), os.Getenv("APPOPTICS_COLLECTOR"))
+	assert.Equal(t, c.CertFile(), os.Getenv("APPOPTICS_TRUSTEDPATH"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+	assert.True(t, c.SettingsRequests() > 0)
+
+	tr := ao.NewTrace("aotest")
+	trCtx := ao.NewContext(context.Background(), tr)
+	l, _ := ao.BeginSpan(trCtx, "child", "Count", 3)
+	l.End()
+	tr.End()
+
+	events, err := c.WaitForEvents(ctx, 4)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, "aotest", events[0]["Layer"])
+	assert.Equal(t, "entry", events[0]["Label"])
+	assert.Equal(t, "child", events[1]["Layer"])
+	assert.Equal(t, 3, events[1]["Count"])
+	assert.Equal(t, "exit", events[3]["Label"])
+
+	// the init message is sent by the new reporter
+	var initMsg aotest.Message
+	for _, m := range c.Status() {
+		if _, ok := m["__Init"]; ok {
+			initMsg = m
+		}
+	}
+	require.NotNil(t, initMsg)
+	assert.Equal(t, ao.Version(), initMsg["Go.AppOptics.Version"])
+}
+
+func TestCollectorResult(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.SetResult(aotest.ResultTryLater)
+	c.Attach()
+
+	// the agent is not ready without the settings
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	assert.False(t, ao.WaitForReady(ctx))
+	assert.True(t, c.SettingsRequests() > 0)
+	assert.Empty(t, c.Status())
+}
+
+func TestCollectorClose(t *testing.T) {
+	os.Setenv("APPOPTICS_COLLECTOR", "collector.example.com:443")
+	defer os.Unsetenv("APPOPTICS_COLLECTOR")
+	_, hadCert := os.LookupEnv("APPOPTICS_TRUSTEDPATH")
+
+	c := aotest.NewCollector()
+	c.Attach()
+	c.Attach()
+	c.Close()
+	assert.Equal(t, "collector.example.com:443", os.Getenv("APPOPTICS_COLLECTOR"))
+	_, hasCert := os.LookupEnv("APPOPTICS_TRUSTEDPATH")
+	assert.Equal(t, hadCert, hasCert)
+	_, err := os.Stat(c.CertFile())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aotest
+
+import (
+	"io"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// The types of the graph assertions, see AssertGraph.
+type (
+	// Node is a decoded event.
+	Node = graphtest.Node
+	// MatchNode matches the nodes by their Layer and Label.
+	MatchNode = graphtest.MatchNode
+	// MatchNodeKV matches the nodes by their Layer, Label and a string KV.
+	MatchNodeKV = graphtest.MatchNodeKV
+	// Edges is a list of the nodes a node has the edges to. An edge to an
+	// event which is not captured, e.g., the remote parent, is matched by
+	// {"Edge", "<op ID>"}.
+	Edges = graphtest.Edges
+	// NodeAsserter checks the edges of a node and calls Callback with it.
+	NodeAsserter = graphtest.NodeAsserter
+	// AsserterMap looks up the NodeAsserters of the nodes.
+	AsserterMap = graphtest.AsserterMap
+	// AssertNodeMap describes the nodes by {Layer, Label}.
+	AssertNodeMap = graphtest.AssertNodeMap
+	// AssertNodeKVMap describes the nodes by {Layer, Label, K, V}, the
+	// nodes can also be matched by {Layer, Label, "", ""}.
+	AssertNodeKVMap = graphtest.AssertNodeKVMap
+	// ExportOptions controls how the events are exported by WriteDOT and
+	// WriteOTLPJSON.
+	ExportOptions = graphtest.ExportOptions
+)
+
+// AssertGraph asserts that the encoded events, e.g., Collector.EventBufs, are
+// numNodes events matching the nodes of asserterMap, with the edges described:
+//   aotest.AssertGraph(t, c.EventBufs(), 2, aotest.AssertNodeMap{
+//       {"myHandler", "entry"}: {},
+//       {"myHandler", "exit"}:  {Edges: aotest.Edges{{"myHandler", "entry"}}},
+//   })
+// The graph is also saved as a DOT file if DOT_GRAPHS is set.
+func AssertGraph(t *testing.T, bufs [][]byte, numNodes int, asserterMap AsserterMap) {
+	graphtest.AssertGraph(t, bufs, numNodes, asserterMap)
+}
+
+// WriteDOT writes the encoded events as a Graphviz DOT graph, for debugging
+// the complex edge structures. The output is stable for the same events.
+func WriteDOT(w io.Writer, bufs [][]byte, opts ExportOptions) error {
+	return graphtest.WriteDOT(w, bufs, opts)
+}
+
+// WriteOTLPJSON writes the finished spans of the encoded events in the
+// OTLP/HTTP JSON encoding, which can be imported by the tools like Jaeger.
+func WriteOTLPJSON(w io.Writer, bufs [][]byte, opts ExportOptions) error {
+	bufs, err := graphtest.Normalize(bufs, opts)
+	if err != nil {
+		return err
+	}
+	b, err := reporter.OTLPJSON(bufs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// AssertGoldenFile asserts that got is the same as the content of the file at
+// path, e.g., the output of WriteDOT with ExportOptions.Normalize. The file is
+// updated instead if UPDATE_GOLDEN is set:
+//   UPDATE_GOLDEN=1 go test -run TestMyHandler
+func AssertGoldenFile(t *testing.T, path string, got []byte) bool {
+	return graphtest.AssertGoldenFile(t, path, got)
+}
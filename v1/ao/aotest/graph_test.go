@@ -0,0 +1,62 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aotest_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceToCollector reports a trace and returns all the events received.
+func traceToCollector(t *testing.T, c *aotest.Collector, total int) [][]byte {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	tr := ao.NewTrace("aotest")
+	trCtx := ao.NewContext(context.Background(), tr)
+	l, _ := ao.BeginSpan(trCtx, "child", "Count", 3)
+	l.End()
+	tr.End()
+
+	_, err := c.WaitForEvents(ctx, total)
+	require.NoError(t, err)
+	return c.EventBufs()
+}
+
+func TestExport(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+
+	bufs := traceToCollector(t, c, 4)
+	aotest.AssertGraph(t, bufs, 4, aotest.AssertNodeMap{
+		{"aotest", "entry"}: {},
+		{"child", "entry"}:  {Edges: aotest.Edges{{"aotest", "entry"}}},
+		{"child", "exit"}:   {Edges: aotest.Edges{{"child", "entry"}}},
+		{"aotest", "exit"}:  {Edges: aotest.Edges{{"child", "exit"}, {"aotest", "entry"}}},
+	})
+
+	// the normalized output doesn't change with the IDs and timestamps
+	opts := aotest.ExportOptions{Normalize: true}
+	var dot, otlp bytes.Buffer
+	require.NoError(t, aotest.WriteDOT(&dot, bufs, opts))
+	require.NoError(t, aotest.WriteOTLPJSON(&otlp, bufs, opts))
+
+	bufs = traceToCollector(t, c, 8)[4:]
+	var dot2, otlp2 bytes.Buffer
+	require.NoError(t, aotest.WriteDOT(&dot2, bufs, opts))
+	require.NoError(t, aotest.WriteOTLPJSON(&otlp2, bufs, opts))
+	assert.Equal(t, dot.String(), dot2.String())
+	assert.Equal(t, otlp.String(), otlp2.String())
+
+	aotest.AssertGoldenFile(t, "testdata/export.dot", dot.Bytes())
+	aotest.AssertGoldenFile(t, "testdata/export.json", otlp.Bytes())
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// backTraces bounds the backtraces captured for the spans and errors, so a
+// spike of the requests asking for them doesn't spend the CPU collecting the
+// same stacks over and over.
+var backTraces backTraceLimiter
+
+type backTraceLimiter struct {
+	// the following fields are accessed atomically
+	inFlight int32 // the number of the backtraces being captured
+	second   int64 // the unix time of the current one-second window
+	count    int64 // the number of the backtraces captured in the window
+
+	bufs sync.Pool
+}
+
+// allow reports if a backtrace can be captured now. The caller must call done
+// afterwards if it returns true.
+func (l *backTraceLimiter) allow(now time.Time) bool {
+	if atomic.AddInt32(&l.inFlight, 1) > int32(config.GetBackTraceConcurrency()) {
+		atomic.AddInt32(&l.inFlight, -1)
+		return false
+	}
+	sec := now.Unix()
+	if prev := atomic.LoadInt64(&l.second); prev != sec {
+		if atomic.CompareAndSwapInt64(&l.second, prev, sec) {
+			atomic.StoreInt64(&l.count, 0)
+		}
+	}
+	if atomic.AddInt64(&l.count, 1) > int64(config.GetBackTraceRate()) {
+		atomic.AddInt32(&l.inFlight, -1)
+		return false
+	}
+	return true
+}
+
+func (l *backTraceLimiter) done() {
+	atomic.AddInt32(&l.inFlight, -1)
+}
+
+// capture returns the stack trace of the calling goroutine, truncated to the
+// maximum size, or an empty string if it's not allowed by the limits.
+func (l *backTraceLimiter) capture() string {
+	if !l.allow(time.Now()) {
+		log.Debug("Backtrace skipped, too many captured")
+		return ""
+	}
+	defer l.done()
+
+	size := config.GetBackTraceMaxBytes()
+	bp, _ := l.bufs.Get().(*[]byte)
+	if bp == nil || cap(*bp) < size {
+		buf := make([]byte, size)
+		bp = &buf
+	}
+	buf := (*bp)[:size]
+	// the stack is truncated once the buffer is full
+	n := runtime.Stack(buf, false)
+	s := string(buf[:n])
+	l.bufs.Put(bp)
+	return s
+}
+
+// captureBackTrace returns the stack trace of the calling goroutine to be
+// reported as the KeyBackTrace KV.
+func captureBackTrace() string {
+	return backTraces.capture()
+}
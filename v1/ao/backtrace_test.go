@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackTraceLimiter(t *testing.T) {
+	os.Setenv("APPOPTICS_BACKTRACE_RATE", "3")
+	os.Setenv("APPOPTICS_BACKTRACE_CONCURRENCY", "2")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_BACKTRACE_RATE")
+		os.Unsetenv("APPOPTICS_BACKTRACE_CONCURRENCY")
+		config.Load()
+	}()
+
+	var l backTraceLimiter
+	now := time.Unix(1000, 0)
+	// bounded by the concurrency
+	assert.True(t, l.allow(now))
+	assert.True(t, l.allow(now))
+	assert.False(t, l.allow(now))
+	l.done()
+	// and then by the rate
+	assert.True(t, l.allow(now))
+	l.done()
+	assert.False(t, l.allow(now))
+	l.done()
+	// the rate is reset in the next second
+	now = now.Add(time.Second)
+	assert.True(t, l.allow(now))
+	l.done()
+	assert.EqualValues(t, 0, l.inFlight)
+}
+
+func TestCaptureBackTrace(t *testing.T) {
+	var l backTraceLimiter
+	bt := l.capture()
+	assert.True(t, strings.HasPrefix(bt, "goroutine "))
+	assert.Contains(t, bt, "TestCaptureBackTrace")
+	assert.EqualValues(t, 0, l.inFlight)
+
+	os.Setenv("APPOPTICS_BACKTRACE_MAX_BYTES", "1024")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_BACKTRACE_MAX_BYTES")
+		config.Load()
+	}()
+	var deep func(int) string
+	deep = func(n int) string {
+		if n == 0 {
+			return l.capture()
+		}
+		return deep(n - 1)
+	}
+	assert.Len(t, deep(100), 1024)
+}
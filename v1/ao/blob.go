@@ -0,0 +1,30 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+
+// Blob is a KV value of the large data which is reported up to a limit of
+// bytes, e.g., the beginning of a request body:
+//   span.Info("RequestBody", ao.NewBlob(body, 4096))
+// If the data is longer than the limit, the event also has the
+// RequestBody_Truncated KV set to true and RequestBody_OriginalSize set to
+// the size of the data.
+type Blob = reporter.Blob
+
+// The limits of a Blob
+const (
+	// DefaultBlobLimit is used if the limit of a Blob is <= 0.
+	DefaultBlobLimit = reporter.DefaultBlobLimit
+	// MaxBlobLimit is the upper bound of the limit of a Blob.
+	MaxBlobLimit = reporter.MaxBlobLimit
+)
+
+// NewBlob returns a Blob of the data reported as binary, up to limit bytes.
+// The data is not copied, so it must not be modified until the Blob is
+// reported.
+func NewBlob(data []byte, limit int) Blob { return reporter.NewBlob(data, limit) }
+
+// NewStringBlob returns a Blob of the string reported up to limit bytes, it's
+// truncated at the boundary of a UTF-8 character.
+func NewStringBlob(s string, limit int) Blob { return reporter.NewStringBlob(s, limit) }
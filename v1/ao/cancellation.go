@@ -0,0 +1,62 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// The KVs reporting the cancellation of the context of a span
+const (
+	keyCancelled   = "Cancelled"
+	keyCancelError = "CancelError"
+	keyCancelCause = "CancelCause"
+)
+
+// bindContext records the context the span is started from, which is checked
+// for the cancellation when the span ends.
+func (s *span) bindContext(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx == nil {
+		s.ctx = ctx
+	}
+}
+
+func (s *span) boundContext() context.Context {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.ctx
+}
+
+// cancellationArgs returns the Cancelled KV to be added to the exit event if
+// the span ends after its context is cancelled or has exceeded its deadline.
+// The span where the cancellation originated, i.e., the outermost one whose
+// context is done, reports an info event with the cause as well, so a cancelled
+// request doesn't repeat it on every span.
+//
+// It must be called before the lock of the span is acquired, as the parent is
+// inspected as well.
+func (s *span) cancellationArgs() []interface{} {
+	ctx := s.boundContext()
+	if ctx == nil || ctx.Err() == nil {
+		return nil
+	}
+	if s.parent == nil || !contextDone(s.parent.boundContext()) {
+		kvs := []interface{}{keyCancelError, ctx.Err().Error()}
+		if cause := contextCause(ctx); cause != nil && cause != ctx.Err() {
+			kvs = append(kvs, keyCancelCause, cause.Error())
+		}
+		_ = s.aoCtx.ReportEvent(reporter.LabelInfo, s.layerName(), kvs...)
+	}
+	return []interface{}{keyCancelled, true}
+}
+
+func contextDone(ctx context.Context) bool {
+	return ctx != nil && ctx.Err() != nil
+}
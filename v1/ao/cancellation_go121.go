@@ -0,0 +1,13 @@
+// +build go1.21
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "context"
+
+// contextCause returns the cause of the cancellation of the context, which is
+// set by the context.CancelCauseFunc since Go 1.21.
+func contextCause(ctx context.Context) error {
+	return context.Cause(ctx)
+}
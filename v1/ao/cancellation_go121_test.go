@@ -0,0 +1,34 @@
+// +build go1.21
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpanCancellationCause(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("root")
+	ctx, cancel := context.WithCancelCause(NewContext(context.Background(), tr))
+	s, _ := BeginSpan(ctx, "child")
+	cancel(errors.New("client went away"))
+	s.End()
+	tr.End()
+
+	r.Close(5)
+	evts := decodeEvents(t, r.EventBufs)
+	require.Len(t, evts, 5)
+	assert.Equal(t, "info", evts[2]["Label"])
+	assert.Equal(t, context.Canceled.Error(), evts[2][keyCancelError])
+	assert.Equal(t, "client went away", evts[2][keyCancelCause])
+	assert.Equal(t, true, evts[3][keyCancelled])
+}
@@ -0,0 +1,13 @@
+// +build !go1.21
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "context"
+
+// contextCause returns the error of the context, as the cause of the
+// cancellation is not available before Go 1.21.
+func contextCause(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func decodeEvents(t *testing.T, bufs [][]byte) []bson.M {
+	var evts []bson.M
+	for _, buf := range bufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		evts = append(evts, m)
+	}
+	return evts
+}
+
+func TestSpanCancellation(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	cctx, cancel := context.WithCancel(ctx)
+	outer, octx := BeginSpan(cctx, "outer")
+	inner, _ := BeginSpan(octx, "inner")
+	notCancelled, _ := BeginSpan(ctx, "sibling")
+
+	cancel()
+	inner.End()
+	outer.End()
+	notCancelled.End()
+	tr.End()
+
+	r.Close(9)
+	evts := decodeEvents(t, r.EventBufs)
+	require.Len(t, evts, 9)
+
+	var infos []bson.M
+	for _, e := range evts {
+		switch {
+		case e["Label"] == "info":
+			infos = append(infos, e)
+		case e["Label"] == "exit" && (e["Layer"] == "inner" || e["Layer"] == "outer"):
+			assert.Equal(t, true, e[keyCancelled], e["Layer"])
+		default:
+			assert.NotContains(t, e, keyCancelled, e["Layer"])
+		}
+	}
+	// only the span where the cancellation originated reports it
+	require.Len(t, infos, 1)
+	assert.Equal(t, "outer", infos[0]["Layer"])
+	assert.Equal(t, context.Canceled.Error(), infos[0][keyCancelError])
+	assert.NotContains(t, infos[0], keyCancelCause)
+}
+
+func TestTraceCancellation(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tr := NewTrace("root")
+	ctx = NewContext(ctx, tr)
+	s, _ := BeginSpan(ctx, "child")
+	cancel()
+	s.End()
+	tr.End()
+
+	r.Close(5)
+	evts := decodeEvents(t, r.EventBufs)
+	require.Len(t, evts, 5)
+	assert.Equal(t, "info", evts[3]["Label"])
+	assert.Equal(t, "root", evts[3]["Layer"])
+	assert.Equal(t, true, evts[4][keyCancelled])
+	assert.Equal(t, "root", evts[4]["Layer"])
+}
@@ -5,7 +5,11 @@ package ao
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"strings"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 )
 
@@ -15,12 +19,49 @@ import (
 // Call or defer the returned Span's End() to time the query's client-side latency.
 func BeginQuerySpan(ctx context.Context, spanName, query, flavor, remoteHost string, args ...interface{}) Span {
 	query = reporter.SQLSanitize(flavor, query)
-	qsKVs := []interface{}{"Spec", "query", "Query", query, "Flavor", flavor, "RemoteHost", remoteHost}
+	qsKVs := []interface{}{"Spec", "query", "Query", query, "Flavor", flavor, "RemoteHost", remoteHost,
+		keySpanKind, SpanKindClient.String()}
 	kvs := mergeKVs(qsKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 	return l
 }
 
+// SQLFingerprint returns the normalized form of the SQL statement of the
+// flavor, which is the same for the statements only differing in the literals,
+// the placeholders, the lengths of the lists of values and the whitespaces:
+//   ao.SQLFingerprint("postgresql", "SELECT * FROM t WHERE id IN ($1, $2)")
+//   // SELECT * FROM t WHERE id IN (?)
+// The literals are removed even if APPOPTICS_SQL_SANITIZE is disabled.
+func SQLFingerprint(flavor, query string) string {
+	return reporter.SQLFingerprint(flavor, query)
+}
+
+// SQLComment appends the trace context of the query span l to the SQL statement
+// as a sqlcommenter-style comment, e.g.,
+//   SELECT * FROM users /*traceparent='00-<trace ID>-<span ID>-01'*/
+// so the database logs can be correlated with the traces. It's only enabled by
+// APPOPTICS_SQL_COMMENTER, otherwise the query is returned unchanged. The query
+// is not changed either if it already contains a comment.
+//   l := ao.BeginQuerySpan(ctx, "querySpan", query, "postgresql", "db.host")
+//   rows, err := db.QueryContext(ctx, ao.SQLComment(l, query))
+//   l.End()
+func SQLComment(l Span, query string) string {
+	if !config.GetSQLCommenter() || l == nil || !l.IsReporting() {
+		return query
+	}
+	if strings.Contains(query, "/*") || strings.Contains(query, "--") {
+		return query
+	}
+	tp := TraceparentFromMetadata(l.MetadataString())
+	if tp == "" {
+		return query
+	}
+	// keep the trailing semicolon, if any, after the comment
+	stmt := strings.TrimRight(query, "; \t\n")
+	return fmt.Sprintf("%s /*traceparent='%s'*/%s", stmt, url.QueryEscape(tp),
+		strings.TrimSpace(query[len(stmt):]))
+}
+
 // BeginCacheSpan returns a Span that reports metadata used by AppOptics to filter cache/KV server
 // request latency heatmaps and charts by span name, cache operation and hostname.
 // Required parameter "op" is meant to report a Redis or Memcached command e.g. "HGET" or "set".
@@ -28,7 +69,8 @@ func BeginQuerySpan(ctx context.Context, spanName, query, flavor, remoteHost str
 // Optional parameter "key" will display in the trace's details, but will not be indexed.
 // Call or defer the returned Span's End() to time the request's client-side latency.
 func BeginCacheSpan(ctx context.Context, spanName, op, key, remoteHost string, hit bool, args ...interface{}) Span {
-	csKVs := []interface{}{"Spec", "cache", "KVOp", op, "KVKey", key, "KVHit", hit, "RemoteHost", remoteHost}
+	csKVs := []interface{}{"Spec", "cache", "KVOp", op, "KVKey", key, "KVHit", hit, "RemoteHost", remoteHost,
+		keySpanKind, SpanKindClient.String()}
 	kvs := mergeKVs(csKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 	return l
@@ -40,7 +82,11 @@ func BeginCacheSpan(ctx context.Context, spanName, op, key, remoteHost string, h
 // metadata headers via http.Request and http.Response.
 // Call or defer the returned Span's End() to time the call's client-side latency.
 func BeginRemoteURLSpan(ctx context.Context, spanName, remoteURL string, args ...interface{}) Span {
-	rsKVs := []interface{}{"Spec", "rsc", "IsService", true, "RemoteURL", remoteURL}
+	return beginRemoteURLSpan(ctx, spanName, remoteURL, SpanKindClient, args...)
+}
+
+func beginRemoteURLSpan(ctx context.Context, spanName, remoteURL string, kind SpanKind, args ...interface{}) Span {
+	rsKVs := mergeKVs([]interface{}{"Spec", "rsc", "IsService", true, "RemoteURL", remoteURL}, spanKindArgs(kind))
 	kvs := mergeKVs(rsKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 	return l
@@ -56,7 +102,8 @@ func BeginRPCSpan(ctx context.Context, spanName, protocol, controller, remoteHos
 		"IsService", true,
 		"RemoteProtocol", protocol,
 		"RemoteHost", remoteHost,
-		"RemoteController", controller}
+		"RemoteController", controller,
+		keySpanKind, SpanKindClient.String()}
 
 	kvs := mergeKVs(rsKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
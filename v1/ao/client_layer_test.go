@@ -3,16 +3,22 @@
 package ao_test
 
 import (
+	"errors"
+	"os"
 	"runtime/debug"
+	"strings"
 	"testing"
 	"time"
 
 	"context"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSpans(t *testing.T) {
@@ -73,3 +79,68 @@ func TestSpans(t *testing.T) {
 		{"myExample", "exit"}: {Edges: g.Edges{{"redis", "exit"}, {"myServiceClient", "exit"}, {"querySpan", "exit"}, {"myExample", "entry"}}},
 	})
 }
+
+func TestSQLComment(t *testing.T) {
+	r := reporter.SetTestReporter() // enable test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("myExample"))
+	query := "SELECT * FROM TEST_TABLE"
+	l := ao.BeginQuerySpan(ctx, "querySpan", query, "postgresql", "remote.host")
+
+	// disabled by default
+	assert.Equal(t, query, ao.SQLComment(l, query))
+
+	os.Setenv("APPOPTICS_SQL_COMMENTER", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SQL_COMMENTER")
+		config.Load()
+	}()
+
+	md := strings.ToLower(l.MetadataString())
+	comment := "/*traceparent='00-" + md[2:34] + "-" + md[42:58] + "-01'*/"
+	assert.Equal(t, query+" "+comment, ao.SQLComment(l, query))
+	assert.Equal(t, query+" "+comment+";", ao.SQLComment(l, query+"; "))
+	// queries with comments are not changed
+	assert.Equal(t, query+" -- users", ao.SQLComment(l, query+" -- users"))
+	assert.Equal(t, "/* app */ "+query, ao.SQLComment(l, "/* app */ "+query))
+	// spans not reporting
+	l.End()
+	assert.Equal(t, query, ao.SQLComment(l, query))
+	assert.Equal(t, query, ao.SQLComment(ao.FromContext(context.Background()), query))
+
+	ao.End(ctx)
+	r.Close(4)
+}
+
+func TestRecordQueryMetrics(t *testing.T) {
+	r := reporter.SetTestReporter()
+	// no trace is needed
+	assert.NoError(t, ao.RecordQueryMetrics("postgresql",
+		"SELECT * FROM t WHERE id IN ($1, $2)", 3*time.Millisecond, 2, errors.New("failed")))
+	r.Close(1)
+
+	require.Len(t, r.SpanMessages, 1)
+	m, ok := r.SpanMessages[0].(*metrics.QuerySpanMessage)
+	require.True(t, ok)
+	assert.Equal(t, ao.SQLFingerprint("postgresql", "SELECT * FROM t WHERE id IN (1)"), m.Fingerprint)
+	assert.Equal(t, "SELECT * FROM t WHERE id IN (?)", m.Fingerprint)
+	assert.Equal(t, "postgresql", m.Flavor)
+	assert.Equal(t, 3*time.Millisecond, m.Duration)
+	assert.EqualValues(t, 2, m.Rows)
+	assert.True(t, m.HasError)
+}
+
+func TestRecordCacheMetrics(t *testing.T) {
+	r := reporter.SetTestReporter()
+	assert.NoError(t, ao.RecordCacheMetrics("sessions", "hget", true, time.Millisecond, nil))
+	r.Close(1)
+
+	require.Len(t, r.SpanMessages, 1)
+	m, ok := r.SpanMessages[0].(*metrics.CacheSpanMessage)
+	require.True(t, ok)
+	assert.Equal(t, "HGET", m.Op)
+	assert.Equal(t, "sessions", m.Cluster)
+	assert.True(t, m.Hit)
+	assert.Equal(t, time.Millisecond, m.Duration)
+	assert.False(t, m.HasError)
+}
@@ -8,9 +8,18 @@ type contextKeyT interface{}
 
 var contextKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Trace")
 var contextSpanKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Span")
+var contextSuppressedKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Suppressed")
+var contextHTTPClientSpanKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.HTTPClientSpan")
 
 // NewContext returns a copy of the parent context and associates it with a Trace.
+// The trace reports if it ends after the parent context is cancelled.
 func NewContext(ctx context.Context, t Trace) context.Context {
+	if at, ok := t.(*aoTrace); ok {
+		at.bindContext(ctx)
+		ctx = bindTraceValues(ctx, at)
+	} else {
+		ctx = WithTraceValues(ctx)
+	}
 	return context.WithValue(context.WithValue(ctx, contextKey, t), contextSpanKey, t)
 }
 
@@ -19,6 +28,29 @@ func newSpanContext(ctx context.Context, l Span) context.Context {
 	return context.WithValue(ctx, contextSpanKey, l)
 }
 
+// SuppressTracing returns a copy of the parent context under which no child
+// spans or KVs are recorded, and the trace context is not injected into the
+// outbound requests. It's meant for the code paths handling regulated data,
+// where even the metadata must not leave the process:
+//   ctx = ao.SuppressTracing(ctx)
+//   handleCardholderData(ctx)
+// The trace bound to the parent context, if any, is not affected and still
+// reports its own span, while TraceFromContext returns a no-op Trace and the
+// KVs of SetUser, SetTransactionName, SetTraceValue, etc. are discarded.
+func SuppressTracing(ctx context.Context) context.Context {
+	return context.WithValue(newSpanContext(ctx, nullSpan{}), contextSuppressedKey, true)
+}
+
+// tracingSuppressed reports if the context is derived from one returned by
+// SuppressTracing.
+func tracingSuppressed(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	suppressed, _ := ctx.Value(contextSuppressedKey).(bool)
+	return suppressed
+}
+
 // FromContext returns the Span bound to the context, if any.
 func FromContext(ctx context.Context) Span {
 	l, ok := fromContext(ctx)
@@ -35,10 +67,11 @@ func fromContext(ctx context.Context) (l Span, ok bool) {
 	return
 }
 
-// TraceFromContext returns the Trace bound to the context, if any.
+// TraceFromContext returns the Trace bound to the context, if any. A no-op
+// Trace is returned if the tracing is suppressed by SuppressTracing.
 func TraceFromContext(ctx context.Context) Trace {
 	t, ok := traceFromContext(ctx)
-	if !ok {
+	if !ok || tracingSuppressed(ctx) {
 		return &nullTrace{}
 	}
 	return t
@@ -58,15 +91,23 @@ func runCtx(ctx context.Context, f func(l Span)) {
 	}
 }
 
-// if context contains a valid Trace, run f
+// if context contains a valid Trace and the tracing is not suppressed, run f
 func runTraceCtx(ctx context.Context, f func(t Trace)) {
+	if tracingSuppressed(ctx) {
+		return
+	}
 	if t, ok := traceFromContext(ctx); ok {
 		f(t)
 	}
 }
 
 // EndTrace ends a Trace, given a context that was associated with the trace.
-func EndTrace(ctx context.Context) { runTraceCtx(ctx, func(t Trace) { t.End() }) }
+func EndTrace(ctx context.Context) {
+	// the trace is ended under SuppressTracing as well
+	if t, ok := traceFromContext(ctx); ok {
+		t.End()
+	}
+}
 
 // End ends a Span, given a context ctx that was associated with it, optionally reporting KV pairs
 // provided by args.
@@ -81,6 +122,11 @@ func Error(ctx context.Context, class, msg string) { runCtx(ctx, func(l Span) {
 // Err reports details error err (along with a stack trace) on the Span associated with the context ctx.
 func Err(ctx context.Context, err error) { runCtx(ctx, func(l Span) { l.Err(err) }) }
 
+// SetSpanStatus sets the status of the Span associated with the context ctx.
+func SetSpanStatus(ctx context.Context, code StatusCode, msg string) {
+	runCtx(ctx, func(l Span) { l.SetSpanStatus(code, msg) })
+}
+
 // MetadataString returns a representation of the Span's context for use with distributed
 // tracing (to create a remote child span). If the Span has ended, an empty string is returned.
 func MetadataString(ctx context.Context) string {
@@ -97,3 +143,21 @@ func IsSampled(ctx context.Context) bool {
 	}
 	return false
 }
+
+// TraceIDFromContext returns the trace ID and span ID of the Span associated
+// with the context ctx, and whether it is sampled. The trace ID is the same as
+// the one used for log injection, which may be returned to customers in error
+// responses to look up the trace. Empty IDs are returned if there is no valid
+// Span associated with the context.
+func TraceIDFromContext(ctx context.Context) (traceID, spanID string, sampled bool) {
+	l, ok := fromContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+	// 1 byte of header, 20 bytes of taskID, 8 bytes of opID and 1 byte of flags
+	md := l.MetadataString()
+	if len(md) != 60 {
+		return "", "", false
+	}
+	return md[2:42], md[42:58], l.IsSampled()
+}
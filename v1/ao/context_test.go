@@ -4,6 +4,7 @@ package ao
 
 import (
 	"context"
+	"net/http"
 	"reflect"
 	"runtime"
 	"strings"
@@ -71,6 +72,34 @@ func TestContextIsSampled(t *testing.T) {
 	assert.True(t, IsSampled(ctx))
 }
 
+func TestTraceIDFromContext(t *testing.T) {
+	traceID, spanID, sampled := TraceIDFromContext(context.Background())
+	assert.Empty(t, traceID)
+	assert.Empty(t, spanID)
+	assert.False(t, sampled)
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("TestTIDFC")
+	ctx := NewContext(context.Background(), tr)
+	md := tr.MetadataString()
+	traceID, spanID, sampled = TraceIDFromContext(ctx)
+	assert.Equal(t, md[2:42], traceID)
+	assert.Equal(t, md[42:58], spanID)
+	assert.True(t, sampled)
+	assert.Equal(t, tr.LoggableTraceID(), traceID+"-1")
+
+	// the span ID is the child span's while the trace ID doesn't change
+	l, ctx2 := BeginSpan(ctx, "child")
+	childTraceID, childSpanID, _ := TraceIDFromContext(ctx2)
+	assert.Equal(t, traceID, childTraceID)
+	assert.Equal(t, l.MetadataString()[42:58], childSpanID)
+	assert.NotEqual(t, spanID, childSpanID)
+	l.End()
+	tr.End()
+
+	r.Close(4)
+}
+
 func TestNullSpan(t *testing.T) {
 	// enable reporting to test reporter
 	r := reporter.SetTestReporter()
@@ -109,6 +138,54 @@ func TestNullSpan(t *testing.T) {
 	})
 }
 
+func TestSuppressTracing(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("TestSuppress"))
+	l1, ctxL := BeginSpan(ctx, "L1")
+
+	sctx := SuppressTracing(ctxL)
+	assert.False(t, IsSampled(sctx))
+	assert.Empty(t, MetadataString(sctx))
+	Info(sctx, "Secret", "1234")
+	l2, sctx2 := BeginSpan(sctx, "L2", "Secret", "5678")
+	assert.IsType(t, nullSpan{}, l2)
+	assert.IsType(t, nullSpan{}, BeginQuerySpan(sctx2, "db", "SELECT *", "postgresql", "db.net"))
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	cl := BeginHTTPClientSpan(sctx2, req)
+	cl.End()
+	assert.Empty(t, req.Header.Get(HTTPHeaderName))
+	assert.True(t, HTTPClientSpanStarted(cl.Request()))
+	assert.False(t, HTTPClientSpanStarted(req))
+	l2.End()
+
+	// nor the KVs of the trace
+	SetTraceValue(ctx, "Tenant", "acme")
+	SetUser(sctx, "alice")
+	SetTraceValue(sctx, "Secret", "1234", WithPromotion())
+	SetTraceValue(sctx, "Tenant", "initech")
+	assert.IsType(t, &nullTrace{}, TraceFromContext(sctx))
+	assert.Equal(t, "acme", TraceValue(sctx, "Tenant"))
+
+	// the spans outside are not affected
+	assert.True(t, l1.IsReporting())
+	l1.End()
+	EndTrace(ctx)
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"TestSuppress", "entry"}: {},
+		{"L1", "entry"}:           {Edges: g.Edges{{"TestSuppress", "entry"}}},
+		{"L1", "exit"}: {Edges: g.Edges{{"L1", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Secret")
+		}},
+		{"TestSuppress", "exit"}: {Edges: g.Edges{{"L1", "exit"}, {"TestSuppress", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "UserID")
+			assert.NotContains(t, n.Map, "Secret")
+		}},
+	})
+}
+
 func TestNilContext(t *testing.T) {
 	assert.NotPanics(t, func() { assert.IsType(t, &nullTrace{}, TraceFromContext(nil)) })
 	assert.NotPanics(t, func() { assert.IsType(t, nullSpan{}, FromContext(nil)) })
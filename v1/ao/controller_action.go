@@ -0,0 +1,55 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+)
+
+// SetControllerAction sets the Controller and Action KVs of the trace bound to
+// the context ctx, which also name its transaction unless a custom name is set,
+// e.g., by SetTransactionName. They replace the ones derived by HTTPHandler
+// from the name of the handler, so the frameworks and the generated code can
+// name the closures precisely rather than "func1":
+//   func(w http.ResponseWriter, r *http.Request) {
+//   	ao.SetControllerAction(r.Context(), "users", "list")
+//   	// ...
+//   }
+// The last call takes effect. It's a no-op if both are empty.
+func SetControllerAction(ctx context.Context, controller, action string) {
+	if controller == "" && action == "" {
+		return
+	}
+	runTraceCtx(ctx, func(t Trace) {
+		if at, ok := t.(*aoTrace); ok {
+			at.setControllerAction(controller, action)
+		}
+	})
+}
+
+func (t *aoTrace) setControllerAction(controller, action string) {
+	if !t.ok() {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.httpSpan.controller = controller
+	t.httpSpan.action = action
+}
+
+// overrideControllerAction replaces the Controller and Action KVs of the exit
+// event by the ones set by SetControllerAction, if any, the lock must be held
+// by the caller.
+func (t *aoTrace) overrideControllerAction() {
+	if t.httpSpan.controller == "" && t.httpSpan.action == "" {
+		return
+	}
+	args := make([]interface{}, 0, len(t.endArgs)+4)
+	for i := 0; i+1 < len(t.endArgs); i += 2 {
+		if k, _ := t.endArgs[i].(string); k == keyController || k == keyAction {
+			continue
+		}
+		args = append(args, t.endArgs[i], t.endArgs[i+1])
+	}
+	t.endArgs = append(args, keyController, t.httpSpan.controller, keyAction, t.httpSpan.action)
+}
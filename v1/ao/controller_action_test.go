@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetControllerAction(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+		ao.SetControllerAction(req.Context(), "ignored", "ignored")
+		ao.SetControllerAction(req.Context(), "users", "list")
+		ao.SetControllerAction(req.Context(), "", "")
+	}, "http://test.com/users")
+	// a no-op without a trace
+	ao.SetControllerAction(context.Background(), "users", "list")
+	r.Close(2)
+
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "users", n.Map["Controller"])
+			assert.Equal(t, "list", n.Map["Action"])
+			assert.Equal(t, "users.list", n.Map["TransactionName"])
+		}},
+	})
+}
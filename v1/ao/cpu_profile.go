@@ -0,0 +1,101 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// The KVs of the exit event reporting the CPU profile of a request: the path
+// of the profile written, or why it's not captured.
+const (
+	keyCPUProfile      = "CPUProfile"
+	keyCPUProfileError = "CPUProfileError"
+)
+
+// cpuProfileLabel is the pprof label of the goroutines serving a profiled
+// request, whose value is the trace ID, so the samples of the request can be
+// told from the others of the process, e.g., by `go tool pprof -tagfocus`.
+const cpuProfileLabel = "appoptics_trace_id"
+
+// the longest a CPU profile runs if the request doesn't end before
+var maxCPUProfileDuration = 30 * time.Second
+
+// requestProfile is the CPU profile of a request, requested by the profile
+// option of a signed X-Trace-Options.
+type requestProfile struct {
+	traceID string
+	orig    context.Context // the context of the request before it's labeled
+	buf     bytes.Buffer
+	timer   *time.Timer
+	once    sync.Once
+	args    []interface{} // the KVs reporting the profile once it's stopped
+}
+
+// startCPUProfile starts the CPU profile of the request, which is stopped when
+// the trace ends, and returns the request whose context carries the pprof
+// label of the trace. The goroutines started by the handler inherit the label.
+// As the CPU profiler is process-wide, only one request is profiled at a time.
+func (t *aoTrace) startCPUProfile(r *http.Request) *http.Request {
+	p := &requestProfile{orig: r.Context()}
+	if md := t.aoCtx.MetadataString(); len(md) == 60 {
+		p.traceID = md[2:42]
+	}
+	t.httpSpan.profile = p
+	if err := pprof.StartCPUProfile(&p.buf); err != nil {
+		log.Infof("Failed to start the CPU profile of trace %s: %s", p.traceID, err)
+		p.once.Do(func() { p.args = []interface{}{keyCPUProfileError, err.Error()} })
+		return r
+	}
+	p.timer = time.AfterFunc(maxCPUProfileDuration, func() { p.stop() })
+
+	ctx := pprof.WithLabels(r.Context(), pprof.Labels(cpuProfileLabel, p.traceID))
+	pprof.SetGoroutineLabels(ctx)
+	return r.WithContext(ctx)
+}
+
+// stop stops the profile, if it's running, and writes it to the directory of
+// APPOPTICS_CPU_PROFILE_DIR. It returns the KVs reporting the profile.
+func (p *requestProfile) stop() []interface{} {
+	if p == nil {
+		return nil
+	}
+	p.once.Do(func() {
+		p.timer.Stop()
+		pprof.StopCPUProfile()
+
+		dir := config.GetCPUProfileDir()
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		path := filepath.Join(dir, "appoptics-cpu-"+p.traceID+".pprof")
+		if err := ioutil.WriteFile(path, p.buf.Bytes(), 0600); err != nil {
+			log.Infof("Failed to write the CPU profile of trace %s: %s", p.traceID, err)
+			p.args = []interface{}{keyCPUProfileError, err.Error()}
+			return
+		}
+		p.args = []interface{}{keyCPUProfile, path}
+	})
+	return p.args
+}
+
+// end stops the profile and restores the labels of the goroutine ending the
+// trace, which is usually the one serving the request.
+func (p *requestProfile) end() []interface{} {
+	if p == nil {
+		return nil
+	}
+	pprof.SetGoroutineLabels(p.orig)
+	return p.stop()
+}
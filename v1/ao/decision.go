@@ -0,0 +1,47 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// Decision is the sampling decision of a trace: if it's sampled, why, and the
+// sample rate it's made with, if any.
+type Decision = reporter.Decision
+
+// DecisionReason is why a trace is sampled or not.
+type DecisionReason = reporter.DecisionReason
+
+// The reasons of the sampling decisions
+const (
+	// DecisionSampleRate means the trace is sampled or not by the sample rate.
+	DecisionSampleRate = reporter.DecisionSampleRate
+	// DecisionBucketExhausted means the trace is sampled by the sample rate
+	// but limited by the token bucket.
+	DecisionBucketExhausted = reporter.DecisionBucketExhausted
+	// DecisionTracingDisabled means the tracing is disabled by the settings,
+	// the tracing mode or the transaction filtering.
+	DecisionTracingDisabled = reporter.DecisionTracingDisabled
+	// DecisionUpstream means the sampled flag of the incoming trace context is
+	// honored.
+	DecisionUpstream = reporter.DecisionUpstream
+	// DecisionTriggerTrace means the trace is requested by the X-Trace-Options
+	// header.
+	DecisionTriggerTrace = reporter.DecisionTriggerTrace
+	// DecisionSettingsNotAvailable means no settings are received from the
+	// collector yet.
+	DecisionSettingsNotAvailable = reporter.DecisionSettingsNotAvailable
+)
+
+// LastDecision returns the sampling decision of the trace associated with the
+// context ctx, e.g., to be shown by a debug endpoint. The zero Decision, whose
+// Reason is empty, is returned if there is no trace or the agent is disabled.
+func LastDecision(ctx context.Context) Decision {
+	if t, ok := traceFromContext(ctx); ok {
+		return t.aoContext().GetDecision()
+	}
+	return Decision{}
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastDecision(t *testing.T) {
+	assert.Equal(t, Decision{}, LastDecision(context.Background()))
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("test")
+	assert.Equal(t, Decision{Sampled: true, Reason: DecisionSampleRate, SampleRate: 1000000},
+		LastDecision(NewContext(context.Background(), tr)))
+	md := tr.MetadataString()
+	tr.End()
+
+	// the sampled flag of the incoming context is honored
+	tr = NewTraceFromID("test", md, nil)
+	assert.Equal(t, Decision{Sampled: true, Reason: DecisionUpstream, SampleRate: -1},
+		LastDecision(NewContext(context.Background(), tr)))
+	tr.End()
+
+	tr = NewTraceFromID("test", md[:len(md)-2]+"00", nil)
+	assert.Equal(t, Decision{Sampled: false, Reason: DecisionUpstream, SampleRate: -1},
+		LastDecision(NewContext(context.Background(), tr)))
+	r.Close(4)
+
+	reporter.SetTestReporter(reporter.TestReporterSettingType(reporter.TriggerTraceOnlyST))
+	tr = NewTraceWithOptions("test", SpanOptions{ContextOptions: ContextOptions{XTraceOptions: "trigger-trace"}})
+	assert.Equal(t, Decision{Sampled: true, Reason: DecisionTriggerTrace, SampleRate: -1},
+		LastDecision(NewContext(context.Background(), tr)))
+	tr = NewTrace("test")
+	assert.Equal(t, Decision{Sampled: false, Reason: DecisionTracingDisabled, SampleRate: -1},
+		LastDecision(NewContext(context.Background(), tr)))
+
+	reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(true))
+	tr = NewTrace("test")
+	assert.Equal(t, Decision{Sampled: false, Reason: DecisionSettingsNotAvailable, SampleRate: -1},
+		LastDecision(NewContext(context.Background(), tr)))
+}
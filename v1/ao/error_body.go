@@ -0,0 +1,54 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// keyErrorResponseBody is the key of the captured 5xx response body reported
+// on the exit event.
+const keyErrorResponseBody = "ErrorResponseBody"
+
+// errorBody captures the beginning of a response body, up to limit bytes.
+type errorBody struct {
+	limit int
+	body  string
+}
+
+func (b *errorBody) capture(p []byte) {
+	n := b.limit - len(b.body)
+	if n <= 0 {
+		return
+	}
+	if len(p) > n {
+		p = p[:n]
+		// don't leave a partial UTF-8 character at the end
+		for i := 1; i <= utf8.UTFMax && i <= len(p); i++ {
+			if utf8.RuneStart(p[len(p)-i]) {
+				if !utf8.FullRune(p[len(p)-i:]) {
+					p = p[:len(p)-i]
+				}
+				break
+			}
+		}
+		b.limit = len(b.body) + len(p)
+	}
+	b.body += string(p)
+}
+
+// captureErrorBody starts capturing the response body if the status is 5xx
+// and the capture is enabled. The body is reported on the exit event of the
+// trace.
+func (w *HTTPResponseWriter) captureErrorBody() {
+	if w.StatusCode < http.StatusInternalServerError || !w.t.IsReporting() {
+		return
+	}
+	if n := config.GetErrorBodyCaptureBytes(); n > 0 && w.errBody.limit == 0 {
+		w.errBody.limit = n
+		w.t.AddEndArgs(keyErrorResponseBody, &w.errBody.body)
+	}
+}
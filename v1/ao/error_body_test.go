@@ -0,0 +1,91 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func handlerErrorBody(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("database "))
+	w.Write([]byte("unavailable: connection refused"))
+}
+
+func handlerOKBody(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello"))
+}
+
+func TestHTTPHandlerErrorBodyCapture(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_ERROR_BODY_CAPTURE_BYTES")
+		config.Load()
+	}()
+	os.Setenv("APPOPTICS_ERROR_BODY_CAPTURE_BYTES", "20")
+	config.Load()
+
+	r := reporter.SetTestReporter()
+	w := httpTest(handlerErrorBody)
+	assert.Equal(t, "database unavailable: connection refused", w.Body.String())
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 500, n.Map["Status"])
+			assert.Equal(t, "database unavailable", n.Map["ErrorResponseBody"])
+		}},
+	})
+
+	// not a server error
+	r = reporter.SetTestReporter()
+	httpTest(handlerOKBody)
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "ErrorResponseBody")
+		}},
+	})
+}
+
+func TestHTTPHandlerErrorBodyCaptureUTF8(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_ERROR_BODY_CAPTURE_BYTES")
+		config.Load()
+	}()
+	os.Setenv("APPOPTICS_ERROR_BODY_CAPTURE_BYTES", "4")
+	config.Load()
+
+	r := reporter.SetTestReporter()
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("ab€"))
+	})
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "ab", n.Map["ErrorResponseBody"])
+		}},
+	})
+
+	// disabled by default
+	os.Unsetenv("APPOPTICS_ERROR_BODY_CAPTURE_BYTES")
+	config.Load()
+	r = reporter.SetTestReporter()
+	httpTest(handlerErrorBody)
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "ErrorResponseBody")
+		}},
+	})
+}
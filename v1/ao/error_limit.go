@@ -0,0 +1,85 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+const (
+	// keySuppressedCount reports the number of the error events with the same
+	// fingerprint suppressed since the last one reported.
+	keySuppressedCount = "SuppressedCount"
+
+	errorLimitWindow = time.Minute
+	// the maximum number of the fingerprints tracked, the errors of the new
+	// fingerprints beyond it are not limited
+	maxErrorFingerprints = 1000
+)
+
+// errorEvents limits the error events reported per fingerprint, so an error
+// storm doesn't flood the reporter with the identical events.
+var errorEvents = errorLimiter{m: make(map[errorFingerprint]*errorCount)}
+
+// errorFingerprint identifies the identical errors.
+type errorFingerprint struct {
+	span, typ, class, msg string
+}
+
+type errorCount struct {
+	start      time.Time // the start of the current window
+	reported   int       // the number of the events reported in the window
+	suppressed int       // the number of the events suppressed since the last one reported
+}
+
+type errorLimiter struct {
+	sync.Mutex
+	m map[errorFingerprint]*errorCount
+}
+
+// allow reports if an error event of the fingerprint can be reported now,
+// along with the number of the events suppressed before it.
+func (l *errorLimiter) allow(fp errorFingerprint, now time.Time) (bool, int) {
+	max := config.GetErrorEventsPerMinute()
+	if max == 0 {
+		return true, 0
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	c, ok := l.m[fp]
+	if !ok {
+		if len(l.m) >= maxErrorFingerprints {
+			l.evict(now)
+		}
+		if len(l.m) >= maxErrorFingerprints {
+			return true, 0
+		}
+		c = &errorCount{start: now}
+		l.m[fp] = c
+	}
+	if now.Sub(c.start) >= errorLimitWindow {
+		c.start, c.reported = now, 0
+	}
+	if c.reported >= max {
+		c.suppressed++
+		return false, 0
+	}
+	c.reported++
+	suppressed := c.suppressed
+	c.suppressed = 0
+	return true, suppressed
+}
+
+// evict removes the fingerprints whose windows have passed. The suppressed
+// counts of them are dropped.
+func (l *errorLimiter) evict(now time.Time) {
+	for fp, c := range l.m {
+		if now.Sub(c.start) >= errorLimitWindow {
+			delete(l.m, fp)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setErrorEventsPerMinute(n string) func() {
+	os.Setenv("APPOPTICS_ERROR_EVENTS_PER_MINUTE", n)
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_ERROR_EVENTS_PER_MINUTE")
+		config.Load()
+	}
+}
+
+func TestErrorLimiter(t *testing.T) {
+	l := errorLimiter{m: make(map[errorFingerprint]*errorCount)}
+	fp := errorFingerprint{"span", "exception", "error", "boom"}
+	now := time.Unix(1000, 0)
+
+	// unlimited by default
+	for i := 0; i < 10; i++ {
+		ok, _ := l.allow(fp, now)
+		assert.True(t, ok)
+	}
+	assert.Empty(t, l.m)
+
+	defer setErrorEventsPerMinute("2")()
+	for i := 0; i < 2; i++ {
+		ok, suppressed := l.allow(fp, now)
+		assert.True(t, ok)
+		assert.Zero(t, suppressed)
+	}
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow(fp, now.Add(time.Second))
+		assert.False(t, ok)
+	}
+	// the other fingerprints are not affected
+	ok, _ := l.allow(errorFingerprint{"span", "exception", "error", "bang"}, now)
+	assert.True(t, ok)
+
+	// the first one of the next window reports the suppressed count
+	ok, suppressed := l.allow(fp, now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 3, suppressed)
+	ok, suppressed = l.allow(fp, now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Zero(t, suppressed)
+}
+
+func TestErrorLimiterMaxFingerprints(t *testing.T) {
+	defer setErrorEventsPerMinute("1")()
+	l := errorLimiter{m: make(map[errorFingerprint]*errorCount)}
+	now := time.Unix(1000, 0)
+	for i := 0; i < maxErrorFingerprints; i++ {
+		ok, _ := l.allow(errorFingerprint{msg: fmt.Sprint(i)}, now)
+		assert.True(t, ok)
+	}
+	// the new ones are not limited once it's full
+	fp := errorFingerprint{msg: "new"}
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow(fp, now)
+		assert.True(t, ok)
+	}
+	assert.Len(t, l.m, maxErrorFingerprints)
+
+	// the expired ones are evicted to make room
+	ok, _ := l.allow(fp, now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Len(t, l.m, 1)
+	ok, _ = l.allow(fp, now.Add(time.Minute))
+	assert.False(t, ok)
+}
+
+func TestErrorEventsSuppressed(t *testing.T) {
+	defer setErrorEventsPerMinute("1")()
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("errorStorm"))
+	s, _ := BeginSpan(ctx, "stormy")
+	for i := 0; i < 5; i++ {
+		s.Err(errors.New("the same error"))
+	}
+	s.End()
+	EndTrace(ctx)
+	r.Close(5)
+
+	g.AssertGraph(t, r.EventBufs, 5, g.AssertNodeMap{
+		{"errorStorm", "entry"}: {},
+		{"stormy", "entry"}:     {Edges: g.Edges{{"errorStorm", "entry"}}},
+		{"stormy", "error"}: {Edges: g.Edges{{"stormy", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "the same error", n.Map["ErrorMsg"])
+			assert.NotContains(t, n.Map, keySuppressedCount)
+		}},
+		{"stormy", "exit"}:     {Edges: g.Edges{{"stormy", "error"}}},
+		{"errorStorm", "exit"}: {Edges: g.Edges{{"stormy", "exit"}, {"errorStorm", "entry"}}},
+	})
+}
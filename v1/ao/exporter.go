@@ -0,0 +1,26 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+
+// Exporter is a reporter implemented by a third party, which receives the KVs
+// of the events reported, see RegisterExporter.
+type Exporter = reporter.Exporter
+
+// ExporterFactory creates the Exporter when the reporter is initialized.
+type ExporterFactory = reporter.ExporterFactory
+
+// RegisterExporter registers a custom exporter, which is used as the reporter
+// if its name is in APPOPTICS_REPORTER, either alone or in a comma-separated
+// list of the reporters, e.g., "ssl,kafka". The names are case-insensitive and
+// the names of the built-in reporters are reserved. The custom metrics and the
+// metrics of the spans are not passed to the exporters.
+//
+// It should be called from an init function, before any trace is started:
+//   func init() {
+//       _ = ao.RegisterExporter("kafka", newKafkaExporter)
+//   }
+func RegisterExporter(name string, factory ExporterFactory) error {
+	return reporter.Register(name, factory)
+}
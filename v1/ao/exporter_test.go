@@ -0,0 +1,14 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterExporter(t *testing.T) {
+	assert.Error(t, RegisterExporter("ssl", func() (Exporter, error) { return nil, nil }))
+	assert.Error(t, RegisterExporter("", nil))
+}
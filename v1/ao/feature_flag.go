@@ -0,0 +1,36 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "context"
+
+// keyFeatureFlagPrefix is the prefix of the KV reporting an evaluated feature flag.
+const keyFeatureFlagPrefix = "FeatureFlag."
+
+// RecordFeatureFlag records the variant of a feature flag evaluated while serving
+// the trace bound to ctx. The flag is attached to the exit event of the root span
+// as the KV "FeatureFlag.<flag>", so performance regressions can be correlated
+// with flag rollouts. If a flag is evaluated more than once in a trace, the last
+// variant is reported.
+func RecordFeatureFlag(ctx context.Context, flag, variant string) {
+	if flag == "" {
+		return
+	}
+	runTraceCtx(ctx, func(t Trace) {
+		if at, ok := t.(*aoTrace); ok {
+			at.recordFeatureFlag(flag, variant)
+		}
+	})
+}
+
+func (t *aoTrace) recordFeatureFlag(flag, variant string) {
+	if !t.ok() {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.featureFlags == nil {
+		t.featureFlags = make(map[string]string)
+	}
+	t.featureFlags[flag] = variant
+}
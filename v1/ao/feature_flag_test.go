@@ -0,0 +1,43 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordFeatureFlag(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	l, ctxL := ao.BeginSpan(ctx, "child")
+	ao.RecordFeatureFlag(ctxL, "new-checkout", "on")
+	ao.RecordFeatureFlag(ctxL, "new-checkout", "off")
+	ao.RecordFeatureFlag(ctxL, "", "ignored")
+	ao.RecordFeatureFlag(context.Background(), "no-trace", "ignored")
+	ao.RecordFeatureFlag(ctx, "theme", "dark")
+	l.End()
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"test", "entry"}:  {},
+		{"child", "entry"}: {Edges: g.Edges{{"test", "entry"}}},
+		{"child", "exit"}: {Edges: g.Edges{{"child", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "FeatureFlag.new-checkout")
+		}},
+		{"test", "exit"}: {Edges: g.Edges{{"child", "exit"}, {"test", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "off", n.Map["FeatureFlag.new-checkout"])
+			assert.Equal(t, "dark", n.Map["FeatureFlag.theme"])
+			assert.NotContains(t, n.Map, "FeatureFlag.")
+			assert.NotContains(t, n.Map, "FeatureFlag.no-trace")
+		}},
+	})
+}
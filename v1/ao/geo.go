@@ -0,0 +1,171 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// The KVs reporting the location of the client of a trace
+const (
+	keyGeoCountry = "Geo.Country"
+	keyGeoRegion  = "Geo.Region"
+	keyGeoCity    = "Geo.City"
+)
+
+const (
+	// the number of goroutines resolving the client IPs
+	geoWorkers = 4
+	// the max number of pending lookups, beyond which the traces are not
+	// enriched
+	geoQueueSize = 1024
+)
+
+// GeoLocation is the location of a client IP.
+type GeoLocation struct {
+	// Country is the ISO code of the country, e.g., "US"
+	Country string
+	// Region is the region or subdivision of the country, e.g., "CA"
+	Region string
+	// City is the name of the city, if any
+	City string
+}
+
+// GeoResolver maps the IP of a client to its location, e.g., by looking it up
+// in a MaxMind database. It returns false if the location is unknown.
+type GeoResolver func(ip net.IP) (GeoLocation, bool)
+
+var geo struct {
+	sync.RWMutex
+	resolve GeoResolver
+	queue   chan *geoLookup
+}
+
+// SetGeoResolver enables the enrichment of the HTTP traces with the location
+// of the client, as resolved by r from the client IP, which is the first one of
+// the X-Forwarded-For header, or the remote address of the request. The
+// location is reported by the Geo.Country, Geo.Region and Geo.City KVs of the
+// exit event of the root span, so the latency can be segmented geographically:
+//   ao.SetGeoResolver(func(ip net.IP) (ao.GeoLocation, bool) {
+//   	rec, err := db.City(ip)
+//   	if err != nil {
+//   		return ao.GeoLocation{}, false
+//   	}
+//   	return ao.GeoLocation{Country: rec.Country.IsoCode}, true
+//   })
+// The lookups are started with the sampled traces and run by a few background
+// goroutines, so a slow resolver doesn't delay the requests. The traces ended
+// before the lookup completes, or started when too many of them are pending,
+// are not enriched. Passing nil disables the enrichment.
+func SetGeoResolver(r GeoResolver) {
+	geo.Lock()
+	defer geo.Unlock()
+	geo.resolve = r
+	if r != nil && geo.queue == nil {
+		geo.queue = make(chan *geoLookup, geoQueueSize)
+		for i := 0; i < geoWorkers; i++ {
+			go resolveGeo(geo.queue)
+		}
+	}
+}
+
+// geoLookup is the pending lookup of the location of a client IP.
+type geoLookup struct {
+	ip   net.IP
+	done chan struct{}
+	loc  GeoLocation
+	ok   bool
+}
+
+// lookupGeo queues the lookup of the location of the client of the request.
+// It returns nil if the resolver is not set, the client IP is unknown or the
+// queue is full.
+func lookupGeo(r *http.Request) *geoLookup {
+	geo.RLock()
+	defer geo.RUnlock()
+	if geo.resolve == nil {
+		return nil
+	}
+	ip := clientIP(r)
+	if ip == nil {
+		return nil
+	}
+	l := &geoLookup{ip: ip, done: make(chan struct{})}
+	select {
+	case geo.queue <- l:
+		return l
+	default:
+		log.Debugf("Geo lookup of %s dropped: too many pending lookups", ip)
+		return nil
+	}
+}
+
+// resolveGeo runs the lookups of the queue q.
+func resolveGeo(q chan *geoLookup) {
+	for l := range q {
+		geo.RLock()
+		resolve := geo.resolve
+		geo.RUnlock()
+		if resolve != nil {
+			l.resolve(resolve)
+		}
+		close(l.done)
+	}
+}
+
+func (l *geoLookup) resolve(resolve GeoResolver) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Warningf("Geo resolver panicked for %s: %v", l.ip, err)
+			l.ok = false
+		}
+	}()
+	l.loc, l.ok = resolve(l.ip)
+}
+
+// args returns the KVs of the location, if it's resolved already.
+func (l *geoLookup) args() []interface{} {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.done:
+	default:
+		return nil
+	}
+	if !l.ok {
+		return nil
+	}
+	var args []interface{}
+	for k, v := range map[string]string{
+		keyGeoCountry: l.loc.Country,
+		keyGeoRegion:  l.loc.Region,
+		keyGeoCity:    l.loc.City,
+	} {
+		if v != "" {
+			args = append(args, k, v)
+		}
+	}
+	return args
+}
+
+// clientIP returns the IP of the client of the request.
+func clientIP(r *http.Request) net.IP {
+	addr := r.RemoteAddr
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		addr = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "10.0.0.1", clientIP(r).String())
+
+	r.Header.Set("X-Forwarded-For", " 203.0.113.7, 10.0.0.2")
+	assert.Equal(t, "203.0.113.7", clientIP(r).String())
+
+	r.Header.Set("X-Forwarded-For", "unknown")
+	assert.Nil(t, clientIP(r))
+}
+
+func TestGeoResolver(t *testing.T) {
+	block := make(chan struct{})
+	SetGeoResolver(func(ip net.IP) (GeoLocation, bool) {
+		switch ip.String() {
+		case "203.0.113.7":
+			return GeoLocation{Country: "NZ", Region: "AUK"}, true
+		case "203.0.113.8":
+			<-block
+			return GeoLocation{Country: "US"}, true
+		case "203.0.113.9":
+			panic("bad database")
+		}
+		return GeoLocation{}, false
+	})
+	defer SetGeoResolver(nil)
+	defer close(block)
+
+	r := reporter.SetTestReporter()
+	h := HTTPHandler(func(w http.ResponseWriter, req *http.Request) {
+		if tr, ok := traceFromContext(req.Context()); ok && req.URL.Path != "/slow" {
+			<-tr.(*aoTrace).geo.done
+		}
+	})
+	for path, ip := range map[string]string{
+		"/resolved": "203.0.113.7",
+		"/slow":     "203.0.113.8",
+		"/panic":    "203.0.113.9",
+		"/unknown":  "198.51.100.1",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		h(httptest.NewRecorder(), req)
+	}
+	r.Close(8)
+
+	var resolved int
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Count: 4},
+		{"http.HandlerFunc", "exit"}: {Count: 4, Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			if _, ok := n.Map[keyGeoCountry]; !ok {
+				assert.NotContains(t, n.Map, keyGeoRegion)
+				return
+			}
+			resolved++
+			assert.Equal(t, "NZ", n.Map[keyGeoCountry])
+			assert.Equal(t, "AUK", n.Map[keyGeoRegion])
+			assert.NotContains(t, n.Map, keyGeoCity)
+		}},
+	})
+	assert.Equal(t, 1, resolved)
+}
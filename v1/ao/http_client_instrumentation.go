@@ -5,8 +5,13 @@ package ao
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 )
 
 // HTTPClientSpan is a Span that aids in reporting HTTP client requests.
@@ -14,27 +19,158 @@ import (
 //   l := ao.BeginHTTPClientSpan(ctx, httpReq)
 //   defer l.End()
 //   // ...
-//   resp, err := client.Do(req)
+//   resp, err := client.Do(l.Request())
 //   l.AddHTTPResponse(resp, err)
 //   // ...
-type HTTPClientSpan struct{ Span }
+type HTTPClientSpan struct {
+	Span
+	peer           string        // the host of the request
+	req            *http.Request // the request marked as instrumented
+	throttleMetric bool
+}
+
+// The KVs of the throttled responses and the metric counting them
+const (
+	keyRetryAfter         = "RetryAfter"
+	keyRateLimitRemaining = "RateLimitRemaining"
+	keyRateLimitReset     = "RateLimitReset"
+
+	httpClientThrottledMetric = "AppOptics.HTTPClient.Throttled"
+)
 
 // BeginHTTPClientSpan stores trace metadata in the headers of an HTTP client request, allowing the
 // trace to be continued on the other end. It returns a Span that must have End() called to
 // benchmark the client request, and should have AddHTTPResponse(r, err) called to process response
 // metadata.
-func BeginHTTPClientSpan(ctx context.Context, req *http.Request) HTTPClientSpan {
+//
+// The trace metadata is only stored if the request's host matches the allowlist configured by
+// APPOPTICS_PROPAGATE_HOSTS or WithPropagateHosts, so trace headers are not leaked to third-party
+// APIs. All hosts are allowed if neither is set. Nothing is stored if the tracing is suppressed by
+// SuppressTracing. The request passed is not replaced, the copy of it marked as instrumented in its
+// context is returned by HTTPClientSpan.Request, see HTTPClientSpanStarted.
+func BeginHTTPClientSpan(ctx context.Context, req *http.Request, opts ...SpanOpt) HTTPClientSpan {
+	return beginHTTPClientSpan(ctx, "http.Client", req, opts...)
+}
+
+func beginHTTPClientSpan(ctx context.Context, spanName string, req *http.Request, opts ...SpanOpt) HTTPClientSpan {
 	if req != nil {
-		l := BeginRemoteURLSpan(ctx, "http.Client", req.URL.String(), "HTTPMethod", req.Method)
-		req.Header.Set(HTTPHeaderName, l.MetadataString())
-		return HTTPClientSpan{Span: l}
+		so := &SpanOptions{Kind: SpanKindClient}
+		for _, f := range opts {
+			f(so)
+		}
+		if so.SpanNamer != nil {
+			if name := so.SpanNamer(req); name != "" {
+				spanName = name
+			}
+		}
+		args := mergeKVs([]interface{}{"HTTPMethod", req.Method}, lazyKVs(FromContext(ctx).IsSampled(), so.CB))
+		l := beginRemoteURLSpan(ctx, spanName, req.URL.String(), so.Kind, args...)
+		hosts := so.PropagateHosts
+		if hosts == nil {
+			hosts = config.GetPropagateHosts()
+		}
+		if !tracingSuppressed(ctx) && hostAllowed(hosts, req.URL.Hostname()) {
+			md := l.MetadataString()
+			req.Header.Set(HTTPHeaderName, md)
+			if sig := SignMetadata(md); sig != "" {
+				req.Header.Set(HTTPHeaderXTraceSignature, sig)
+			}
+		}
+		marked := req
+		if !HTTPClientSpanStarted(req) {
+			marked = req.WithContext(context.WithValue(req.Context(), contextHTTPClientSpanKey, true))
+		}
+		return HTTPClientSpan{Span: l, peer: req.URL.Host, req: marked, throttleMetric: so.ThrottleMetric}
 	}
 	return HTTPClientSpan{Span: nullSpan{}}
 }
 
+// Request returns a shallow copy of the request passed to BeginHTTPClientSpan,
+// sharing its headers, whose context marks it as instrumented. It's the
+// request to make, so the instrumented transports, e.g., the one of aohttp,
+// don't report it again. It returns nil if the request passed is nil.
+func (s HTTPClientSpan) Request() *http.Request {
+	return s.req
+}
+
+// HTTPClientSpanStarted reports if req is the HTTPClientSpan.Request of a span
+// started by BeginHTTPClientSpan, or derived from it, whether the trace context
+// headers are injected or not, so the instrumented transports don't report the
+// same request twice.
+func HTTPClientSpanStarted(req *http.Request) bool {
+	started, _ := req.Context().Value(contextHTTPClientSpanKey).(bool)
+	return started
+}
+
+// WithPropagateHosts returns a function that sets the host patterns the trace
+// context headers are injected into by BeginHTTPClientSpan. A pattern is either
+// a host name, or a wildcard "*.example.com" which matches all the subdomains
+// of example.com. Calling it without any pattern disables the injection.
+//   l := ao.BeginHTTPClientSpan(ctx, req, ao.WithPropagateHosts("*.example.com"))
+func WithPropagateHosts(hosts ...string) SpanOpt {
+	return func(o *SpanOptions) {
+		o.PropagateHosts = make([]string, 0, len(hosts))
+		for _, h := range hosts {
+			o.PropagateHosts = append(o.PropagateHosts, strings.ToLower(h))
+		}
+	}
+}
+
+// WithThrottleMetric returns a function that enables the
+// AppOptics.HTTPClient.Throttled metric, which counts the 429 and 503 responses
+// by the peer host and status, to make the throttling by third-party APIs
+// obvious on the dashboards.
+//   l := ao.BeginHTTPClientSpan(ctx, req, ao.WithThrottleMetric())
+func WithThrottleMetric() SpanOpt {
+	return func(o *SpanOptions) {
+		o.ThrottleMetric = true
+	}
+}
+
+// WithSpanNamer returns a function that names the spans of the requests by
+// namer, e.g., after the operations of the RPC-style APIs sharing the same
+// URL, instead of http.Client. The default name is kept if namer returns an
+// empty string.
+//   l := ao.BeginHTTPClientSpan(ctx, req, ao.WithSpanNamer(func(r *http.Request) string {
+//       return r.Header.Get("X-Operation")
+//   }))
+func WithSpanNamer(namer func(*http.Request) string) SpanOpt {
+	return func(o *SpanOptions) {
+		o.SpanNamer = namer
+	}
+}
+
+// PropagatesToHost reports if the trace context headers may be injected into
+// the requests to the host by APPOPTICS_PROPAGATE_HOSTS, which allows all the
+// hosts if it's not set. It's for the instrumentations injecting the headers
+// themselves rather than by BeginHTTPClientSpan.
+func PropagatesToHost(host string) bool {
+	return hostAllowed(config.GetPropagateHosts(), host)
+}
+
+// hostAllowed checks if the host matches any of the patterns. A nil pattern
+// list allows all hosts.
+func hostAllowed(patterns []string, host string) bool {
+	if patterns == nil {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "*.") {
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
 // AddHTTPResponse adds information from http.Response to this span. It will also check the HTTP
 // response headers and propagate any valid distributed trace context from the end of the HTTP
-// server's span to this one.
+// server's span to this one. For the 429 and 503 responses, the Retry-After and
+// X-RateLimit-Remaining/Reset headers are reported as well.
 func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {
 	if l.ok() {
 		if err != nil {
@@ -45,6 +181,61 @@ func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {
 			if md := resp.Header.Get(HTTPHeaderName); md != "" {
 				l.AddEndArgs(keyEdge, md)
 			}
+			l.addThrottling(resp)
 		}
 	}
 }
+
+// addThrottling reports the rate limiting information of a throttled response.
+func (l HTTPClientSpan) addThrottling(resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, ok := parseRetryAfter(ra, time.Now()); ok {
+			l.AddEndArgs(keyRetryAfter, secs)
+		} else {
+			l.AddEndArgs(keyRetryAfter, ra)
+		}
+	}
+	// the formats of the reset time vary, e.g., seconds or a Unix timestamp,
+	// so they're reported as is
+	if v := resp.Header.Get("X-RateLimit-Remaining"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			l.AddEndArgs(keyRateLimitRemaining, n)
+		} else {
+			l.AddEndArgs(keyRateLimitRemaining, v)
+		}
+	}
+	if v := resp.Header.Get("X-RateLimit-Reset"); v != "" {
+		l.AddEndArgs(keyRateLimitReset, v)
+	}
+
+	if l.throttleMetric {
+		_ = IncrementMetric(httpClientThrottledMetric, MetricOptions{
+			Count:   1,
+			HostTag: true,
+			Tags: map[string]string{
+				"Peer":   l.peer,
+				"Status": strconv.Itoa(resp.StatusCode),
+			},
+		})
+	}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either the seconds
+// to wait or an HTTP date, into the seconds to wait.
+func parseRetryAfter(v string, now time.Time) (int64, bool) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		return secs, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	secs := int64(t.Sub(now) / time.Second)
+	if secs < 0 {
+		secs = 0
+	}
+	return secs, true
+}
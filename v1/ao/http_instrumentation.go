@@ -11,11 +11,12 @@ import (
 	"net/url"
 	"reflect"
 	"runtime"
-	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 )
 
@@ -29,29 +30,179 @@ const (
 	// HTTPHeaderXTraceOptionsSignature is a constant for the HTTP headers to propagate
 	// X-Trace-Options-Signature values. It contains the response codes for X-Trace-Options
 	HTTPHeaderXTraceOptionsSignature = reporter.HTTPHeaderXTraceOptionsSignature
-	httpHandlerSpanName              = "http.HandlerFunc"
+	// HTTPHeaderXTraceSignature is a constant for the HTTP header to propagate the
+	// signature of the X-Trace header, see SignMetadata.
+	HTTPHeaderXTraceSignature = reporter.HTTPHeaderXTraceSignature
+	httpHandlerSpanName       = "http.HandlerFunc"
 )
 
+// The transaction names of the requests not matching any route. They're used
+// instead of the paths of the 404 and 405 responses, which are chosen by the
+// clients and may explode the number of the transactions.
+const (
+	TransactionNameNotFound         = "NotFound"
+	TransactionNameMethodNotAllowed = "MethodNotAllowed"
+)
+
+// SetRouteUnmatched marks the request traced by the trace bound to the context
+// ctx as not matching any route, which is called by the routers or the
+// framework middlewares. Its transaction is named NotFound or MethodNotAllowed
+// if the response is a 404 or 405, unless a custom name is set, e.g., by
+// SetTransactionName. The other 404 and 405 responses, e.g., of a handler
+// which doesn't find the requested resource, keep the names of their routes.
+// The requests not matching any pattern of an http.ServeMux wrapped by
+// WrapHTTPHandler are marked automatically.
+func SetRouteUnmatched(ctx context.Context) {
+	runTraceCtx(ctx, func(t Trace) {
+		if at, ok := t.(*aoTrace); ok {
+			at.setRouteUnmatched()
+		}
+	})
+}
+
+func (t *aoTrace) setRouteUnmatched() {
+	if !t.ok() {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.httpSpan.unmatched = true
+}
+
+// unmatchedRouteTxnName returns the transaction name of a response which
+// doesn't match any route, or an empty string for the other responses.
+func unmatchedRouteTxnName(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return TransactionNameNotFound
+	case http.StatusMethodNotAllowed:
+		return TransactionNameMethodNotAllowed
+	default:
+		return ""
+	}
+}
+
+// NotFoundHandler returns a handler replying with 404 and naming the
+// transaction NotFound, which can be used as the not-found handler of a
+// router, e.g., mux.Router.NotFoundHandler of gorilla/mux, to give the
+// unmatched requests the same transaction name regardless of how the routes
+// are named.
+func NotFoundHandler() http.Handler {
+	return unmatchedRouteHandler(http.StatusNotFound, TransactionNameNotFound)
+}
+
+// MethodNotAllowedHandler returns a handler replying with 405 and naming the
+// transaction MethodNotAllowed, see NotFoundHandler.
+func MethodNotAllowedHandler() http.Handler {
+	return unmatchedRouteHandler(http.StatusMethodNotAllowed, TransactionNameMethodNotAllowed)
+}
+
+func unmatchedRouteHandler(status int, txnName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = SetTransactionName(r.Context(), txnName)
+		http.Error(w, http.StatusText(status), status)
+	})
+}
+
 // key used for HTTP span to indicate a new context
 var httpSpanKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.HTTPSpan")
 
 // HTTPHandler wraps an http.HandlerFunc with entry / exit events,
 // returning a new handler that can be used in its place.
 //   http.HandleFunc("/path", ao.HTTPHandler(myHandler))
+// A handler wrapped more than once, or wrapped inside a handler already traced
+// by TraceFromHTTPRequestResponse, is only traced by the outermost one. The
+// traces of the requests routed by http.ServeMux are named after the route
+// patterns, see RoutePattern for the Go version required.
 func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanOpt) func(http.ResponseWriter, *http.Request) {
-	// At wrap time (when binding handler to router): get name of wrapped handler func
-	var endArgs []interface{}
-	if f := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); f != nil {
-		// e.g. "main.slowHandler", "github.com/appoptics/appoptics-apm-go/v1/ao_test.handler404"
-		fname := f.Name()
-		if s := strings.SplitN(fname[strings.LastIndex(fname, "/")+1:], ".", 2); len(s) == 2 {
-			endArgs = append(endArgs, "Controller", s[0], "Action", s[1])
+	return wrapHTTPHandler(http.HandlerFunc(handler), wrapLocation(2), opts)
+}
+
+// WrapHTTPHandler wraps an http.Handler with entry / exit events as
+// HTTPHandler does, so the handlers which aren't functions, e.g., routers and
+// the handlers built by middleware chains, can be traced without converting
+// them to http.HandlerFunc.
+//   http.Handle("/api/", ao.WrapHTTPHandler(apiRouter))
+// The Controller and Action KVs are the package and the type of the handler,
+// or the ones set by WithControllerAction.
+func WrapHTTPHandler(handler http.Handler, opts ...SpanOpt) http.Handler {
+	return wrapHTTPHandler(handler, wrapLocation(2), opts)
+}
+
+// WithControllerAction returns a function that sets the Controller and Action
+// KVs of the requests traced by HTTPHandler or WrapHTTPHandler, instead of the
+// ones derived from the name of the handler, which may be meaningless for the
+// handlers built at runtime, e.g., "func1" for a closure.
+//   http.Handle("/users", ao.WrapHTTPHandler(h, ao.WithControllerAction("users", "list")))
+func WithControllerAction(controller, action string) SpanOpt {
+	return func(o *SpanOptions) {
+		o.Controller = controller
+		o.Action = action
+	}
+}
+
+// wrapLocation returns where the handler is wrapped, to identify the duplicate
+// wraps, skip is the number of the stack frames to skip as of runtime.Caller.
+func wrapLocation(skip int) string {
+	if _, file, line, ok := runtime.Caller(skip); ok {
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return "unknown"
+}
+
+// handlerControllerAction returns the Controller and Action KVs of the handler
+// derived from its name, e.g., "main" and "slowHandler" of the function
+// main.slowHandler, or "api" and "Router" of the type *api.Router. The names
+// set by WithControllerAction take precedence.
+func handlerControllerAction(handler http.Handler, o *SpanOptions) []interface{} {
+	if o.Controller != "" || o.Action != "" {
+		return []interface{}{keyController, o.Controller, keyAction, o.Action}
+	}
+	if f, ok := handler.(http.HandlerFunc); ok {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			// e.g. "main.slowHandler", "github.com/appoptics/appoptics-apm-go/v1/ao_test.handler404"
+			fname := fn.Name()
+			if s := strings.SplitN(fname[strings.LastIndex(fname, "/")+1:], ".", 2); len(s) == 2 {
+				return []interface{}{keyController, s[0], keyAction, s[1]}
+			}
 		}
+		return nil
+	}
+	t := reflect.TypeOf(handler)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	if t == nil || t.Name() == "" || t.PkgPath() == "" {
+		return nil
+	}
+	pkg := t.PkgPath()
+	return []interface{}{keyController, pkg[strings.LastIndex(pkg, "/")+1:], keyAction, t.Name()}
+}
+
+// wrapHTTPHandler returns the handler tracing the requests served by handler,
+// which is wrapped at wrapLoc.
+func wrapHTTPHandler(handler http.Handler, wrapLoc string, opts []SpanOpt) http.HandlerFunc {
+	EnableIntegration(IntegrationHTTPHandler)
+	var dupOnce sync.Once
+	// At wrap time (when binding handler to router): get name of wrapped handler
+	so := &SpanOptions{}
+	for _, f := range opts {
+		f(so)
+	}
+	endArgs := handlerControllerAction(handler, so)
 	// return wrapped HTTP request handler
 	return func(w http.ResponseWriter, r *http.Request) {
-		if Closed() {
-			handler(w, r)
+		if Closed() || IntegrationDisabled(IntegrationHTTPHandler) {
+			handler.ServeHTTP(w, r.WithContext(WithTraceValues(r.Context())))
+			return
+		}
+		// the request is traced by an outer wrapper already, unless it's
+		// re-dispatched by InternalRedispatch
+		if traced, _ := r.Context().Value(httpSpanKey).(bool); traced && redispatchedFrom(r) == "" {
+			dupOnce.Do(func() {
+				log.Debugf("The HTTP handler wrapped at %s is traced already, skipped.", wrapLoc)
+			})
+			handler.ServeHTTP(w, r)
 			return
 		}
 
@@ -65,7 +216,14 @@ func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanO
 			}
 		}()
 		// Call original HTTP handler
-		handler(w, r)
+		handler.ServeHTTP(w, r)
+		if mux, ok := handler.(*http.ServeMux); ok {
+			if rw, ok := w.(*HTTPResponseWriter); ok && unmatchedRouteTxnName(rw.StatusCode) != "" {
+				if _, pattern := mux.Handler(r); pattern == "" {
+					SetRouteUnmatched(r.Context())
+				}
+			}
+		}
 	}
 }
 
@@ -79,8 +237,16 @@ func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanO
 //       defer tr.End()
 //       // ...
 //   }
+// The requests of the paths excluded by APPOPTICS_EXCLUDED_PATHS, by default
+// /debug/pprof/* and /metrics, are neither traced nor counted by the metrics,
+// and the http.ResponseWriter is returned as is. The context of the request is
+// bound to a store of the values of SetTraceValue only.
 func TraceFromHTTPRequestResponse(spanName string, w http.ResponseWriter, r *http.Request, opts ...SpanOpt) (Trace, http.ResponseWriter,
 	*http.Request) {
+	if IsExcludedPath(r.URL.Path) {
+		return &nullTrace{}, w, r.WithContext(WithTraceValues(r.Context()))
+	}
+	setupStart := time.Now()
 
 	// determine if this is a new context, if so set flag isNewContext to start a new HTTP Span
 	isNewContext := false
@@ -90,38 +256,114 @@ func TraceFromHTTPRequestResponse(spanName string, w http.ResponseWriter, r *htt
 		isNewContext = true
 	}
 
-	t := traceFromHTTPRequest(spanName, r, isNewContext, opts...)
+	// only the outermost handler of the request consults the LoadShedder
+	shed := isNewContext && consultLoadShedder(r)
+	if shed {
+		r = r.WithContext(context.WithValue(r.Context(), loadShedKey, true))
+	}
+
+	parentMd := redispatchedFrom(r)
+	if parentMd != "" {
+		// only the first handler of the re-dispatched request is traced
+		r = r.WithContext(context.WithValue(r.Context(), httpRedispatchKey, ""))
+	}
+
+	t := traceFromHTTPRequest(spanName, r, isNewContext, parentMd, opts...)
 
 	// Associate the trace with http.Request to expose it to the handler
 	r = r.WithContext(NewContext(r.Context(), t))
+	if at, ok := t.(*aoTrace); ok {
+		if at.aoCtx.ProfileRequested() {
+			r = at.startCPUProfile(r)
+		}
+		// the pattern is set once the request is routed by http.ServeMux,
+		// so it's looked up when the trace ends
+		at.httpSpan.req = r
+		if isNewContext {
+			at.httpSpan.load = startRequestLoad(shed)
+		}
+		if shed {
+			at.AddEndArgs(keyLoadShed, true)
+		}
+	}
 
 	wrapper := newResponseWriter(w, t) // wrap writer with response-observing writer
+	if at, ok := t.(*aoTrace); ok {
+		at.httpSpan.rsp = wrapper
+	}
+	so := &SpanOptions{}
+	for _, f := range opts {
+		f(so)
+	}
+	wrapper.rumCorrelation = so.RUMCorrelation
 	for k, v := range t.HTTPRspHeaders() {
 		wrapper.Header().Set(k, v)
 	}
+	if at, ok := t.(*aoTrace); ok {
+		at.httpSpan.setupTime = time.Since(setupStart)
+		at.httpSpan.handlerStart = time.Now()
+	}
 
 	return t, wrapper, r
 }
 
+// IsExcludedPath reports if the requests of the URL path are excluded from
+// tracing and metrics by APPOPTICS_EXCLUDED_PATHS, by default /debug/pprof/*
+// and /metrics. It's for the middlewares of the frameworks starting the traces
+// themselves rather than by TraceFromHTTPRequestResponse.
+func IsExcludedPath(path string) bool {
+	return config.IsExcludedPath(path)
+}
+
+// RoutePattern returns the pattern of the http.ServeMux route matching the
+// request, e.g., "GET /users/{id}", or an empty string if it's not routed by
+// http.ServeMux or built before Go 1.22. The traces of the requests routed by
+// http.ServeMux are named after the patterns unless the transaction names are
+// set explicitly, whether the handlers are wrapped by HTTPHandler or the
+// ServeMux itself is:
+//   mux := http.NewServeMux()
+//   mux.HandleFunc("GET /users/{id}", getUser)
+//   http.ListenAndServe(":8080", ao.HTTPHandler(mux.ServeHTTP))
+// http.Request.Pattern is only set if the go directive of the go.mod of the
+// main module is 1.22 or later, or GODEBUG=httpmuxgo121=0 is set, otherwise
+// http.ServeMux keeps the routing of Go 1.21 and the pattern is empty.
+func RoutePattern(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	return requestPattern(r)
+}
+
 // HTTPResponseWriter observes an http.ResponseWriter when WriteHeader() or Write() is called to
 // check the status code and response headers.
 type HTTPResponseWriter struct {
-	Writer      http.ResponseWriter
-	t           Trace
-	StatusCode  int
-	WroteHeader bool
+	Writer         http.ResponseWriter
+	t              Trace
+	StatusCode     int
+	WroteHeader    bool
+	rumCorrelation bool
+	errBody        errorBody
 }
 
 func (w *HTTPResponseWriter) Write(p []byte) (n int, err error) {
 	if !w.WroteHeader {
+		if w.rumCorrelation && w.Header().Get("Content-Type") == "" {
+			// the same as what net/http does if Content-Type is not set
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
 		w.WriteHeader(w.StatusCode)
 	}
+	w.errBody.capture(p)
 	return w.Writer.Write(p)
 }
 
 // Header implements the http.ResponseWriter interface.
 func (w *HTTPResponseWriter) Header() http.Header { return w.Writer.Header() }
 
+// Unwrap returns the wrapped http.ResponseWriter, so http.ResponseController
+// can reach its optional methods, e.g., SetWriteDeadline and EnableFullDuplex.
+func (w *HTTPResponseWriter) Unwrap() http.ResponseWriter { return w.Writer }
+
 // WriteHeader implements the http.ResponseWriter interface.
 func (w *HTTPResponseWriter) WriteHeader(status int) {
 	w.StatusCode = status                // observe HTTP status code
@@ -133,6 +375,9 @@ func (w *HTTPResponseWriter) WriteHeader(status int) {
 		}
 		w.Header().Set(HTTPHeaderName, w.t.ExitMetadata()) // replace downstream MD with ours
 	}
+	w.addRUMCorrelation()
+	w.addServerTiming()
+	w.captureErrorBody()
 	w.WroteHeader = true
 	w.Writer.WriteHeader(status)
 }
@@ -151,9 +396,10 @@ func newResponseWriter(writer http.ResponseWriter, t Trace) *HTTPResponseWriter
 }
 
 // traceFromHTTPRequest returns a Trace, given an http.Request. If a distributed trace is described
-// in the "X-Trace" header, this context will be continued.
-func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, opts ...SpanOpt) Trace {
-	so := &SpanOptions{}
+// in the "X-Trace" header, this context will be continued. The request re-dispatched internally
+// continues parentMd instead, if it's not empty.
+func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, parentMd string, opts ...SpanOpt) Trace {
+	so := &SpanOptions{Kind: SpanKindServer}
 	for _, f := range opts {
 		f(so)
 	}
@@ -168,14 +414,37 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 		}
 	}
 
+	qt, hasQueueTime := queueTime(r, time.Now())
+	if hasQueueTime && isNewContext {
+		recordQueueTime(qt)
+	}
+
+	ic := extractContext(r.Header)
+	if parentMd != "" {
+		ic = incomingContext{md: parentMd}
+	}
+	xtOpts := r.Header.Get(HTTPHeaderXTraceOptions)
+	xtOptsSig := r.Header.Get(HTTPHeaderXTraceOptionsSignature)
+	mdSig := r.Header.Get(HTTPHeaderXTraceSignature)
+	replay, isReplay := replayedWith(r)
+	if isReplay && parentMd == "" {
+		ic = incomingContext{md: replay.MdStr}
+		xtOpts, xtOptsSig, mdSig = replay.XTraceOptions, replay.XTraceOptionsSignature, replay.MdSignature
+	}
+
 	// start trace, passing in metadata header
 	t := NewTraceWithOptions(spanName, SpanOptions{
 		WithBackTrace: false,
+		Kind:          so.Kind,
 		ContextOptions: reporter.ContextOptions{
-			MdStr:                  r.Header.Get(HTTPHeaderName),
+			MdStr:                  ic.md,
 			URL:                    r.URL.EscapedPath(),
-			XTraceOptions:          r.Header.Get(HTTPHeaderXTraceOptions),
-			XTraceOptionsSignature: r.Header.Get(HTTPHeaderXTraceOptionsSignature),
+			Method:                 r.Method,
+			XTraceOptions:          xtOpts,
+			XTraceOptionsSignature: xtOptsSig,
+			RemoteAddr:             r.RemoteAddr,
+			MdSignature:            mdSig,
+			Ingress:                parentMd == "",
 			CB: func() KVMap {
 				kvs := KVMap{
 					keySpec:       "ws",
@@ -202,8 +471,29 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 					}
 				}
 
+				if hasQueueTime {
+					kvs[keyQueueTime] = int64(qt / time.Microsecond)
+				}
+
+				if ic.format != "" && ic.format != config.XTracePropagation {
+					kvs[keyContextFormat] = string(ic.format)
+				}
+				if isReplay {
+					kvs[keyReplay] = true
+				}
+				if len(ic.links) > 0 {
+					kvs[keyLinkedContexts] = strings.Join(ic.links, ",")
+				}
+
 				if so.WithBackTrace {
-					kvs[KeyBackTrace] = string(debug.Stack())
+					if bt := captureBackTrace(); bt != "" {
+						kvs[KeyBackTrace] = bt
+					}
+				}
+				if so.CB != nil {
+					for k, v := range so.CB() {
+						kvs[k] = v
+					}
 				}
 
 				return kvs
@@ -220,6 +510,11 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 	}
 	t.SetHost(host)
 
+	// resolve the location of the client in the background
+	if at, ok := t.(*aoTrace); ok && at.IsSampled() && isNewContext {
+		at.geo = lookupGeo(r)
+	}
+
 	// Clear the start time if it is not a new context
 	if !isNewContext {
 		t.SetStartTime(time.Time{})
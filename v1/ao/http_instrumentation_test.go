@@ -27,6 +27,7 @@ import (
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func handler404(w http.ResponseWriter, r *http.Request) { w.WriteHeader(404) }
@@ -120,6 +121,71 @@ func TestHTTPHandler404(t *testing.T) {
 	})
 }
 
+func TestHTTPHandlerWrappedTwice(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	response := httpTest(ao.HTTPHandler(handler404))
+	assert.Len(t, response.HeaderMap[ao.HTTPHeaderName], 1)
+
+	// the inner wrap doesn't start another trace
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, response.HeaderMap.Get(ao.HTTPHeaderName), n.Map[ao.HTTPHeaderName])
+			assert.EqualValues(t, 404, n.Map["Status"])
+		}},
+	})
+}
+
+func TestUnmatchedRouteTxnName(t *testing.T) {
+	os.Setenv("APPOPTICS_PREPEND_DOMAIN", "false")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_PREPEND_DOMAIN")
+		config.Load()
+	}()
+	r := reporter.SetTestReporter() // set up test reporter
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusOK} {
+		for _, unmatched := range []bool{false, true} {
+			req, _ := http.NewRequest("GET", "http://test.com/wp-admin/setup.php", nil)
+			tr, w, req := ao.TraceFromHTTPRequestResponse("scanned", httptest.NewRecorder(), req)
+			if unmatched {
+				ao.SetRouteUnmatched(req.Context())
+			}
+			w.WriteHeader(status)
+			tr.End()
+		}
+	}
+	// the handlers of the routers set the transaction name regardless of the
+	// controller and action
+	httpTest(ao.NotFoundHandler().ServeHTTP)
+	response := httpTest(ao.MethodNotAllowedHandler().ServeHTTP)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+	// a wrapped http.ServeMux marks the requests not matching any pattern,
+	// but not the 404 of a matched one
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", http.NotFound)
+	handler := ao.WrapHTTPHandler(mux)
+	for _, path := range []string{"/wp-admin/setup.php", "/users/1"} {
+		req, _ := http.NewRequest("GET", "http://test.com"+path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	r.Close(20)
+	var names []string
+	for _, buf := range r.EventBufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		if m["Label"] == "exit" {
+			names = append(names, m["TransactionName"].(string))
+		}
+	}
+	assert.Equal(t, []string{"/wp-admin/setup.php", ao.TransactionNameNotFound,
+		"/wp-admin/setup.php", ao.TransactionNameMethodNotAllowed, "/wp-admin/setup.php", "/wp-admin/setup.php",
+		ao.TransactionNameNotFound, ao.TransactionNameMethodNotAllowed,
+		ao.TransactionNameNotFound, "/users/"}, names)
+}
+
 func TestHTTPHandler200(t *testing.T) {
 	os.Setenv("APPOPTICS_PREPEND_DOMAIN", "false")
 	config.Load()
@@ -213,6 +279,109 @@ func TestSingleHTTPSpan(t *testing.T) {
 	assert.Equal(t, 1, len(r.SpanMessages))
 }
 
+func TestMetricsOnlyHTTPSpan(t *testing.T) {
+	assert.NoError(t, ao.SetTracingMode("metrics-only"))
+	defer config.Load()
+	r := reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(false)) // set up test reporter
+	httpTest(handler200)
+	r.Close(0)
+
+	// no events are reported, but the span metrics are still recorded
+	assert.Empty(t, r.EventBufs)
+	require.Len(t, r.SpanMessages, 1)
+	m, ok := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+	assert.True(t, ok)
+	assert.Equal(t, "ao_test.handler200", m.Transaction)
+	assert.Equal(t, 200, m.Status)
+}
+
+func TestExcludedPathHTTPSpan(t *testing.T) {
+	r := reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(false)) // set up test reporter
+	response := httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+		assert.False(t, ao.TraceFromContext(req.Context()).IsReporting())
+		_, wrapped := w.(*ao.HTTPResponseWriter)
+		assert.False(t, wrapped)
+	}, "http://test.com/debug/pprof/heap")
+	httpTestWithEndpoint(handler200, "http://test.com/metrics")
+	r.Close(0)
+
+	// neither traced nor counted by the metrics
+	assert.Empty(t, response.HeaderMap[ao.HTTPHeaderName])
+	assert.Empty(t, r.EventBufs)
+	assert.Empty(t, r.SpanMessages)
+
+	os.Setenv("APPOPTICS_EXCLUDED_PATHS", "none")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_EXCLUDED_PATHS")
+		config.Load()
+	}()
+	r = reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(false))
+	httpTestWithEndpoint(handler200, "http://test.com/metrics")
+	r.Close(2)
+	assert.Len(t, r.EventBufs, 2)
+	assert.Len(t, r.SpanMessages, 1)
+}
+
+func TestHTTPHandlerMiddlewareTime(t *testing.T) {
+	os.Setenv("APPOPTICS_REPORT_OVERHEAD", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORT_OVERHEAD")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}, "http://test.com/slow")
+	r.Close(2)
+
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "MiddlewareTime_us")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.IsType(t, int64(0), n.Map["MiddlewareTime_us"])
+			assert.True(t, n.Map["MiddlewareTime_us"].(int64) >= 0)
+			assert.True(t, n.Map["HandlerTime_us"].(int64) >= 10000)
+		}},
+	})
+}
+
+type teapotHandler struct{}
+
+func (teapotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusTeapot)
+}
+
+func TestWrapHTTPHandler(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	for _, h := range []http.Handler{
+		ao.WrapHTTPHandler(&teapotHandler{}),
+		ao.WrapHTTPHandler(http.HandlerFunc(handler404)),
+		ao.WrapHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			ao.WithControllerAction("users", "list")),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/hello", nil))
+	}
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeKVMap{
+		{"http.HandlerFunc", "entry", "", ""}: {Count: 3},
+		{"http.HandlerFunc", "exit", "Action", "teapotHandler"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "ao_test", n.Map["Controller"])
+			assert.EqualValues(t, http.StatusTeapot, n.Map["Status"])
+		}},
+		{"http.HandlerFunc", "exit", "Action", "handler404"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "ao_test", n.Map["Controller"])
+		}},
+		{"http.HandlerFunc", "exit", "Action", "list"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "users", n.Map["Controller"])
+		}},
+	})
+}
+
 // testServer tests creating a span/trace from inside an HTTP handler (using ao.TraceFromHTTPRequest)
 func testServer(t *testing.T, list net.Listener) {
 	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -831,3 +1000,176 @@ func TestHTTPHandlerOpts(t *testing.T) {
 		}},
 	})
 }
+
+func TestHTTPHandlerQueueTime(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	start := time.Now().Add(-50 * time.Millisecond)
+	hd := map[string]string{
+		ao.HTTPHeaderRequestStart: fmt.Sprintf("t=%d", start.UnixNano()/int64(time.Microsecond)),
+	}
+	httpTestWithEndpointWithHeaders(handler200, "http://test.com/hello", hd)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			qt, ok := n.Map["QueueTime"].(int64)
+			assert.True(t, ok)
+			assert.True(t, qt >= 50000, qt)
+			assert.True(t, qt < 10000000, qt)
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestHTTPClientSpanPropagateHosts(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("test"))
+
+	xTrace := func(url string, opts ...ao.SpanOpt) string {
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		assert.False(t, ao.HTTPClientSpanStarted(req))
+		l := ao.BeginHTTPClientSpan(ctx, req, opts...)
+		l.End()
+		// the copy is marked whether the headers are injected or not, the
+		// request passed is not replaced
+		assert.True(t, ao.HTTPClientSpanStarted(l.Request()))
+		assert.False(t, ao.HTTPClientSpanStarted(req))
+		assert.Equal(t, req.Header, l.Request().Header)
+		return req.Header.Get(ao.HTTPHeaderName)
+	}
+
+	// no allowlist: injected into all hosts
+	assert.NotEmpty(t, xTrace("http://api.thirdparty.com/v1"))
+
+	// per-request allowlist
+	opt := ao.WithPropagateHosts("API.example.com", "*.internal.example.com")
+	assert.NotEmpty(t, xTrace("http://api.example.com:8080/v1", opt))
+	assert.NotEmpty(t, xTrace("http://db.internal.example.com/v1", opt))
+	assert.Empty(t, xTrace("http://internal.example.com/v1", opt))
+	assert.Empty(t, xTrace("http://api.thirdparty.com/v1", opt))
+	assert.Empty(t, xTrace("http://api.example.com/v1", ao.WithPropagateHosts()))
+
+	// global allowlist
+	os.Setenv("APPOPTICS_PROPAGATE_HOSTS", "*.example.com")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_PROPAGATE_HOSTS")
+		config.Load()
+	}()
+	assert.NotEmpty(t, xTrace("http://api.example.com/v1"))
+	assert.Empty(t, xTrace("http://api.thirdparty.com/v1"))
+	// the per-request option takes precedence
+	assert.NotEmpty(t, xTrace("http://api.thirdparty.com/v1", ao.WithPropagateHosts("api.thirdparty.com")))
+
+	// an invalid global allowlist fails closed
+	os.Setenv("APPOPTICS_PROPAGATE_HOSTS", "http://api.example.com")
+	config.Load()
+	assert.Empty(t, xTrace("http://api.example.com/v1"))
+
+	r.Close(19)
+}
+
+func TestSignedTraceContext(t *testing.T) {
+	os.Setenv("APPOPTICS_CONTEXT_SIGNING_KEY", "secret")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_CONTEXT_SIGNING_KEY")
+		config.Load()
+	}()
+
+	// the outgoing contexts are signed
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("test"))
+	req, err := http.NewRequest("GET", "http://api.example.com/v1", nil)
+	require.NoError(t, err)
+	l := ao.BeginHTTPClientSpan(ctx, req)
+	l.End()
+	md := req.Header.Get(ao.HTTPHeaderName)
+	assert.NotEmpty(t, md)
+	assert.Equal(t, ao.SignMetadata(md), req.Header.Get(ao.HTTPHeaderXTraceSignature))
+	r.Close(2)
+
+	// and the incoming ones are checked
+	incoming := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	for _, sig := range []string{ao.SignMetadata(incoming), ""} {
+		r = reporter.SetTestReporter()
+		w := httpTestWithEndpointWithHeaders(handler200, "http://test.com/hello",
+			map[string]string{ao.HTTPHeaderName: incoming, ao.HTTPHeaderXTraceSignature: sig})
+		// the trace is continued either way
+		assert.Equal(t, incoming[2:42], w.Header().Get(ao.HTTPHeaderName)[2:42])
+		r.Close(2)
+		var edges g.Edges
+		if sig != "" {
+			edges = g.Edges{{"Edge", incoming[42:58]}}
+		}
+		g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+			// but an unsigned one is not the parent of the entry
+			{"http.HandlerFunc", "entry"}: {Edges: edges},
+			{"http.HandlerFunc", "exit"}:  {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+		})
+	}
+}
+
+func TestHTTPClientSpanThrottled(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("test"))
+
+	respond := func(status int, hd map[string]string) {
+		req, err := http.NewRequest("GET", "http://api.thirdparty.com/v1", nil)
+		require.NoError(t, err)
+		l := ao.BeginHTTPClientSpan(ctx, req, ao.WithThrottleMetric())
+		resp := &http.Response{StatusCode: status, Header: http.Header{}, Request: req}
+		for k, v := range hd {
+			resp.Header.Set(k, v)
+		}
+		l.AddHTTPResponse(resp, nil)
+		l.End()
+	}
+	respond(429, map[string]string{
+		"Retry-After":           "120",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     "1640995200",
+	})
+	respond(503, map[string]string{
+		"Retry-After": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+	})
+	respond(503, map[string]string{"Retry-After": "soon"})
+	// the headers of the other responses are ignored
+	respond(200, map[string]string{"X-RateLimit-Remaining": "99"})
+
+	r.Close(9)
+	var exits []bson.M
+	for _, buf := range r.EventBufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		if m["Label"] == "exit" {
+			exits = append(exits, m)
+		}
+	}
+	require.Len(t, exits, 4)
+	assert.EqualValues(t, 120, exits[0]["RetryAfter"])
+	assert.EqualValues(t, 0, exits[0]["RateLimitRemaining"])
+	assert.Equal(t, "1640995200", exits[0]["RateLimitReset"])
+	assert.InDelta(t, 3600, exits[1]["RetryAfter"], 5)
+	assert.NotContains(t, exits[1], "RateLimitRemaining")
+	assert.Equal(t, "soon", exits[2]["RetryAfter"])
+	assert.NotContains(t, exits[3], "RateLimitRemaining")
+	assert.NotContains(t, exits[3], "RetryAfter")
+}
+
+func BenchmarkHTTPResponseWriterWriteHeader(b *testing.B) {
+	_ = reporter.SetTestReporter()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://test.com/hello", nil)
+	tr, w, _ := ao.TraceFromHTTPRequestResponse("test", rec, req)
+	defer tr.End()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k := range rec.Header() {
+			delete(rec.Header(), k)
+		}
+		w.WriteHeader(200)
+	}
+}
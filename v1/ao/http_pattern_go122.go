@@ -0,0 +1,13 @@
+// +build go1.22
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "net/http"
+
+// requestPattern returns the pattern of the http.ServeMux route matching the
+// request, which is set since Go 1.22.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
@@ -0,0 +1,60 @@
+// +build go1.22
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+//go:debug httpmuxgo121=0
+
+package ao_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutePatternTxnName(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET /users/{id}", ao.RoutePattern(r))
+	})
+	mux.HandleFunc("GET /orders/{id}", ao.HTTPHandler(handler200))
+	mux.HandleFunc("GET /named/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_ = ao.SetTransactionName(r.Context(), "custom")
+	})
+	handler := ao.HTTPHandler(mux.ServeHTTP)
+
+	for path, txn := range map[string]string{
+		// the ServeMux is wrapped, the pattern is known once it routes
+		"/users/1": "GET /users/{id}",
+		// the custom transaction name takes precedence
+		"/named/3": "custom",
+	} {
+		r := reporter.SetTestReporter()
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com"+path, nil))
+		r.Close(2)
+		g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+			{"http.HandlerFunc", "entry"}: {},
+			{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+				assert.Equal(t, txn, n.Map["TransactionName"], path)
+			}},
+		})
+	}
+
+	// the handlers wrapped individually are named after the patterns too
+	r := reporter.SetTestReporter()
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/orders/2", nil))
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "GET /orders/{id}", n.Map["TransactionName"])
+		}},
+	})
+
+	assert.Empty(t, ao.RoutePattern(nil))
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+)
+
+const httpProxySpanName = "http.ReverseProxy"
+
+// key used for the request re-dispatched internally, to the metadata of the
+// span it's re-dispatched from
+var httpRedispatchKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.HTTPRedispatch")
+
+// InternalRedispatch marks a request which is about to be dispatched
+// internally to another handler, e.g., after its URL is rewritten. The first
+// handler wrapped by HTTPHandler, or traced by TraceFromHTTPRequestResponse,
+// which serves it continues the trace of the span bound to its context, rather
+// than being skipped as a duplicate wrap or starting a new trace. It returns a
+// shallow copy of the request, as r.WithContext does:
+//   r = ao.InternalRedispatch(r)
+//   mux.ServeHTTP(w, r)
+// The request is returned as is if there is no trace bound to its context.
+func InternalRedispatch(r *http.Request) *http.Request {
+	md := MetadataString(r.Context())
+	if md == "" {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), httpSpanKey, true)
+	return r.WithContext(context.WithValue(ctx, httpRedispatchKey, md))
+}
+
+// redispatchedFrom returns the metadata of the span the request is
+// re-dispatched from, or an empty string if it's not re-dispatched.
+func redispatchedFrom(r *http.Request) string {
+	md, _ := r.Context().Value(httpRedispatchKey).(string)
+	return md
+}
+
+// WrapReverseProxy instruments a httputil.ReverseProxy, reporting a
+// http.ReverseProxy span for each request proxied and propagating the trace
+// context to the backend, including the proxy to the process itself. The
+// trace context headers are only sent to the hosts allowed by
+// APPOPTICS_PROPAGATE_HOSTS or WithPropagateHosts, see BeginHTTPClientSpan.
+// It replaces the Transport of the proxy and returns the proxy itself:
+//   proxy := ao.WrapReverseProxy(httputil.NewSingleHostReverseProxy(target))
+//   http.HandleFunc("/api/", ao.HTTPHandler(proxy.ServeHTTP))
+func WrapReverseProxy(p *httputil.ReverseProxy, opts ...SpanOpt) *httputil.ReverseProxy {
+	EnableIntegration(IntegrationHTTPHandler)
+	base := p.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	p.Transport = &proxyTransport{base: base, opts: opts}
+	return p
+}
+
+type proxyTransport struct {
+	base http.RoundTripper
+	opts []SpanOpt
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if Closed() || IntegrationDisabled(IntegrationHTTPHandler) {
+		return t.base.RoundTrip(req)
+	}
+	// the headers copied from the incoming request carry the context of the
+	// trace entry, which is replaced by the proxy span's or not sent at all
+	req = req.Clone(req.Context())
+	req.Header.Del(HTTPHeaderName)
+	req.Header.Del(HTTPHeaderXTraceSignature)
+
+	l := beginHTTPClientSpan(req.Context(), httpProxySpanName, req, t.opts...)
+	defer l.End()
+	resp, err := t.base.RoundTrip(l.Request())
+	l.AddHTTPResponse(resp, err)
+	return resp, err
+}
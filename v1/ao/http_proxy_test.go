@@ -0,0 +1,98 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalRedispatch(t *testing.T) {
+	// the internal parent is honored regardless of the policy
+	os.Setenv("APPOPTICS_PARENT_SAMPLING", "never")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_PARENT_SAMPLING")
+		config.Load()
+	}()
+	r := reporter.SetTestReporter()
+
+	inner := ao.HTTPHandler(handler200)
+	outer := func(w http.ResponseWriter, req *http.Request) {
+		l, ctx := ao.BeginSpan(req.Context(), "rewrite")
+		req = req.WithContext(ctx)
+		// the duplicate wraps are skipped without the mark
+		inner(w, req)
+		req = ao.InternalRedispatch(req)
+		req.URL.Path = "/rewritten"
+		inner(w, req)
+		l.End()
+	}
+	httpTest(outer)
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeKVMap{
+		{"http.HandlerFunc", "entry", "URL", "/hello?testq"}:     {},
+		{"rewrite", "entry", "", ""}:                             {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+		{"http.HandlerFunc", "entry", "URL", "/rewritten?testq"}: {Edges: g.Edges{{"rewrite", "entry"}}},
+		{"http.HandlerFunc", "exit", "Action", "handler200"}:     {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+		{"rewrite", "exit", "", ""}:                              {Edges: g.Edges{{"rewrite", "entry"}}},
+		// the inner exit is linked through the X-Trace header of the response
+		{"http.HandlerFunc", "exit", "", ""}: {Edges: g.Edges{{"http.HandlerFunc", "exit"}, {"rewrite", "exit"}, {"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestInternalRedispatchNoTrace(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://test.com/hello", nil)
+	assert.Equal(t, req, ao.InternalRedispatch(req))
+}
+
+func TestWrapReverseProxy(t *testing.T) {
+	r := reporter.SetTestReporter()
+	var backendXTrace string
+	backend := httptest.NewServer(http.HandlerFunc(ao.HTTPHandler(func(w http.ResponseWriter, req *http.Request) {
+		backendXTrace = req.Header.Get(ao.HTTPHeaderName)
+		w.WriteHeader(201)
+	})))
+	defer backend.Close()
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	proxy := ao.WrapReverseProxy(httputil.NewSingleHostReverseProxy(target))
+	rr := httpTestWithEndpoint(proxy.ServeHTTP, "http://test.com/api")
+	assert.Equal(t, 201, rr.Code)
+	assert.NotEmpty(t, backendXTrace)
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeKVMap{
+		// the test request has no remote address, unlike the one of the backend
+		{"http.HandlerFunc", "entry", "Remote-Host", ""}: {},
+		{"http.ReverseProxy", "entry", "", ""}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, backend.URL+"/api", n.Map["RemoteURL"])
+			assert.Equal(t, "GET", n.Map["HTTPMethod"])
+		}},
+		// the backend continues the trace of the proxy span
+		{"http.HandlerFunc", "entry", "", ""}: {Edges: g.Edges{{"http.ReverseProxy", "entry"}}, Callback: func(n g.Node) {
+			// the default X-Trace format is not reported
+			assert.NotContains(t, n.Map, "ContextFormat")
+		}},
+		{"http.HandlerFunc", "exit", "", ""}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 201, n.Map["Status"])
+		}},
+		{"http.ReverseProxy", "exit", "", ""}: {Edges: g.Edges{{"http.HandlerFunc", "exit"}, {"http.ReverseProxy", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 201, n.Map["RemoteStatus"])
+		}},
+		{"http.HandlerFunc", "exit", "Controller", "httputil"}: {Edges: g.Edges{{"http.ReverseProxy", "exit"}, {"http.HandlerFunc", "entry"}}},
+	})
+}
@@ -0,0 +1,63 @@
+// +build go1.20
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPResponseController(t *testing.T) {
+	r := reporter.SetTestReporter()
+	s := httptest.NewServer(http.HandlerFunc(ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(*ao.HTTPResponseWriter)
+		assert.True(t, ok)
+		rc := http.NewResponseController(w)
+		assert.NoError(t, rc.SetReadDeadline(time.Now().Add(time.Minute)))
+		assert.NoError(t, rc.SetWriteDeadline(time.Now().Add(time.Minute)))
+		assert.NoError(t, rc.EnableFullDuplex())
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("streaming"))
+		assert.NoError(t, rc.Flush())
+	})))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "streaming", string(body))
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(ao.HTTPHeaderName))
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, http.StatusAccepted, n.Map["Status"])
+		}},
+	})
+}
+
+func TestHTTPResponseWriterUnwrap(t *testing.T) {
+	r := reporter.SetTestReporter()
+	rec := httptest.NewRecorder()
+	ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, rec, w.(interface{ Unwrap() http.ResponseWriter }).Unwrap())
+		// the recorder doesn't support the deadlines
+		err := http.NewResponseController(w).SetWriteDeadline(time.Now())
+		assert.ErrorIs(t, err, http.ErrNotSupported)
+	})(rec, httptest.NewRequest("GET", "/", nil))
+	r.Close(2)
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+)
+
+// The names of the integrations provided by this package.
+const (
+	IntegrationHTTPHandler = "net/http"
+	IntegrationOpenTracing = "opentracing"
+)
+
+// Integration describes an instrumentation of a library or framework.
+type Integration struct {
+	Name    string
+	Version string
+	// Enabled reports if the instrumentation is installed, e.g., the
+	// middleware has been created, rather than just imported.
+	Enabled bool
+	// Disabled reports if the instrumentation is turned into a no-op by
+	// APPOPTICS_DISABLED_INTEGRATIONS.
+	Disabled bool
+}
+
+var integrations = struct {
+	sync.RWMutex
+	m map[string]*Integration
+}{m: map[string]*Integration{
+	IntegrationHTTPHandler: {Name: IntegrationHTTPHandler, Version: utils.Version()},
+	IntegrationOpenTracing: {Name: IntegrationOpenTracing, Version: utils.Version()},
+}}
+
+// Version returns the version of the AppOptics Go agent.
+func Version() string {
+	return utils.Version()
+}
+
+// RegisterIntegration registers an instrumentation of a library or framework.
+// It's usually called in the init function of the instrumentation package, the
+// integration is registered as disabled until EnableIntegration is called.
+// Registering a name again updates the version only.
+func RegisterIntegration(name, version string) {
+	integrations.Lock()
+	defer integrations.Unlock()
+	if i, ok := integrations.m[name]; ok {
+		i.Version = version
+		return
+	}
+	integrations.m[name] = &Integration{Name: name, Version: version}
+}
+
+// EnableIntegration marks the integration as enabled, which is called by the
+// instrumentation when it's installed, e.g., the middleware is created. An
+// integration not registered is registered without a version.
+func EnableIntegration(name string) {
+	integrations.RLock()
+	i, ok := integrations.m[name]
+	enabled := ok && i.Enabled
+	integrations.RUnlock()
+	if enabled {
+		return
+	}
+
+	integrations.Lock()
+	defer integrations.Unlock()
+	i, ok = integrations.m[name]
+	if !ok {
+		i = &Integration{Name: name}
+		integrations.m[name] = i
+	}
+	if !i.Enabled {
+		i.Enabled = true
+		log.Debugf("Integration %s is enabled.", name)
+	}
+}
+
+// RegisteredIntegrations returns the registered integrations sorted by name,
+// which tells the instrumentations linked into the application and the ones
+// installed. It helps to find out why traces are missing.
+func RegisteredIntegrations() []Integration {
+	integrations.RLock()
+	defer integrations.RUnlock()
+	list := make([]Integration, 0, len(integrations.m))
+	for _, i := range integrations.m {
+		list = append(list, *i)
+		list[len(list)-1].Disabled = IntegrationDisabled(i.Name)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// IntegrationDisabled checks if the integration is disabled by the
+// configuration APPOPTICS_DISABLED_INTEGRATIONS, in which case the
+// instrumentation should pass the calls through without tracing them. An
+// integration is disabled by its name, or the name of its parent followed by
+// a dot, e.g., "aogrpc.client" is disabled by "aogrpc".
+func IntegrationDisabled(name string) bool {
+	for _, d := range config.GetDisabledIntegrations() {
+		if name == d || strings.HasPrefix(name, d+".") {
+			return true
+		}
+	}
+	return false
+}
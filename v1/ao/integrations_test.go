@@ -0,0 +1,86 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func findIntegration(name string) (ao.Integration, bool) {
+	for _, i := range ao.RegisteredIntegrations() {
+		if i.Name == name {
+			return i, true
+		}
+	}
+	return ao.Integration{}, false
+}
+
+func TestRegisteredIntegrations(t *testing.T) {
+	ao.RegisterIntegration("test-integration", "1.0.0")
+	i, ok := findIntegration("test-integration")
+	assert.True(t, ok)
+	assert.Equal(t, ao.Integration{Name: "test-integration", Version: "1.0.0"}, i)
+
+	ao.EnableIntegration("test-integration")
+	i, _ = findIntegration("test-integration")
+	assert.True(t, i.Enabled)
+
+	// registering again keeps it enabled
+	ao.RegisterIntegration("test-integration", "1.0.1")
+	i, _ = findIntegration("test-integration")
+	assert.Equal(t, ao.Integration{Name: "test-integration", Version: "1.0.1", Enabled: true}, i)
+
+	// enabled without registration
+	ao.EnableIntegration("test-unregistered")
+	i, ok = findIntegration("test-unregistered")
+	assert.True(t, ok)
+	assert.Equal(t, ao.Integration{Name: "test-unregistered", Enabled: true}, i)
+
+	// the built-in integrations
+	ao.HTTPHandler(handler200)
+	i, ok = findIntegration(ao.IntegrationHTTPHandler)
+	assert.True(t, ok)
+	assert.Equal(t, ao.Version(), i.Version)
+	assert.True(t, i.Enabled)
+	_, ok = findIntegration(ao.IntegrationOpenTracing)
+	assert.True(t, ok)
+
+	list := ao.RegisteredIntegrations()
+	for k := 1; k < len(list); k++ {
+		assert.True(t, list[k-1].Name < list[k].Name)
+	}
+}
+
+func TestIntegrationDisabled(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_DISABLED_INTEGRATIONS")
+		config.Load()
+	}()
+	assert.False(t, ao.IntegrationDisabled("aogrpc.client"))
+
+	os.Setenv("APPOPTICS_DISABLED_INTEGRATIONS", "aogrpc, net/http")
+	config.Load()
+	assert.True(t, ao.IntegrationDisabled("aogrpc"))
+	assert.True(t, ao.IntegrationDisabled("aogrpc.client"))
+	assert.False(t, ao.IntegrationDisabled("aogrpcx"))
+	assert.False(t, ao.IntegrationDisabled("aoretry"))
+
+	i, _ := findIntegration(ao.IntegrationHTTPHandler)
+	assert.True(t, i.Disabled)
+	i, _ = findIntegration(ao.IntegrationOpenTracing)
+	assert.False(t, i.Disabled)
+
+	// the handler is called without tracing
+	r := reporter.SetTestReporter()
+	w := httpTest(handler200)
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get(ao.HTTPHeaderName))
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+}
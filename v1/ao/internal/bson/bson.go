@@ -33,6 +33,15 @@ func (b *Buffer) Finish() {
 	b.setInt32(0, int32(len(b.buf)))
 }
 
+// Elements returns the elements appended to the buffer before it's finished,
+// which can be appended to another buffer by AppendElements.
+func (b *Buffer) Elements() []byte { return b.buf[4:] }
+
+// AppendElements appends the serialized elements returned by Elements.
+func (b *Buffer) AppendElements(elems []byte) {
+	b.addBytes(elems...)
+}
+
 func (b *Buffer) AppendString(k, v string) {
 	b.addElemName('\x02', k)
 	b.addStr(v)
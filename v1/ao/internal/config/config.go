@@ -15,12 +15,14 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/pkg/errors"
@@ -38,6 +40,19 @@ const (
 	defaultSSLCollector = "collector.appoptics.com:443"
 	maxTokenBucketCapacity = 8
 	maxTokenBucketRate =4
+	// the max number of child spans in the Server-Timing header
+	maxServerTimingSpans = 10
+
+	maxErrorBodyCaptureBytes = 4096
+
+	maxDrainTimeout = 60000
+
+	// the upper bounds of the local overrides of the sampling token buckets
+	maxSamplingBucketCapacity = 1000
+	maxSamplingBucketRate     = 100
+
+	minBackTraceMaxBytes = 1024
+	maxBackTraceMaxBytes = 1024 * 1024
 )
 
 // The environment variables
@@ -54,12 +69,54 @@ const (
 	envAppOpticsHistogramPrecision    = "APPOPTICS_HISTOGRAM_PRECISION"
 	envAppOpticsEventsFlushInterval   = "APPOPTICS_EVENTS_FLUSH_INTERVAL"
 	envAppOpticsMaxReqBytes           = "APPOPTICS_MAX_REQUEST_BYTES"
+	envAppOpticsEventsMaxAge          = "APPOPTICS_EVENTS_MAX_AGE"
 	envAppOpticsDisabled              = "APPOPTICS_DISABLED"
 	envAppOpticsConfigFile            = "APPOPTICS_CONFIG_FILE"
 	envAppOpticsServerlessServiceName = "APPOPTICS_SERVICE_NAME"
 	envAppOpticsTokenBucketCap        = "APPOPTICS_TOKEN_BUCKET_CAPACITY"
 	envAppOpticsTokenBucketRate       = "APPOPTICS_TOKEN_BUCKET_RATE"
 	envAppOpticsTransactionName       = "APPOPTICS_TRANSACTION_NAME"
+	envAppOpticsHTTPMethodSampleRates = "APPOPTICS_HTTP_METHOD_SAMPLE_RATES"
+	envAppOpticsServerTiming          = "APPOPTICS_SERVER_TIMING"
+	envAppOpticsServerTimingSpans     = "APPOPTICS_SERVER_TIMING_SPANS"
+	envAppOpticsErrorBodyCaptureBytes = "APPOPTICS_ERROR_BODY_CAPTURE_BYTES"
+	envAppOpticsPropagateHosts        = "APPOPTICS_PROPAGATE_HOSTS"
+	envAppOpticsDisabledIntegrations  = "APPOPTICS_DISABLED_INTEGRATIONS"
+	envAppOpticsSpanSchemaValidation  = "APPOPTICS_SPAN_SCHEMA_VALIDATION"
+	envAppOpticsSQLCommenter          = "APPOPTICS_SQL_COMMENTER"
+	envAppOpticsPropagationFormats    = "APPOPTICS_PROPAGATION_FORMATS"
+	envAppOpticsPropagationConflict   = "APPOPTICS_PROPAGATION_CONFLICT"
+	envAppOpticsDrainTimeout          = "APPOPTICS_DRAIN_TIMEOUT"
+	envAppOpticsSettingsSnapshot      = "APPOPTICS_SETTINGS_SNAPSHOT"
+	envAppOpticsSnapshotMaxAge        = "APPOPTICS_SNAPSHOT_MAX_AGE"
+	envAppOpticsJaegerEndpoint        = "APPOPTICS_JAEGER_ENDPOINT"
+	envAppOpticsCompression           = "APPOPTICS_COMPRESSION"
+	envAppOpticsReportOverhead        = "APPOPTICS_REPORT_OVERHEAD"
+	envAppOpticsBackTraceRate         = "APPOPTICS_BACKTRACE_RATE"
+	envAppOpticsBackTraceConcurrency  = "APPOPTICS_BACKTRACE_CONCURRENCY"
+	envAppOpticsBackTraceMaxBytes     = "APPOPTICS_BACKTRACE_MAX_BYTES"
+	envAppOpticsSamplingMode          = "APPOPTICS_SAMPLING_MODE"
+	envAppOpticsParentSampling        = "APPOPTICS_PARENT_SAMPLING"
+	envAppOpticsTrustedParents        = "APPOPTICS_TRUSTED_PARENTS"
+	envAppOpticsContextSigningKey     = "APPOPTICS_CONTEXT_SIGNING_KEY"
+	envAppOpticsUnsignedContext       = "APPOPTICS_UNSIGNED_CONTEXT"
+	envAppOpticsErrorEventsPerMinute  = "APPOPTICS_ERROR_EVENTS_PER_MINUTE"
+	envAppOpticsSpanCompression       = "APPOPTICS_SPAN_COMPRESSION_THRESHOLD"
+	envAppOpticsMaxSpanDepth          = "APPOPTICS_MAX_SPAN_DEPTH"
+	envAppOpticsCaptureTrailers       = "APPOPTICS_CAPTURE_TRAILERS"
+	envAppOpticsExcludedPaths         = "APPOPTICS_EXCLUDED_PATHS"
+	envAppOpticsTimestampPrecision    = "APPOPTICS_TIMESTAMP_PRECISION"
+	envAppOpticsReportSpanDuration    = "APPOPTICS_REPORT_SPAN_DURATION"
+	envAppOpticsCPUProfileDir         = "APPOPTICS_CPU_PROFILE_DIR"
+	envAppOpticsMetricTags            = "APPOPTICS_METRIC_TAGS"
+	envAppOpticsMetricTagValuesMax    = "APPOPTICS_METRIC_TAG_VALUES_MAX"
+
+	envAppOpticsSamplingBucketCap  = "APPOPTICS_SAMPLING_BUCKET_CAPACITY"
+	envAppOpticsSamplingBucketRate = "APPOPTICS_SAMPLING_BUCKET_RATE"
+	envAppOpticsRelaxedBucketCap   = "APPOPTICS_TRIGGER_TRACE_RELAXED_BUCKET_CAPACITY"
+	envAppOpticsRelaxedBucketRate  = "APPOPTICS_TRIGGER_TRACE_RELAXED_BUCKET_RATE"
+	envAppOpticsStrictBucketCap    = "APPOPTICS_TRIGGER_TRACE_STRICT_BUCKET_CAPACITY"
+	envAppOpticsStrictBucketRate   = "APPOPTICS_TRIGGER_TRACE_STRICT_BUCKET_RATE"
 )
 
 // Errors
@@ -87,8 +144,17 @@ type Config struct {
 	// The host and port of the UDP collector
 	CollectorUDP string `yaml:"CollectorUDP,omitempty" env:"APPOPTICS_COLLECTOR_UDP"`
 
-	// The reporter type, ssl or udp
+	// The reporter type, ssl or udp, or jaeger for development. The events are
+	// sent to all the reporters of a comma-separated list, e.g., "ssl,jaeger",
+	// and the first one is the primary reporter that receives the metrics of
+	// the events dropped by the others. The exporters registered by
+	// ao.RegisterExporter are selected by their names as well.
 	ReporterType string `yaml:"ReporterType,omitempty" env:"APPOPTICS_REPORTER" default:"ssl"`
+	// The ReporterType discarded as unknown, which is selected if its
+	// exporters are registered later
+	unknownReporterType string
+	// The OTLP/HTTP traces endpoint the jaeger reporter sends the spans to
+	JaegerEndpoint string `yaml:"JaegerEndpoint,omitempty" env:"APPOPTICS_JAEGER_ENDPOINT" default:"http://localhost:4318/v1/traces"`
 
 	Sampling *SamplingConfig `yaml:"Sampling,omitempty"`
 
@@ -127,12 +193,164 @@ type Config struct {
 	ProxyCertPath string `yaml:"ProxyCertPath" env:"APPOPTICS_PROXY_CERT_PATH"`
 	// Report runtime metrics or not
 	RuntimeMetrics bool `yaml:"RuntimeMetrics" env:"APPOPTICS_RUNTIME_METRICS" default:"true"`
+	// Report host metrics (CPU, memory, load, disk and network) or not
+	HostMetrics bool `yaml:"HostMetrics" env:"APPOPTICS_HOST_METRICS" default:"false"`
+	// Report container metrics from cgroup or not, which are only reported
+	// when running in a container
+	ContainerMetrics bool `yaml:"ContainerMetrics" env:"APPOPTICS_CONTAINER_METRICS" default:"false"`
 	// ReportQueryString indicates if the query string should be reported as part of the URL
 	ReportQueryString bool    `yaml:"ReportQueryString" env:"APPOPTICS_REPORT_QUERY_STRING" default:"true"`
 	TokenBucketCap    float64 `yaml:"TokenBucketCap" env:"APPOPTICS_TOKEN_BUCKET_CAPACITY" default:"8"`
 	TokenBucketRate   float64 `yaml:"TokenBucketRate" env:"APPOPTICS_TOKEN_BUCKET_RATE" default:"0.17"`
 	// The user-defined transaction name. It's only available in the AWS Lambda environment.
 	TransactionName string `yaml:"TransactionName" env:"APPOPTICS_TRANSACTION_NAME"`
+	// The per-HTTP-method sample rates in the format of "METHOD:rate,...", e.g.,
+	// "OPTIONS:0,HEAD:10000". The rate is in the same unit as SampleRate and a
+	// rate of 0 means the requests of this method are never traced.
+	HTTPMethodSampleRates string `yaml:"HTTPMethodSampleRates,omitempty" env:"APPOPTICS_HTTP_METHOD_SAMPLE_RATES"`
+	// The parsed HTTPMethodSampleRates
+	httpMethodSampleRates map[string]int
+	// ServerTiming indicates if the Server-Timing header with the duration of
+	// the trace should be added to the HTTP responses
+	ServerTiming bool `yaml:"ServerTiming,omitempty" env:"APPOPTICS_SERVER_TIMING"`
+	// The number of the slowest child spans to be included in the Server-Timing
+	// header, in addition to the total duration
+	ServerTimingSpans int `yaml:"ServerTimingSpans,omitempty" env:"APPOPTICS_SERVER_TIMING_SPANS" default:"0"`
+	// The maximum number of bytes of the response body to be reported on the
+	// exit event when the HTTP status is 5xx. Zero disables the capture.
+	ErrorBodyCaptureBytes int `yaml:"ErrorBodyCaptureBytes,omitempty" env:"APPOPTICS_ERROR_BODY_CAPTURE_BYTES" default:"0"`
+	// The comma-separated list of the hosts the trace context headers are
+	// injected into by the HTTP client instrumentation, e.g.,
+	// "api.example.com,*.internal.example.com". The headers are injected into
+	// all the outgoing requests if it's empty, and into none if it's invalid.
+	PropagateHosts string `yaml:"PropagateHosts,omitempty" env:"APPOPTICS_PROPAGATE_HOSTS"`
+	// The parsed PropagateHosts
+	propagateHosts []string
+	// The comma-separated names of the integrations turned into no-ops, e.g.,
+	// "aogrpc.client,aogobreaker". A name disables the sub-integrations
+	// prefixed by it and a dot too, e.g., "aogrpc" disables "aogrpc.client".
+	DisabledIntegrations string `yaml:"DisabledIntegrations,omitempty" env:"APPOPTICS_DISABLED_INTEGRATIONS"`
+	// The parsed DisabledIntegrations
+	disabledIntegrations []string
+	// SpanSchemaValidation indicates if the KVs of the spans are checked against
+	// the registered span schemas. It's meant for development and testing.
+	SpanSchemaValidation bool `yaml:"SpanSchemaValidation,omitempty" env:"APPOPTICS_SPAN_SCHEMA_VALIDATION"`
+	// The comma-separated names of the response trailers reported on the exit
+	// events, e.g., "grpc-status,grpc-message". They're case-insensitive.
+	CaptureTrailers string `yaml:"CaptureTrailers,omitempty" env:"APPOPTICS_CAPTURE_TRAILERS"`
+	// The parsed CaptureTrailers
+	captureTrailers []string
+	// The comma-separated URL paths of the HTTP requests neither traced nor
+	// counted by the metrics, e.g., the internal endpoints. A path ending with
+	// "*" matches the paths prefixed by it, and "/*" also matches the path
+	// without it, e.g., "/debug/pprof/*" matches "/debug/pprof". "none"
+	// excludes nothing.
+	ExcludedPaths string `yaml:"ExcludedPaths,omitempty" env:"APPOPTICS_EXCLUDED_PATHS" default:"/debug/pprof/*,/metrics"`
+	// The parsed ExcludedPaths
+	excludedPaths []string
+	// The comma-separated names of the KVs of the root spans, e.g.,
+	// "tenant,region", whose values tag the transaction metrics, so the
+	// dashboards can be split by the business dimensions. At most 3 are used.
+	// The KVs of the entry events, e.g., returned by the SpanOptions.CB of
+	// NewTraceWithOptions or the ao.WithLazyKVs of HTTPHandler, count as
+	// well, so the callbacks are called for the traces not sampled too.
+	MetricTags string `yaml:"MetricTags,omitempty" env:"APPOPTICS_METRIC_TAGS"`
+	// The parsed MetricTags
+	metricTags []string
+	// The maximum number of the distinct values of a metric tag, the values
+	// beyond it are reported as "other" to cap the cardinality.
+	MetricTagValuesMax int `yaml:"MetricTagValuesMax,omitempty" env:"APPOPTICS_METRIC_TAG_VALUES_MAX" default:"20"`
+	// SQLCommenter indicates if the trace context should be appended to the
+	// SQL statements as a sqlcommenter-style comment
+	SQLCommenter bool `yaml:"SQLCommenter,omitempty" env:"APPOPTICS_SQL_COMMENTER"`
+	// The comma-separated trace context formats extracted from the incoming
+	// HTTP requests, in the order of precedence, e.g., "xtrace,traceparent,b3"
+	PropagationFormats string `yaml:"PropagationFormats,omitempty" env:"APPOPTICS_PROPAGATION_FORMATS" default:"xtrace"`
+	// The parsed PropagationFormats
+	propagationFormats []PropagationFormat
+	// How the trace contexts conflicting with the extracted one are handled:
+	// either ignored or reported as links
+	PropagationConflict PropagationConflict `yaml:"PropagationConflict,omitempty" env:"APPOPTICS_PROPAGATION_CONFLICT" default:"ignore"`
+	// The maximum time in milliseconds to wait for the agent to send the
+	// pending events when the process is stopping
+	DrainTimeout int `yaml:"DrainTimeout,omitempty" env:"APPOPTICS_DRAIN_TIMEOUT" default:"5000"`
+	// The file the last settings received from the collector are saved to.
+	// They are restored from it at startup, so the agent starts tracing with
+	// them if the collector is not reachable. Empty disables the snapshot.
+	SettingsSnapshot string `yaml:"SettingsSnapshot,omitempty" env:"APPOPTICS_SETTINGS_SNAPSHOT"`
+	// The maximum age in seconds of a settings snapshot to be restored
+	SnapshotMaxAge int `yaml:"SnapshotMaxAge,omitempty" env:"APPOPTICS_SNAPSHOT_MAX_AGE" default:"3600"`
+	// The compression of the gRPC requests sent to the collector, either gzip,
+	// zstd or none. The requests are sent uncompressed if the collector doesn't
+	// support the compression.
+	Compression string `yaml:"Compression,omitempty" env:"APPOPTICS_COMPRESSION" default:"gzip"`
+	// ReportOverhead indicates if the time spent by the agent building the
+	// events of a trace is reported as a KV of its exit event, along with the
+	// time spent by the HTTP instrumentation itself and by the handler
+	ReportOverhead bool `yaml:"ReportOverhead,omitempty" env:"APPOPTICS_REPORT_OVERHEAD"`
+	// The maximum number of the backtraces captured per second, the spans and
+	// errors beyond it are reported without the backtrace
+	BackTraceRate int `yaml:"BackTraceRate,omitempty" env:"APPOPTICS_BACKTRACE_RATE" default:"100"`
+	// The maximum number of the backtraces captured at the same time
+	BackTraceConcurrency int `yaml:"BackTraceConcurrency,omitempty" env:"APPOPTICS_BACKTRACE_CONCURRENCY" default:"4"`
+	// The maximum size in bytes of a backtrace, the longer ones are truncated
+	BackTraceMaxBytes int `yaml:"BackTraceMaxBytes,omitempty" env:"APPOPTICS_BACKTRACE_MAX_BYTES" default:"16384"`
+	// How the requests are sampled against the sample rate: either randomly
+	// or by the hash of the trace ID
+	SamplingMode SamplingMode `yaml:"SamplingMode,omitempty" env:"APPOPTICS_SAMPLING_MODE" default:"random"`
+	// When the sampled flag of the incoming trace context is honored: always,
+	// never, or only if the peer is in TrustedParents. The traces of the
+	// contexts not honored are continued but sampled by the local decision.
+	// It only applies to the contexts received in the HTTP or gRPC requests.
+	ParentSampling ParentSampling `yaml:"ParentSampling,omitempty" env:"APPOPTICS_PARENT_SAMPLING" default:"always"`
+	// The comma-separated list of the IPs or CIDRs of the trusted peers, e.g.,
+	// "10.0.0.0/8,192.168.1.10"
+	TrustedParents string `yaml:"TrustedParents,omitempty" env:"APPOPTICS_TRUSTED_PARENTS"`
+	// The parsed TrustedParents
+	trustedParents []*net.IPNet
+	// The key of the HMAC signatures of the incoming trace contexts in the
+	// X-Trace-Signature header. The signatures are not checked if it's empty.
+	ContextSigningKey string `yaml:"ContextSigningKey,omitempty" env:"APPOPTICS_CONTEXT_SIGNING_KEY"`
+	// How the incoming trace contexts without a valid signature are handled:
+	// either rejected, i.e., ignored, or downgraded, i.e., the trace is
+	// continued but its sampled flag is not honored. It only applies to the
+	// contexts received in the HTTP or gRPC requests.
+	UnsignedContext UnsignedContext `yaml:"UnsignedContext,omitempty" env:"APPOPTICS_UNSIGNED_CONTEXT" default:"downgrade"`
+	// The maximum number of the error events with the same fingerprint
+	// reported per minute, the ones beyond it are suppressed. Zero means
+	// unlimited.
+	ErrorEventsPerMinute int `yaml:"ErrorEventsPerMinute,omitempty" env:"APPOPTICS_ERROR_EVENTS_PER_MINUTE" default:"0"`
+	// The number of consecutive identical child spans (same name and remote
+	// host) reported as is, the ones beyond it are compressed into a single
+	// composite span. Zero means the compression is disabled.
+	SpanCompressionThreshold int `yaml:"SpanCompressionThreshold,omitempty" env:"APPOPTICS_SPAN_COMPRESSION_THRESHOLD" default:"0"`
+	// The maximum depth of the spans of a trace, including the root span.
+	// The spans deeper than it are reported as the info events of their
+	// deepest allowed ancestor. Zero means unlimited.
+	MaxSpanDepth int `yaml:"MaxSpanDepth,omitempty" env:"APPOPTICS_MAX_SPAN_DEPTH" default:"0"`
+	// The precision of the timestamps of the events, either us or ns. The
+	// Timestamp_ns KV is reported along with Timestamp_u if it's ns.
+	TimestampPrecision string `yaml:"TimestampPrecision,omitempty" env:"APPOPTICS_TIMESTAMP_PRECISION" default:"us"`
+	// ReportSpanDuration indicates if the duration of a span, as measured by
+	// the monotonic clock, is reported as the Duration_ns KV of its exit event,
+	// so it's not affected by the adjustments of the wall clock
+	ReportSpanDuration bool `yaml:"ReportSpanDuration,omitempty" env:"APPOPTICS_REPORT_SPAN_DURATION"`
+	// The directory the CPU profiles of the requests are written to, which
+	// are requested by the profile option of a signed X-Trace-Options. The
+	// temporary directory is used if it's empty.
+	CPUProfileDir string `yaml:"CPUProfileDir,omitempty" env:"APPOPTICS_CPU_PROFILE_DIR"`
+	// The local overrides of the token buckets limiting the traces started by
+	// the sampling and by the (relaxed and strict) trigger traces: the
+	// capacity is the burst allowed and the rate is the tokens refilled per
+	// second. Zero means the value of the remote settings is used. A larger
+	// capacity absorbs the bursts of e.g. cron-driven traffic without
+	// exhausting the bucket for the rest of the minute.
+	SamplingBucketCap             float64 `yaml:"SamplingBucketCap,omitempty" env:"APPOPTICS_SAMPLING_BUCKET_CAPACITY" default:"0"`
+	SamplingBucketRate            float64 `yaml:"SamplingBucketRate,omitempty" env:"APPOPTICS_SAMPLING_BUCKET_RATE" default:"0"`
+	TriggerTraceRelaxedBucketCap  float64 `yaml:"TriggerTraceRelaxedBucketCap,omitempty" env:"APPOPTICS_TRIGGER_TRACE_RELAXED_BUCKET_CAPACITY" default:"0"`
+	TriggerTraceRelaxedBucketRate float64 `yaml:"TriggerTraceRelaxedBucketRate,omitempty" env:"APPOPTICS_TRIGGER_TRACE_RELAXED_BUCKET_RATE" default:"0"`
+	TriggerTraceStrictBucketCap   float64 `yaml:"TriggerTraceStrictBucketCap,omitempty" env:"APPOPTICS_TRIGGER_TRACE_STRICT_BUCKET_CAPACITY" default:"0"`
+	TriggerTraceStrictBucketRate  float64 `yaml:"TriggerTraceStrictBucketRate,omitempty" env:"APPOPTICS_TRIGGER_TRACE_STRICT_BUCKET_RATE" default:"0"`
 }
 
 // SamplingConfig defines the configuration options for the sampling decision
@@ -156,7 +374,8 @@ const (
 	URL FilterType = "url"
 )
 
-// TracingMode defines the tracing mode which is either `enabled` or `disabled`
+// TracingMode defines the tracing mode which is `enabled`, `disabled`, `dry-run`
+// or `metrics-only`
 type TracingMode string
 
 const (
@@ -164,10 +383,84 @@ const (
 	EnabledTracingMode TracingMode = "enabled"
 	// DisabledTracingMode means tracing is disabled
 	DisabledTracingMode TracingMode = "disabled"
+	// DryRunTracingMode means the requests are traced as if it's enabled, but
+	// the events, metrics and status messages are only logged rather than
+	// sent, e.g., to measure the overhead of the instrumentation in a load
+	// test. The settings are still retrieved from the collector.
+	DryRunTracingMode TracingMode = "dry-run"
+	// MetricsOnlyTracingMode means the requests are never traced, i.e., no
+	// span is exported, but the metrics of the requests are still recorded
+	// and sent as if it's enabled.
+	MetricsOnlyTracingMode TracingMode = "metrics-only"
 
 	UnknownTracingMode TracingMode = "unknown"
 )
 
+// PropagationFormat defines the format of a trace context propagated in the
+// HTTP headers
+type PropagationFormat string
+
+const (
+	// XTracePropagation is the AppOptics X-Trace header
+	XTracePropagation PropagationFormat = "xtrace"
+	// TraceparentPropagation is the W3C traceparent header
+	TraceparentPropagation PropagationFormat = "traceparent"
+	// B3Propagation is the Zipkin B3 single or multiple headers
+	B3Propagation PropagationFormat = "b3"
+)
+
+// PropagationConflict defines how the conflicting trace contexts are handled
+type PropagationConflict string
+
+const (
+	// IgnorePropagationConflict means the conflicting contexts are ignored
+	IgnorePropagationConflict PropagationConflict = "ignore"
+	// LinkPropagationConflict means the conflicting contexts are reported as links
+	LinkPropagationConflict PropagationConflict = "link"
+)
+
+// SamplingMode defines how the sampling decisions are made against the sample
+// rate
+type SamplingMode string
+
+const (
+	// RandomSamplingMode means each request is sampled randomly
+	RandomSamplingMode SamplingMode = "random"
+	// TraceIDSamplingMode means a request is sampled if the hash of its trace
+	// ID is below the sample rate, so the services of a call chain configured
+	// with the same rate make the same decision independently
+	TraceIDSamplingMode SamplingMode = "traceid"
+)
+
+// ParentSampling defines when the sampled flag of the incoming trace context
+// is honored
+type ParentSampling string
+
+const (
+	// AlwaysParentSampling means the incoming trace contexts are always honored
+	AlwaysParentSampling ParentSampling = "always"
+	// NeverParentSampling means the sampled flags of the incoming trace
+	// contexts are never honored, the traces are continued but sampled by the
+	// local decision
+	NeverParentSampling ParentSampling = "never"
+	// TrustedParentSampling means the sampled flags of the incoming trace
+	// contexts are only honored if they are from the trusted peers
+	TrustedParentSampling ParentSampling = "trusted"
+)
+
+// UnsignedContext defines how the incoming trace contexts without a valid
+// signature are handled
+type UnsignedContext string
+
+const (
+	// RejectUnsignedContext means the unsigned contexts are ignored and new
+	// traces are started instead
+	RejectUnsignedContext UnsignedContext = "reject"
+	// DowngradeUnsignedContext means the traces of the unsigned contexts are
+	// continued, but the sampling decisions are made locally
+	DowngradeUnsignedContext UnsignedContext = "downgrade"
+)
+
 // TransactionFilter defines the transaction filtering based on a filter type.
 type TransactionFilter struct {
 	Type       FilterType  `yaml:"Type"`
@@ -361,10 +654,12 @@ func (c *Config) validate() error {
 	if hasLambdaEnv() {
 		c.ReporterType = reporterTypeServerless
 	} else {
-		c.ReporterType = strings.ToLower(strings.TrimSpace(c.ReporterType))
+		c.ReporterType = ToReporterType(c.ReporterType)
 	}
+	c.unknownReporterType = ""
 	if ok := IsValidReporterType(c.ReporterType); !ok {
 		log.Info(InvalidEnv("ReporterType", c.ReporterType))
+		c.unknownReporterType = c.ReporterType
 		c.ReporterType = getFieldDefaultValue(c, "ReporterType")
 	}
 
@@ -412,6 +707,174 @@ func (c *Config) validate() error {
 		}
 	}
 
+	for name, v := range map[string]*float64{
+		"SamplingBucketCap":            &c.SamplingBucketCap,
+		"TriggerTraceRelaxedBucketCap": &c.TriggerTraceRelaxedBucketCap,
+		"TriggerTraceStrictBucketCap":  &c.TriggerTraceStrictBucketCap,
+	} {
+		if valid := IsValidSamplingBucketCap(*v); !valid {
+			log.Warning(InvalidEnv(name, fmt.Sprintf("%f", *v)))
+			if *v < 0 {
+				*v = 0
+			} else {
+				*v = maxSamplingBucketCapacity
+			}
+		}
+	}
+
+	for name, v := range map[string]*float64{
+		"SamplingBucketRate":            &c.SamplingBucketRate,
+		"TriggerTraceRelaxedBucketRate": &c.TriggerTraceRelaxedBucketRate,
+		"TriggerTraceStrictBucketRate":  &c.TriggerTraceStrictBucketRate,
+	} {
+		if valid := IsValidSamplingBucketRate(*v); !valid {
+			log.Warning(InvalidEnv(name, fmt.Sprintf("%f", *v)))
+			if *v < 0 {
+				*v = 0
+			} else {
+				*v = maxSamplingBucketRate
+			}
+		}
+	}
+
+	if ok := IsValidServerTimingSpans(c.ServerTimingSpans); !ok {
+		log.Warning(InvalidEnv("ServerTimingSpans", strconv.Itoa(c.ServerTimingSpans)))
+		c.ServerTimingSpans = ToInteger(getFieldDefaultValue(c, "ServerTimingSpans"))
+	}
+
+	if ok := IsValidDrainTimeout(c.DrainTimeout); !ok {
+		log.Warning(InvalidEnv("DrainTimeout", strconv.Itoa(c.DrainTimeout)))
+		c.DrainTimeout = ToInteger(getFieldDefaultValue(c, "DrainTimeout"))
+	}
+
+	if c.SnapshotMaxAge < 0 {
+		log.Warning(InvalidEnv("SnapshotMaxAge", strconv.Itoa(c.SnapshotMaxAge)))
+		c.SnapshotMaxAge = ToInteger(getFieldDefaultValue(c, "SnapshotMaxAge"))
+	}
+
+	c.Compression = strings.ToLower(strings.TrimSpace(c.Compression))
+	if ok := IsValidCompression(c.Compression); !ok {
+		log.Warning(InvalidEnv("Compression", c.Compression))
+		c.Compression = getFieldDefaultValue(c, "Compression")
+	}
+
+	if c.BackTraceRate < 1 {
+		log.Warning(InvalidEnv("BackTraceRate", strconv.Itoa(c.BackTraceRate)))
+		c.BackTraceRate = ToInteger(getFieldDefaultValue(c, "BackTraceRate"))
+	}
+
+	if c.BackTraceConcurrency < 1 {
+		log.Warning(InvalidEnv("BackTraceConcurrency", strconv.Itoa(c.BackTraceConcurrency)))
+		c.BackTraceConcurrency = ToInteger(getFieldDefaultValue(c, "BackTraceConcurrency"))
+	}
+
+	if ok := IsValidBackTraceMaxBytes(c.BackTraceMaxBytes); !ok {
+		log.Warning(InvalidEnv("BackTraceMaxBytes", strconv.Itoa(c.BackTraceMaxBytes)))
+		c.BackTraceMaxBytes = ToInteger(getFieldDefaultValue(c, "BackTraceMaxBytes"))
+	}
+
+	if c.MetricTagValuesMax < 1 {
+		log.Warning(InvalidEnv("MetricTagValuesMax", strconv.Itoa(c.MetricTagValuesMax)))
+		c.MetricTagValuesMax = ToInteger(getFieldDefaultValue(c, "MetricTagValuesMax"))
+	}
+
+	if c.ErrorEventsPerMinute < 0 {
+		log.Warning(InvalidEnv("ErrorEventsPerMinute", strconv.Itoa(c.ErrorEventsPerMinute)))
+		c.ErrorEventsPerMinute = ToInteger(getFieldDefaultValue(c, "ErrorEventsPerMinute"))
+	}
+
+	c.TimestampPrecision = strings.ToLower(strings.TrimSpace(c.TimestampPrecision))
+	if ok := IsValidTimestampPrecision(c.TimestampPrecision); !ok {
+		log.Warning(InvalidEnv("TimestampPrecision", c.TimestampPrecision))
+		c.TimestampPrecision = getFieldDefaultValue(c, "TimestampPrecision")
+	}
+
+	if c.SpanCompressionThreshold < 0 {
+		log.Warning(InvalidEnv("SpanCompressionThreshold", strconv.Itoa(c.SpanCompressionThreshold)))
+		c.SpanCompressionThreshold = ToInteger(getFieldDefaultValue(c, "SpanCompressionThreshold"))
+	}
+
+	if c.MaxSpanDepth < 0 {
+		log.Warning(InvalidEnv("MaxSpanDepth", strconv.Itoa(c.MaxSpanDepth)))
+		c.MaxSpanDepth = ToInteger(getFieldDefaultValue(c, "MaxSpanDepth"))
+	}
+
+	if ok := IsValidErrorBodyCaptureBytes(c.ErrorBodyCaptureBytes); !ok {
+		log.Warning(InvalidEnv("ErrorBodyCaptureBytes", strconv.Itoa(c.ErrorBodyCaptureBytes)))
+		c.ErrorBodyCaptureBytes = ToInteger(getFieldDefaultValue(c, "ErrorBodyCaptureBytes"))
+	}
+
+	c.httpMethodSampleRates = nil
+	if c.HTTPMethodSampleRates != "" {
+		rates, err := ParseHTTPMethodSampleRates(c.HTTPMethodSampleRates)
+		if err != nil {
+			log.Warning(InvalidEnv("HTTPMethodSampleRates", c.HTTPMethodSampleRates))
+			c.HTTPMethodSampleRates = getFieldDefaultValue(c, "HTTPMethodSampleRates")
+		} else {
+			c.httpMethodSampleRates = rates
+		}
+	}
+
+	formats, err := ParsePropagationFormats(c.PropagationFormats)
+	if err != nil || len(formats) == 0 {
+		log.Warning(InvalidEnv("PropagationFormats", c.PropagationFormats))
+		c.PropagationFormats = getFieldDefaultValue(c, "PropagationFormats")
+		formats, _ = ParsePropagationFormats(c.PropagationFormats)
+	}
+	c.propagationFormats = formats
+
+	c.SamplingMode = SamplingMode(strings.ToLower(strings.TrimSpace(string(c.SamplingMode))))
+	if ok := IsValidSamplingMode(c.SamplingMode); !ok {
+		log.Warning(InvalidEnv("SamplingMode", string(c.SamplingMode)))
+		c.SamplingMode = SamplingMode(getFieldDefaultValue(c, "SamplingMode"))
+	}
+
+	c.ParentSampling = ParentSampling(strings.ToLower(strings.TrimSpace(string(c.ParentSampling))))
+	if ok := IsValidParentSampling(c.ParentSampling); !ok {
+		log.Warning(InvalidEnv("ParentSampling", string(c.ParentSampling)))
+		c.ParentSampling = ParentSampling(getFieldDefaultValue(c, "ParentSampling"))
+	}
+
+	c.trustedParents = nil
+	if c.TrustedParents != "" {
+		nets, err := ParseTrustedParents(c.TrustedParents)
+		if err != nil {
+			log.Warning(InvalidEnv("TrustedParents", c.TrustedParents))
+			c.TrustedParents = getFieldDefaultValue(c, "TrustedParents")
+		} else {
+			c.trustedParents = nets
+		}
+	}
+
+	c.UnsignedContext = UnsignedContext(strings.ToLower(strings.TrimSpace(string(c.UnsignedContext))))
+	if ok := IsValidUnsignedContext(c.UnsignedContext); !ok {
+		log.Warning(InvalidEnv("UnsignedContext", string(c.UnsignedContext)))
+		c.UnsignedContext = UnsignedContext(getFieldDefaultValue(c, "UnsignedContext"))
+	}
+
+	if ok := IsValidPropagationConflict(c.PropagationConflict); !ok {
+		log.Warning(InvalidEnv("PropagationConflict", string(c.PropagationConflict)))
+		c.PropagationConflict = PropagationConflict(getFieldDefaultValue(c, "PropagationConflict"))
+	}
+
+	c.propagateHosts = nil
+	if c.PropagateHosts != "" {
+		hosts, err := ParsePropagateHosts(c.PropagateHosts)
+		if err != nil {
+			// fail closed, an invalid allowlist propagates to no hosts
+			log.Warning(InvalidEnv("PropagateHosts", c.PropagateHosts))
+			c.PropagateHosts = getFieldDefaultValue(c, "PropagateHosts")
+			c.propagateHosts = []string{}
+		} else {
+			c.propagateHosts = hosts
+		}
+	}
+
+	c.disabledIntegrations = ParseDisabledIntegrations(c.DisabledIntegrations)
+	c.captureTrailers = ParseTrailers(c.CaptureTrailers)
+	c.excludedPaths = ParseExcludedPaths(c.ExcludedPaths)
+	c.metricTags = ParseMetricTags(c.MetricTags)
+
 	return c.ReporterProperties.validate()
 }
 
@@ -481,6 +944,10 @@ func (d *Delta) sanitize() *Delta {
 		if d.delta[idx].key == "ServiceKey" {
 			d.delta[idx].value = MaskServiceKey(d.delta[idx].value)
 		}
+		// the signing key is not logged at all
+		if d.delta[idx].key == "ContextSigningKey" && d.delta[idx].value != "" {
+			d.delta[idx].value = "********"
+		}
 	}
 	return d
 }
@@ -780,6 +1247,27 @@ func (c *Config) GetReporterType() string {
 	return c.ReporterType
 }
 
+// RecheckReporterType selects the reporter type discarded as unknown by the
+// last Load if it has become valid, i.e., the exporters in it are registered
+// by RegisterReporterType afterwards. It reports if the reporter type is
+// changed.
+func (c *Config) RecheckReporterType() bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.unknownReporterType == "" || !IsValidReporterType(c.unknownReporterType) {
+		return false
+	}
+	c.ReporterType, c.unknownReporterType = c.unknownReporterType, ""
+	return true
+}
+
+// GetJaegerEndpoint returns the endpoint of the jaeger reporter
+func (c *Config) GetJaegerEndpoint() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.JaegerEndpoint
+}
+
 // GetCollectorUDP returns the UDP collector host
 func (c *Config) GetCollectorUDP() string {
 	c.RLock()
@@ -794,6 +1282,20 @@ func (c *Config) GetTracingMode() TracingMode {
 	return c.Sampling.TracingMode
 }
 
+// SetTracingMode changes the local tracing mode at runtime. Switching between
+// enabled, dry-run and metrics-only takes effect immediately, while the other
+// changes take effect when the settings are refreshed from the collector.
+func (c *Config) SetTracingMode(mode TracingMode) error {
+	mode = NormalizeTracingMode(mode)
+	if !IsValidTracingMode(mode) {
+		return fmt.Errorf("invalid tracing mode: %s", mode)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.Sampling.SetTracingMode(mode)
+	return nil
+}
+
 // GetSampleRate returns the local sample rate
 func (c *Config) GetSampleRate() int {
 	c.RLock()
@@ -886,6 +1388,27 @@ func (c *Config) GetRuntimeMetrics() bool {
 	return c.RuntimeMetrics
 }
 
+// GetHostMetrics returns the host metrics flag
+func (c *Config) GetHostMetrics() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.HostMetrics
+}
+
+// GetContainerMetrics returns the container metrics flag
+func (c *Config) GetContainerMetrics() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ContainerMetrics
+}
+
+// GetSpanSchemaValidation returns the span schema validation flag
+func (c *Config) GetSpanSchemaValidation() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SpanSchemaValidation
+}
+
 // GetTokenBucketCap returns the token bucket capacity
 func (c *Config) GetTokenBucketCap() float64 {
 	c.RLock()
@@ -935,3 +1458,289 @@ func (c *Config) GetSQLSanitize() int {
 	defer c.RUnlock()
 	return c.SQLSanitize
 }
+
+// GetHTTPMethodSampleRate returns the sample rate configured for the HTTP
+// method, and false if there is none.
+func (c *Config) GetHTTPMethodSampleRate(method string) (int, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	rate, ok := c.httpMethodSampleRates[strings.ToUpper(method)]
+	return rate, ok
+}
+
+// GetServerTiming returns if the Server-Timing header is enabled
+func (c *Config) GetServerTiming() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ServerTiming
+}
+
+// GetServerTimingSpans returns the number of child spans to be included in the
+// Server-Timing header
+func (c *Config) GetServerTimingSpans() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ServerTimingSpans
+}
+
+// GetPropagateHosts returns the host patterns the trace context headers are
+// injected into. A nil slice means no restriction, while an empty one, e.g., of
+// an invalid APPOPTICS_PROPAGATE_HOSTS, allows no hosts.
+func (c *Config) GetPropagateHosts() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.propagateHosts
+}
+
+// GetPropagationFormats returns the trace context formats to be extracted, in
+// the order of precedence
+func (c *Config) GetPropagationFormats() []PropagationFormat {
+	c.RLock()
+	defer c.RUnlock()
+	return c.propagationFormats
+}
+
+// GetPropagationConflict returns how the conflicting trace contexts are handled
+func (c *Config) GetPropagationConflict() PropagationConflict {
+	c.RLock()
+	defer c.RUnlock()
+	return c.PropagationConflict
+}
+
+// GetSamplingMode returns how the requests are sampled against the sample rate
+func (c *Config) GetSamplingMode() SamplingMode {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SamplingMode
+}
+
+// GetParentSampling returns when the sampled flag of the incoming trace
+// context is honored
+func (c *Config) GetParentSampling() ParentSampling {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ParentSampling
+}
+
+// GetTrustedParents returns the networks of the trusted peers
+func (c *Config) GetTrustedParents() []*net.IPNet {
+	c.RLock()
+	defer c.RUnlock()
+	return c.trustedParents
+}
+
+// GetContextSigningKey returns the key of the signatures of the incoming trace
+// contexts
+func (c *Config) GetContextSigningKey() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ContextSigningKey
+}
+
+// GetUnsignedContext returns how the incoming trace contexts without a valid
+// signature are handled
+func (c *Config) GetUnsignedContext() UnsignedContext {
+	c.RLock()
+	defer c.RUnlock()
+	return c.UnsignedContext
+}
+
+// GetDrainTimeout returns the maximum time to wait for the pending events to
+// be sent when the process is stopping
+func (c *Config) GetDrainTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.DrainTimeout) * time.Millisecond
+}
+
+// GetCompression returns the compression of the gRPC requests
+func (c *Config) GetCompression() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Compression
+}
+
+// GetDisabledIntegrations returns the names of the integrations disabled by
+// the configuration
+func (c *Config) GetDisabledIntegrations() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.disabledIntegrations
+}
+
+// GetCaptureTrailers returns the lower-cased names of the response trailers
+// to be reported
+func (c *Config) GetCaptureTrailers() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.captureTrailers
+}
+
+// IsExcludedPath returns if the HTTP requests of the URL path are excluded
+// from tracing and metrics
+func (c *Config) IsExcludedPath(path string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, p := range c.excludedPaths {
+		if prefix := strings.TrimSuffix(p, "*"); prefix != p {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+			if dir := strings.TrimSuffix(prefix, "/"); dir != prefix && path == dir {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSQLCommenter returns if the trace context comment is appended to the SQL
+// statements
+func (c *Config) GetSQLCommenter() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SQLCommenter
+}
+
+// GetTimestampPrecision returns the precision of the timestamps of the events
+func (c *Config) GetTimestampPrecision() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TimestampPrecision
+}
+
+// GetReportSpanDuration returns if the monotonic durations of the spans are
+// reported on their exit events
+func (c *Config) GetReportSpanDuration() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ReportSpanDuration
+}
+
+// GetMetricTags returns the names of the KVs tagging the transaction metrics
+func (c *Config) GetMetricTags() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.metricTags
+}
+
+// GetMetricTagValuesMax returns the maximum number of the distinct values of a
+// metric tag
+func (c *Config) GetMetricTagValuesMax() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MetricTagValuesMax
+}
+
+// GetCPUProfileDir returns the directory the CPU profiles of the requests are
+// written to, empty for the temporary directory
+func (c *Config) GetCPUProfileDir() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.CPUProfileDir
+}
+
+// GetReportOverhead returns if the overhead of the agent is reported on the
+// traces
+func (c *Config) GetReportOverhead() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ReportOverhead
+}
+
+// GetBackTraceRate returns the maximum number of the backtraces captured per
+// second
+func (c *Config) GetBackTraceRate() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.BackTraceRate
+}
+
+// GetBackTraceConcurrency returns the maximum number of the backtraces
+// captured at the same time
+func (c *Config) GetBackTraceConcurrency() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.BackTraceConcurrency
+}
+
+// GetBackTraceMaxBytes returns the maximum size of a backtrace
+func (c *Config) GetBackTraceMaxBytes() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.BackTraceMaxBytes
+}
+
+// GetSettingsSnapshot returns the path of the settings snapshot, empty if it's
+// disabled
+func (c *Config) GetSettingsSnapshot() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SettingsSnapshot
+}
+
+// GetSnapshotMaxAge returns the maximum age of a settings snapshot to be
+// restored
+func (c *Config) GetSnapshotMaxAge() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.SnapshotMaxAge) * time.Second
+}
+
+// GetErrorEventsPerMinute returns the maximum number of the error events with
+// the same fingerprint reported per minute
+func (c *Config) GetErrorEventsPerMinute() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ErrorEventsPerMinute
+}
+
+// GetSpanCompressionThreshold returns the number of consecutive identical child
+// spans reported before the rest of them are compressed, zero if disabled
+func (c *Config) GetSpanCompressionThreshold() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SpanCompressionThreshold
+}
+
+// GetMaxSpanDepth returns the maximum depth of the spans of a trace, zero if
+// unlimited
+func (c *Config) GetMaxSpanDepth() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MaxSpanDepth
+}
+
+// GetSamplingBucket returns the local overrides of the capacity and the rate
+// of the sampling token bucket, zero if not configured
+func (c *Config) GetSamplingBucket() (float64, float64) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SamplingBucketCap, c.SamplingBucketRate
+}
+
+// GetTriggerTraceRelaxedBucket returns the local overrides of the capacity and
+// the rate of the relaxed trigger trace token bucket, zero if not configured
+func (c *Config) GetTriggerTraceRelaxedBucket() (float64, float64) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TriggerTraceRelaxedBucketCap, c.TriggerTraceRelaxedBucketRate
+}
+
+// GetTriggerTraceStrictBucket returns the local overrides of the capacity and
+// the rate of the strict trigger trace token bucket, zero if not configured
+func (c *Config) GetTriggerTraceStrictBucket() (float64, float64) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TriggerTraceStrictBucketCap, c.TriggerTraceStrictBucketRate
+}
+
+// GetErrorBodyCaptureBytes returns the maximum number of bytes of the 5xx
+// response body to be reported
+func (c *Config) GetErrorBodyCaptureBytes() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ErrorBodyCaptureBytes
+}
@@ -3,7 +3,11 @@
 package config
 
 import (
+	"strconv"
 	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 )
 
 // ReporterOptions defines the options of a reporter. The fields of it
@@ -12,6 +16,11 @@ type ReporterOptions struct {
 	// Events flush interval in seconds
 	EventFlushInterval int64 `yaml:"EventFlushInterval,omitempty" env:"APPOPTICS_EVENTS_FLUSH_INTERVAL" default:"2"`
 
+	// The maximum time in milliseconds an event waits in the queue before it's
+	// sent. The queued events are flushed as soon as the oldest one reaches
+	// this age, which is useful for services of low traffic. 0 means disabled.
+	EventMaxAge int64 `yaml:"EventMaxAge,omitempty" env:"APPOPTICS_EVENTS_MAX_AGE" default:"0"`
+
 	// The maximum bytes per RPC request
 	MaxReqBytes int64 `yaml:"MaxReqBytes,omitempty" env:"APPOPTICS_MAX_REQUEST_BYTES" default:"2048000"`
 
@@ -58,12 +67,21 @@ func (r *ReporterOptions) GetEventFlushInterval() int64 {
 	return atomic.LoadInt64(&r.EventFlushInterval)
 }
 
+// GetEventMaxAge returns the maximum time an event waits before it's sent,
+// or zero if it's not limited.
+func (r *ReporterOptions) GetEventMaxAge() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.EventMaxAge)) * time.Millisecond
+}
+
 // GetMaxReqBytes returns the maximum RPC request size
 func (r *ReporterOptions) GetMaxReqBytes() int64 {
 	return atomic.LoadInt64(&r.MaxReqBytes)
 }
 
 func (r *ReporterOptions) validate() error {
-	// TODO
+	if age := r.GetEventMaxAge(); age < 0 {
+		log.Warning(InvalidEnv("EventMaxAge", strconv.FormatInt(r.EventMaxAge, 10)))
+		atomic.StoreInt64(&r.EventMaxAge, 0)
+	}
 	return nil
 }
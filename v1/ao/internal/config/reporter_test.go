@@ -4,6 +4,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,5 +18,13 @@ func TestReporterOptions(t *testing.T) {
 	r.SetMaxReqBytes(2000)
 	assert.Equal(t, r.GetMaxReqBytes(), int64(2000))
 
+	assert.Equal(t, time.Duration(0), r.GetEventMaxAge())
+	r.EventMaxAge = 500
+	assert.Equal(t, 500*time.Millisecond, r.GetEventMaxAge())
+
+	assert.Nil(t, r.validate())
+
+	r.EventMaxAge = -1
 	assert.Nil(t, r.validate())
+	assert.Equal(t, time.Duration(0), r.GetEventMaxAge())
 }
@@ -4,9 +4,11 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
@@ -33,11 +35,25 @@ const (
 	invalidCharReplacer = ""
 )
 
+// the compressions of the gRPC requests
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// the precisions of the timestamps of the events
+const (
+	TimestampPrecisionMicro = "us"
+	TimestampPrecisionNano  = "ns"
+)
+
 // reporter types
 const (
 	reporterTypeSSL = "ssl"
 	reporterTypeUDP = "udp"
 	reporterTypeServerless = "serverless"
+	reporterTypeJaeger = "jaeger"
 )
 
 var (
@@ -92,10 +108,55 @@ func IsValidFile(file string) bool {
 	return true
 }
 
-// IsValidReporterType checks if the reporter type is valid.
-func IsValidReporterType(t string) bool {
+// the reporter types of the custom exporters
+var customReporterTypes = struct {
+	sync.RWMutex
+	m map[string]bool
+}{m: make(map[string]bool)}
+
+// RegisterReporterType makes the reporter type of a custom exporter valid.
+func RegisterReporterType(name string) {
+	customReporterTypes.Lock()
+	defer customReporterTypes.Unlock()
+	customReporterTypes.m[strings.ToLower(name)] = true
+}
+
+// IsBuiltinReporterType checks if the reporter type is provided by the agent.
+func IsBuiltinReporterType(t string) bool {
 	t = strings.ToLower(strings.TrimSpace(t))
-	return t == reporterTypeSSL || t == reporterTypeUDP || t == reporterTypeServerless
+	return t == reporterTypeSSL || t == reporterTypeUDP || t == reporterTypeServerless ||
+		t == reporterTypeJaeger
+}
+
+// IsValidReporterType checks if the reporter type is valid, i.e., built-in or
+// registered by RegisterReporterType. A comma-separated list of the types is
+// valid too.
+func IsValidReporterType(types string) bool {
+	customReporterTypes.RLock()
+	defer customReporterTypes.RUnlock()
+	for _, t := range strings.Split(types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !IsBuiltinReporterType(t) && !customReporterTypes.m[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// ToReporterType converts the reporter types to lowercase, the duplicates and
+// empty items of a comma-separated list are removed.
+func ToReporterType(types string) string {
+	var ts []string
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		ts = append(ts, t)
+	}
+	return strings.Join(ts, ",")
 }
 
 // IsValidEc2MetadataTimeout checks if the timeout is within the designated range
@@ -105,7 +166,8 @@ func IsValidEc2MetadataTimeout(t int) bool {
 
 // IsValidTracingMode checks if the mode is valid
 func IsValidTracingMode(m TracingMode) bool {
-	return m == EnabledTracingMode || m == DisabledTracingMode
+	return m == EnabledTracingMode || m == DisabledTracingMode || m == DryRunTracingMode ||
+		m == MetricsOnlyTracingMode
 }
 
 // IsValidSampleRate checks if the rate is valid
@@ -121,8 +183,235 @@ func IsValidTokenBucketCap(cap float64) bool {
 	return cap >= 0 && cap <= maxTokenBucketCapacity
 }
 
+// IsValidSamplingBucketCap checks if the local override of a sampling token
+// bucket capacity is within the designated range
+func IsValidSamplingBucketCap(cap float64) bool {
+	return cap >= 0 && cap <= maxSamplingBucketCapacity
+}
+
+// IsValidSamplingBucketRate checks if the local override of a sampling token
+// bucket rate is within the designated range
+func IsValidSamplingBucketRate(rate float64) bool {
+	return rate >= 0 && rate <= maxSamplingBucketRate
+}
+
+// IsValidServerTimingSpans checks if the number of child spans in the
+// Server-Timing header is within the designated range
+func IsValidServerTimingSpans(n int) bool {
+	return n >= 0 && n <= maxServerTimingSpans
+}
+
+// IsValidCompression checks if the compression of the gRPC requests is
+// supported
+func IsValidCompression(c string) bool {
+	return c == CompressionGzip || c == CompressionZstd || c == CompressionNone
+}
+
+// IsValidTimestampPrecision checks if the precision of the timestamps of the
+// events is supported
+func IsValidTimestampPrecision(p string) bool {
+	return p == TimestampPrecisionMicro || p == TimestampPrecisionNano
+}
+
+// IsValidDrainTimeout checks if the drain timeout is within the designated range
+func IsValidDrainTimeout(t int) bool {
+	return t >= 0 && t <= maxDrainTimeout
+}
+
+// IsValidErrorBodyCaptureBytes checks if the number of bytes of the error
+// response body to be captured is within the designated range
+func IsValidErrorBodyCaptureBytes(n int) bool {
+	return n >= 0 && n <= maxErrorBodyCaptureBytes
+}
+
+// IsValidBackTraceMaxBytes checks if the maximum size of a backtrace is within
+// the designated range
+func IsValidBackTraceMaxBytes(n int) bool {
+	return n >= minBackTraceMaxBytes && n <= maxBackTraceMaxBytes
+}
+
+// ParseHTTPMethodSampleRates parses the per-HTTP-method sample rates in the
+// format of "METHOD:rate,...". The method names are converted to upper case.
+func ParseHTTPMethodSampleRates(s string) (map[string]int, error) {
+	rates := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid HTTP method sample rate: %s", item)
+		}
+		method := strings.ToUpper(strings.TrimSpace(kv[0]))
+		rate, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if method == "" || err != nil || !IsValidSampleRate(rate) {
+			return nil, fmt.Errorf("invalid HTTP method sample rate: %s", item)
+		}
+		rates[method] = rate
+	}
+	return rates, nil
+}
+
+// ParsePropagateHosts parses the comma-separated host patterns the trace
+// context headers are injected into. A pattern is either a host name, or a
+// wildcard "*.example.com" which matches all the subdomains of example.com.
+// A list of no patterns, e.g., ",", is invalid rather than an allowlist of all
+// the hosts.
+func ParsePropagateHosts(s string) ([]string, error) {
+	var hosts []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		name := strings.TrimPrefix(item, "*.")
+		if name == "" || strings.ContainsAny(name, "*/: ") {
+			return nil, fmt.Errorf("invalid propagate host: %s", item)
+		}
+		hosts = append(hosts, item)
+	}
+	if hosts == nil && s != "" {
+		return nil, fmt.Errorf("invalid propagate hosts: %s", s)
+	}
+	return hosts, nil
+}
+
+// ParseTrustedParents parses the comma-separated IPs or CIDRs of the trusted
+// peers. An IP is converted to a network of itself only.
+func ParseTrustedParents(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted parent: %s", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted parent: %s", item)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ParseDisabledIntegrations parses the comma-separated integration names.
+func ParseDisabledIntegrations(s string) []string {
+	var names []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			names = append(names, item)
+		}
+	}
+	return names
+}
+
+// ParseTrailers parses the comma-separated names of the trailers.
+func ParseTrailers(s string) []string {
+	var names []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			names = append(names, item)
+		}
+	}
+	return names
+}
+
+// ParseExcludedPaths parses the comma-separated URL paths excluded from tracing
+// and metrics. "none" excludes nothing.
+func ParseExcludedPaths(s string) []string {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		return nil
+	}
+	var paths []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			paths = append(paths, item)
+		}
+	}
+	return paths
+}
+
+// the maximum number of the metric tags, each of which multiplies the number
+// of the transaction metrics
+const metricTagsMax = 3
+
+// ParseMetricTags parses the comma-separated names of the KVs tagging the
+// transaction metrics. The duplicates and the names beyond the first 3 are
+// dropped.
+func ParseMetricTags(s string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" || seen[item] {
+			continue
+		}
+		if len(names) == metricTagsMax {
+			break
+		}
+		seen[item] = true
+		names = append(names, item)
+	}
+	return names
+}
+
+// ParsePropagationFormats parses the comma-separated trace context formats.
+func ParsePropagationFormats(s string) ([]PropagationFormat, error) {
+	var formats []PropagationFormat
+	seen := make(map[PropagationFormat]bool)
+	for _, item := range strings.Split(s, ",") {
+		f := PropagationFormat(strings.ToLower(strings.TrimSpace(item)))
+		if f == "" {
+			continue
+		}
+		if f != XTracePropagation && f != TraceparentPropagation && f != B3Propagation {
+			return nil, fmt.Errorf("invalid propagation format: %s", item)
+		}
+		if seen[f] {
+			return nil, fmt.Errorf("duplicate propagation format: %s", item)
+		}
+		seen[f] = true
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+// IsValidPropagationConflict checks if the conflict handling is valid
+func IsValidPropagationConflict(c PropagationConflict) bool {
+	return c == IgnorePropagationConflict || c == LinkPropagationConflict
+}
+
+// IsValidSamplingMode checks if the sampling mode is valid
+func IsValidSamplingMode(m SamplingMode) bool {
+	return m == RandomSamplingMode || m == TraceIDSamplingMode
+}
+
+// IsValidParentSampling checks if the parent-based sampling policy is valid
+func IsValidParentSampling(p ParentSampling) bool {
+	return p == AlwaysParentSampling || p == NeverParentSampling || p == TrustedParentSampling
+}
+
+// IsValidUnsignedContext checks if the handling of the unsigned contexts is
+// valid
+func IsValidUnsignedContext(u UnsignedContext) bool {
+	return u == RejectUnsignedContext || u == DowngradeUnsignedContext
+}
+
 // NormalizeTracingMode converts an old-style tracing mode (always/never) to a
-// new-style tracing mode (enabled/disabled).
+// new-style tracing mode (enabled/disabled). The dry-run and metrics-only modes
+// are case-insensitive.
 func NormalizeTracingMode(m TracingMode) TracingMode {
 	modeStr := strings.ToLower(strings.TrimSpace(string(m)))
 	mode := m
@@ -131,6 +420,10 @@ func NormalizeTracingMode(m TracingMode) TracingMode {
 		mode = EnabledTracingMode
 	} else if modeStr == "never" {
 		mode = DisabledTracingMode
+	} else if modeStr == string(DryRunTracingMode) {
+		mode = DryRunTracingMode
+	} else if modeStr == string(MetricsOnlyTracingMode) {
+		mode = MetricsOnlyTracingMode
 	}
 
 	return mode
@@ -59,6 +59,7 @@ func TestMaskServiceKey(t *testing.T) {
 func TestIsValidTracingMode(t *testing.T) {
 	assert.Equal(t, true, IsValidTracingMode("enabled"))
 	assert.Equal(t, true, IsValidTracingMode("disabled"))
+	assert.Equal(t, true, IsValidTracingMode("dry-run"))
 	assert.Equal(t, false, IsValidTracingMode("abc"))
 	assert.Equal(t, false, IsValidTracingMode(""))
 	assert.Equal(t, false, IsValidTracingMode("ENABLED"))
@@ -74,6 +75,35 @@ func TestIsValidReporterType(t *testing.T) {
 	assert.Equal(t, false, IsValidReporterType(""))
 	assert.Equal(t, false, IsValidReporterType("udpabc"))
 	assert.Equal(t, true, IsValidReporterType("serverless"))
+	assert.Equal(t, true, IsValidReporterType("Jaeger"))
+	assert.Equal(t, true, IsValidReporterType("ssl, jaeger"))
+	assert.Equal(t, false, IsValidReporterType("ssl,custom"))
+	RegisterReporterType("Custom")
+	assert.Equal(t, true, IsValidReporterType("ssl,custom"))
+	assert.Equal(t, false, IsBuiltinReporterType("custom"))
+	assert.Equal(t, false, IsValidReporterType("ssl,"))
+	assert.Equal(t, false, IsValidReporterType("ssl,xxx"))
+}
+
+func TestToReporterType(t *testing.T) {
+	assert.Equal(t, "ssl", ToReporterType(" SSL "))
+	assert.Equal(t, "ssl,jaeger", ToReporterType("ssl, Jaeger,,ssl,"))
+	assert.Equal(t, "", ToReporterType(""))
+}
+
+func TestIsValidCompression(t *testing.T) {
+	assert.True(t, IsValidCompression("gzip"))
+	assert.True(t, IsValidCompression("none"))
+	assert.True(t, IsValidCompression("zstd"))
+	assert.False(t, IsValidCompression("br"))
+	assert.False(t, IsValidCompression(""))
+}
+
+func TestIsValidTimestampPrecision(t *testing.T) {
+	assert.True(t, IsValidTimestampPrecision("us"))
+	assert.True(t, IsValidTimestampPrecision("ns"))
+	assert.False(t, IsValidTimestampPrecision("ms"))
+	assert.False(t, IsValidTimestampPrecision(""))
 }
 
 func TestConverters(t *testing.T) {
@@ -110,3 +140,76 @@ func TestToServiceKey(t *testing.T) {
 		assert.Equal(t, tc.after, ToServiceKey(tc.before), fmt.Sprintf("Case #%d", idx))
 	}
 }
+
+func TestParseHTTPMethodSampleRates(t *testing.T) {
+	rates, err := ParseHTTPMethodSampleRates("options:0, HEAD:10000,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"OPTIONS": 0, "HEAD": 10000}, rates)
+
+	rates, err = ParseHTTPMethodSampleRates("")
+	assert.Nil(t, err)
+	assert.Empty(t, rates)
+
+	for _, s := range []string{"OPTIONS", "OPTIONS:abc", ":100", "HEAD:-1", "HEAD:1000001"} {
+		_, err = ParseHTTPMethodSampleRates(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestParsePropagateHosts(t *testing.T) {
+	hosts, err := ParsePropagateHosts("API.example.com, *.internal.example.com,")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api.example.com", "*.internal.example.com"}, hosts)
+
+	hosts, err = ParsePropagateHosts("")
+	assert.Nil(t, err)
+	assert.Empty(t, hosts)
+
+	for _, s := range []string{"*", "*.", "a.*.com", "http://example.com", "example.com:8080", ",", " , ", " "} {
+		_, err = ParsePropagateHosts(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestParseTrustedParents(t *testing.T) {
+	nets, err := ParseTrustedParents("10.0.0.0/8, 192.168.1.10,::1,")
+	assert.Nil(t, err)
+	assert.Len(t, nets, 3)
+	assert.Equal(t, "10.0.0.0/8", nets[0].String())
+	assert.Equal(t, "192.168.1.10/32", nets[1].String())
+	assert.Equal(t, "::1/128", nets[2].String())
+
+	nets, err = ParseTrustedParents("")
+	assert.Nil(t, err)
+	assert.Empty(t, nets)
+
+	for _, s := range []string{"10.0.0.0/33", "example.com", "10.0.0"} {
+		_, err = ParseTrustedParents(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestParseDisabledIntegrations(t *testing.T) {
+	assert.Equal(t, []string{"aogrpc.client", "net/http"}, ParseDisabledIntegrations(" aogrpc.Client,,net/http "))
+	assert.Empty(t, ParseDisabledIntegrations(""))
+}
+
+func TestParsePropagationFormats(t *testing.T) {
+	formats, err := ParsePropagationFormats("B3, traceparent,xtrace,")
+	assert.Nil(t, err)
+	assert.Equal(t, []PropagationFormat{B3Propagation, TraceparentPropagation, XTracePropagation}, formats)
+
+	for _, s := range []string{"jaeger", "xtrace,b3,xtrace"} {
+		_, err = ParsePropagationFormats(s)
+		assert.NotNil(t, err, s)
+	}
+
+	assert.True(t, IsValidPropagationConflict(LinkPropagationConflict))
+	assert.False(t, IsValidPropagationConflict("merge"))
+}
+
+func TestParseMetricTags(t *testing.T) {
+	assert.Nil(t, ParseMetricTags(""))
+	assert.Equal(t, []string{"tenant", "region"}, ParseMetricTags(" tenant,,region, tenant "))
+	assert.Equal(t, []string{"a", "b", "c"}, ParseMetricTags("a,b,c,d"))
+}
@@ -18,15 +18,27 @@ var GetTrustedPath = conf.GetTrustedPath
 // GetReporterType is a wrapper to the method of the global config
 var GetReporterType = conf.GetReporterType
 
+// RecheckReporterType is a wrapper to the method of the global config
+var RecheckReporterType = conf.RecheckReporterType
+
 // GetTracingMode is a wrapper to the method of the global config
 var GetTracingMode = conf.GetTracingMode
 
+// SetTracingMode is a wrapper to the method of the global config
+var SetTracingMode = conf.SetTracingMode
+
 // GetSampleRate is a wrapper to the method of the global config
 var GetSampleRate = conf.GetSampleRate
 
 // SamplingConfigured is a wrapper to the method of the global config
 var SamplingConfigured = conf.SamplingConfigured
 
+// GetCompression is a wrapper to the method of the global config
+var GetCompression = conf.GetCompression
+
+// GetJaegerEndpoint is a wrapper to the method of the global config
+var GetJaegerEndpoint = conf.GetJaegerEndpoint
+
 // GetCollectorUDP is a wrapper to the method of the global config
 var GetCollectorUDP = conf.GetCollectorUDP
 
@@ -63,6 +75,12 @@ var GetProxyCertPath = conf.GetProxyCertPath
 // GetRuntimeMetrics is a wrapper to the method of the global config
 var GetRuntimeMetrics = conf.GetRuntimeMetrics
 
+// GetHostMetrics is a wrapper to the method of the global config
+var GetHostMetrics = conf.GetHostMetrics
+
+// GetContainerMetrics is a wrapper to the method of the global config
+var GetContainerMetrics = conf.GetContainerMetrics
+
 var GetTokenBucketCap = conf.GetTokenBucketCap
 var GetTokenBucketRate = conf.GetTokenBucketRate
 var GetReportQueryString = conf.GetReportQueryString
@@ -72,6 +90,111 @@ var GetTransactionFiltering = conf.GetTransactionFiltering
 
 var GetTransactionName = conf.GetTransactionName
 
+// GetHTTPMethodSampleRate is a wrapper to the method of the global config
+var GetHTTPMethodSampleRate = conf.GetHTTPMethodSampleRate
+
+// GetServerTiming is a wrapper to the method of the global config
+var GetServerTiming = conf.GetServerTiming
+
+// GetServerTimingSpans is a wrapper to the method of the global config
+var GetServerTimingSpans = conf.GetServerTimingSpans
+
+// GetPropagateHosts is a wrapper to the method of the global config
+var GetPropagateHosts = conf.GetPropagateHosts
+
+// GetDisabledIntegrations is a wrapper to the method of the global config
+var GetDisabledIntegrations = conf.GetDisabledIntegrations
+
+// GetCaptureTrailers is a wrapper to the method of the global config
+var GetCaptureTrailers = conf.GetCaptureTrailers
+
+// IsExcludedPath is a wrapper to the method of the global config
+var IsExcludedPath = conf.IsExcludedPath
+
+// GetPropagationFormats is a wrapper to the method of the global config
+var GetPropagationFormats = conf.GetPropagationFormats
+
+// GetSamplingMode is a wrapper to the method of the global config
+var GetSamplingMode = conf.GetSamplingMode
+
+// GetParentSampling is a wrapper to the method of the global config
+var GetParentSampling = conf.GetParentSampling
+
+// GetTrustedParents is a wrapper to the method of the global config
+var GetTrustedParents = conf.GetTrustedParents
+
+// GetContextSigningKey is a wrapper to the method of the global config
+var GetContextSigningKey = conf.GetContextSigningKey
+
+// GetUnsignedContext is a wrapper to the method of the global config
+var GetUnsignedContext = conf.GetUnsignedContext
+
+// GetPropagationConflict is a wrapper to the method of the global config
+var GetPropagationConflict = conf.GetPropagationConflict
+
+// GetDrainTimeout is a wrapper to the method of the global config
+var GetDrainTimeout = conf.GetDrainTimeout
+
+// GetSQLCommenter is a wrapper to the method of the global config
+var GetSQLCommenter = conf.GetSQLCommenter
+
+// GetTimestampPrecision is a wrapper to the method of the global config
+var GetTimestampPrecision = conf.GetTimestampPrecision
+
+// GetReportSpanDuration is a wrapper to the method of the global config
+var GetReportSpanDuration = conf.GetReportSpanDuration
+
+// GetMetricTags is a wrapper to the method of the global config
+var GetMetricTags = conf.GetMetricTags
+
+// GetMetricTagValuesMax is a wrapper to the method of the global config
+var GetMetricTagValuesMax = conf.GetMetricTagValuesMax
+
+// GetCPUProfileDir is a wrapper to the method of the global config
+var GetCPUProfileDir = conf.GetCPUProfileDir
+
+// GetReportOverhead is a wrapper to the method of the global config
+var GetReportOverhead = conf.GetReportOverhead
+
+// GetBackTraceRate is a wrapper to the method of the global config
+var GetBackTraceRate = conf.GetBackTraceRate
+
+// GetBackTraceConcurrency is a wrapper to the method of the global config
+var GetBackTraceConcurrency = conf.GetBackTraceConcurrency
+
+// GetBackTraceMaxBytes is a wrapper to the method of the global config
+var GetBackTraceMaxBytes = conf.GetBackTraceMaxBytes
+
+// GetSettingsSnapshot is a wrapper to the method of the global config
+var GetSettingsSnapshot = conf.GetSettingsSnapshot
+
+// GetSnapshotMaxAge is a wrapper to the method of the global config
+var GetSnapshotMaxAge = conf.GetSnapshotMaxAge
+
+// GetErrorEventsPerMinute is a wrapper to the method of the global config
+var GetErrorEventsPerMinute = conf.GetErrorEventsPerMinute
+
+// GetSpanCompressionThreshold is a wrapper to the method of the global config
+var GetSpanCompressionThreshold = conf.GetSpanCompressionThreshold
+
+// GetMaxSpanDepth is a wrapper to the method of the global config
+var GetMaxSpanDepth = conf.GetMaxSpanDepth
+
+// GetSamplingBucket is a wrapper to the method of the global config
+var GetSamplingBucket = conf.GetSamplingBucket
+
+// GetTriggerTraceRelaxedBucket is a wrapper to the method of the global config
+var GetTriggerTraceRelaxedBucket = conf.GetTriggerTraceRelaxedBucket
+
+// GetTriggerTraceStrictBucket is a wrapper to the method of the global config
+var GetTriggerTraceStrictBucket = conf.GetTriggerTraceStrictBucket
+
+// GetErrorBodyCaptureBytes is a wrapper to the method of the global config
+var GetErrorBodyCaptureBytes = conf.GetErrorBodyCaptureBytes
+
+// GetSpanSchemaValidation is a wrapper to the method of the global config
+var GetSpanSchemaValidation = conf.GetSpanSchemaValidation
+
 // GetSQLSanitize is a wrapper to method GetSQLSanitize of the global variable config.
 var GetSQLSanitize = conf.GetSQLSanitize
 
@@ -0,0 +1,181 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package graphtest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExportOptions controls how the events are exported.
+type ExportOptions struct {
+	// Normalize replaces the task IDs, the op IDs and the timestamps with
+	// the sequential ones in the order of the events, sorts the KVs and
+	// drops the ones which differ in each run, e.g., Hostname, so the output
+	// can be compared with a golden file.
+	Normalize bool
+	// ExcludeKeys are the KVs left out, e.g., the durations measured.
+	ExcludeKeys []string
+}
+
+// the KVs dropped by the normalization
+var volatileKeys = []string{"Hostname", "PID", "Backtrace"}
+
+// the KVs not shown as the labels of the DOT nodes
+var dotHiddenKeys = map[string]bool{
+	"X-Trace": true, "Backtrace": true, "Timestamp_u": true, "Hostname": true, "_V": true, "PID": true,
+}
+
+// Normalize returns a copy of the encoded events as described by
+// ExportOptions.
+func Normalize(bufs [][]byte, opts ExportOptions) ([][]byte, error) {
+	excluded := make(map[string]bool)
+	for _, k := range opts.ExcludeKeys {
+		excluded[k] = true
+	}
+	if opts.Normalize {
+		for _, k := range volatileKeys {
+			excluded[k] = true
+		}
+	}
+	tasks := make(map[string]string)
+	ops := make(map[string]string)
+	newID := func(m map[string]string, id string, width, pad int) string {
+		if n, ok := m[id]; ok {
+			return n
+		}
+		n := fmt.Sprintf("%0*X%s", width, len(m)+1, strings.Repeat("0", pad))
+		m[id] = n
+		return n
+	}
+
+	ret := make([][]byte, 0, len(bufs))
+	for i, buf := range bufs {
+		d := bson.D{}
+		if err := bson.Unmarshal(buf, &d); err != nil {
+			return nil, fmt.Errorf("event #%d: %v", i, err)
+		}
+		nd := make(bson.D, 0, len(d))
+		for _, kv := range d {
+			if excluded[kv.Name] {
+				continue
+			}
+			if opts.Normalize {
+				switch kv.Name {
+				case "X-Trace":
+					md, _ := kv.Value.(string)
+					if len(md) != 60 {
+						return nil, fmt.Errorf("event #%d: invalid X-Trace %q", i, md)
+					}
+					// the trace ID is the first 16 bytes of the task ID
+					kv.Value = md[:2] + newID(tasks, md[2:42], 32, 8) + newID(ops, md[42:58], 16, 0) + md[58:]
+				case "Edge":
+					edge, _ := kv.Value.(string)
+					kv.Value = newID(ops, strings.ToUpper(edge), 16, 0)
+				case "Timestamp_u":
+					kv.Value = int64(i+1) * 1000
+				}
+			}
+			nd = append(nd, kv)
+		}
+		if opts.Normalize {
+			// some KVs are added from the maps in random order
+			sort.SliceStable(nd, func(i, j int) bool { return nd[i].Name < nd[j].Name })
+		}
+		b, err := bson.Marshal(nd)
+		if err != nil {
+			return nil, fmt.Errorf("event #%d: %v", i, err)
+		}
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+// WriteDOT writes the encoded events as a Graphviz DOT graph. The nodes are
+// written in the order of the events and their KVs are sorted, so the output
+// is stable for the same events.
+func WriteDOT(w io.Writer, bufs [][]byte, opts ExportOptions) error {
+	bufs, err := Normalize(bufs, opts)
+	if err != nil {
+		return err
+	}
+	nodes := make([]Node, 0, len(bufs))
+	minT := int64(math.MaxInt64) // find min timestamp
+	for _, buf := range bufs {
+		n, _, err := decodeNode(buf)
+		if err != nil {
+			return err
+		}
+		if t, ok := n.Map["Timestamp_u"].(int64); ok && t < minT {
+			minT = t
+		}
+		nodes = append(nodes, n)
+	}
+
+	fmt.Fprintln(w, "digraph main{")
+	fmt.Fprintln(w, "\tedge[arrowhead=vee]")
+	fmt.Fprintln(w, "\tgraph [rankdir=RL,compound=true,ranksep=1.0];")
+	for _, n := range nodes {
+		ts, _ := n.Map["Timestamp_u"].(int64)
+		keys := make([]string, 0, len(n.Map))
+		for k := range n.Map {
+			if !dotHiddenKeys[k] {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var suffix string
+		for _, k := range keys {
+			switch v := n.Map[k].(type) {
+			case []byte:
+				suffix += fmt.Sprintf("\\n%s: %s", k, v)
+			case string:
+				suffix += fmt.Sprintf("\\n%s: %s", k, strings.Replace(v, `"`, `\"`, -1))
+			default:
+				suffix += fmt.Sprintf("\\n%s: %v", k, v)
+			}
+		}
+		fmt.Fprintf(w, "\top%s[shape=%s,label=\"%s\"];\n",
+			n.OpID, "box",
+			fmt.Sprintf("%s: %s\\n%s\\n%0.3fms%s", n.Layer, n.Label, n.OpID, float64(ts-minT)/1000.0, suffix))
+		for _, target := range n.Edges {
+			fmt.Fprintf(w, "\top%s -> op%s;\n", n.OpID, target)
+		}
+	}
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
+
+// AssertGoldenFile asserts that got is the same as the content of the file.
+// The file is written with got instead if UPDATE_GOLDEN is set, e.g.,
+//   UPDATE_GOLDEN=1 go test -run TestMyHandler
+func AssertGoldenFile(t *testing.T, path string, got []byte) bool {
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Errorf("failed to create the directory of %s: %v", path, err)
+			return false
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Errorf("failed to update %s: %v", path, err)
+			return false
+		}
+		t.Logf("Updated golden file %s", path)
+		return true
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("failed to read %s, run the test with UPDATE_GOLDEN=1 to create it: %v", path, err)
+		return false
+	}
+	return assert.Equal(t, string(want), string(got), "the output differs from %s", path)
+}
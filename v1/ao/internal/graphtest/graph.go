@@ -6,8 +6,6 @@ package graphtest
 import (
 	"encoding/hex"
 	"fmt"
-	"io"
-	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -43,34 +41,11 @@ func buildGraph(t *testing.T, bufs [][]byte) eventGraph {
 	t.Logf("got %v events\n", len(bufs))
 	g := make(eventGraph)
 	for i, buf := range bufs {
-		d := bson.D{}
-		err := bson.Unmarshal(buf, &d)
+		n, d, err := decodeNode(buf)
 		assert.NoError(t, err)
-		n := Node{Map: make(map[string]interface{})}
 		if os.Getenv("LOG_EVENTS") != "" {
 			t.Logf("# event %v\n", i)
-		}
-		for _, v := range d {
-			switch v.Name {
-			case "Edge":
-				n.Edges = append(n.Edges, v.Value.(string))
-			case "Layer":
-				n.Layer = v.Value.(string)
-			case "Label":
-				n.Label = v.Value.(string)
-			case "X-Trace":
-				n.OpID = v.Value.(string)[42:58]
-
-				buf := v.Value.(string)[58:60]
-				flag := make([]byte, 1)
-				if _, err := hex.Decode(flag, []byte(buf)); err != nil {
-					n.Flag = buf[0]
-				}
-				fallthrough
-			default:
-				n.Map[v.Name] = v.Value
-			}
-			if os.Getenv("LOG_EVENTS") != "" {
+			for _, v := range d {
 				t.Logf("got kv %v\n", v)
 			}
 		}
@@ -79,6 +54,37 @@ func buildGraph(t *testing.T, bufs [][]byte) eventGraph {
 	return g
 }
 
+// decodeNode decodes an encoded event into a Node, along with its KVs in order.
+func decodeNode(buf []byte) (Node, bson.D, error) {
+	d := bson.D{}
+	if err := bson.Unmarshal(buf, &d); err != nil {
+		return Node{}, nil, err
+	}
+	n := Node{Map: make(map[string]interface{})}
+	for _, v := range d {
+		switch v.Name {
+		case "Edge":
+			n.Edges = append(n.Edges, v.Value.(string))
+		case "Layer":
+			n.Layer = v.Value.(string)
+		case "Label":
+			n.Label = v.Value.(string)
+		case "X-Trace":
+			n.OpID = v.Value.(string)[42:58]
+
+			buf := v.Value.(string)[58:60]
+			flag := make([]byte, 1)
+			if _, err := hex.Decode(flag, []byte(buf)); err != nil {
+				n.Flag = buf[0]
+			}
+			fallthrough
+		default:
+			n.Map[v.Name] = v.Value
+		}
+	}
+	return n, d, nil
+}
+
 // MatchNode describes a node by its Layer and Label, used to match for assertions about a node and
 // when listing its outedges.
 type MatchNode struct{ Layer, Label string }
@@ -272,7 +278,7 @@ func AssertGraph(t *testing.T, bufs [][]byte, numNodes int, asserterMap Asserter
 		output, _ := os.Create(fname)
 		defer output.Close()
 		t.Logf("Saving DOT graph %s", fname)
-		dotGraph(g, output)
+		WriteDOT(output, bufs, ExportOptions{})
 	}
 }
 
@@ -305,55 +311,3 @@ func assertOutEdges(t *testing.T, g eventGraph, n Node, edges ...MatchNode) {
 		assert.Equal(t, foundEdges, len(edges))
 	}
 }
-
-// dotGraph writes a graphviz dot file to output Writer
-func dotGraph(g eventGraph, output io.Writer) {
-	fmt.Fprintln(output, "digraph main{")
-	fmt.Fprintln(output, "\tedge[arrowhead=vee]")
-	fmt.Fprintln(output, "\tgraph [rankdir=RL,compound=true,ranksep=1.0];")
-
-	minT := int64(math.MaxInt64) // find min timestamp
-	for _, n := range g {
-		if ts, ok := n.Map["Timestamp_u"]; ok {
-			if t, ok := ts.(int64); ok && t < minT {
-				minT = t
-			}
-		}
-	}
-
-	for opID, n := range g {
-		var ts int64
-		if tval, ok := n.Map["Timestamp_u"]; ok {
-			if t, ok := tval.(int64); ok {
-				ts = t
-			}
-		}
-		var suffix string
-
-		blocked := make(map[string]bool)
-		for _, k := range []string{"X-Trace", "Backtrace", "Timestamp_u", "Hostname", "_V", "PID"} {
-			blocked[k] = true
-		}
-		for k, v := range n.Map {
-			if !blocked[k] {
-				switch vv := v.(type) {
-				case []byte:
-					suffix += fmt.Sprintf("\\n%s: %s", k, v)
-				case string:
-					suffix += fmt.Sprintf("\\n%s: %s", k, strings.Replace(vv, `"`, `\"`, -1))
-				default:
-					suffix += fmt.Sprintf("\\n%s: %v", k, v)
-				}
-			}
-		}
-		fmt.Fprintf(output, "\top%s[shape=%s,label=\"%s\"];\n",
-			opID, "box",
-			fmt.Sprintf("%s: %s\\n%s\\n%0.3fms%s", n.Layer, n.Label, opID, float64(ts-minT)/1000.0, suffix))
-
-		for _, target := range n.Edges {
-			fmt.Fprintf(output, "\top%s -> op%s;\n", opID, target)
-		}
-	}
-
-	fmt.Fprintln(output, "}")
-}
@@ -0,0 +1,241 @@
+// +build linux
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
+)
+
+const (
+	cgroupRoot     = "/sys/fs/cgroup"
+	procSelfCgroup = "/proc/self/cgroup"
+
+	// cgroup v1 reports a huge page-aligned number as the memory limit when
+	// there is no limit.
+	cgroupV1NoLimit = 1 << 62
+)
+
+// cgroupPaths are the directories of the CPU and memory controllers of the
+// cgroup the process belongs to.
+type cgroupPaths struct {
+	v2          bool
+	cpu, memory string
+}
+
+// cgroupStats are the resource usage and limits read from cgroup. The counters
+// are cumulative.
+type cgroupStats struct {
+	cpuOK, memOK     bool
+	periods          uint64
+	throttledPeriods uint64
+	throttledTime    time.Duration
+	cpuLimit         float64 // in cores, 0 means not limited
+	memLimit         uint64  // in bytes, 0 means not limited
+	memUsage         uint64
+	oomKills         uint64
+}
+
+var (
+	selfCgroup     cgroupPaths
+	selfCgroupOnce sync.Once
+)
+
+// lastCgroupStats is the stats read by the last collection.
+var lastCgroupStats struct {
+	sync.Mutex
+	s *cgroupStats
+}
+
+// addContainerMetrics adds the resource metrics of the container to the BSON
+// buffer. They're only reported if the process is in a container, which is
+// either detected by the host ID, or its cgroup has a CPU or memory limit. The
+// counters are reported as the increase since the last collection.
+func addContainerMetrics(bbuf *bson.Buffer, index *int) {
+	selfCgroupOnce.Do(func() {
+		selfCgroup = findCgroup(cgroupRoot, readProcFile(procSelfCgroup))
+	})
+	cur := readCgroupStats(selfCgroup)
+	if !cur.cpuOK && !cur.memOK {
+		return
+	}
+	if cur.cpuLimit == 0 && cur.memLimit == 0 && host.BestEffortCurrentID().ContainerId() == "" {
+		return
+	}
+
+	lastCgroupStats.Lock()
+	prev := lastCgroupStats.s
+	lastCgroupStats.s = &cur
+	lastCgroupStats.Unlock()
+
+	tags := hostTags()
+	if cur.memOK {
+		addMetricsValueWithTags(bbuf, index, "container.memory.Usage", int64(cur.memUsage), tags)
+		if cur.memLimit > 0 {
+			addMetricsValueWithTags(bbuf, index, "container.memory.Limit", int64(cur.memLimit), tags)
+		}
+	}
+	if cur.cpuLimit > 0 {
+		addMetricsValueWithTags(bbuf, index, "container.cpu.Limit", cur.cpuLimit, tags)
+	}
+	if prev == nil {
+		return
+	}
+	if cur.cpuOK && prev.cpuOK {
+		addMetricsValueWithTags(bbuf, index, "container.cpu.Periods",
+			int64(delta(cur.periods, prev.periods)), tags)
+		addMetricsValueWithTags(bbuf, index, "container.cpu.ThrottledPeriods",
+			int64(delta(cur.throttledPeriods, prev.throttledPeriods)), tags)
+		addMetricsValueWithTags(bbuf, index, "container.cpu.ThrottledTimeMs",
+			int64(delta(uint64(cur.throttledTime), uint64(prev.throttledTime))/uint64(time.Millisecond)), tags)
+	}
+	if cur.memOK && prev.memOK {
+		addMetricsValueWithTags(bbuf, index, "container.memory.OOMKills",
+			int64(delta(cur.oomKills, prev.oomKills)), tags)
+	}
+}
+
+// findCgroup finds the controller directories of the cgroup in the content of
+// /proc/self/cgroup, e.g.,
+//   0::/kubepods/burstable/pod1234/abcd        (v2)
+//   4:cpu,cpuacct:/docker/abcd                 (v1)
+//   9:memory:/docker/abcd                      (v1)
+// The path is relative to the host's cgroup root, which is usually mounted as
+// the root inside a container, so the root is used if the path doesn't exist.
+func findCgroup(root, self string) cgroupPaths {
+	var p cgroupPaths
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		p.v2 = true
+		p.cpu = root
+		for _, line := range strings.Split(self, "\n") {
+			if strings.HasPrefix(line, "0::") {
+				p.cpu = cgroupDir(root, strings.TrimPrefix(line, "0::"))
+				break
+			}
+		}
+		p.memory = p.cpu
+		return p
+	}
+
+	p.cpu = filepath.Join(root, "cpu")
+	p.memory = filepath.Join(root, "memory")
+	for _, line := range strings.Split(self, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			switch c {
+			case "cpu":
+				p.cpu = cgroupDir(filepath.Join(root, "cpu"), parts[2])
+			case "memory":
+				p.memory = cgroupDir(filepath.Join(root, "memory"), parts[2])
+			}
+		}
+	}
+	return p
+}
+
+func cgroupDir(mount, path string) string {
+	dir := filepath.Join(mount, path)
+	if _, err := os.Stat(dir); err == nil {
+		return dir
+	}
+	return mount
+}
+
+func readCgroupStats(p cgroupPaths) cgroupStats {
+	if p.v2 {
+		return readCgroupV2Stats(p)
+	}
+	return readCgroupV1Stats(p)
+}
+
+func readCgroupV2Stats(p cgroupPaths) cgroupStats {
+	var s cgroupStats
+	cpu := parseKeyValues(readProcFile(filepath.Join(p.cpu, "cpu.stat")))
+	if periods, ok := cpu["nr_periods"]; ok {
+		s.cpuOK = true
+		s.periods = periods
+		s.throttledPeriods = cpu["nr_throttled"]
+		s.throttledTime = time.Duration(cpu["throttled_usec"]) * time.Microsecond
+	}
+	// cpu.max: $MAX $PERIOD, e.g., "max 100000" or "50000 100000"
+	if fields := strings.Fields(readProcFile(filepath.Join(p.cpu, "cpu.max"))); len(fields) == 2 {
+		quota, err1 := strconv.ParseFloat(fields[0], 64)
+		period, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+			s.cpuLimit = quota / period
+		}
+	}
+
+	if usage, ok := readUint(filepath.Join(p.memory, "memory.current")); ok {
+		s.memOK = true
+		s.memUsage = usage
+	}
+	if limit, ok := readUint(filepath.Join(p.memory, "memory.max")); ok {
+		s.memLimit = limit
+	}
+	s.oomKills = parseKeyValues(readProcFile(filepath.Join(p.memory, "memory.events")))["oom_kill"]
+	return s
+}
+
+func readCgroupV1Stats(p cgroupPaths) cgroupStats {
+	var s cgroupStats
+	cpu := parseKeyValues(readProcFile(filepath.Join(p.cpu, "cpu.stat")))
+	if periods, ok := cpu["nr_periods"]; ok {
+		s.cpuOK = true
+		s.periods = periods
+		s.throttledPeriods = cpu["nr_throttled"]
+		s.throttledTime = time.Duration(cpu["throttled_time"])
+	}
+	// the quota is -1 if not limited, which fails readUint
+	quota, ok1 := readUint(filepath.Join(p.cpu, "cpu.cfs_quota_us"))
+	period, ok2 := readUint(filepath.Join(p.cpu, "cpu.cfs_period_us"))
+	if ok1 && ok2 && quota > 0 && period > 0 {
+		s.cpuLimit = float64(quota) / float64(period)
+	}
+
+	if usage, ok := readUint(filepath.Join(p.memory, "memory.usage_in_bytes")); ok {
+		s.memOK = true
+		s.memUsage = usage
+	}
+	if limit, ok := readUint(filepath.Join(p.memory, "memory.limit_in_bytes")); ok && limit < cgroupV1NoLimit {
+		s.memLimit = limit
+	}
+	// oom_kill is available since Linux 4.13
+	s.oomKills = parseKeyValues(readProcFile(filepath.Join(p.memory, "memory.oom_control")))["oom_kill"]
+	return s
+}
+
+// readUint reads a file containing a single unsigned integer.
+func readUint(path string) (uint64, bool) {
+	v, err := strconv.ParseUint(strings.TrimSpace(readProcFile(path)), 10, 64)
+	return v, err == nil
+}
+
+// parseKeyValues parses the flat keyed files of cgroup, e.g., cpu.stat:
+//   nr_periods 120
+//   nr_throttled 3
+func parseKeyValues(s string) map[string]uint64 {
+	kvs := make(map[string]uint64)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			kvs[fields[0]] = v
+		}
+	}
+	return kvs
+}
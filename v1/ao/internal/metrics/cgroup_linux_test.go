@@ -0,0 +1,96 @@
+// +build linux
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCgroupFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestCgroupV2Stats(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup2")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeCgroupFiles(t, root, map[string]string{
+		"cgroup.controllers":           "cpu memory",
+		"kubepods/pod1/cpu.stat":       "usage_usec 1000\nnr_periods 120\nnr_throttled 3\nthrottled_usec 45000\n",
+		"kubepods/pod1/cpu.max":        "50000 100000\n",
+		"kubepods/pod1/memory.current": "104857600\n",
+		"kubepods/pod1/memory.max":     "268435456\n",
+		"kubepods/pod1/memory.events":  "low 0\nhigh 0\nmax 2\noom 1\noom_kill 1\n",
+	})
+
+	p := findCgroup(root, "0::/kubepods/pod1\n")
+	assert.Equal(t, cgroupPaths{v2: true, cpu: filepath.Join(root, "kubepods/pod1"),
+		memory: filepath.Join(root, "kubepods/pod1")}, p)
+	assert.Equal(t, cgroupStats{
+		cpuOK:            true,
+		memOK:            true,
+		periods:          120,
+		throttledPeriods: 3,
+		throttledTime:    45 * time.Millisecond,
+		cpuLimit:         0.5,
+		memLimit:         268435456,
+		memUsage:         104857600,
+		oomKills:         1,
+	}, readCgroupStats(p))
+
+	// the cgroup namespace makes the root the cgroup of the container
+	p = findCgroup(root, "0::/\n")
+	assert.Equal(t, root, p.cpu)
+	writeCgroupFiles(t, root, map[string]string{"cpu.max": "max 100000\n", "memory.max": "max\n", "memory.current": "1024\n"})
+	s := readCgroupStats(p)
+	assert.True(t, s.memOK)
+	assert.False(t, s.cpuOK)
+	assert.Zero(t, s.cpuLimit)
+	assert.Zero(t, s.memLimit)
+}
+
+func TestCgroupV1Stats(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup1")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeCgroupFiles(t, root, map[string]string{
+		"cpu/cpu.stat":                 "nr_periods 200\nnr_throttled 10\nthrottled_time 2000000000\n",
+		"cpu/cpu.cfs_quota_us":         "200000\n",
+		"cpu/cpu.cfs_period_us":        "100000\n",
+		"memory/memory.usage_in_bytes": "52428800\n",
+		"memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"memory/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+	})
+
+	// the host paths don't exist inside the container
+	p := findCgroup(root, "4:cpu,cpuacct:/docker/abcd\n9:memory:/docker/abcd\n")
+	assert.Equal(t, cgroupPaths{cpu: filepath.Join(root, "cpu"), memory: filepath.Join(root, "memory")}, p)
+	assert.Equal(t, cgroupStats{
+		cpuOK:            true,
+		memOK:            true,
+		periods:          200,
+		throttledPeriods: 10,
+		throttledTime:    2 * time.Second,
+		cpuLimit:         2,
+		memUsage:         52428800,
+		oomKills:         2,
+	}, readCgroupStats(p))
+
+	// no cgroup at all
+	assert.Equal(t, cgroupStats{}, readCgroupStats(findCgroup(filepath.Join(root, "none"), "")))
+}
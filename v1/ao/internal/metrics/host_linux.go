@@ -0,0 +1,255 @@
+// +build linux
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+)
+
+const (
+	procStat     = "/proc/stat"
+	procMemInfo  = "/proc/meminfo"
+	procLoadAvg  = "/proc/loadavg"
+	procDiskStat = "/proc/diskstats"
+	procNetDev   = "/proc/net/dev"
+	sysBlock     = "/sys/block"
+
+	// the sector size of /proc/diskstats, which is always 512 regardless of
+	// the actual size of the device
+	diskSectorSize = 512
+)
+
+// cpuTimes is the cumulative CPU time in jiffies from the first line of
+// /proc/stat.
+type cpuTimes struct {
+	user, system, iowait, idle, total uint64
+}
+
+// hostSample is a snapshot of the cumulative host counters. The utilization
+// and throughput are computed from the difference of two samples.
+type hostSample struct {
+	at                  time.Time
+	cpu                 cpuTimes
+	cpuOK               bool
+	diskRead, diskWrite uint64 // bytes
+	netRx, netTx        uint64 // bytes
+}
+
+// lastHostSample is the sample taken by the last collection.
+var lastHostSample struct {
+	sync.Mutex
+	s *hostSample
+}
+
+// addSystemMetrics adds the CPU, memory, load, disk I/O and network metrics of
+// the host to the BSON buffer. The rates are not reported by the first
+// collection as there's no previous sample.
+func addSystemMetrics(bbuf *bson.Buffer, index *int) {
+	tags := hostTags()
+
+	if loads, ok := parseLoadAvg(readProcFile(procLoadAvg)); ok {
+		addMetricsValueWithTags(bbuf, index, "host.load.Load1", loads[0], tags)
+		addMetricsValueWithTags(bbuf, index, "host.load.Load5", loads[1], tags)
+		addMetricsValueWithTags(bbuf, index, "host.load.Load15", loads[2], tags)
+	}
+
+	mem := parseMemInfo(readProcFile(procMemInfo))
+	if total, ok := mem["MemTotal"]; ok {
+		// MemAvailable is missing from the kernels older than 3.14
+		avail, ok := mem["MemAvailable"]
+		if !ok {
+			avail = mem["MemFree"] + mem["Buffers"] + mem["Cached"]
+		}
+		addMetricsValueWithTags(bbuf, index, "host.memory.Total", int64(total), tags)
+		addMetricsValueWithTags(bbuf, index, "host.memory.Available", int64(avail), tags)
+		addMetricsValueWithTags(bbuf, index, "host.memory.Used", int64(total-avail), tags)
+	}
+
+	cur := takeHostSample()
+	lastHostSample.Lock()
+	prev := lastHostSample.s
+	lastHostSample.s = cur
+	lastHostSample.Unlock()
+	if prev == nil {
+		return
+	}
+
+	if cur.cpuOK && prev.cpuOK && cur.cpu.total > prev.cpu.total {
+		d := float64(cur.cpu.total - prev.cpu.total)
+		idle := float64(delta(cur.cpu.idle, prev.cpu.idle) + delta(cur.cpu.iowait, prev.cpu.iowait))
+		addMetricsValueWithTags(bbuf, index, "host.cpu.Utilization", 100*(d-idle)/d, tags)
+		addMetricsValueWithTags(bbuf, index, "host.cpu.User", 100*float64(delta(cur.cpu.user, prev.cpu.user))/d, tags)
+		addMetricsValueWithTags(bbuf, index, "host.cpu.System", 100*float64(delta(cur.cpu.system, prev.cpu.system))/d, tags)
+		addMetricsValueWithTags(bbuf, index, "host.cpu.IOWait", 100*float64(delta(cur.cpu.iowait, prev.cpu.iowait))/d, tags)
+	}
+
+	secs := cur.at.Sub(prev.at).Seconds()
+	if secs <= 0 {
+		return
+	}
+	addMetricsValueWithTags(bbuf, index, "host.disk.ReadBytesPerSec", float64(delta(cur.diskRead, prev.diskRead))/secs, tags)
+	addMetricsValueWithTags(bbuf, index, "host.disk.WriteBytesPerSec", float64(delta(cur.diskWrite, prev.diskWrite))/secs, tags)
+	addMetricsValueWithTags(bbuf, index, "host.network.ReceiveBytesPerSec", float64(delta(cur.netRx, prev.netRx))/secs, tags)
+	addMetricsValueWithTags(bbuf, index, "host.network.TransmitBytesPerSec", float64(delta(cur.netTx, prev.netTx))/secs, tags)
+}
+
+func takeHostSample() *hostSample {
+	s := &hostSample{at: time.Now()}
+	s.cpu, s.cpuOK = parseCPUTimes(readProcFile(procStat))
+	s.diskRead, s.diskWrite = parseDiskStats(readProcFile(procDiskStat), isWholeDisk)
+	s.netRx, s.netTx = parseNetDev(readProcFile(procNetDev))
+	return s
+}
+
+// delta returns the increase of a cumulative counter, or zero if the counter
+// has been reset or wrapped around.
+func delta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+func readProcFile(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// parseLoadAvg parses the content of /proc/loadavg, e.g.,
+// 0.15 0.09 0.08 1/189 2849
+func parseLoadAvg(s string) ([3]float64, bool) {
+	var loads [3]float64
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return loads, false
+	}
+	for i := range loads {
+		l, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return loads, false
+		}
+		loads[i] = l
+	}
+	return loads, true
+}
+
+// parseMemInfo parses the content of /proc/meminfo to a map of the values
+// in bytes, e.g., MemTotal:        7657668 kB
+func parseMemInfo(s string) map[string]uint64 {
+	mem := make(map[string]uint64)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) == 3 && fields[2] == "kB" {
+			v *= 1024
+		}
+		mem[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return mem
+}
+
+// parseCPUTimes parses the aggregated CPU line of /proc/stat, e.g.,
+// cpu  user nice system idle iowait irq softirq steal guest guest_nice
+// The guest time is already included in the user time.
+func parseCPUTimes(s string) (cpuTimes, bool) {
+	var t cpuTimes
+	line := s
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		line = s[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return t, false
+	}
+	var v [8]uint64
+	for i := 1; i < len(fields) && i <= len(v); i++ {
+		n, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return t, false
+		}
+		v[i-1] = n
+		t.total += n
+	}
+	t.user = v[0] + v[1]
+	t.system = v[2] + v[5] + v[6]
+	t.idle = v[3]
+	t.iowait = v[4]
+	return t, true
+}
+
+// parseDiskStats sums up the bytes read and written of the disks in the
+// content of /proc/diskstats, e.g.,
+// 8       0 sda 3468 1792 226889 1230 2862 4332 82588 2714 0 2300 3944
+// The partitions are skipped so the I/O is not counted twice.
+func parseDiskStats(s string, isDisk func(name string) bool) (read, write uint64) {
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || !isDisk(fields[2]) {
+			continue
+		}
+		r, err1 := strconv.ParseUint(fields[5], 10, 64)
+		w, err2 := strconv.ParseUint(fields[9], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		read += r * diskSectorSize
+		write += w * diskSectorSize
+	}
+	return read, write
+}
+
+// isWholeDisk checks if the block device is a disk rather than a partition,
+// loop device, ramdisk or device mapper target, which are backed by the disks.
+func isWholeDisk(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "dm-", "zram"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	_, err := os.Stat(filepath.Join(sysBlock, name))
+	return err == nil
+}
+
+// parseNetDev sums up the bytes received and transmitted by the interfaces
+// other than the loopback one in the content of /proc/net/dev, e.g.,
+//   eth0: 1961844 2048 0 0 0 0 0 0 1441570 1715 0 0 0 0 0 0
+func parseNetDev(s string) (rx, tx uint64) {
+	for _, line := range strings.Split(s, "\n") {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		fields := strings.Fields(line[i+1:])
+		if name == "lo" || len(fields) < 9 {
+			continue
+		}
+		r, err1 := strconv.ParseUint(fields[0], 10, 64)
+		t, err2 := strconv.ParseUint(fields[8], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		rx += r
+		tx += t
+	}
+	return rx, tx
+}
@@ -0,0 +1,91 @@
+// +build linux
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostStats(t *testing.T) {
+	loads, ok := parseLoadAvg("0.15 0.09 0.08 1/189 2849\n")
+	assert.True(t, ok)
+	assert.Equal(t, [3]float64{0.15, 0.09, 0.08}, loads)
+	_, ok = parseLoadAvg("")
+	assert.False(t, ok)
+
+	mem := parseMemInfo("MemTotal:        7657668 kB\nMemFree:          161396 kB\nHugePages_Total:       0\n")
+	assert.Equal(t, uint64(7657668*1024), mem["MemTotal"])
+	assert.Equal(t, uint64(161396*1024), mem["MemFree"])
+	assert.Equal(t, uint64(0), mem["HugePages_Total"])
+
+	cpu, ok := parseCPUTimes("cpu  100 10 50 800 20 5 5 10 0 0\ncpu0 100 10 50 800 20 5 5 10 0 0\n")
+	assert.True(t, ok)
+	assert.Equal(t, cpuTimes{user: 110, system: 60, iowait: 20, idle: 800, total: 1000}, cpu)
+	_, ok = parseCPUTimes("intr 12345\n")
+	assert.False(t, ok)
+
+	diskStats := `   8       0 sda 3468 1792 226889 1230 2862 4332 82588 2714 0 2300 3944
+   8       1 sda1 3000 1700 200000 1100 2800 4300 80000 2700 0 2200 3800
+   7       0 loop0 10 0 100 0 0 0 0 0 0 0 0`
+	read, write := parseDiskStats(diskStats, func(name string) bool { return name == "sda" })
+	assert.Equal(t, uint64(226889*512), read)
+	assert.Equal(t, uint64(82588*512), write)
+
+	netDev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 9000 90 0 0 0 0 0 0 9000 90 0 0 0 0 0 0
+  eth0: 1961844 2048 0 0 0 0 0 0 1441570 1715 0 0 0 0 0 0
+  eth1: 1000 10 0 0 0 0 0 0 2000 20 0 0 0 0 0 0`
+	rx, tx := parseNetDev(netDev)
+	assert.Equal(t, uint64(1962844), rx)
+	assert.Equal(t, uint64(1443570), tx)
+
+	assert.False(t, isWholeDisk("loop0"))
+	assert.False(t, isWholeDisk("dm-0"))
+	assert.False(t, isWholeDisk("not-a-device"))
+	assert.Equal(t, uint64(0), delta(1, 2))
+}
+
+func TestAddSystemMetrics(t *testing.T) {
+	lastHostSample.Lock()
+	lastHostSample.s = nil
+	lastHostSample.Unlock()
+
+	names := func() map[string]map[string]interface{} {
+		bbuf := bson.NewBuffer()
+		start := bbuf.AppendStartArray("measurements")
+		index := 0
+		addSystemMetrics(bbuf, &index)
+		bbuf.AppendFinishObject(start)
+		bbuf.Finish()
+		got := make(map[string]map[string]interface{})
+		for _, m := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+			mt := m.(map[string]interface{})
+			got[mt["name"].(string)] = mt
+		}
+		return got
+	}
+
+	// no rates without a previous sample
+	first := names()
+	assert.Contains(t, first, "host.load.Load1")
+	assert.Contains(t, first, "host.memory.Total")
+	assert.NotContains(t, first, "host.disk.ReadBytesPerSec")
+	assert.NotEmpty(t, first["host.memory.Used"]["tags"].(map[string]interface{})["HostName"])
+
+	second := names()
+	for _, name := range []string{
+		"host.disk.ReadBytesPerSec",
+		"host.disk.WriteBytesPerSec",
+		"host.network.ReceiveBytesPerSec",
+		"host.network.TransmitBytesPerSec",
+	} {
+		assert.Contains(t, second, name)
+		assert.IsType(t, float64(0), second[name]["value"], name)
+	}
+}
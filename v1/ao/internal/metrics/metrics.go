@@ -5,7 +5,6 @@ package metrics
 import (
 	"os"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,6 +22,8 @@ import (
 const (
 	metricsTransactionsMaxDefault = 200 // default max amount of transaction names we allow per cycle
 	metricsHistPrecisionDefault   = 2   // default histogram precision
+	metricIDsInternMax            = 10000
+	transactionNamesInternMax     = 10000
 
 	metricsTagNameLengthMax  = 64  // max number of characters for tag names
 	metricsTagValueLengthMax = 255 // max number of characters for tag values
@@ -86,6 +87,26 @@ type HTTPSpanMessage struct {
 	Status      int    // HTTP status code (e.g. 200, 500, ...)
 	Host        string // HTTP-Host
 	Method      string // HTTP method (e.g. GET, POST, ...)
+	// the values of the span KVs tagging the metrics, see APPOPTICS_METRIC_TAGS
+	Tags map[string]string
+}
+
+// QuerySpanMessage is used for the metrics of the database queries, which are
+// aggregated by the statement fingerprints.
+type QuerySpanMessage struct {
+	BaseSpanMessage
+	Fingerprint string // the normalized statement, see reporter.SQLFingerprint
+	Flavor      string // the flavor of the statement (e.g. mysql, postgresql)
+	Rows        int64  // the number of the rows returned or affected, or -1 if unknown
+}
+
+// CacheSpanMessage is used for the metrics of the cache requests, which are
+// aggregated by the commands and the cache clusters.
+type CacheSpanMessage struct {
+	BaseSpanMessage
+	Op      string // the cache command (e.g. GET, SET)
+	Cluster string // the name of the cache cluster
+	Hit     bool   // whether the requested key is found
 }
 
 // Measurement is a single measurement for reporting
@@ -97,22 +118,87 @@ type Measurement struct {
 	ReportSum bool              // include the sum in the report?
 }
 
-// Measurements are a collection of mutex-protected measurements
+// Measurements are a collection of mutex-protected measurements. They're
+// recorded into shards with a mutex each, so the concurrent recordings don't
+// contend on a single lock, and merged by CopyAndReset.
 type Measurements struct {
+	// the merged measurements of a copy returned by Clone or CopyAndReset
 	m             map[string]*Measurement
+	shards        shardedMeasurements
 	transMap      *TransMap
 	IsCustom      bool
 	FlushInterval int32
 	sync.Mutex    // protect access to this collection
+	// the serialized tags shared by the copies reported in each interval
+	tags *tagsCache
 }
 
 func NewMeasurements(isCustom bool, flushInterval int32, maxCount int32) *Measurements {
 	return &Measurements{
-		m:             make(map[string]*Measurement),
+		shards:        newShardedMeasurements(),
 		transMap:      NewTransMap(maxCount),
 		IsCustom:      isCustom,
 		FlushInterval: flushInterval,
+		tags:          newTagsCache(),
+	}
+}
+
+// tagsCache holds the serialized tags of the measurements keyed by their
+// metric IDs. The metrics reported in an interval are mostly the same as the
+// ones of the last interval, so their tags are serialized once and reused by
+// the following messages. The tags of the metrics not reported by the last
+// message are dropped by rotate.
+type tagsCache struct {
+	sync.Mutex
+	cur, prev map[string][]byte
+}
+
+func newTagsCache() *tagsCache {
+	return &tagsCache{cur: make(map[string][]byte)}
+}
+
+// elements returns the serialized tags of the metric ID, which are serialized
+// if they're not cached.
+func (c *tagsCache) elements(id string, tags map[string]string) []byte {
+	if c == nil {
+		return tagsElements(tags)
+	}
+	c.Lock()
+	defer c.Unlock()
+	elems, ok := c.cur[id]
+	if !ok {
+		if elems, ok = c.prev[id]; !ok {
+			elems = tagsElements(tags)
+		}
+		c.cur[id] = elems
+	}
+	return elems
+}
+
+// rotate drops the tags not used since the last call, which is called once a
+// message is built.
+func (c *tagsCache) rotate() {
+	if c == nil {
+		return
 	}
+	c.Lock()
+	defer c.Unlock()
+	c.prev, c.cur = c.cur, make(map[string][]byte, len(c.cur))
+}
+
+// tagsElements serializes the tags of a measurement.
+func tagsElements(tags map[string]string) []byte {
+	bbuf := bson.NewBuffer()
+	for k, v := range tags {
+		if len(k) > metricsTagNameLengthMax {
+			k = k[0:metricsTagNameLengthMax]
+		}
+		if len(v) > metricsTagValueLengthMax {
+			v = v[0:metricsTagValueLengthMax]
+		}
+		bbuf.AppendString(k, v)
+	}
+	return bbuf.Elements()
 }
 
 // a single histogram
@@ -136,6 +222,12 @@ type EventQueueStats struct {
 	numFailed     int64 // number of messages that failed to send
 	totalEvents   int64 // number of messages queued to send
 	queueLargest  int64 // maximum number of messages that were in the queue at one time
+	eventBytes    int64 // bytes of the events sent
+	metricBytes   int64 // bytes of the metrics messages sent
+	statusBytes   int64 // bytes of the status messages sent
+	buildTime     int64 // nanoseconds spent building and serializing the events
+	queueWait     int64 // nanoseconds the events waited in the queue before sent
+	queueWaitMax  int64 // maximum nanoseconds an event waited in the queue
 }
 
 func (s *EventQueueStats) NumSentAdd(n int64) {
@@ -154,6 +246,34 @@ func (s *EventQueueStats) TotalEventsAdd(n int64) {
 	atomic.AddInt64(&s.totalEvents, n)
 }
 
+func (s *EventQueueStats) EventBytesAdd(n int64) {
+	atomic.AddInt64(&s.eventBytes, n)
+}
+
+func (s *EventQueueStats) MetricBytesAdd(n int64) {
+	atomic.AddInt64(&s.metricBytes, n)
+}
+
+func (s *EventQueueStats) StatusBytesAdd(n int64) {
+	atomic.AddInt64(&s.statusBytes, n)
+}
+
+func (s *EventQueueStats) BuildTimeAdd(d time.Duration) {
+	atomic.AddInt64(&s.buildTime, int64(d))
+}
+
+// QueueWaitAdd adds the total time a batch of events waited in the queue and
+// updates the maximum wait with the wait of the oldest one.
+func (s *EventQueueStats) QueueWaitAdd(total, oldest time.Duration) {
+	atomic.AddInt64(&s.queueWait, int64(total))
+	for {
+		curr := atomic.LoadInt64(&s.queueWaitMax)
+		if int64(oldest) <= curr || atomic.CompareAndSwapInt64(&s.queueWaitMax, curr, int64(oldest)) {
+			return
+		}
+	}
+}
+
 // RateCounts is the rate counts reported by trace sampler
 type RateCounts struct{ requested, sampled, limited, traced, through int64 }
 
@@ -305,10 +425,7 @@ func (t *TransMap) Overflow() bool {
 }
 
 // collection of currently stored histograms (flushed on each metrics report cycle)
-var metricsHTTPHistograms = &histograms{
-	histograms: make(map[string]*histogram),
-	precision:  metricsHistPrecisionDefault,
-}
+var metricsHTTPHistograms = newShardedHistograms(metricsHistPrecisionDefault)
 
 // TODO: use config package, and add validator (0-5)
 // initialize values according to env variables
@@ -319,7 +436,7 @@ func init() {
 		log.Infof("Non-default APPOPTICS_HISTOGRAM_PRECISION: %s", precision)
 		if p, err := strconv.Atoi(precision); err == nil {
 			if p >= 0 && p <= 5 {
-				metricsHTTPHistograms.precision = p
+				metricsHTTPHistograms.setPrecision(p)
 			} else {
 				log.Errorf("value of %v must be between 0 and 5: %v", pEnv, precision)
 			}
@@ -379,9 +496,10 @@ func BuildMessage(m *Measurements, serverless bool) []byte {
 	start := bbuf.AppendStartArray("measurements")
 	index := 0
 
-	for _, measurement := range m.m {
-		addMeasurementToBSON(bbuf, &index, measurement)
+	for id, measurement := range m.m {
+		addMeasurementToBSON(bbuf, &index, measurement, m.tags.elements(id, measurement.Tags))
 	}
+	m.tags.rotate()
 
 	bbuf.AppendFinishObject(start)
 
@@ -404,25 +522,35 @@ func (m *Measurements) CopyAndReset(flushInterval int32) *Measurements {
 	m.Lock()
 	defer m.Unlock()
 
-	if len(m.m) == 0 {
-		m.FlushInterval = flushInterval
-		return nil
-	}
-
-	clone := m.Clone()
-	m.m = make(map[string]*Measurement)
-	m.transMap.Reset()
+	var clone *Measurements
+	// the transaction map is reset with the shards locked, so it's consistent
+	// with the measurements recorded
+	maps := m.shards.swap(func(empty bool) {
+		if !empty {
+			clone = &Measurements{
+				transMap:      m.transMap.Clone(),
+				IsCustom:      m.IsCustom,
+				FlushInterval: m.FlushInterval,
+				tags:          m.tags,
+			}
+			m.transMap.Reset()
+		}
+	})
 	m.FlushInterval = flushInterval
+	if clone != nil {
+		clone.m = mergeMeasurements(maps)
+	}
 	return clone
 }
 
-// Clone returns a shallow copy
+// Clone returns a copy with the measurements of all the shards merged
 func (m *Measurements) Clone() *Measurements {
 	return &Measurements{
-		m:             m.m,
+		m:             m.shards.merged(),
 		transMap:      m.transMap.Clone(),
 		IsCustom:      m.IsCustom,
 		FlushInterval: m.FlushInterval,
+		tags:          m.tags,
 	}
 }
 
@@ -498,7 +626,7 @@ func addRuntimeMetrics(bbuf *bson.Buffer, index *int) {
 //
 // return				metrics message in BSON format
 func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
-	rcs map[string]*RateCounts, runtimeMetrics bool) []byte {
+	rcs map[string]*RateCounts, runtimeMetrics, hostMetrics, containerMetrics bool) []byte {
 	if m == nil {
 		return nil
 	}
@@ -525,18 +653,36 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 		addMetricsValue(bbuf, &index, "NumFailed", qs.numFailed)
 		addMetricsValue(bbuf, &index, "TotalEvents", qs.totalEvents)
 		addMetricsValue(bbuf, &index, "QueueLargest", qs.queueLargest)
+		addMetricsValue(bbuf, &index, "EventBytesSent", qs.eventBytes)
+		addMetricsValue(bbuf, &index, "MetricBytesSent", qs.metricBytes)
+		addMetricsValue(bbuf, &index, "StatusBytesSent", qs.statusBytes)
+		// the overhead of the agent, in microseconds
+		addMetricsValue(bbuf, &index, "EventBuildTime", qs.buildTime/int64(time.Microsecond))
+		addMetricsValue(bbuf, &index, "EventQueueWait", qs.queueWait/int64(time.Microsecond))
+		addMetricsValue(bbuf, &index, "EventQueueWaitMax", qs.queueWaitMax/int64(time.Microsecond))
 	}
 
 	addHostMetrics(bbuf, &index)
 
+	if hostMetrics {
+		// CPU, memory, load, disk and network stats of the host
+		addSystemMetrics(bbuf, &index)
+	}
+
+	if containerMetrics {
+		// CPU throttling, memory and OOM kills of the container from cgroup
+		addContainerMetrics(bbuf, &index)
+	}
+
 	if runtimeMetrics {
 		// runtime stats
 		addRuntimeMetrics(bbuf, &index)
 	}
 
-	for _, measurement := range m.m {
-		addMeasurementToBSON(bbuf, &index, measurement)
+	for id, measurement := range m.m {
+		addMeasurementToBSON(bbuf, &index, measurement, m.tags.elements(id, measurement.Tags))
 	}
+	m.tags.rotate()
 
 	bbuf.AppendFinishObject(start)
 	// ==========================================
@@ -546,14 +692,10 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 	start = bbuf.AppendStartArray("histograms")
 	index = 0
 
-	metricsHTTPHistograms.lock.Lock()
-
-	for _, h := range metricsHTTPHistograms.histograms {
+	// clear histograms
+	for _, h := range metricsHTTPHistograms.flush() {
 		addHistogramToBSON(bbuf, &index, h)
 	}
-	metricsHTTPHistograms.histograms = make(map[string]*histogram) // clear histograms
-
-	metricsHTTPHistograms.lock.Unlock()
 	bbuf.AppendFinishObject(start)
 	// ==========================================
 
@@ -568,18 +710,54 @@ func BuildBuiltinMetricsMessage(m *Measurements, qs *EventQueueStats,
 // append host ID to a BSON buffer
 // bbuf	the BSON buffer to append the KVs to
 func appendHostId(bbuf *bson.Buffer) {
-	if host.ConfiguredHostname() != "" {
-		bbuf.AppendString("ConfiguredHostname", host.ConfiguredHostname())
+	configured, addrs := host.ConfiguredHostname(), host.IPAddresses()
+	bbuf.AppendElements(hostIDElements(configured, addrs))
+}
+
+// the serialized host identification KVs of the last metrics message, which
+// are shared by all the messages until the configured hostname or the IP
+// addresses change. The uname and the distro are not supposed to change.
+var hostIDCache struct {
+	sync.Mutex
+	key   string
+	elems []byte
+}
+
+// hostIDElements returns the serialized host identification KVs.
+func hostIDElements(configured string, addrs []string) []byte {
+	key := configured + "\x00" + strings.Join(addrs, ",")
+	hostIDCache.Lock()
+	defer hostIDCache.Unlock()
+	if hostIDCache.elems != nil && hostIDCache.key == key {
+		return hostIDCache.elems
+	}
+
+	bbuf := bson.NewBuffer()
+	if configured != "" {
+		bbuf.AppendString("ConfiguredHostname", configured)
 	}
 	appendUname(bbuf)
 	bbuf.AppendString("Distro", host.Distro())
-	appendIPAddresses(bbuf)
+	appendIPAddresses(bbuf, addrs)
+
+	hostIDCache.key, hostIDCache.elems = key, bbuf.Elements()
+	return hostIDCache.elems
 }
 
-// gets and appends IP addresses to a BSON buffer
+// hostTags returns the tags identifying the host and container of the host
+// metrics.
+func hostTags() map[string]string {
+	tags := map[string]string{"HostName": host.Hostname()}
+	if cid := host.BestEffortCurrentID().ContainerId(); cid != "" {
+		tags["ContainerId"] = cid
+	}
+	return tags
+}
+
+// appends IP addresses to a BSON buffer
 // bbuf	the BSON buffer to append the KVs to
-func appendIPAddresses(bbuf *bson.Buffer) {
-	addrs := host.IPAddresses()
+// addrs	the IP addresses of the host
+func appendIPAddresses(bbuf *bson.Buffer, addrs []string) {
 	if addrs == nil {
 		return
 	}
@@ -616,6 +794,16 @@ func appendMACAddresses(bbuf *bson.Buffer, macs []string) {
 // name		key name
 // value	value (type: int, int64, float32, float64)
 func addMetricsValue(bbuf *bson.Buffer, index *int, name string, value interface{}) {
+	addMetricsValueWithTags(bbuf, index, name, value, nil)
+}
+
+// appends a metric with tags to a BSON buffer, the form will be:
+// {
+//   "name":"myName",
+//   "value":0,
+//   "tags":{"k":"v"}
+// }
+func addMetricsValueWithTags(bbuf *bson.Buffer, index *int, name string, value interface{}, tags map[string]string) {
 	start := bbuf.AppendStartObject(strconv.Itoa(*index))
 	defer func() {
 		if err := recover(); err != nil {
@@ -639,6 +827,14 @@ func addMetricsValue(bbuf *bson.Buffer, index *int, name string, value interface
 		bbuf.AppendString("value", "unknown")
 	}
 
+	if len(tags) > 0 {
+		tagsStart := bbuf.AppendStartObject("tags")
+		for k, v := range tags {
+			bbuf.AppendString(k, v)
+		}
+		bbuf.AppendFinishObject(tagsStart)
+	}
+
 	bbuf.AppendFinishObject(start)
 	*index += 1
 }
@@ -650,18 +846,36 @@ func GetTransactionFromPath(path string) string {
 	if path == "" || path == "/" {
 		return "/"
 	}
-	p := strings.Split(path, "/")
-	lp := len(p)
-	if lp > maxPathLenForTransactionName {
-		lp = maxPathLenForTransactionName
+	// cut the path at the slash ending its first segments
+	slashes := 0
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		if slashes++; slashes == maxPathLenForTransactionName {
+			return transactionNames.Intern(path[:i])
+		}
 	}
-	return strings.Join(p[0:lp], "/")
+	return transactionNames.Intern(path)
+}
+
+// JoinTransactionName joins the parts of a transaction name, e.g., the domain
+// and the path. The transaction names are interned, the name is only
+// allocated if it's not seen before.
+func JoinTransactionName(parts ...string) string {
+	var arr [128]byte
+	buf := arr[:0]
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return transactionNames.InternBytes(buf)
 }
 
 // Process processes an HttpSpanMessage
 func (s *HTTPSpanMessage) Process(m *Measurements) {
 	// always add to overall histogram
-	recordHistogram(metricsHTTPHistograms, "", s.Duration)
+	hi := metricsHTTPHistograms.next()
+	recordHistogram(hi, "", s.Duration)
 
 	// only record the transaction-specific histogram and measurements if we are still within the limit
 	// otherwise report it as an 'other' measurement
@@ -671,7 +885,7 @@ func (s *HTTPSpanMessage) Process(m *Measurements) {
 		return
 	}
 
-	recordHistogram(metricsHTTPHistograms, s.Transaction, s.Duration)
+	recordHistogram(hi, s.Transaction, s.Duration)
 }
 
 func (s *HTTPSpanMessage) produceTagsList() []map[string]string {
@@ -688,7 +902,8 @@ func (s *HTTPSpanMessage) produceTagsList() []map[string]string {
 	tagsList = append(tagsList, withMethodTags)
 
 	withStatusTags := utils.CopyMap(&primaryTags)
-	withStatusTags["HttpStatus"] = strconv.Itoa(s.Status)
+	var status [8]byte
+	withStatusTags["HttpStatus"] = httpStatuses.InternBytes(strconv.AppendInt(status[:0], int64(s.Status), 10))
 	tagsList = append(tagsList, withStatusTags)
 
 	if s.HasError {
@@ -697,6 +912,14 @@ func (s *HTTPSpanMessage) produceTagsList() []map[string]string {
 		tagsList = append(tagsList, withErrorTags)
 	}
 
+	// secondary keys: the span KVs, one at a time so the number of the metrics
+	// grows linearly with them
+	for k, v := range s.Tags {
+		withSpanTags := utils.CopyMap(&primaryTags)
+		withSpanTags[k] = v
+		tagsList = append(tagsList, withSpanTags)
+	}
+
 	return tagsList
 }
 
@@ -719,11 +942,132 @@ func (s *HTTPSpanMessage) processMeasurements(tagsList []map[string]string,
 	return nil, nil
 }
 
+// The names of the query metrics
+const (
+	QueryResponseTime = "QueryResponseTime"
+	QueryRows         = "QueryRows"
+)
+
+// Process processes a QuerySpanMessage. The response time is recorded in
+// microseconds, along with the rows if known. The queries of the new
+// fingerprints are dropped once the limit of the metrics is reached.
+func (s *QuerySpanMessage) Process(m *Measurements) {
+	primaryTags := map[string]string{"QueryFingerprint": s.Fingerprint, "Flavor": s.Flavor}
+	tagsList := []map[string]string{primaryTags}
+	if s.HasError {
+		withErrorTags := utils.CopyMap(&primaryTags)
+		withErrorTags["Errors"] = "true"
+		tagsList = append(tagsList, withErrorTags)
+	}
+	if err := m.record(QueryResponseTime, tagsList, float64(s.Duration/time.Microsecond), 1, true); err != nil {
+		return
+	}
+	if s.Rows >= 0 {
+		_ = m.record(QueryRows, []map[string]string{primaryTags}, float64(s.Rows), 1, true)
+	}
+}
+
+// The names of the cache metrics
+const (
+	CacheResponseTime = "CacheResponseTime"
+	CacheHitCount     = "CacheHitCount"
+	CacheMissCount    = "CacheMissCount"
+)
+
+// Process processes a CacheSpanMessage. The response time is recorded in
+// microseconds, and the request is counted as a hit or a miss. The new
+// commands and clusters are dropped once the limit of the metrics is reached.
+func (s *CacheSpanMessage) Process(m *Measurements) {
+	primaryTags := map[string]string{"KVOp": s.Op, "CacheCluster": s.Cluster}
+	tagsList := []map[string]string{primaryTags}
+	if s.HasError {
+		withErrorTags := utils.CopyMap(&primaryTags)
+		withErrorTags["Errors"] = "true"
+		tagsList = append(tagsList, withErrorTags)
+	}
+	if err := m.record(CacheResponseTime, tagsList, float64(s.Duration/time.Microsecond), 1, true); err != nil {
+		return
+	}
+	name := CacheMissCount
+	if s.Hit {
+		name = CacheHitCount
+	}
+	_ = m.record(name, []map[string]string{primaryTags}, 0, 1, false)
+}
+
 func (m *Measurements) recordWithSoloTags(name string, tags map[string]string,
 	value float64, count int, reportValue bool) error {
 	return m.record(name, []map[string]string{tags}, value, count, reportValue)
 }
 
+// the transaction names are the same for most of the requests, they're
+// interned to be shared by the messages of the spans.
+var transactionNames = utils.NewInterner(transactionNamesInternMax)
+
+// the HTTP status codes as the tag values
+var httpStatuses = utils.NewInterner(1000)
+
+// the metric IDs are the same for most of the requests, they're interned so
+// they don't have to be allocated for each measurement recorded.
+var metricIDs = utils.NewInterner(metricIDsInternMax)
+
+type tagKV struct{ k, v string }
+
+// less compares the tags as if they're joined by the TagsKVSeparator, without
+// joining them.
+func (t tagKV) less(o tagKV) bool {
+	la, lb := t.len(), o.len()
+	for i := 0; i < la && i < lb; i++ {
+		if ca, cb := t.at(i), o.at(i); ca != cb {
+			return ca < cb
+		}
+	}
+	return la < lb
+}
+
+func (t tagKV) len() int { return len(t.k) + len(TagsKVSeparator) + len(t.v) }
+
+func (t tagKV) at(i int) byte {
+	if i < len(t.k) {
+		return t.k[i]
+	}
+	if i -= len(t.k); i < len(TagsKVSeparator) {
+		return TagsKVSeparator[i]
+	}
+	return t.v[i-len(TagsKVSeparator)]
+}
+
+// appendMetricID appends the ID of the measurement to buf, it's the name,
+// reportValue and the tags sorted, joined by MetricIDSeparator, e.g.,
+// "TransactionResponseTime&true&TransactionName:name&".
+func appendMetricID(buf []byte, name string, reportValue bool, tags map[string]string) []byte {
+	buf = append(buf, name...)
+	buf = append(buf, MetricIDSeparator...)
+	buf = strconv.AppendBool(buf, reportValue)
+	buf = append(buf, MetricIDSeparator...)
+
+	// tags are part of the ID but since there's no guarantee that the map items
+	// are always iterated in the same order, we need to sort them ourselves
+	var arr [8]tagKV
+	kvs := arr[:0]
+	for k, v := range tags {
+		kvs = append(kvs, tagKV{k, v})
+	}
+	// insertion sort, as there are only a few tags
+	for i := 1; i < len(kvs); i++ {
+		for j := i; j > 0 && kvs[j].less(kvs[j-1]); j-- {
+			kvs[j], kvs[j-1] = kvs[j-1], kvs[j]
+		}
+	}
+	for _, kv := range kvs {
+		buf = append(buf, kv.k...)
+		buf = append(buf, TagsKVSeparator...)
+		buf = append(buf, kv.v...)
+		buf = append(buf, MetricIDSeparator...)
+	}
+	return buf
+}
+
 // records a measurement
 // name			key name
 // tagsList		the list of the additional tags
@@ -736,37 +1080,23 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 		return nil
 	}
 
-	idTagsMap := make(map[string]map[string]string)
-	idPrefixList := []string{name, strconv.FormatBool(reportValue)}
-
+	idTagsMap := make(map[string]map[string]string, len(tagsList))
+	var buf []byte
 	for _, tags := range tagsList {
-		idList := append(idPrefixList[:0:0], idPrefixList...)
-		if tags != nil {
-			// tags are part of the ID but since there's no guarantee that the map items
-			// are always iterated in the same order, we need to sort them ourselves
-			var tagsSorted []string
-			for k, v := range tags {
-				tagsSorted = append(tagsSorted, k+TagsKVSeparator+v)
-			}
-			sort.Strings(tagsSorted)
-
-			idList = append(idList, tagsSorted...)
-		}
-		idList = append(idList, "")
-		id := strings.Join(idList, MetricIDSeparator)
-
-		idTagsMap[id] = tags
+		buf = appendMetricID(buf[:0], name, reportValue, tags)
+		idTagsMap[metricIDs.InternBytes(buf)] = tags
 	}
 
 	var me *Measurement
 	var ok bool
 
 	// create a new measurement if it doesn't exist
-	// the lock protects both Measurements and Measurement
-	m.Lock()
-	defer m.Unlock()
+	// the lock of the shard protects both its map and Measurement
+	shard := m.shards.next()
+	shard.Lock()
+	defer shard.Unlock()
 	for id, tags := range idTagsMap {
-		if me, ok = m.m[id]; !ok {
+		if me, ok = shard.m[id]; !ok {
 			if strings.HasPrefix(id, OtherMetricIDPrefix) ||
 				m.transMap.IsWithinLimit(id) {
 				me = &Measurement{
@@ -774,7 +1104,7 @@ func (m *Measurements) record(name string, tagsList []map[string]string,
 					Tags:      tags,
 					ReportSum: reportValue,
 				}
-				m.m[id] = me
+				shard.m[id] = me
 			} else {
 				return ErrExceedsMetricsCountLimit
 			}
@@ -832,7 +1162,8 @@ func recordHistogram(hi *histograms, name string, duration time.Duration) {
 // bbuf		the BSON buffer to append the metric to
 // index	a running integer (0,1,2,...) which is needed for BSON arrays
 // m		measurement to be added
-func addMeasurementToBSON(bbuf *bson.Buffer, index *int, m *Measurement) {
+// tags		the serialized tags of the measurement, see tagsElements
+func addMeasurementToBSON(bbuf *bson.Buffer, index *int, m *Measurement, tags []byte) {
 	start := bbuf.AppendStartObject(strconv.Itoa(*index))
 
 	bbuf.AppendString("name", m.Name)
@@ -843,15 +1174,7 @@ func addMeasurementToBSON(bbuf *bson.Buffer, index *int, m *Measurement) {
 
 	if len(m.Tags) > 0 {
 		start := bbuf.AppendStartObject("tags")
-		for k, v := range m.Tags {
-			if len(k) > metricsTagNameLengthMax {
-				k = k[0:metricsTagNameLengthMax]
-			}
-			if len(v) > metricsTagValueLengthMax {
-				v = v[0:metricsTagValueLengthMax]
-			}
-			bbuf.AppendString(k, v)
-		}
+		bbuf.AppendElements(tags)
 		bbuf.AppendFinishObject(start)
 	}
 
@@ -918,6 +1241,12 @@ func (s *EventQueueStats) CopyAndReset() *EventQueueStats {
 	c.totalEvents = atomic.SwapInt64(&s.totalEvents, 0)
 	c.numOverflowed = atomic.SwapInt64(&s.numOverflowed, 0)
 	c.queueLargest = atomic.SwapInt64(&s.queueLargest, 0)
+	c.eventBytes = atomic.SwapInt64(&s.eventBytes, 0)
+	c.metricBytes = atomic.SwapInt64(&s.metricBytes, 0)
+	c.statusBytes = atomic.SwapInt64(&s.statusBytes, 0)
+	c.buildTime = atomic.SwapInt64(&s.buildTime, 0)
+	c.queueWait = atomic.SwapInt64(&s.queueWait, 0)
+	c.queueWaitMax = atomic.SwapInt64(&s.queueWaitMax, 0)
 
 	return c
 }
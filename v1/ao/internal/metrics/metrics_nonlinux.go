@@ -9,3 +9,7 @@ import "github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 func appendUname(bbuf *bson.Buffer) {}
 
 func addHostMetrics(bbuf *bson.Buffer, index *int) {}
+
+func addSystemMetrics(bbuf *bson.Buffer, index *int) {}
+
+func addContainerMetrics(bbuf *bson.Buffer, index *int) {}
@@ -7,16 +7,20 @@ import (
 	"math"
 	"net"
 	"os"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	mbson "gopkg.in/mgo.v2/bson"
 )
 
@@ -42,7 +46,7 @@ func round(val float64, roundOn float64, places int) (newVal float64) {
 
 func TestAppendIPAddresses(t *testing.T) {
 	bbuf := bson.NewBuffer()
-	appendIPAddresses(bbuf)
+	appendIPAddresses(bbuf, host.IPAddresses())
 	bbuf.Finish()
 	m := bsonToMap(bbuf)
 
@@ -70,6 +74,60 @@ func TestAppendIPAddresses(t *testing.T) {
 	}
 }
 
+func TestHostIDElements(t *testing.T) {
+	elems := hostIDElements("alias", []string{"10.0.0.1"})
+	// not rebuilt until the host identification changes
+	assert.True(t, &elems[0] == &hostIDElements("alias", []string{"10.0.0.1"})[0])
+
+	bbuf := bson.NewBuffer()
+	bbuf.AppendElements(hostIDElements("", []string{"10.0.0.2"}))
+	bbuf.Finish()
+	m := bsonToMap(bbuf)
+	assert.NotContains(t, m, "ConfiguredHostname")
+	assert.Equal(t, []interface{}{"10.0.0.2"}, m["IPAddresses"])
+	assert.Equal(t, host.Distro(), m["Distro"])
+
+	bbuf = bson.NewBuffer()
+	bbuf.AppendElements(hostIDElements("alias", nil))
+	bbuf.Finish()
+	m = bsonToMap(bbuf)
+	assert.Equal(t, "alias", m["ConfiguredHostname"])
+	assert.NotContains(t, m, "IPAddresses")
+}
+
+func TestTagsCache(t *testing.T) {
+	c := newTagsCache()
+	tags := map[string]string{"TransactionName": "/users"}
+	elems := c.elements("a", tags)
+	// reused by the following messages while the metric is reported
+	c.rotate()
+	assert.True(t, &elems[0] == &c.elements("a", tags)[0])
+	c.rotate()
+	assert.True(t, &elems[0] == &c.elements("a", tags)[0])
+	// dropped once a message doesn't report the metric
+	c.rotate()
+	c.rotate()
+	assert.False(t, &elems[0] == &c.elements("a", tags)[0])
+	assert.Equal(t, elems, c.elements("a", tags))
+
+	var nilCache *tagsCache
+	assert.Equal(t, elems, nilCache.elements("a", tags))
+	nilCache.rotate()
+
+	// the copies of the measurements share the cache
+	m := NewMeasurements(false, 60, 100)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, m.Increment("requests", MetricOptions{Count: 1, Tags: tags}))
+		bbuf := bson.WithBuf(BuildMessage(m.CopyAndReset(60), false))
+		mm := bsonToMap(bbuf)
+		measurements := mm["measurements"].([]interface{})
+		require.Len(t, measurements, 1)
+		assert.Equal(t, map[string]interface{}{"TransactionName": "/users"},
+			measurements[0].(map[string]interface{})["tags"])
+	}
+	assert.Len(t, m.tags.prev, 1)
+}
+
 func TestAppendMACAddresses(t *testing.T) {
 	host.Start()
 
@@ -207,8 +265,8 @@ func TestRecordMeasurement(t *testing.T) {
 	t1["t2"] = "tag2"
 	me.recordWithSoloTags("name1", t1, 111.11, 1, false)
 	me.recordWithSoloTags("name1", t1, 222, 1, false)
-	assert.NotNil(t, me.m["name1&false&t1:tag1&t2:tag2&"])
-	m := me.m["name1&false&t1:tag1&t2:tag2&"]
+	assert.NotNil(t, me.Clone().m["name1&false&t1:tag1&t2:tag2&"])
+	m := me.Clone().m["name1&false&t1:tag1&t2:tag2&"]
 	assert.Equal(t, "tag1", m.Tags["t1"])
 	assert.Equal(t, "tag2", m.Tags["t2"])
 	assert.Equal(t, 333.11, m.Sum)
@@ -218,14 +276,66 @@ func TestRecordMeasurement(t *testing.T) {
 	t2 := make(map[string]string)
 	t2["t3"] = "tag3"
 	me.recordWithSoloTags("name2", t2, 123.456, 3, true)
-	assert.NotNil(t, me.m["name2&true&t3:tag3&"])
-	m = me.m["name2&true&t3:tag3&"]
+	assert.NotNil(t, me.Clone().m["name2&true&t3:tag3&"])
+	m = me.Clone().m["name2&true&t3:tag3&"]
 	assert.Equal(t, "tag3", m.Tags["t3"])
 	assert.Equal(t, 123.456, m.Sum)
 	assert.Equal(t, 3, m.Count)
 	assert.True(t, m.ReportSum)
 }
 
+func TestAppendMetricID(t *testing.T) {
+	assert.Equal(t, "name&true&", string(appendMetricID(nil, "name", true, nil)))
+	// sorted as if the tags are joined, the first key is ordered after the
+	// second one as ':' > '1'
+	assert.Equal(t, "name&false&k1:v&k:v&ka:v&",
+		string(appendMetricID(nil, "name", false, map[string]string{"k": "v", "ka": "v", "k1": "v"})))
+	assert.Equal(t, "name&false&k:a&k:a:b&",
+		string(appendMetricID([]byte("xyz")[:0], "name", false, map[string]string{"k": "a", "k:a": "b"})))
+
+	// the IDs are interned
+	tags := map[string]string{"TransactionName": "t1", "HttpMethod": "GET"}
+	id1 := metricIDs.InternBytes(appendMetricID(nil, "name", true, tags))
+	id2 := metricIDs.InternBytes(appendMetricID(nil, "name", true, tags))
+	assert.Equal(t, "name&true&HttpMethod:GET&TransactionName:t1&", id1)
+	assert.Equal(t, stringData(id1), stringData(id2))
+}
+
+func TestJoinTransactionName(t *testing.T) {
+	n1 := JoinTransactionName("example.com", "/", "users")
+	n2 := JoinTransactionName("example.com/", "users")
+	assert.Equal(t, "example.com/users", n1)
+	assert.Equal(t, stringData(n1), stringData(n2))
+
+	long := strings.Repeat("a", 200)
+	assert.Equal(t, long+".action", JoinTransactionName(long, ".", "action"))
+
+	// the paths are neither allocated nor retained
+	p1 := GetTransactionFromPath("/users/123/profile")
+	p2 := GetTransactionFromPath("/users/123/settings")
+	assert.Equal(t, "/users/123", p1)
+	assert.Equal(t, stringData(p1), stringData(p2))
+}
+
+func stringData(s string) uintptr { return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data }
+
+func BenchmarkHTTPSpanMessageProcess(b *testing.B) {
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Millisecond, HasError: true},
+		Path:            "/users/123/profile",
+		Status:          500,
+		Host:            "example.com",
+		Method:          "GET",
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Transaction = GetTransactionFromPath(s.Path)
+		_, _ = s.processMeasurements(nil, m)
+	}
+}
+
 func TestRecordHistogram(t *testing.T) {
 	var hi = &histograms{
 		histograms: make(map[string]*histogram),
@@ -284,8 +394,8 @@ func TestAddMeasurementToBSON(t *testing.T) {
 
 	index := 0
 	bbuf := bson.NewBuffer()
-	addMeasurementToBSON(bbuf, &index, measurement1)
-	addMeasurementToBSON(bbuf, &index, measurement2)
+	addMeasurementToBSON(bbuf, &index, measurement1, tagsElements(tags1))
+	addMeasurementToBSON(bbuf, &index, measurement2, tagsElements(tags2))
 	bbuf.Finish()
 	m := bsonToMap(bbuf)
 
@@ -373,7 +483,7 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		map[string]*RateCounts{ // requested, sampled, limited, traced, through
 			RCRegular:             {10, 2, 5, 5, 1},
 			RCRelaxedTriggerTrace: {3, 0, 1, 2, 0},
-			RCStrictTriggerTrace:  {4, 0, 3, 1, 0}}, true))
+			RCStrictTriggerTrace:  {4, 0, 3, 1, 0}}, true, false, false))
 	m := bsonToMap(bbuf)
 
 	_, ok := m["Hostname"]
@@ -406,6 +516,12 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		{"NumFailed", int64(1)},
 		{"TotalEvents", int64(1)},
 		{"QueueLargest", int64(1)},
+		{"EventBytesSent", int64(1)},
+		{"MetricBytesSent", int64(1)},
+		{"StatusBytesSent", int64(1)},
+		{"EventBuildTime", int64(1)},
+		{"EventQueueWait", int64(1)},
+		{"EventQueueWaitMax", int64(1)},
 	}
 	if runtime.GOOS == "linux" {
 		testCases = append(testCases, []testCase{
@@ -460,7 +576,7 @@ func TestGenerateMetricsMessage(t *testing.T) {
 	}
 
 	m = bsonToMap(bson.WithBuf(BuildBuiltinMetricsMessage(testMetrics, &EventQueueStats{},
-		map[string]*RateCounts{RCRegular: {}, RCRelaxedTriggerTrace: {}, RCStrictTriggerTrace: {}}, true)))
+		map[string]*RateCounts{RCRegular: {}, RCRelaxedTriggerTrace: {}, RCStrictTriggerTrace: {}}, true, false, false)))
 
 	assert.NotNil(t, m["TransactionNameOverflow"])
 	assert.True(t, m["TransactionNameOverflow"].(bool))
@@ -483,6 +599,14 @@ func TestEventQueueStats(t *testing.T) {
 	es.SetQueueLargest(10)
 	assert.EqualValues(t, 10, es.queueLargest)
 
+	es.BuildTimeAdd(time.Millisecond)
+	assert.EqualValues(t, time.Millisecond, es.buildTime)
+
+	es.QueueWaitAdd(3*time.Second, 2*time.Second)
+	es.QueueWaitAdd(time.Second, time.Second)
+	assert.EqualValues(t, 4*time.Second, es.queueWait)
+	assert.EqualValues(t, 2*time.Second, es.queueWaitMax)
+
 	original := es
 	swapped := es.CopyAndReset()
 	assert.Equal(t, EventQueueStats{}, es)
@@ -526,8 +650,97 @@ func TestHTTPSpanMessageProcess(t *testing.T) {
 
 	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
 	s.Process(m)
-	measurement, ok := m.m["TransactionResponseTime&true&TransactionName:transaction&"]
+	measurement, ok := m.Clone().m["TransactionResponseTime&true&TransactionName:transaction&"]
 	assert.True(t, ok)
 	assert.NotNil(t, m)
 	assert.EqualValues(t, "TransactionResponseTime", measurement.Name)
 }
+
+func TestHTTPSpanMessageProcessTags(t *testing.T) {
+	s := HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Millisecond},
+		Transaction:     "transaction",
+		Status:          200,
+		Method:          "GET",
+		Tags:            map[string]string{"tenant": "acme", "region": "eu"},
+	}
+
+	m := NewMeasurements(false, 60, metricsTransactionsMaxDefault)
+	s.Process(m)
+	c := m.Clone()
+	assert.Len(t, c.m, 5)
+	tenant := c.m["TransactionResponseTime&true&TransactionName:transaction&tenant:acme&"]
+	require.NotNil(t, tenant)
+	assert.Equal(t, 1000.0, tenant.Sum)
+	assert.NotNil(t, c.m["TransactionResponseTime&true&TransactionName:transaction&region:eu&"])
+}
+
+func TestQuerySpanMessageProcess(t *testing.T) {
+	m := NewMeasurements(false, 60, 3)
+	s := QuerySpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: 2 * time.Millisecond},
+		Fingerprint:     "SELECT * FROM t WHERE id = ?",
+		Flavor:          "mysql",
+		Rows:            3,
+	}
+	s.Process(m)
+	s.HasError = true
+	s.Process(m)
+	// the rows are unknown
+	s.HasError = false
+	s.Rows = -1
+	s.Process(m)
+
+	c := m.Clone()
+	rt := c.m["QueryResponseTime&true&Flavor:mysql&QueryFingerprint:SELECT * FROM t WHERE id = ?&"]
+	require.NotNil(t, rt)
+	assert.Equal(t, 3, rt.Count)
+	assert.Equal(t, 6000.0, rt.Sum)
+	errs := c.m["QueryResponseTime&true&Errors:true&Flavor:mysql&QueryFingerprint:SELECT * FROM t WHERE id = ?&"]
+	require.NotNil(t, errs)
+	assert.Equal(t, 1, errs.Count)
+	rows := c.m["QueryRows&true&Flavor:mysql&QueryFingerprint:SELECT * FROM t WHERE id = ?&"]
+	require.NotNil(t, rows)
+	assert.Equal(t, 2, rows.Count)
+	assert.Equal(t, 6.0, rows.Sum)
+
+	// the new fingerprints are dropped once the limit is reached
+	s = QuerySpanMessage{Fingerprint: "SELECT 1", Flavor: "mysql", Rows: -1}
+	s.Process(m)
+	assert.Len(t, m.Clone().m, 3)
+}
+
+func TestCacheSpanMessageProcess(t *testing.T) {
+	m := NewMeasurements(false, 60, 4)
+	s := CacheSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Millisecond},
+		Op:              "GET",
+		Cluster:         "sessions",
+		Hit:             true,
+	}
+	s.Process(m)
+	s.Process(m)
+	s.Hit = false
+	s.HasError = true
+	s.Process(m)
+
+	c := m.Clone()
+	rt := c.m["CacheResponseTime&true&CacheCluster:sessions&KVOp:GET&"]
+	require.NotNil(t, rt)
+	assert.Equal(t, 3, rt.Count)
+	assert.Equal(t, 3000.0, rt.Sum)
+	errs := c.m["CacheResponseTime&true&CacheCluster:sessions&Errors:true&KVOp:GET&"]
+	require.NotNil(t, errs)
+	assert.Equal(t, 1, errs.Count)
+	hits := c.m["CacheHitCount&false&CacheCluster:sessions&KVOp:GET&"]
+	require.NotNil(t, hits)
+	assert.Equal(t, 2, hits.Count)
+	misses := c.m["CacheMissCount&false&CacheCluster:sessions&KVOp:GET&"]
+	require.NotNil(t, misses)
+	assert.Equal(t, 1, misses.Count)
+
+	// the new commands are dropped once the limit is reached
+	s = CacheSpanMessage{Op: "SET", Cluster: "sessions"}
+	s.Process(m)
+	assert.Len(t, m.Clone().m, 4)
+}
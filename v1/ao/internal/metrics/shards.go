@@ -0,0 +1,165 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// the maximum number of the shards of a collection
+const maxShards = 16
+
+// shardCount returns the number of the shards of a collection, one for each P
+// which can record concurrently.
+func shardCount() int {
+	n := runtime.GOMAXPROCS(0)
+	if n > maxShards {
+		n = maxShards
+	}
+	return n
+}
+
+// shardPicker picks the shards in turn. There is no way to get the current P
+// or goroutine, so the consecutive recordings go to different shards instead.
+type shardPicker struct {
+	n uint32 // accessed atomically
+}
+
+func (p *shardPicker) pick(count int) int {
+	return int(atomic.AddUint32(&p.n, 1) % uint32(count))
+}
+
+type measurementShard struct {
+	sync.Mutex
+	m map[string]*Measurement
+}
+
+type shardedMeasurements struct {
+	shards []*measurementShard
+	picker shardPicker
+}
+
+func newShardedMeasurements() shardedMeasurements {
+	s := shardedMeasurements{shards: make([]*measurementShard, shardCount())}
+	for i := range s.shards {
+		s.shards[i] = &measurementShard{m: make(map[string]*Measurement)}
+	}
+	return s
+}
+
+func (s *shardedMeasurements) next() *measurementShard {
+	return s.shards[s.picker.pick(len(s.shards))]
+}
+
+// swap replaces the maps of all the shards with empty ones and returns the
+// old maps. The function fn is called with all the shards locked before they
+// are swapped, empty is true if there is no measurement in any of them, in
+// which case the shards are left as they are.
+func (s *shardedMeasurements) swap(fn func(empty bool)) []map[string]*Measurement {
+	for _, shard := range s.shards {
+		shard.Lock()
+		defer shard.Unlock()
+	}
+	empty := true
+	for _, shard := range s.shards {
+		if len(shard.m) != 0 {
+			empty = false
+			break
+		}
+	}
+	fn(empty)
+	if empty {
+		return nil
+	}
+	maps := make([]map[string]*Measurement, len(s.shards))
+	for i, shard := range s.shards {
+		maps[i] = shard.m
+		shard.m = make(map[string]*Measurement)
+	}
+	return maps
+}
+
+// merged returns the measurements of all the shards merged, without
+// resetting them.
+func (s *shardedMeasurements) merged() map[string]*Measurement {
+	maps := make([]map[string]*Measurement, len(s.shards))
+	for i, shard := range s.shards {
+		shard.Lock()
+		maps[i] = make(map[string]*Measurement, len(shard.m))
+		for id, me := range shard.m {
+			c := *me
+			maps[i][id] = &c
+		}
+		shard.Unlock()
+	}
+	return mergeMeasurements(maps)
+}
+
+// mergeMeasurements merges the measurements with the same ID by adding up
+// their counts and sums. The measurements in maps may be modified.
+func mergeMeasurements(maps []map[string]*Measurement) map[string]*Measurement {
+	merged := make(map[string]*Measurement)
+	for _, m := range maps {
+		for id, me := range m {
+			if prev, ok := merged[id]; ok {
+				prev.Count += me.Count
+				prev.Sum += me.Sum
+			} else {
+				merged[id] = me
+			}
+		}
+	}
+	return merged
+}
+
+// shardedHistograms records the histograms into shards, they're merged when
+// flushed.
+type shardedHistograms struct {
+	shards []*histograms
+	picker shardPicker
+}
+
+func newShardedHistograms(precision int) *shardedHistograms {
+	s := &shardedHistograms{shards: make([]*histograms, shardCount())}
+	for i := range s.shards {
+		s.shards[i] = &histograms{
+			histograms: make(map[string]*histogram),
+			precision:  precision,
+		}
+	}
+	return s
+}
+
+func (s *shardedHistograms) setPrecision(p int) {
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+		shard.precision = p
+		shard.lock.Unlock()
+	}
+}
+
+func (s *shardedHistograms) next() *histograms {
+	return s.shards[s.picker.pick(len(s.shards))]
+}
+
+// flush clears all the shards and returns their histograms merged.
+func (s *shardedHistograms) flush() map[string]*histogram {
+	merged := make(map[string]*histogram)
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+		hs := shard.histograms
+		shard.histograms = make(map[string]*histogram)
+		shard.lock.Unlock()
+
+		for id, h := range hs {
+			if prev, ok := merged[id]; ok {
+				prev.hist.Add(h.hist)
+			} else {
+				merged[id] = h
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedMeasurements(t *testing.T) {
+	me := NewMeasurements(true, 60, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, me.Summary("summary", 2, MetricOptions{Count: 1}))
+				assert.NoError(t, me.Increment("increment", MetricOptions{Count: 1}))
+			}
+		}()
+	}
+	wg.Wait()
+	// the limit is shared by the shards
+	assert.Equal(t, ErrExceedsMetricsCountLimit, me.Increment("third", MetricOptions{Count: 1}))
+
+	c := me.CopyAndReset(30)
+	assert.Len(t, c.m, 2)
+	assert.Equal(t, 800, c.m["summary&true&"].Count)
+	assert.Equal(t, float64(1600), c.m["summary&true&"].Sum)
+	assert.Equal(t, 800, c.m["increment&false&"].Count)
+	assert.True(t, c.transMap.Overflow())
+	assert.EqualValues(t, 30, me.FlushInterval)
+
+	// nothing is recorded since the last copy
+	assert.Nil(t, me.CopyAndReset(60))
+	assert.NoError(t, me.Increment("third", MetricOptions{Count: 1}))
+	assert.Len(t, me.Clone().m, 1)
+}
+
+func TestShardedHistograms(t *testing.T) {
+	hi := newShardedHistograms(metricsHistPrecisionDefault)
+	for i := 0; i < 2*len(hi.shards); i++ {
+		recordHistogram(hi.next(), "", time.Millisecond)
+		recordHistogram(hi.next(), "txn", time.Millisecond)
+	}
+	hs := hi.flush()
+	assert.Len(t, hs, 2)
+	assert.EqualValues(t, 2*len(hi.shards), hs[""].hist.TotalCount())
+	assert.EqualValues(t, 2*len(hi.shards), hs["txn"].hist.TotalCount())
+	assert.Equal(t, "txn", hs["txn"].tags["TransactionName"])
+	assert.Empty(t, hi.flush())
+}
+
+func BenchmarkMeasurementsParallel(b *testing.B) {
+	me := NewMeasurements(true, 60, 500)
+	opts := MetricOptions{Count: 1, Tags: map[string]string{"Method": "GET"}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = me.Increment("requests", opts)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// the counters of the gRPC reporter since the start. All the fields are
+// supposed to be accessed through atomic operations.
+var agentCounters struct {
+	eventsQueued    int64
+	eventsDropped   int64
+	eventsSent      int64
+	eventsFailed    int64
+	reconnects      int64
+	settingsUpdated int64 // the Unix time in nanoseconds of the last settings received
+}
+
+// AgentStats is the state of the agent internals, which tells the health of
+// the agent, e.g., if the collector is unreachable or the events are dropped.
+type AgentStats struct {
+	// QueueDepth is the number of events waiting to be sent
+	QueueDepth int
+	// QueueCapacity is the max number of events waiting to be sent, beyond
+	// which they're dropped
+	QueueCapacity int
+	// EventsQueued is the number of events queued since the start
+	EventsQueued int64
+	// EventsSent is the number of events accepted by the collector
+	EventsSent int64
+	// EventsDropped is the number of events dropped as the queue is full
+	EventsDropped int64
+	// EventsFailed is the number of events rejected by the collector, e.g.,
+	// as the limit is exceeded. They're retried.
+	EventsFailed int64
+	// Reconnects is the number of times the connection to the collector is
+	// replaced, e.g., by a redirection
+	Reconnects int64
+	// SettingsAge is the time since the settings are received from the
+	// collector, -1 if they're never received
+	SettingsAge time.Duration
+}
+
+// GetAgentStats returns the state of the agent internals. The counters are
+// only maintained by the gRPC reporter.
+func GetAgentStats() AgentStats {
+	s := AgentStats{
+		EventsQueued:  atomic.LoadInt64(&agentCounters.eventsQueued),
+		EventsSent:    atomic.LoadInt64(&agentCounters.eventsSent),
+		EventsDropped: atomic.LoadInt64(&agentCounters.eventsDropped),
+		EventsFailed:  atomic.LoadInt64(&agentCounters.eventsFailed),
+		Reconnects:    atomic.LoadInt64(&agentCounters.reconnects),
+		SettingsAge:   -1,
+	}
+	if t := atomic.LoadInt64(&agentCounters.settingsUpdated); t > 0 {
+		s.SettingsAge = time.Since(time.Unix(0, t))
+	}
+	if r, ok := primaryReporter().(*grpcReporter); ok {
+		s.QueueDepth = len(r.eventMessages)
+		s.QueueCapacity = cap(r.eventMessages)
+	}
+	return s
+}
+
+// primaryReporter returns the global reporter, or the primary sink of it if
+// the events are reported to more than one.
+func primaryReporter() reporter {
+	if t, ok := globalReporter.(*teeReporter); ok && len(t.sinks) > 0 {
+		return t.sinks[0].reporter
+	}
+	return globalReporter
+}
+
+// addMessagesSent counts the events of the method accepted by the collector.
+func (c *grpcConnection) addMessagesSent(m Method) {
+	if _, ok := m.(*PostEventsMethod); ok {
+		atomic.AddInt64(&agentCounters.eventsSent, m.MessageLen())
+	}
+}
+
+// addMessagesFailed counts the events of the method rejected by the collector.
+func (c *grpcConnection) addMessagesFailed(m Method) {
+	if _, ok := m.(*PostEventsMethod); ok {
+		atomic.AddInt64(&agentCounters.eventsFailed, m.MessageLen())
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import "unicode/utf8"
+
+const (
+	// DefaultBlobLimit is the limit of a Blob created with a limit <= 0.
+	DefaultBlobLimit = 4096
+	// MaxBlobLimit is the upper bound of the limit of a Blob.
+	MaxBlobLimit = 64 * 1024
+)
+
+// the suffixes of the keys of the KVs marking a truncated Blob
+const (
+	blobTruncatedSuffix    = "_Truncated"
+	blobOriginalSizeSuffix = "_OriginalSize"
+)
+
+// Blob is a KV value of the large data, e.g., a request body or a query plan,
+// which is reported up to a limit of bytes. If the data is truncated, the
+// event also has the <key>_Truncated KV set to true and the <key>_OriginalSize
+// KV set to the size of the data. The data is not copied until it's encoded
+// into the event.
+type Blob struct {
+	data  []byte
+	str   string
+	isStr bool
+	limit int
+}
+
+// NewBlob returns a Blob of the data reported as binary.
+func NewBlob(data []byte, limit int) Blob {
+	return Blob{data: data, limit: blobLimit(limit)}
+}
+
+// NewStringBlob returns a Blob of the string, which is truncated at the
+// boundary of a UTF-8 character.
+func NewStringBlob(s string, limit int) Blob {
+	return Blob{str: s, isStr: true, limit: blobLimit(limit)}
+}
+
+func blobLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return DefaultBlobLimit
+	case limit > MaxBlobLimit:
+		return MaxBlobLimit
+	default:
+		return limit
+	}
+}
+
+// Size returns the size of the data in bytes, before the truncation.
+func (b Blob) Size() int {
+	if b.isStr {
+		return len(b.str)
+	}
+	return len(b.data)
+}
+
+// Truncated returns whether the data is longer than the limit.
+func (b Blob) Truncated() bool {
+	return b.Size() > b.limit
+}
+
+// truncateUTF8 returns the longest prefix of s up to n bytes which doesn't end
+// with a partial UTF-8 character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for i := 1; i <= utf8.UTFMax && i <= len(s); i++ {
+		if utf8.RuneStart(s[len(s)-i]) {
+			if !utf8.FullRuneInString(s[len(s)-i:]) {
+				s = s[:len(s)-i]
+			}
+			break
+		}
+	}
+	return s
+}
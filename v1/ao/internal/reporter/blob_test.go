@@ -0,0 +1,66 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"strings"
+	"testing"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobLimit(t *testing.T) {
+	assert.Equal(t, DefaultBlobLimit, NewBlob(nil, 0).limit)
+	assert.Equal(t, DefaultBlobLimit, NewStringBlob("", -1).limit)
+	assert.Equal(t, MaxBlobLimit, NewBlob(nil, MaxBlobLimit+1).limit)
+	assert.Equal(t, 10, NewBlob(nil, 10).limit)
+
+	b := NewStringBlob("hello", 5)
+	assert.Equal(t, 5, b.Size())
+	assert.False(t, b.Truncated())
+	b = NewBlob([]byte("hello!"), 5)
+	assert.Equal(t, 6, b.Size())
+	assert.True(t, b.Truncated())
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "abc", truncateUTF8("abc", 5))
+	assert.Equal(t, "ab", truncateUTF8("abc", 2))
+	// "é" is 2 bytes and "世" is 3 bytes
+	assert.Equal(t, "a", truncateUTF8("aé", 2))
+	assert.Equal(t, "aé", truncateUTF8("aé", 3))
+	assert.Equal(t, "", truncateUTF8("世界", 2))
+	assert.Equal(t, "世", truncateUTF8("世界", 5))
+}
+
+func TestEventBlob(t *testing.T) {
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	body := []byte(strings.Repeat("x", 100))
+	plan := NewStringBlob("Seq Scan on é", 13)
+	err := ctx.reportEvent(LabelEntry, testLayer, false,
+		"Body", NewBlob(body, 10),
+		"Short", NewBlob([]byte("ok"), 10),
+		"Plan", &plan,
+	)
+	assert.NoError(t, err)
+
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"go_test", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, body[:10], n.Map["Body"])
+			assert.Equal(t, true, n.Map["Body_Truncated"])
+			assert.EqualValues(t, 100, n.Map["Body_OriginalSize"])
+
+			assert.Equal(t, []byte("ok"), n.Map["Short"])
+			assert.NotContains(t, n.Map, "Short_Truncated")
+			assert.NotContains(t, n.Map, "Short_OriginalSize")
+
+			// the partial character is dropped
+			assert.Equal(t, "Seq Scan on ", n.Map["Plan"])
+			assert.Equal(t, true, n.Map["Plan_Truncated"])
+			assert.EqualValues(t, 14, n.Map["Plan_OriginalSize"])
+		}},
+	})
+}
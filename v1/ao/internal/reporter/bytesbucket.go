@@ -29,6 +29,16 @@ type BytesBucket struct {
 	// the function to get the new interval
 	getInterval func() time.Duration
 
+	// the function to get the maximum age of the water, nil or zero means
+	// not limited
+	getMaxAge func() time.Duration
+
+	// when the oldest water in the bucket was poured in
+	oldest time.Time
+
+	// the sum of the UnixNano times when the water was poured in
+	pouredAt int64
+
 	// where the water is stored in
 	water [][]byte
 
@@ -100,6 +110,39 @@ func WithIntervalGetter(fn func() time.Duration) BucketOption {
 	}
 }
 
+// WithMaxAgeGetter provides the maximum age of the water, the bucket is
+// drainable once the oldest water in it reaches the age, no matter when the
+// next drain interval is due.
+func WithMaxAgeGetter(fn func() time.Duration) BucketOption {
+	return func(b *BytesBucket) {
+		b.getMaxAge = fn
+	}
+}
+
+// pour puts the water into the bucket without checking the watermark.
+func (b *BytesBucket) pour(m []byte) {
+	now := time.Now()
+	if b.watermark == 0 {
+		b.oldest = now
+	}
+	b.pouredAt += now.UnixNano()
+	b.watermark += len(m)
+	b.water = append(b.water, m)
+}
+
+// ageTimeout returns a channel which fires when the oldest water in the bucket
+// reaches the maximum age, or nil if there is no water or no limit.
+func (b *BytesBucket) ageTimeout() <-chan time.Time {
+	if b.getMaxAge == nil || b.watermark == 0 {
+		return nil
+	}
+	age := b.getMaxAge()
+	if age <= 0 {
+		return nil
+	}
+	return time.After(age - time.Since(b.oldest))
+}
+
 // PourIn pours as much water as possible from the source into the bucket and returns
 // the water it pours in.
 // This method blocks until it's either full or timeout.
@@ -112,8 +155,7 @@ func (b *BytesBucket) PourIn() int {
 
 	for len(b.waitingList) != 0 {
 		if len(b.waitingList[0]) <= b.HWM-b.watermark {
-			b.watermark += len(b.waitingList[0])
-			b.water = append(b.water, b.waitingList[0])
+			b.pour(b.waitingList[0])
 			b.waitingList = b.waitingList[1:]
 		} else {
 			break
@@ -121,6 +163,7 @@ func (b *BytesBucket) PourIn() int {
 	}
 
 	drainTimeout := time.After(b.nextDrainTimeout.Sub(time.Now()))
+	ageTimeout := b.ageTimeout()
 	// drain the first drop of water ASAP
 	drainASAP := b.neverDrained
 
@@ -135,12 +178,15 @@ outer:
 			}
 
 			if len(m) <= b.HWM-b.watermark {
-				b.watermark += len(m)
-				b.water = append(b.water, m)
+				first := b.watermark == 0
+				b.pour(m)
 				if drainASAP {
 					b.full = true
 					break outer
 				}
+				if first {
+					ageTimeout = b.ageTimeout()
+				}
 			} else { // let's stop when the bucket is full
 				if len(b.waitingList) <= 100 {
 					b.waitingList = append(b.waitingList, m)
@@ -159,6 +205,10 @@ outer:
 				drainASAP = true
 			}
 
+		case <-ageTimeout:
+			b.full = true
+			break outer
+
 		case <-b.closing:
 			if b.gracefulShutdown && b.watermark != 0 {
 				b.full = true
@@ -176,6 +226,7 @@ func (b *BytesBucket) Drain() [][]byte {
 
 	b.water = [][]byte{}
 	b.watermark = 0
+	b.pouredAt = 0
 	b.full = false
 	b.neverDrained = false
 	b.nextDrainTimeout = time.Now().Add(b.getInterval())
@@ -201,6 +252,17 @@ func (b *BytesBucket) DroppedCount() int {
 	return b.droppedCount
 }
 
+// Wait returns the total time the water has been in the bucket since it was
+// poured in, and the time of the oldest one.
+func (b *BytesBucket) Wait() (total, oldest time.Duration) {
+	if len(b.water) == 0 {
+		return 0, 0
+	}
+	now := time.Now()
+	total = time.Duration(int64(len(b.water))*now.UnixNano() - b.pouredAt)
+	return total, now.Sub(b.oldest)
+}
+
 // Count returns the water count during a drain cycle
 func (b *BytesBucket) Count() int {
 	return len(b.water)
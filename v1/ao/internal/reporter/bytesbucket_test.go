@@ -94,3 +94,49 @@ func TestBytesBucket_OversizeCount(t *testing.T) {
 	assert.Equal(t, 0, poured)
 	assert.True(t, b.Full())
 }
+
+func TestBytesBucket_MaxAge(t *testing.T) {
+	source := make(chan []byte, 10)
+	// the interval is long enough to never be reached in this test
+	b := NewBytesBucket(source,
+		WithHWM(100),
+		WithIntervalGetter(func() time.Duration { return time.Hour }),
+		WithMaxAgeGetter(func() time.Duration { return time.Millisecond * 50 }))
+
+	// the first drop of water is drained ASAP
+	source <- []byte{0}
+	assert.Equal(t, 1, b.PourIn())
+	assert.True(t, b.Full())
+	b.Drain()
+
+	// the bucket is drainable once the oldest water reaches the max age
+	source <- []byte{1}
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		source <- []byte{2}
+	}()
+	start := time.Now()
+	assert.Equal(t, 2, b.PourIn())
+	assert.True(t, b.Full())
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= time.Millisecond*50, elapsed)
+	assert.True(t, elapsed < time.Second, elapsed)
+	// the second water has waited less than the oldest one
+	total, oldest := b.Wait()
+	assert.True(t, oldest >= time.Millisecond*50, oldest)
+	assert.True(t, total > oldest && total < 2*oldest, total)
+	b.Drain()
+	total, oldest = b.Wait()
+	assert.Zero(t, total)
+	assert.Zero(t, oldest)
+
+	// no water, no age limit
+	closing := make(chan struct{})
+	b.closing = closing
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		close(closing)
+	}()
+	assert.Equal(t, 0, b.PourIn())
+	assert.False(t, b.Full())
+}
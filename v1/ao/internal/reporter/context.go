@@ -10,11 +10,14 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/pkg/errors"
 )
@@ -41,6 +44,7 @@ const (
 const HTTPHeaderXTraceOptions = "X-Trace-Options"
 const HTTPHeaderXTraceOptionsSignature = "X-Trace-Options-Signature"
 const HTTPHeaderXTraceOptionsResponse = "X-Trace-Options-Response"
+const HTTPHeaderXTraceSignature = "X-Trace-Signature"
 
 var (
 	errInvalidTaskID = errors.New("invalid task id")
@@ -74,9 +78,16 @@ type oboeContext struct {
 }
 
 type transactionContext struct {
-	name string
+	// the nanoseconds spent by the agent building the events of the trace,
+	// accessed atomically
+	overhead int64
+	name     string
 	// if the trace/transaction is enabled (defined by per-URL transaction filtering)
 	enabled bool
+	// the sampling decision of the trace
+	decision Decision
+	// if the CPU profile of the request is requested by X-Trace-Options
+	profile bool
 	sync.RWMutex
 }
 
@@ -88,10 +99,24 @@ type ContextOptions struct {
 	MdStr string
 	// URL is used to do the URL-based transaction filtering.
 	URL string
+	// Method is the HTTP method of the request, used to apply the per-method
+	// sample rate.
+	Method string
 	// XTraceOptions represents the X-Trace-Options header.
 	XTraceOptions string
 	// XTraceOptionsSignature represents the X-Trace-Options-Signature header.
 	XTraceOptionsSignature string
+	// RemoteAddr is the address of the peer, either an IP or "host:port". The
+	// trusted parent sampling policy only honors MdStr if it's trusted.
+	RemoteAddr string
+	// MdSignature represents the X-Trace-Signature header, the signature of
+	// MdStr. It's only checked if APPOPTICS_CONTEXT_SIGNING_KEY is set.
+	MdSignature string
+	// Ingress marks MdStr as received from the network, e.g., in the headers
+	// of an HTTP or gRPC request. Only such a MdStr is subject to the parent
+	// sampling policy and the signature check, the ones from the same process
+	// are always honored.
+	Ingress bool
 	// CB is the callback function to produce the KVs.
 	CB func() KVMap
 }
@@ -117,6 +142,21 @@ func (md *oboeMetadata) Init() {
 	md.ids.opID = make([]byte, oboeMaxOpIDLen)
 }
 
+// reset is the same as Init but the ID buffers allocated before are zeroed
+// and reused.
+func (md *oboeMetadata) reset() {
+	md.version = xtrCurrentVersion
+	md.taskLen = oboeMaxTaskIDLen
+	md.opLen = oboeMaxOpIDLen
+	md.flags = 0
+	for i := range md.ids.taskID {
+		md.ids.taskID[i] = 0
+	}
+	for i := range md.ids.opID {
+		md.ids.opID[i] = 0
+	}
+}
+
 // randReader provides random IDs, and can be overridden for testing.
 // set by default to read from the crypto/rand Reader.
 var randReader = rand.Reader
@@ -326,11 +366,15 @@ type Context interface {
 	SetSampled(trace bool)
 	SetEnabled(enabled bool)
 	GetEnabled() bool
+	SetDecision(d Decision)
+	GetDecision() Decision
 	SetTransactionName(name string)
 	GetTransactionName() string
 	MetadataString() string
 	NewEvent(label Label, layer string, addCtxEdge bool) Event
 	GetVersion() uint8
+	Overhead() time.Duration
+	ProfileRequested() bool
 }
 
 // A Event is an event that may or may not be tracing, created by a Context.
@@ -354,11 +398,15 @@ func (e *nullContext) IsSampled() bool                                       { r
 func (e *nullContext) SetSampled(trace bool)                                 {}
 func (e *nullContext) SetEnabled(enabled bool)                               {}
 func (e *nullContext) GetEnabled() bool                                      { return true }
+func (e *nullContext) SetDecision(d Decision)                                {}
+func (e *nullContext) GetDecision() Decision                                 { return Decision{} }
 func (e *nullContext) SetTransactionName(name string)                        {}
 func (e *nullContext) GetTransactionName() string                            { return "" }
 func (e *nullContext) MetadataString() string                                { return "" }
 func (e *nullContext) NewEvent(l Label, y string, g bool) Event              { return &nullEvent{} }
 func (e *nullContext) GetVersion() uint8                                     { return 0 }
+func (e *nullContext) Overhead() time.Duration                               { return 0 }
+func (e *nullContext) ProfileRequested() bool                                { return false }
 func (e *nullEvent) ReportContext(c Context, g bool, a ...interface{}) error { return nil }
 func (e *nullEvent) MetadataString() string                                  { return "" }
 
@@ -405,7 +453,7 @@ func parseTriggerTraceFlag(opts, sig string) (TriggerTraceMode, map[string]strin
 
 		if len(kvSlice) == 2 {
 			v = strings.TrimSpace(kvSlice[1])
-		} else if kvSlice[0] != "trigger-trace" {
+		} else if kvSlice[0] != "trigger-trace" && kvSlice[0] != xtoProfile {
 			log.Debugf("Dangling key found: %s", kvSlice[0])
 			ignored = append(ignored, k)
 			continue
@@ -420,6 +468,7 @@ func parseTriggerTraceFlag(opts, sig string) (TriggerTraceMode, map[string]strin
 		if !(strings.HasPrefix(k, "custom-") ||
 			k == "pd-keys" ||
 			k == "trigger-trace" ||
+			k == xtoProfile ||
 			k == "ts") {
 			ignored = append(ignored, k)
 			continue
@@ -473,6 +522,26 @@ func parseTriggerTraceFlag(opts, sig string) (TriggerTraceMode, map[string]strin
 	return mode, kvs, ignored, authErr
 }
 
+// xtoProfile is the option of X-Trace-Options requesting the CPU profile of
+// a trigger trace request
+const xtoProfile = "profile"
+
+// takeProfileOption removes the profile option from the KVs of X-Trace-Options
+// and returns if it's requested, which is only honored for the trigger trace
+// requests with a valid signature as the CPU profiles are costly, or if it's
+// ignored.
+func takeProfileOption(mode TriggerTraceMode, kvs map[string]string) (requested, ignored bool) {
+	v, ok := kvs[xtoProfile]
+	if !ok {
+		return false, false
+	}
+	delete(kvs, xtoProfile)
+	if v != "" || mode != ModeRelaxedTriggerTrace {
+		return false, true
+	}
+	return true, false
+}
+
 // Trigger trace signature authentication errors
 const (
 	ttAuthBadTimestamp   = "bad-timestamp"
@@ -540,6 +609,11 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 	addCtxEdge := false
 
 	tMode, tKVs, tIgnoredKeys, authErr := parseTriggerTraceFlag(opts.XTraceOptions, opts.XTraceOptionsSignature)
+	profile, profileIgnored := takeProfileOption(tMode, tKVs)
+	if profileIgnored {
+		tIgnoredKeys = append(tIgnoredKeys, xtoProfile)
+	}
+	profileGranted := false
 
 	var SetHeaders = func(tt string) {
 		// Only set response headers when X-Trace-Options is present
@@ -559,6 +633,9 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 		} else if authErr != nil {
 			v = append(v, "auth="+authErr.Error())
 		}
+		if profileGranted {
+			v = append(v, xtoProfile+"="+ttOK)
+		}
 		headers[HTTPHeaderXTraceOptionsResponse] = strings.Join(v, ";")
 	}
 
@@ -576,6 +653,23 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 	}()
 
 	continuedTrace := false
+	localDecision := false
+
+	// the upstream sampled flag is ignored but the trace is still continued
+	untrusted := opts.MdStr != "" && opts.Ingress && !honorsParent(opts.RemoteAddr)
+	if untrusted {
+		log.Debugf("incoming x-trace from %s: sampled flag ignored by the parent sampling policy", opts.RemoteAddr)
+	}
+
+	unsigned := false
+	if opts.MdStr != "" && opts.Ingress && !validMetadataSignature(opts.MdStr, opts.MdSignature) {
+		if config.GetUnsignedContext() == config.RejectUnsignedContext {
+			log.Debug("passed in x-trace is not signed, ignoring")
+			opts.MdStr = ""
+		} else {
+			unsigned = true
+		}
+	}
 
 	if opts.MdStr != "" {
 		var err error
@@ -583,6 +677,13 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 			log.Debug("passed in x-trace seems invalid, ignoring")
 		} else if ctx.GetVersion() != xtrCurrentVersion {
 			log.Debug("passed in x-trace has wrong version, ignoring")
+		} else if untrusted || ctx.IsSampled() && unsigned {
+			// the trace is continued but the sampling decision is made
+			// locally, as if it's a new request
+			if unsigned {
+				log.Debug("passed in x-trace is not signed, its sampled flag is ignored")
+			}
+			localDecision = true
 		} else if ctx.IsSampled() {
 			traced = true
 			addCtxEdge = true
@@ -596,6 +697,7 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 
 			_, flags, _ := mergeURLSetting(setting, opts.URL)
 			ctx.SetEnabled(flags.Enabled())
+			ctx.SetDecision(Decision{Sampled: false, Reason: DecisionUpstream, SampleRate: -1})
 
 			if tMode.Requested() {
 				SetHeaders(ttIgnored)
@@ -607,14 +709,26 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 		}
 	}
 
-	if !traced {
+	if !traced && !localDecision {
 		ctx = newContext(true)
 	}
 
-	decision := shouldTraceRequestWithURL(layer, traced, opts.URL, tMode)
+	var taskID []byte
+	if c, ok := ctx.(*oboeContext); ok {
+		taskID = c.metadata.ids.taskID
+	}
+	decision := shouldTraceHTTPRequest(layer, traced, opts.URL, opts.Method, tMode, taskID)
 	ctx.SetEnabled(decision.enabled)
+	ctx.SetDecision(decisionOf(decision))
 
 	if decision.trace {
+		if localDecision {
+			ctx.SetSampled(true)
+		}
+		if c, ok := ctx.(*oboeContext); ok && profile {
+			c.txCtx.profile = true
+			profileGranted = true
+		}
 		if reportEntry {
 			var kvs map[string]interface{}
 			if cb != nil {
@@ -656,6 +770,57 @@ func NewContext(layer string, reportEntry bool, opts ContextOptions,
 	return ctx, true, headers
 }
 
+// SignMetadata returns the signature of the metadata string md to be sent in
+// the X-Trace-Signature header, or an empty string if there is no signing key.
+func SignMetadata(md string) string {
+	key := config.GetContextSigningKey()
+	if key == "" || md == "" {
+		return ""
+	}
+	return HmacHash([]byte(key), []byte(strings.ToUpper(md)))
+}
+
+// validMetadataSignature checks the signature of the incoming metadata string
+// md. All the signatures are valid if there is no signing key.
+func validMetadataSignature(md, sig string) bool {
+	if config.GetContextSigningKey() == "" {
+		return true
+	}
+	return sig != "" && hmac.Equal([]byte(SignMetadata(md)), []byte(strings.ToLower(sig)))
+}
+
+// honorsParent reports if the sampled flag of the incoming trace context of a
+// request from the peer at addr is honored by the parent sampling policy.
+func honorsParent(addr string) bool {
+	switch config.GetParentSampling() {
+	case config.NeverParentSampling:
+		return false
+	case config.TrustedParentSampling:
+		return isTrustedParent(addr)
+	default:
+		return true
+	}
+}
+
+// isTrustedParent checks if the peer at addr is in the trusted networks. A
+// peer whose address is unknown is not trusted.
+func isTrustedParent(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range config.GetTrustedParents() {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ctx *oboeContext) Copy() Context {
 	md := oboeMetadata{}
 	md.Init()
@@ -682,6 +847,18 @@ func (ctx *oboeContext) GetEnabled() bool {
 	return ctx.txCtx.enabled
 }
 
+func (ctx *oboeContext) SetDecision(d Decision) {
+	ctx.txCtx.Lock()
+	defer ctx.txCtx.Unlock()
+	ctx.txCtx.decision = d
+}
+
+func (ctx *oboeContext) GetDecision() Decision {
+	ctx.txCtx.RLock()
+	defer ctx.txCtx.RUnlock()
+	return ctx.txCtx.decision
+}
+
 func (ctx *oboeContext) SetTransactionName(name string) {
 	ctx.txCtx.Lock()
 	defer ctx.txCtx.Unlock()
@@ -713,6 +890,22 @@ func (ctx *oboeContext) GetVersion() uint8 {
 	return ctx.metadata.version
 }
 
+// Overhead returns the time spent by the agent building the events reported
+// so far by the trace.
+func (ctx *oboeContext) Overhead() time.Duration {
+	return time.Duration(atomic.LoadInt64(&ctx.txCtx.overhead))
+}
+
+// ProfileRequested returns if the CPU profile of the request is requested by
+// the profile option of a signed X-Trace-Options, and the request is traced.
+func (ctx *oboeContext) ProfileRequested() bool {
+	return ctx.txCtx.profile
+}
+
+func (ctx *oboeContext) addOverhead(d time.Duration) {
+	atomic.AddInt64(&ctx.txCtx.overhead, int64(d))
+}
+
 // Create and report and event using a map of KVs
 func (ctx *oboeContext) ReportEventMap(label Label, layer string, keys map[string]interface{}) error {
 	return ctx.reportEventMap(label, layer, true, keys)
@@ -734,16 +927,22 @@ func (ctx *oboeContext) ReportEvent(label Label, layer string, args ...interface
 
 // Create and report an event using KVs from variadic args
 func (ctx *oboeContext) reportEvent(label Label, layer string, addCtxEdge bool, args ...interface{}) error {
-	// create new event from context
-	e, err := ctx.newEvent(label, layer)
+	start := time.Now()
+	// create new event from context, it's not referenced once reported
+	e, err := newPooledEvent(&ctx.metadata, label, layer)
 	if err != nil { // error creating event (e.g. couldn't init random IDs)
 		return err
 	}
+	defer releaseEvent(e)
+	e.buildStart = start
 	return ctx.report(e, addCtxEdge, args...)
 }
 
 // report an event using KVs from variadic args
 func (ctx *oboeContext) report(e *event, addCtxEdge bool, args ...interface{}) error {
+	if e.buildStart.IsZero() { // the event was created before, e.g., by NewEvent
+		e.buildStart = time.Now()
+	}
 	for i := 0; i+1 < len(args); i += 2 {
 		if err := e.AddKV(args[i], args[i+1]); err != nil {
 			return err
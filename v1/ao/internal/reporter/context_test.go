@@ -7,10 +7,12 @@ import (
 	"crypto/rand"
 	"errors"
 	"io"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -228,6 +230,24 @@ func TestReportEventMap(t *testing.T) {
 	})
 }
 
+func TestContextOverhead(t *testing.T) {
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	assert.Zero(t, ctx.Overhead())
+
+	assert.NoError(t, ctx.ReportEvent(LabelEntry, "myLayer"))
+	first := ctx.Overhead()
+	assert.True(t, first > 0)
+
+	// the copies of the context share the overhead of the trace
+	cp := ctx.Copy()
+	assert.NoError(t, cp.ReportEvent(LabelInfo, "myLayer", "K", "V"))
+	assert.True(t, ctx.Overhead() > first)
+	assert.Equal(t, ctx.Overhead(), cp.Overhead())
+	assert.Zero(t, NewNullContext().Overhead())
+	r.Close(2)
+}
+
 func TestNewContextForURL(t *testing.T) {
 	r := SetTestReporter()
 
@@ -254,6 +274,138 @@ func TestNewContextForURL(t *testing.T) {
 	})
 }
 
+func TestParentSampling(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_PARENT_SAMPLING")
+		os.Unsetenv("APPOPTICS_TRUSTED_PARENTS")
+		config.Load()
+	}()
+	parent := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	// the entry of a trace sampled by the local decision has the SampleRate
+	// and no edge to the parent
+	assertHonored := func(addr string, ingress, honored bool) {
+		t.Helper()
+		r := SetTestReporter()
+		ctx, ok, _ := NewContext("testParent", true, ContextOptions{MdStr: parent, RemoteAddr: addr, Ingress: ingress}, nil)
+		assert.True(t, ok)
+		// the trace is continued anyway
+		assert.True(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+		r.Close(1)
+		var edges g.Edges
+		if honored {
+			edges = g.Edges{{"Edge", parent[42:58]}}
+		}
+		g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+			{"testParent", "entry"}: {Edges: edges, Callback: func(n g.Node) {
+				_, sampledLocally := n.Map["SampleRate"]
+				assert.Equal(t, !honored, sampledLocally, addr)
+			}},
+		})
+	}
+
+	// always by default
+	assertHonored("", true, true)
+
+	os.Setenv("APPOPTICS_PARENT_SAMPLING", "never")
+	config.Load()
+	assertHonored("10.1.2.3:8080", true, false)
+	// the trace context from the same process is always honored
+	assertHonored("", false, true)
+
+	os.Setenv("APPOPTICS_PARENT_SAMPLING", "trusted")
+	os.Setenv("APPOPTICS_TRUSTED_PARENTS", "10.0.0.0/8,::1")
+	config.Load()
+	assertHonored("10.1.2.3:8080", true, true)
+	assertHonored("10.1.2.3", true, true)
+	assertHonored("[::1]:8080", true, true)
+	assertHonored("192.168.1.1:8080", true, false)
+	assertHonored("", true, false)
+	assertHonored("", false, true)
+
+	// the local decision samples an untrusted unsampled parent
+	r := SetTestReporter(TestReporterUseSettings(false), TestReporterShouldTrace(true))
+	unsampled := parent[:58] + "00"
+	ctx, ok, _ := NewContext("testParent", true, ContextOptions{MdStr: unsampled, Ingress: true}, nil)
+	assert.True(t, ok)
+	assert.True(t, ctx.IsSampled())
+	assert.True(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"testParent", "entry"}: {Callback: func(n g.Node) {
+			assert.Contains(t, n.Map, "SampleRate")
+		}},
+	})
+}
+
+func TestSignedContext(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_CONTEXT_SIGNING_KEY")
+		os.Unsetenv("APPOPTICS_UNSIGNED_CONTEXT")
+		config.Load()
+	}()
+	parent := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	assert.Empty(t, SignMetadata(parent))
+
+	os.Setenv("APPOPTICS_CONTEXT_SIGNING_KEY", "secret")
+	config.Load()
+	sig := SignMetadata(parent)
+	assert.Equal(t, HmacHash([]byte("secret"), []byte(parent)), sig)
+	assert.Equal(t, sig, SignMetadata(strings.ToLower(parent)))
+
+	newCtx := func(sig string, shouldTrace bool) (Context, *TestReporter) {
+		r := SetTestReporter(TestReporterUseSettings(false), TestReporterShouldTrace(shouldTrace))
+		ctx, ok, _ := NewContext("testSigned", true, ContextOptions{MdStr: parent, MdSignature: sig, Ingress: true}, nil)
+		assert.True(t, ok)
+		return ctx, r
+	}
+
+	// a signed context is continued
+	ctx, r := newCtx(strings.ToUpper(sig), true)
+	assert.True(t, ctx.IsSampled())
+	assert.True(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"testSigned", "entry"}: {Edges: g.Edges{{"Edge", parent[42:58]}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SampleRate")
+		}},
+	})
+
+	// downgraded, the trace is continued but sampled by the local decision
+	ctx, r = newCtx("bad", false)
+	assert.False(t, ctx.IsSampled())
+	assert.True(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+	r.Close(0)
+	ctx, r = newCtx("", true)
+	assert.True(t, ctx.IsSampled())
+	assert.True(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"testSigned", "entry"}: {Callback: func(n g.Node) {
+			assert.Contains(t, n.Map, "SampleRate")
+		}},
+	})
+
+	// rejected, a new trace is started
+	os.Setenv("APPOPTICS_UNSIGNED_CONTEXT", "reject")
+	config.Load()
+	ctx, r = newCtx("", true)
+	assert.True(t, ctx.IsSampled())
+	assert.False(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+	r.Close(1)
+
+	// the contexts from the same process are not signed
+	r = SetTestReporter(TestReporterUseSettings(false), TestReporterShouldTrace(true))
+	ctx, ok, _ := NewContext("testSigned", true, ContextOptions{MdStr: parent}, nil)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(ctx.MetadataString(), parent[:42]))
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"testSigned", "entry"}: {Edges: g.Edges{{"Edge", parent[42:58]}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SampleRate")
+		}},
+	})
+}
+
 func TestNewContextForURLTracingDisabled(t *testing.T) {
 	r := SetTestReporter(TestReporterDisableTracing()) // set up test reporter
 
@@ -332,6 +484,29 @@ func TestParseTriggerTraceFlag(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestTakeProfileOption(t *testing.T) {
+	mode, kvs, ignored, err := parseTriggerTraceFlag("trigger-trace;profile;custom-key=val", "")
+	assert.Equal(t, ModeStrictTriggerTrace, mode)
+	assert.Nil(t, err)
+	assert.Empty(t, ignored)
+
+	// only honored for the signed requests
+	requested, ign := takeProfileOption(mode, kvs)
+	assert.False(t, requested)
+	assert.True(t, ign)
+	assert.Equal(t, map[string]string{"custom-key": "val"}, kvs)
+
+	requested, ign = takeProfileOption(ModeRelaxedTriggerTrace, map[string]string{"profile": ""})
+	assert.True(t, requested)
+	assert.False(t, ign)
+	requested, ign = takeProfileOption(ModeRelaxedTriggerTrace, map[string]string{"profile": "on"})
+	assert.False(t, requested)
+	assert.True(t, ign)
+	requested, ign = takeProfileOption(ModeRelaxedTriggerTrace, map[string]string{})
+	assert.False(t, requested)
+	assert.False(t, ign)
+}
+
 func TestAllZeroTaskID(t *testing.T) {
 	var md oboeMetadata
 	assert.EqualValues(t, errInvalidTaskID, md.FromString("2B0000000000000000000000000000000000000000AB2198D447EA220300"))
@@ -0,0 +1,47 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+// DecisionReason is why a trace is sampled or not.
+type DecisionReason string
+
+// The reasons of the sampling decisions
+const (
+	// DecisionSampleRate means the trace is sampled or not by the sample rate.
+	DecisionSampleRate DecisionReason = "sample-rate"
+	// DecisionBucketExhausted means the trace is sampled by the sample rate
+	// but limited by the token bucket.
+	DecisionBucketExhausted DecisionReason = "bucket-exhausted"
+	// DecisionTracingDisabled means the tracing is disabled by the settings,
+	// the tracing mode or the transaction filtering.
+	DecisionTracingDisabled DecisionReason = "tracing-disabled"
+	// DecisionUpstream means the sampled flag of the incoming trace context is
+	// honored.
+	DecisionUpstream DecisionReason = "upstream"
+	// DecisionTriggerTrace means the trace is requested by the X-Trace-Options
+	// header.
+	DecisionTriggerTrace DecisionReason = "trigger-trace"
+	// DecisionSettingsNotAvailable means no settings are received from the
+	// collector yet.
+	DecisionSettingsNotAvailable DecisionReason = "settings-not-available"
+)
+
+// Decision is the sampling decision of a trace.
+type Decision struct {
+	// Sampled is if the trace is sampled
+	Sampled bool
+	// Reason is why the trace is sampled or not
+	Reason DecisionReason
+	// SampleRate is the sample rate out of 1,000,000 the decision is made
+	// with, -1 if it's not made by the sample rate
+	SampleRate int
+}
+
+// decisionOf returns the decision reported to the applications.
+func decisionOf(d SampleDecision) Decision {
+	rate := d.rate
+	if d.reason != DecisionSampleRate && d.reason != DecisionBucketExhausted {
+		rate = -1
+	}
+	return Decision{Sampled: d.trace, Reason: d.reason, SampleRate: rate}
+}
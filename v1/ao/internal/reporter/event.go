@@ -9,6 +9,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -18,6 +22,16 @@ import (
 type event struct {
 	metadata oboeMetadata
 	bbuf     *bson.Buffer
+	// the event has been prepared by the tee reporter, so its reporters
+	// don't prepare it again
+	prepared bool
+	// when the agent started building the event, and how long it took until
+	// the event was serialized
+	buildStart time.Time
+	buildTime  time.Duration
+	// the time of the event set by TimestampKey, if it's not reported
+	// when it happens
+	timestamp time.Time
 }
 
 // Label is a required event attribute.
@@ -30,6 +44,9 @@ const (
 	LabelInfo  = "info"
 	LabelError = "error"
 	EdgeKey    = "Edge"
+	// TimestampKey is the KV whose time.Time value replaces the time the
+	// event is reported at, e.g., for the spans reported after they end.
+	TimestampKey = "Timestamp_u"
 )
 
 const (
@@ -117,7 +134,7 @@ func newTracingMode(mode config.TracingMode) tracingMode {
 	switch mode {
 	case config.DisabledTracingMode:
 		return TRACE_DISABLED
-	case config.EnabledTracingMode:
+	case config.EnabledTracingMode, config.DryRunTracingMode, config.MetricsOnlyTracingMode:
 		return TRACE_ENABLED
 	default:
 	}
@@ -154,8 +171,12 @@ func oboeEventInit(evt *event, md *oboeMetadata) error {
 		return errors.New("oboeEventInit got nil args")
 	}
 
-	// Metadata initialization
-	evt.metadata.Init()
+	// Metadata initialization, the ID buffers of a reused event are kept
+	if len(evt.metadata.ids.taskID) == oboeMaxTaskIDLen && len(evt.metadata.ids.opID) == oboeMaxOpIDLen {
+		evt.metadata.reset()
+	} else {
+		evt.metadata.Init()
+	}
 
 	evt.metadata.taskLen = md.taskLen
 	evt.metadata.opLen = md.opLen
@@ -190,6 +211,32 @@ func newEvent(md *oboeMetadata, label Label, layer string) (*event, error) {
 	return e, nil
 }
 
+// eventPool holds the events which can be reused. Only the events created and
+// reported within the same call are pooled, e.g., by oboeContext.ReportEvent,
+// as the ones returned by NewEvent may be held by the caller. The BSON buffer
+// is never reused as the reporters keep its bytes.
+var eventPool = sync.Pool{New: func() interface{} { return &event{} }}
+
+// newPooledEvent is the same as newEvent but the event is taken from the
+// pool. It must be returned by releaseEvent once reported.
+func newPooledEvent(md *oboeMetadata, label Label, layer string) (*event, error) {
+	e := eventPool.Get().(*event)
+	if err := oboeEventInit(e, md); err != nil {
+		releaseEvent(e)
+		return nil, err
+	}
+	e.addLabelLayer(label, layer)
+	return e, nil
+}
+
+// releaseEvent resets the event and puts it back to the pool.
+func releaseEvent(e *event) {
+	ids := e.metadata.ids
+	*e = event{}
+	e.metadata.ids = ids
+	eventPool.Put(e)
+}
+
 func (e *event) addLabelLayer(label Label, layer string) {
 	e.AddString("Label", string(label))
 	if layer != "" {
@@ -223,6 +270,64 @@ func (e *event) AddFloat64(key string, value float64) { e.bbuf.AppendFloat64(key
 // Adds float key/value to event
 func (e *event) AddBool(key string, value bool) { e.bbuf.AppendBool(key, value) }
 
+// Adds a string slice as a BSON array to event
+func (e *event) AddStringSlice(key string, value []string) {
+	start := e.bbuf.AppendStartArray(key)
+	for i, v := range value {
+		e.bbuf.AppendString(strconv.Itoa(i), v)
+	}
+	e.bbuf.AppendFinishObject(start)
+}
+
+// Adds an int slice as a BSON array to event
+func (e *event) AddIntSlice(key string, value []int) {
+	start := e.bbuf.AppendStartArray(key)
+	for i, v := range value {
+		e.bbuf.AppendInt(strconv.Itoa(i), v)
+	}
+	e.bbuf.AppendFinishObject(start)
+}
+
+// Adds a string map as a BSON document to event, the keys are sorted so the
+// encoding is stable.
+func (e *event) AddStringMap(key string, value map[string]string) {
+	keys := make([]string, 0, len(value))
+	for k := range value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	start := e.bbuf.AppendStartObject(key)
+	for _, k := range keys {
+		e.bbuf.AppendString(k, value[k])
+	}
+	e.bbuf.AppendFinishObject(start)
+}
+
+// Adds a time as an ISO 8601 (RFC 3339) UTC timestamp string to event
+func (e *event) AddTime(key string, value time.Time) {
+	e.bbuf.AppendString(key, value.UTC().Format(time.RFC3339Nano))
+}
+
+// Adds a duration as float64 milliseconds to event
+func (e *event) AddDuration(key string, value time.Duration) {
+	e.bbuf.AppendFloat64(key, float64(value)/float64(time.Millisecond))
+}
+
+// Adds a Blob to event, along with the markers if it's truncated
+func (e *event) AddBlob(key string, value Blob) {
+	if value.isStr {
+		e.bbuf.AppendString(key, truncateUTF8(value.str, value.limit))
+	} else if value.Truncated() {
+		e.bbuf.AppendBinary(key, value.data[:value.limit])
+	} else {
+		e.bbuf.AppendBinary(key, value.data)
+	}
+	if value.Truncated() {
+		e.bbuf.AppendBool(key+blobTruncatedSuffix, true)
+		e.bbuf.AppendInt64(key+blobOriginalSizeSuffix, int64(value.Size()))
+	}
+}
+
 // Adds edge (reference to previous event) to event
 func (e *event) AddEdge(ctx *oboeContext) {
 	e.bbuf.AppendString(EdgeKey, ctx.metadata.opString())
@@ -262,6 +367,26 @@ func (e *event) AddKV(key, value interface{}) error {
 		e.AddInt64(k, v)
 	case int32:
 		e.AddInt32(k, v)
+	case int16:
+		e.AddInt32(k, int32(v))
+	case int8:
+		e.AddInt32(k, int32(v))
+	case time.Duration: // in milliseconds
+		e.AddDuration(k, v)
+	case time.Time:
+		if k == TimestampKey {
+			e.timestamp = v
+		} else {
+			e.AddTime(k, v)
+		}
+	case []string:
+		e.AddStringSlice(k, v)
+	case []int:
+		e.AddIntSlice(k, v)
+	case map[string]string:
+		e.AddStringMap(k, v)
+	case Blob:
+		e.AddBlob(k, v)
 	case uint:
 		if v <= math.MaxInt64 {
 			e.AddInt64(k, int64(v))
@@ -272,6 +397,10 @@ func (e *event) AddKV(key, value interface{}) error {
 		}
 	case uint32:
 		e.AddInt64(k, int64(v))
+	case uint16:
+		e.AddInt32(k, int32(v))
+	case uint8:
+		e.AddInt32(k, int32(v))
 	case float32:
 		e.AddFloat32(k, v)
 	case float64:
@@ -310,6 +439,18 @@ func (e *event) AddKV(key, value interface{}) error {
 		if v != nil {
 			e.AddInt32(k, *v)
 		}
+	case *time.Duration:
+		if v != nil {
+			e.AddDuration(k, *v)
+		}
+	case *time.Time:
+		if v != nil {
+			e.AddTime(k, *v)
+		}
+	case *Blob:
+		if v != nil {
+			e.AddBlob(k, *v)
+		}
 	case *uint:
 		if v != nil {
 			if *v <= math.MaxInt64 {
@@ -5,10 +5,12 @@ package reporter
 import (
 	"math"
 	"testing"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
 )
 
 var testLayer = "go_test"
@@ -36,6 +38,7 @@ func TestSendEvent(t *testing.T) {
 	stringTest := "testStr"
 	boolTest := true
 	bufTest := []byte("testBuf")
+	durationTest := 1500 * time.Millisecond
 	badMD := "1DSF*&)DS&F#"
 
 	err = ctx.ReportEvent("info", testLayer,
@@ -59,6 +62,12 @@ func TestSendEvent(t *testing.T) {
 		"TestString", &stringTest,
 		"TestBool", &boolTest,
 		"TestBuf", &bufTest,
+		"TestInt8V", int8(-8),
+		"TestInt16V", int16(-16),
+		"TestUint8V", uint8(8),
+		"TestUint16V", uint16(16),
+		"TestDurationV", durationTest,
+		"TestDuration", &durationTest,
 		"Edge", &badMD, // should not be reported
 		"Key", // key with no value -- should be skipped/ignored
 	)
@@ -96,11 +105,47 @@ func TestSendEvent(t *testing.T) {
 			assert.Equal(t, n.Map["TestString"], stringTest)
 			assert.Equal(t, n.Map["TestBool"], boolTest)
 			assert.Equal(t, n.Map["TestBuf"], bufTest)
+			assert.EqualValues(t, -8, n.Map["TestInt8V"])
+			assert.EqualValues(t, -16, n.Map["TestInt16V"])
+			assert.EqualValues(t, 8, n.Map["TestUint8V"])
+			assert.EqualValues(t, 16, n.Map["TestUint16V"])
+			assert.Equal(t, 1500.0, n.Map["TestDurationV"])
+			assert.Equal(t, 1500.0, n.Map["TestDuration"])
 		}},
 		{"go_test", "exit"}: {Edges: g.Edges{{"go_test", "info"}}},
 	})
 }
 
+func TestEventKVEncodings(t *testing.T) {
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.FixedZone("EST", -5*3600))
+	err := ctx.reportEvent(LabelEntry, testLayer, false,
+		"TestStrings", []string{"a", "b"},
+		"TestInts", []int{1, -2, math.MaxInt32 + 1},
+		"TestEmpty", []string(nil),
+		"TestMap", map[string]string{"z": "1", "a": "2"},
+		"TestTime", ts,
+		"TestTimePtr", &ts,
+		"TestDuration", 250*time.Microsecond,
+	)
+	assert.NoError(t, err)
+
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"go_test", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, []interface{}{"a", "b"}, n.Map["TestStrings"])
+			assert.Equal(t, []interface{}{1, -2, int64(math.MaxInt32 + 1)}, n.Map["TestInts"])
+			assert.Equal(t, []interface{}{}, n.Map["TestEmpty"])
+			// the keys are sorted
+			assert.Equal(t, bson.D{{Name: "a", Value: "2"}, {Name: "z", Value: "1"}}, n.Map["TestMap"])
+			assert.Equal(t, "2021-03-04T10:06:07.89Z", n.Map["TestTime"])
+			assert.Equal(t, "2021-03-04T10:06:07.89Z", n.Map["TestTimePtr"])
+			assert.Equal(t, 0.25, n.Map["TestDuration"])
+		}},
+	})
+}
+
 func TestOboeEvent(t *testing.T) {
 	// oboe_event_init
 	evt := &event{}
@@ -117,6 +162,32 @@ func TestOboeEvent(t *testing.T) {
 	assert.Len(t, evt.MetadataString(), oboeMetadataStringLen) // event md string correct length
 }
 
+func TestPooledEvent(t *testing.T) {
+	var md oboeMetadata
+	md.Init()
+	assert.NoError(t, md.SetRandom())
+	md.flags = XTR_FLAGS_SAMPLED
+
+	e, err := newPooledEvent(&md, LabelEntry, testLayer)
+	assert.NoError(t, err)
+	e.prepared = true
+	taskID, opID := e.metadata.ids.taskID, e.metadata.ids.opID
+	releaseEvent(e)
+	// everything but the ID buffers is reset
+	assert.Equal(t, event{metadata: oboeMetadata{ids: oboeIDs{taskID, opID}}}, *e)
+
+	// a reused event is the same as a new one
+	var md2 oboeMetadata
+	md2.Init()
+	assert.NoError(t, md2.SetRandom())
+	assert.NoError(t, oboeEventInit(e, &md2))
+	assert.Equal(t, md2.ids.taskID, e.metadata.ids.taskID)
+	assert.NotEqual(t, md2.ids.opID, e.metadata.ids.opID)
+	assert.False(t, e.metadata.isSampled())
+	assert.False(t, e.prepared)
+	assert.Len(t, e.MetadataString(), oboeMetadataStringLen)
+}
+
 func TestEventMetadata(t *testing.T) {
 	r := SetTestReporter()
 
@@ -190,3 +261,24 @@ func TestTracingModeToFlags(t *testing.T) {
 	assert.Equal(t, FLAG_OK, newTracingMode(config.DisabledTracingMode).toFlags())
 	assert.Equal(t, FLAG_OK, tracingMode(100).toFlags())
 }
+
+// preparingReporter prepares the events as the real reporters do but drops
+// them, to measure the cost of building the events only.
+type preparingReporter struct{ nullReporter }
+
+func (r *preparingReporter) reportEvent(ctx *oboeContext, e *event) error {
+	return prepareEvent(ctx, e)
+}
+
+func BenchmarkReportEvent(b *testing.B) {
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+	globalReporter = &preparingReporter{}
+
+	ctx := newContext(true).(*oboeContext)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = ctx.ReportEvent(LabelInfo, testLayer, "Method", "GET", "Status", 200)
+	}
+}
@@ -5,12 +5,15 @@ package reporter
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"math"
 	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/bson"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
@@ -18,10 +21,44 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Current settings configuration
+// Current settings configuration. The settings are looked up by every request,
+// so they're kept in an immutable snapshot which is swapped atomically on
+// update and read without locking. The lock only serializes the updates.
 type oboeSettingsCfg struct {
-	settings map[oboeSettingKey]*oboeSettings
-	lock     sync.RWMutex
+	snapshot atomic.Value // settingsSnapshot
+	lock     sync.Mutex
+}
+
+// settingsSnapshot is never modified once stored in oboeSettingsCfg.
+type settingsSnapshot map[oboeSettingKey]*oboeSettings
+
+func newOboeSettingsCfg() *oboeSettingsCfg {
+	sc := &oboeSettingsCfg{}
+	sc.snapshot.Store(settingsSnapshot{})
+	return sc
+}
+
+// settings returns the current snapshot, which must not be modified.
+func (sc *oboeSettingsCfg) settings() settingsSnapshot {
+	return sc.snapshot.Load().(settingsSnapshot)
+}
+
+// update calls fn to modify a copy of the current snapshot, and swaps the
+// snapshot with the copy.
+func (sc *oboeSettingsCfg) update(fn func(ss settingsSnapshot)) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	cur := sc.settings()
+	ss := make(settingsSnapshot, len(cur)+1)
+	for k, s := range cur {
+		ss[k] = s
+	}
+	fn(ss)
+	sc.snapshot.Store(ss)
+}
+
+func (sc *oboeSettingsCfg) set(key oboeSettingKey, s *oboeSettings) {
+	sc.update(func(ss settingsSnapshot) { ss[key] = s })
 }
 
 // FlushRateCounts collects the request counters values by categories.
@@ -100,6 +137,12 @@ func (b *tokenBucket) setRateCap(rate, cap float64) {
 	}
 }
 
+func (b *tokenBucket) rateCap() (float64, float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.ratePerSec, b.capacity
+}
+
 func (b *tokenBucket) avail() float64 {
 	b.lock.Lock()
 	defer b.lock.Unlock()
@@ -113,9 +156,7 @@ type oboeSettingKey struct {
 }
 
 // Global configuration settings
-var globalSettingsCfg = &oboeSettingsCfg{
-	settings: make(map[oboeSettingKey]*oboeSettings),
-}
+var globalSettingsCfg = newOboeSettingsCfg()
 
 // The global token bucket. Trace decisions of all the requests are controlled
 // by this single bucket.
@@ -148,18 +189,113 @@ func sendInitMessage() {
 			return
 		}
 
-		// we choose to ignore the errors
-		_ = e.AddKV("__Init", 1)
-		_ = e.AddKV("Go.Version", utils.GoVersion())
-		_ = e.AddKV("Go.AppOptics.Version", utils.Version())
-		_ = e.AddKV("Go.InstallDirectory", utils.InstallDir())
-		_ = e.AddKV("Go.InstallTimestamp", utils.InstallTsInSec())
-		_ = e.AddKV("Go.LastRestart", utils.LastRestartInUSec())
-
+		e.bbuf.AppendElements(initMessageElements())
 		_ = e.ReportStatus(c)
 	}
 }
 
+// the serialized KVs of the init message, which are the same for the process
+// but the ones of the callbacks. They're rebuilt only if a callback is
+// registered, so the init message sent again, e.g., by ReloadReporter, reuses
+// them.
+var initMessageKVs struct {
+	sync.Mutex
+	gen   int // the generation of the callbacks the KVs are built with
+	elems []byte
+}
+
+// initMessageElements returns the serialized KVs of the init message.
+func initMessageElements() []byte {
+	initMessageCallbacks.RLock()
+	gen := initMessageCallbacks.gen
+	initMessageCallbacks.RUnlock()
+
+	initMessageKVs.Lock()
+	defer initMessageKVs.Unlock()
+	if initMessageKVs.elems != nil && initMessageKVs.gen == gen {
+		return initMessageKVs.elems
+	}
+
+	e := &event{bbuf: bson.NewBuffer()}
+	// we choose to ignore the errors
+	_ = e.AddKV("__Init", 1)
+	_ = e.AddKV("Go.Version", utils.GoVersion())
+	_ = e.AddKV("Go.AppOptics.Version", utils.Version())
+	_ = e.AddKV("Go.InstallDirectory", utils.InstallDir())
+	_ = e.AddKV("Go.InstallTimestamp", utils.InstallTsInSec())
+	_ = e.AddKV("Go.LastRestart", utils.LastRestartInUSec())
+	addBuildInfoKVs(e, utils.AppBuildInfo())
+	addCallbackInitKVs(e)
+
+	initMessageKVs.gen, initMessageKVs.elems = gen, e.bbuf.Elements()
+	return initMessageKVs.elems
+}
+
+// the callbacks providing the KVs of the application to the init message
+var initMessageCallbacks struct {
+	sync.RWMutex
+	fns []func() map[string]interface{}
+	gen int // incremented by each callback registered
+}
+
+// AddInitMessageCallback registers a callback to provide extra KVs to the init
+// message. As the init message is sent when the reporter is initialized, which
+// is usually before the callback is registered, the init message is sent again
+// with the KVs of all the callbacks registered so far. The callbacks are called
+// only then, and the KVs are reused by the init messages sent afterwards.
+func AddInitMessageCallback(fn func() map[string]interface{}) {
+	if fn == nil {
+		return
+	}
+	initMessageCallbacks.Lock()
+	initMessageCallbacks.fns = append(initMessageCallbacks.fns, fn)
+	initMessageCallbacks.gen++
+	initMessageCallbacks.Unlock()
+
+	sendInitMessage()
+}
+
+// addCallbackInitKVs adds the KVs provided by the callbacks to the init
+// message. The KVs reported by the agent itself, i.e., the ones prefixed with
+// "__" or "Go.", can't be overridden and are skipped.
+func addCallbackInitKVs(e *event) {
+	initMessageCallbacks.RLock()
+	fns := initMessageCallbacks.fns
+	initMessageCallbacks.RUnlock()
+
+	for _, fn := range fns {
+		for k, v := range fn() {
+			if strings.HasPrefix(k, "__") || strings.HasPrefix(k, "Go.") {
+				log.Warningf("Init message: KV %s is reserved, skipped.", k)
+				continue
+			}
+			if err := e.AddKV(k, v); err != nil {
+				log.Warningf("Init message: failed to add KV %s: %v", k, err)
+			}
+		}
+	}
+}
+
+// addBuildInfoKVs adds the build information of the application to the init
+// message. The KVs not available are skipped.
+func addBuildInfoKVs(e *event, bi utils.BuildInfo) {
+	_ = e.AddKV("Go.OS", bi.GOOS)
+	_ = e.AddKV("Go.Arch", bi.GOARCH)
+	if bi.ModulePath != "" {
+		_ = e.AddKV("Go.Module.Path", bi.ModulePath)
+	}
+	if bi.ModuleVersion != "" {
+		_ = e.AddKV("Go.Module.Version", bi.ModuleVersion)
+	}
+	if bi.VCSRevision != "" {
+		_ = e.AddKV("Go.VCS.Revision", bi.VCSRevision)
+		_ = e.AddKV("Go.VCS.Modified", bi.VCSModified)
+	}
+	if bi.VCSTime != "" {
+		_ = e.AddKV("Go.VCS.Time", bi.VCSTime)
+	}
+}
+
 func (b *tokenBucket) count(sampled, hasMetadata, rateLimit bool) bool {
 	b.RequestedInc()
 
@@ -218,6 +354,8 @@ type SampleDecision struct {
 	xTraceOptsRsp string
 	bucketCap     float64
 	bucketRate    float64
+	// why the request is traced or not
+	reason DecisionReason
 }
 
 type TriggerTraceMode int
@@ -278,11 +416,13 @@ func (tm TriggerTraceMode) Requested() bool {
 	}
 }
 
-func oboeSampleRequest(layer string, traced bool, url string, triggerTrace TriggerTraceMode) SampleDecision {
+// oboeSampleRequest makes the sampling decision of a request. The taskID is
+// only used in the trace ID sampling mode, it may be nil otherwise.
+func oboeSampleRequest(layer string, traced bool, url string, method string, triggerTrace TriggerTraceMode, taskID []byte) SampleDecision {
 	if usingTestReporter {
 		if r, ok := globalReporter.(*TestReporter); ok {
 			if !r.UseSettings {
-				return SampleDecision{r.ShouldTrace, 0, SAMPLE_SOURCE_NONE, true, ttEmpty, 0, 0} // trace tests
+				return SampleDecision{r.ShouldTrace, 0, SAMPLE_SOURCE_NONE, true, ttEmpty, 0, 0, DecisionSampleRate} // trace tests
 			}
 		}
 	}
@@ -290,13 +430,27 @@ func oboeSampleRequest(layer string, traced bool, url string, triggerTrace Trigg
 	var setting *oboeSettings
 	var ok bool
 	if setting, ok = getSetting(layer); !ok {
-		return SampleDecision{false, 0, SAMPLE_SOURCE_NONE, false, ttSettingsNotAvailable, 0, 0}
+		return SampleDecision{false, 0, SAMPLE_SOURCE_NONE, false, ttSettingsNotAvailable, 0, 0, DecisionSettingsNotAvailable}
 	}
 
 	retval := false
 	doRateLimiting := false
+	sample := shouldSample
+	if config.GetSamplingMode() == config.TraceIDSamplingMode && len(taskID) != 0 {
+		sample = func(sampleRate int) bool { return shouldSampleTraceID(taskID, sampleRate) }
+	}
 
 	sampleRate, flags, source := mergeURLSetting(setting, url)
+	sampleRate, source = mergeHTTPMethodSetting(sampleRate, source, method)
+
+	if metricsOnly() {
+		// not traced, but enabled so the metrics are still recorded
+		rsp := ttNotRequested
+		if triggerTrace.Requested() {
+			rsp = ttTracingDisabled
+		}
+		return SampleDecision{false, sampleRate, source, flags.Enabled(), rsp, 0, 0, DecisionTracingDisabled}
+	}
 
 	// Choose an appropriate bucket
 	bucket := setting.bucket
@@ -309,16 +463,19 @@ func oboeSampleRequest(layer string, traced bool, url string, triggerTrace Trigg
 	if triggerTrace.Requested() && !traced {
 		sampled := (triggerTrace != ModeInvalidTriggerTrace) && (flags.TriggerTraceEnabled())
 		rsp := ttOK
+		reason := DecisionTriggerTrace
 
 		ret := bucket.count(sampled, false, true)
 
 		if flags.TriggerTraceEnabled() && triggerTrace.Enabled() {
 			if !ret {
 				rsp = ttRateExceeded
+				reason = DecisionBucketExhausted
 			}
 		} else if triggerTrace == ModeInvalidTriggerTrace {
 			rsp = ""
 		} else {
+			reason = DecisionTracingDisabled
 			if !flags.Enabled() {
 				rsp = ttTracingDisabled
 			} else {
@@ -326,13 +483,15 @@ func oboeSampleRequest(layer string, traced bool, url string, triggerTrace Trigg
 			}
 		}
 		ttCap, ttRate := getTokenBucketSetting(setting, triggerTrace)
-		return SampleDecision{ret, -1, SAMPLE_SOURCE_UNSET, flags.Enabled(), rsp, ttRate, ttCap}
+		return SampleDecision{ret, -1, SAMPLE_SOURCE_UNSET, flags.Enabled(), rsp, ttRate, ttCap, reason}
 	}
 
+	reason := DecisionTracingDisabled
 	if !traced {
 		// A new request
 		if flags&FLAG_SAMPLE_START != 0 {
-			retval = shouldSample(sampleRate)
+			retval = sample(sampleRate)
+			reason = DecisionSampleRate
 			if retval {
 				doRateLimiting = true
 			}
@@ -341,12 +500,17 @@ func oboeSampleRequest(layer string, traced bool, url string, triggerTrace Trigg
 		// A traced request
 		if flags&FLAG_SAMPLE_THROUGH_ALWAYS != 0 {
 			retval = true
+			reason = DecisionUpstream
 		} else if flags&FLAG_SAMPLE_THROUGH != 0 {
-			retval = shouldSample(sampleRate)
+			retval = sample(sampleRate)
+			reason = DecisionSampleRate
 		}
 	}
 
 	retval = bucket.count(retval, traced, doRateLimiting)
+	if doRateLimiting && !retval {
+		reason = DecisionBucketExhausted
+	}
 
 	rsp := ttNotRequested
 	if triggerTrace.Requested() {
@@ -355,7 +519,7 @@ func oboeSampleRequest(layer string, traced bool, url string, triggerTrace Trigg
 
 	ttCap, ttRate := getTokenBucketSetting(setting, ModeTriggerTraceNotPresent)
 
-	return SampleDecision{retval, sampleRate, source, flags.Enabled(), rsp, ttCap, ttRate}
+	return SampleDecision{retval, sampleRate, source, flags.Enabled(), rsp, ttCap, ttRate, reason}
 }
 
 func getTokenBucketSetting(setting *oboeSettings, ttMode TriggerTraceMode) (float64, float64) {
@@ -469,6 +633,19 @@ func mergeURLSetting(setting *oboeSettings, url string) (int, settingFlag, sampl
 	return setting.value, flags, source
 }
 
+// mergeHTTPMethodSetting applies the per-HTTP-method sample rate, if any. The
+// lower one of the service level sample rate and the per-method one is used.
+func mergeHTTPMethodSetting(rate int, source sampleSource, method string) (int, sampleSource) {
+	if method == "" {
+		return rate, source
+	}
+	methodRate, ok := config.GetHTTPMethodSampleRate(method)
+	if !ok || methodRate >= rate {
+		return rate, source
+	}
+	return methodRate, SAMPLE_SOURCE_FILE
+}
+
 func adjustSampleRate(rate int64) int {
 	if rate < 0 {
 		log.Debugf("Invalid sample rate: %d", rate)
@@ -482,6 +659,19 @@ func adjustSampleRate(rate int64) int {
 	return int(rate)
 }
 
+// localRateCap overrides the rate and the capacity of a token bucket from the
+// remote settings with the local configuration, if any.
+func localRateCap(rate, capacity float64, local func() (float64, float64)) (float64, float64) {
+	localCap, localRate := local()
+	if localRate > 0 {
+		rate = localRate
+	}
+	if localCap > 0 {
+		capacity = localCap
+	}
+	return rate, capacity
+}
+
 func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int64, args map[string][]byte) {
 	ns := newOboeSettings()
 
@@ -497,14 +687,17 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 
 	rate := parseFloat64(args, kvBucketRate, 0)
 	capacity := parseFloat64(args, kvBucketCapacity, 0)
+	rate, capacity = localRateCap(rate, capacity, config.GetSamplingBucket)
 	ns.bucket.setRateCap(rate, capacity)
 
 	tRelaxedRate := parseFloat64(args, kvTriggerTraceRelaxedBucketRate, 0)
 	tRelaxedCapacity := parseFloat64(args, kvTriggerTraceRelaxedBucketCapacity, 0)
+	tRelaxedRate, tRelaxedCapacity = localRateCap(tRelaxedRate, tRelaxedCapacity, config.GetTriggerTraceRelaxedBucket)
 	ns.triggerTraceRelaxedBucket.setRateCap(tRelaxedRate, tRelaxedCapacity)
 
 	tStrictRate := parseFloat64(args, kvTriggerTraceStrictBucketRate, 0)
 	tStrictCapacity := parseFloat64(args, kvTriggerTraceStrictBucketCapacity, 0)
+	tStrictRate, tStrictCapacity = localRateCap(tStrictRate, tStrictCapacity, config.GetTriggerTraceStrictBucket)
 	ns.triggerTraceStrictBucket.setRateCap(tStrictRate, tStrictCapacity)
 
 	merged := mergeLocalSetting(ns)
@@ -514,19 +707,24 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 		layer: layer,
 	}
 
-	globalSettingsCfg.lock.Lock()
-	globalSettingsCfg.settings[key] = merged
-	globalSettingsCfg.lock.Unlock()
+	globalSettingsCfg.set(key, merged)
+	notifySettings(newSettings(merged, string(flags)))
 }
 
 // Used for tests only
 func resetSettings() {
 	FlushRateCounts()
 
-	globalSettingsCfg.lock.Lock()
-	defer globalSettingsCfg.lock.Unlock()
-	globalSettingsCfg.settings = make(map[oboeSettingKey]*oboeSettings)
+	globalSettingsCfg.update(func(ss settingsSnapshot) {
+		for k := range ss {
+			delete(ss, k)
+		}
+	})
 	globalTokenBucket.reset()
+
+	settingsCallbacks.Lock()
+	settingsCallbacks.last = nil
+	settingsCallbacks.Unlock()
 }
 
 // OboeCheckSettingsTimeout checks and deletes expired settings
@@ -535,28 +733,33 @@ func OboeCheckSettingsTimeout() {
 }
 
 func (sc *oboeSettingsCfg) checkSettingsTimeout() {
-	sc.lock.Lock()
-	defer sc.lock.Unlock()
-
-	ss := sc.settings
-	for k, s := range ss {
-		e := s.timestamp.Add(time.Duration(s.ttl) * time.Second)
-		if e.Before(time.Now()) {
-			delete(ss, k)
+	now := time.Now()
+	expired := func(s *oboeSettings) bool {
+		return s.timestamp.Add(time.Duration(s.ttl) * time.Second).Before(now)
+	}
+	// it's checked periodically, only swap the snapshot if needed
+	for _, s := range sc.settings() {
+		if !expired(s) {
+			continue
 		}
+		sc.update(func(ss settingsSnapshot) {
+			for k, s := range ss {
+				if expired(s) {
+					delete(ss, k)
+				}
+			}
+		})
+		return
 	}
 }
 
 func getSetting(layer string) (*oboeSettings, bool) {
-	globalSettingsCfg.lock.RLock()
-	defer globalSettingsCfg.lock.RUnlock()
-
 	// for now only look up the default settings
 	key := oboeSettingKey{
 		sType: TYPE_DEFAULT,
 		layer: "",
 	}
-	if setting, ok := globalSettingsCfg.settings[key]; ok {
+	if setting, ok := globalSettingsCfg.settings()[key]; ok {
 		return setting, true
 	}
 
@@ -564,15 +767,12 @@ func getSetting(layer string) (*oboeSettings, bool) {
 }
 
 func removeSetting(layer string) {
-	globalSettingsCfg.lock.Lock()
-	defer globalSettingsCfg.lock.Unlock()
-
 	key := oboeSettingKey{
 		sType: TYPE_DEFAULT,
 		layer: "",
 	}
 
-	delete(globalSettingsCfg.settings, key)
+	globalSettingsCfg.update(func(ss settingsSnapshot) { delete(ss, key) })
 }
 
 func hasDefaultSetting() bool {
@@ -588,6 +788,19 @@ func shouldSample(sampleRate int) bool {
 	return retval
 }
 
+// shouldSampleTraceID samples a trace if the hash of its task ID is below the
+// sample rate. All the services seeing the same task ID make the same decision
+// for the same rate, and the traces sampled at a lower rate are always sampled
+// at a higher one.
+func shouldSampleTraceID(taskID []byte, sampleRate int) bool {
+	if sampleRate >= maxSamplingRate {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write(taskID)
+	return h.Sum64()%maxSamplingRate < uint64(sampleRate)
+}
+
 func flagStringToBin(flagString string) settingFlag {
 	flags := settingFlag(0)
 	if flagString != "" {
@@ -4,6 +4,8 @@ package reporter
 
 import (
 	"os"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -42,10 +44,105 @@ func assertInitMessage(t *testing.T, bufs [][]byte) {
 			assert.True(t, strings.HasSuffix(n.Map["Go.InstallDirectory"].(string), "appoptics-apm-go/v1/ao"))
 			assert.Less(t, baseline.Unix(), n.Map["Go.InstallTimestamp"])
 			assert.Less(t, baseline.UnixNano()/1e3, n.Map["Go.LastRestart"])
+			assert.Equal(t, runtime.GOOS, n.Map["Go.OS"])
+			assert.Equal(t, runtime.GOARCH, n.Map["Go.Arch"])
 		}},
 	})
 }
 
+func TestAddBuildInfoKVs(t *testing.T) {
+	r := SetTestReporter()
+
+	c := newContext(true).(*oboeContext)
+	e, err := c.newEvent("single", "go")
+	require.NoError(t, err)
+	addBuildInfoKVs(e, utils.BuildInfo{
+		ModulePath:    "example.com/app",
+		ModuleVersion: "v1.2.3",
+		VCSRevision:   "0123456789abcdef",
+		VCSTime:       "2021-12-01T10:00:00Z",
+		VCSModified:   true,
+		GOOS:          "linux",
+		GOARCH:        "arm64",
+	})
+	require.NoError(t, e.ReportStatus(c))
+
+	e, err = c.newEvent("single", "go")
+	require.NoError(t, err)
+	addBuildInfoKVs(e, utils.BuildInfo{GOOS: "linux", GOARCH: "amd64"})
+	require.NoError(t, e.ReportStatus(c))
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeKVMap{
+		{"go", "single", "Go.Arch", "arm64"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, "linux", n.Map["Go.OS"])
+			assert.Equal(t, "example.com/app", n.Map["Go.Module.Path"])
+			assert.Equal(t, "v1.2.3", n.Map["Go.Module.Version"])
+			assert.Equal(t, "0123456789abcdef", n.Map["Go.VCS.Revision"])
+			assert.Equal(t, "2021-12-01T10:00:00Z", n.Map["Go.VCS.Time"])
+			assert.Equal(t, true, n.Map["Go.VCS.Modified"])
+		}},
+		// the KVs not available are skipped
+		{"go", "single", "Go.Arch", "amd64"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			for _, k := range []string{"Go.Module.Path", "Go.Module.Version", "Go.VCS.Revision", "Go.VCS.Time", "Go.VCS.Modified"} {
+				assert.NotContains(t, n.Map, k)
+			}
+		}},
+	})
+}
+
+func TestInitMessageCallback(t *testing.T) {
+	r := SetTestReporter()
+	defer func() { initMessageCallbacks.fns = nil }()
+
+	AddInitMessageCallback(func() map[string]interface{} {
+		return map[string]interface{}{
+			"DeploymentID": "deploy-42",
+			"FeatureFlags": "new-checkout",
+			"__Init":       2,
+			"Go.Version":   "go0",
+		}
+	})
+	AddInitMessageCallback(nil)
+	AddInitMessageCallback(func() map[string]interface{} {
+		return map[string]interface{}{"GitSHA": "0123abc"}
+	})
+
+	r.Close(2)
+	require.Len(t, r.EventBufs, 2)
+	first, last := mbson.M{}, mbson.M{}
+	require.NoError(t, mbson.Unmarshal(r.EventBufs[0], first))
+	require.NoError(t, mbson.Unmarshal(r.EventBufs[1], last))
+
+	assert.Equal(t, "deploy-42", first["DeploymentID"])
+	assert.Equal(t, "new-checkout", first["FeatureFlags"])
+	assert.NotContains(t, first, "GitSHA")
+	// the reserved KVs are not overridden
+	assert.Equal(t, 1, first["__Init"])
+	assert.Equal(t, utils.GoVersion(), first["Go.Version"])
+
+	// the init message is sent again with the KVs of all the callbacks
+	assert.Equal(t, "deploy-42", last["DeploymentID"])
+	assert.Equal(t, "0123abc", last["GitSHA"])
+}
+
+func TestInitMessageElements(t *testing.T) {
+	defer func() { initMessageCallbacks.fns = nil }()
+	calls := 0
+	AddInitMessageCallback(func() map[string]interface{} {
+		calls++
+		return map[string]interface{}{"DeploymentID": "deploy-42"}
+	})
+	elems := initMessageElements()
+	// not rebuilt until another callback is registered
+	assert.True(t, &elems[0] == &initMessageElements()[0])
+	assert.Equal(t, 1, calls)
+
+	AddInitMessageCallback(func() map[string]interface{} { return nil })
+	assert.Equal(t, 2, calls)
+	assert.False(t, &elems[0] == &initMessageElements()[0])
+}
+
 func TestInitMessageUDP(t *testing.T) {
 	assertUDPMode(t)
 
@@ -116,6 +213,40 @@ func TestTokenBucketTime(t *testing.T) {
 	assert.EqualValues(t, 3, b.available)
 }
 
+func TestLocalBucketSettings(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_SAMPLING_BUCKET_CAPACITY")
+		os.Unsetenv("APPOPTICS_SAMPLING_BUCKET_RATE")
+		os.Unsetenv("APPOPTICS_TRIGGER_TRACE_STRICT_BUCKET_CAPACITY")
+		config.Load()
+	}()
+	r := SetTestReporter(TestReporterDisableDefaultSetting(true))
+	defer r.Close(0)
+
+	os.Setenv("APPOPTICS_SAMPLING_BUCKET_CAPACITY", "200")
+	os.Setenv("APPOPTICS_SAMPLING_BUCKET_RATE", "20")
+	os.Setenv("APPOPTICS_TRIGGER_TRACE_STRICT_BUCKET_CAPACITY", "50")
+	config.Load()
+
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(8, 0.17, 6, 0.1, 4, 0.1, -1, -1, []byte("")))
+
+	setting, ok := getSetting("")
+	require.True(t, ok)
+	// the local configuration takes precedence over the remote one
+	assert.EqualValues(t, 200, setting.bucket.capacity)
+	assert.EqualValues(t, 20, setting.bucket.ratePerSec)
+	// the remote one is used if it's not configured locally
+	assert.EqualValues(t, 6, setting.triggerTraceRelaxedBucket.capacity)
+	assert.EqualValues(t, 0.1, setting.triggerTraceRelaxedBucket.ratePerSec)
+	assert.EqualValues(t, 50, setting.triggerTraceStrictBucket.capacity)
+	assert.EqualValues(t, 0.1, setting.triggerTraceStrictBucket.ratePerSec)
+
+	// the burst no longer exhausts the sampling
+	assert.Equal(t, 150, callShouldTraceRequest(150, false))
+}
+
 func testLayerCount(count int64) interface{} {
 	return mbson.D{mbson.DocElem{Name: testLayer, Value: count}}
 }
@@ -433,29 +564,35 @@ func TestSampleTokenBucket(t *testing.T) {
 // }
 
 func TestCheckSettingsTimeout(t *testing.T) {
-	sc := &oboeSettingsCfg{
-		settings: make(map[oboeSettingKey]*oboeSettings),
-	}
+	sc := newOboeSettingsCfg()
 	k1 := oboeSettingKey{
 		sType: TYPE_DEFAULT,
 		layer: "expired",
 	}
-	sc.settings[k1] = &oboeSettings{
+	sc.set(k1, &oboeSettings{
 		timestamp: time.Now().Add(-time.Second * 2),
 		ttl:       1,
-	}
+	})
 
 	k2 := oboeSettingKey{
 		sType: TYPE_DEFAULT,
 		layer: "alive",
 	}
-	sc.settings[k2] = &oboeSettings{
+	sc.set(k2, &oboeSettings{
 		timestamp: time.Now(),
 		ttl:       2,
-	}
+	})
+	alive := sc.settings()
+	sc.checkSettingsTimeout()
+	assert.Contains(t, sc.settings(), k2, k2.layer)
+	assert.NotContains(t, sc.settings(), k1, k1.layer)
+	// the snapshot read before is not modified
+	assert.Contains(t, alive, k1, k1.layer)
+
+	// nothing expired, the snapshot is kept
+	alive = sc.settings()
 	sc.checkSettingsTimeout()
-	assert.Contains(t, sc.settings, k2, k2.layer)
-	assert.NotContains(t, sc.settings, k1, k1.layer)
+	assert.Equal(t, reflect.ValueOf(alive).Pointer(), reflect.ValueOf(sc.settings()).Pointer())
 }
 
 func TestMergeRemoteSettingWithLocalConfig(t *testing.T) {
@@ -580,3 +717,120 @@ func TestParseFloat64(t *testing.T) {
 	}
 	assert.EqualValues(t, 1.01, parseFloat64(args, "key", 1.01))
 }
+
+func TestMergeHTTPMethodSetting(t *testing.T) {
+	_ = os.Unsetenv("APPOPTICS_TRACING_MODE")
+	_ = os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	_ = os.Setenv("APPOPTICS_HTTP_METHOD_SAMPLE_RATES", "OPTIONS:0,HEAD:10000")
+	_ = config.Load()
+	defer func() {
+		_ = os.Unsetenv("APPOPTICS_HTTP_METHOD_SAMPLE_RATES")
+		_ = config.Load()
+	}()
+
+	rate, source := mergeHTTPMethodSetting(1000000, SAMPLE_SOURCE_DEFAULT, "")
+	assert.Equal(t, 1000000, rate)
+	assert.Equal(t, SAMPLE_SOURCE_DEFAULT, source)
+
+	rate, source = mergeHTTPMethodSetting(1000000, SAMPLE_SOURCE_DEFAULT, "GET")
+	assert.Equal(t, 1000000, rate)
+	assert.Equal(t, SAMPLE_SOURCE_DEFAULT, source)
+
+	rate, source = mergeHTTPMethodSetting(1000000, SAMPLE_SOURCE_DEFAULT, "HEAD")
+	assert.Equal(t, 10000, rate)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+
+	// the lower service level rate wins
+	rate, source = mergeHTTPMethodSetting(1000, SAMPLE_SOURCE_DEFAULT, "HEAD")
+	assert.Equal(t, 1000, rate)
+	assert.Equal(t, SAMPLE_SOURCE_DEFAULT, source)
+
+	resetSettings()
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(1000000, 1000000, 1000000, 1000000, 1000000, 1000000, -1, -1, []byte("")))
+	decision := shouldTraceHTTPRequest(testLayer, false, "/cors", "OPTIONS", ModeTriggerTraceNotPresent, nil)
+	assert.False(t, decision.trace)
+	assert.True(t, decision.enabled)
+	assert.Equal(t, 0, decision.rate)
+	decision = shouldTraceHTTPRequest(testLayer, false, "/cors", "GET", ModeTriggerTraceNotPresent, nil)
+	assert.True(t, decision.trace)
+}
+
+func TestShouldSampleTraceID(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		md := &oboeMetadata{}
+		md.Init()
+		assert.NoError(t, md.SetRandom())
+		id := md.ids.taskID
+		low := shouldSampleTraceID(id, 100000)
+		// the same ID always gets the same decision
+		assert.Equal(t, low, shouldSampleTraceID(id, 100000))
+		// and it's sampled at any higher rate
+		if low {
+			sampled++
+			assert.True(t, shouldSampleTraceID(id, 500000))
+		}
+		assert.True(t, shouldSampleTraceID(id, maxSamplingRate))
+		assert.False(t, shouldSampleTraceID(id, 0))
+	}
+	// about 10% of them
+	assert.InDelta(t, 1000, sampled, 200)
+}
+
+func TestTraceIDSamplingMode(t *testing.T) {
+	_ = os.Setenv("APPOPTICS_SAMPLING_MODE", "traceid")
+	_ = config.Load()
+	defer func() {
+		_ = os.Unsetenv("APPOPTICS_SAMPLING_MODE")
+		_ = config.Load()
+		resetSettings()
+	}()
+	resetSettings()
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH"),
+		500000, 120, argsToMap(1000000, 1000000, 1000000, 1000000, 1000000, 1000000, -1, -1, []byte("")))
+
+	for i := 0; i < 100; i++ {
+		md := &oboeMetadata{}
+		md.Init()
+		assert.NoError(t, md.SetRandom())
+		id := md.ids.taskID
+		expected := shouldSampleTraceID(id, 500000)
+		// the entry service and the downstream ones make the same decision
+		assert.Equal(t, expected, shouldTraceHTTPRequest(testLayer, false, "", "GET", ModeTriggerTraceNotPresent, id).trace)
+		assert.Equal(t, expected, shouldTraceHTTPRequest(testLayer, true, "", "GET", ModeTriggerTraceNotPresent, id).trace)
+	}
+}
+
+func BenchmarkGetSettingParallel(b *testing.B) {
+	resetSettings()
+	defer resetSettings()
+	updateSetting(int32(TYPE_DEFAULT), "", []byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(1000000, 1000000, 1000000, 1000000, 1000000, 1000000, -1, -1, []byte("")))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = getSetting("")
+		}
+	})
+}
+
+func TestSampleDecisionReason(t *testing.T) {
+	r := SetTestReporter(TestReporterDisableDefaultSetting(true))
+	defer r.Close(0)
+
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(1, 0.001, 0, 0, 0, 0, -1, -1, []byte("")))
+
+	d := decisionOf(shouldTraceHTTPRequest(testLayer, false, "", "GET", ModeTriggerTraceNotPresent, nil))
+	assert.Equal(t, Decision{Sampled: true, Reason: DecisionSampleRate, SampleRate: 1000000}, d)
+	d = decisionOf(shouldTraceHTTPRequest(testLayer, false, "", "GET", ModeTriggerTraceNotPresent, nil))
+	assert.Equal(t, Decision{Sampled: false, Reason: DecisionBucketExhausted, SampleRate: 1000000}, d)
+	// the trigger traces are disabled without the TRIGGER_TRACE flag
+	d = decisionOf(shouldTraceHTTPRequest(testLayer, false, "", "GET", ModeStrictTriggerTrace, nil))
+	assert.Equal(t, Decision{Sampled: false, Reason: DecisionTracingDisabled, SampleRate: -1}, d)
+}
@@ -108,23 +108,46 @@ func initReporter() {
 	setGlobalReporter(rt)
 }
 
+// ReloadReporter reloads the configuration and replaces the global reporter
+// with a new one of the configured type, which sends the init message again.
+// It's used by the end-to-end tests to point the agent to a mock collector.
+func ReloadReporter() {
+	config.Load()
+	resetSettings()
+	initReporter()
+	sendInitMessage()
+}
+
 func setGlobalReporter(reporterType string) {
 	// Close the previous reporter
 	if globalReporter != nil {
 		globalReporter.ShutdownNow()
 	}
 
+	if types := strings.Split(reporterType, ","); len(types) > 1 {
+		globalReporter = newTeeReporter(types)
+		return
+	}
+	globalReporter = newReporter(reporterType)
+}
+
+func newReporter(reporterType string) reporter {
+	if f, ok := registeredExporter(strings.ToLower(reporterType)); ok {
+		return newCustomReporter(strings.ToLower(reporterType), f)
+	}
 	switch strings.ToLower(reporterType) {
 	case "ssl":
 		fallthrough // using fallthrough since the SSL reporter (gRPC) is our default reporter
 	default:
-		globalReporter = newGRPCReporter()
+		return newGRPCReporter()
 	case "udp":
-		globalReporter = udpNewReporter()
+		return udpNewReporter()
 	case "none":
-		globalReporter = newNullReporter()
+		return newNullReporter()
 	case "serverless":
-		globalReporter = newServerlessReporter(os.Stderr)
+		return newServerlessReporter(os.Stderr)
+	case "jaeger":
+		return newJaegerReporter()
 	}
 }
 
@@ -151,6 +174,18 @@ func Closed() bool {
 	return globalReporter.Closed()
 }
 
+// dryRun returns if the messages should be built but not sent, as the tracing
+// mode is dry-run.
+func dryRun() bool {
+	return config.GetTracingMode() == config.DryRunTracingMode
+}
+
+// metricsOnly returns if the requests should never be traced while their
+// metrics are still recorded, as the tracing mode is metrics-only.
+func metricsOnly() bool {
+	return config.GetTracingMode() == config.MetricsOnlyTracingMode
+}
+
 // ReportSpan is called from the app when a span message is available
 // span	span message to be put on the channel
 //
@@ -168,6 +203,9 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 	if ctx == nil || e == nil {
 		return errors.New("invalid context, event")
 	}
+	if e.prepared { // by the tee reporter for its reporters
+		return nil
+	}
 
 	// The context metadata must have the same task_id as the event.
 	if !bytes.Equal(ctx.metadata.ids.taskID, e.metadata.ids.taskID) {
@@ -179,8 +217,14 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 		return errors.New("invalid event, same as context")
 	}
 
-	us := time.Now().UnixNano() / 1000
-	e.AddInt64("Timestamp_u", us)
+	ns := time.Now().UnixNano()
+	if !e.timestamp.IsZero() {
+		ns = e.timestamp.UnixNano()
+	}
+	e.AddInt64(TimestampKey, ns/1000)
+	if config.GetTimestampPrecision() == config.TimestampPrecisionNano {
+		e.AddInt64("Timestamp_ns", ns)
+	}
 
 	e.AddString("Hostname", host.Hostname())
 	e.AddInt("PID", host.PID())
@@ -189,11 +233,21 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 	ctx.metadata.ids.setOpID(e.metadata.ids.opID)
 
 	e.bbuf.Finish()
+	if !e.buildStart.IsZero() {
+		e.buildTime = time.Since(e.buildStart)
+		ctx.addOverhead(e.buildTime)
+	}
 	return nil
 }
 
 func shouldTraceRequestWithURL(layer string, traced bool, url string, triggerTrace TriggerTraceMode) SampleDecision {
-	return oboeSampleRequest(layer, traced, url, triggerTrace)
+	return oboeSampleRequest(layer, traced, url, "", triggerTrace, nil)
+}
+
+// Determines if the HTTP request should be traced, based on sample rate settings,
+// the URL, the HTTP method and the task ID.
+func shouldTraceHTTPRequest(layer string, traced bool, url string, method string, triggerTrace TriggerTraceMode, taskID []byte) SampleDecision {
+	return oboeSampleRequest(layer, traced, url, method, triggerTrace, taskID)
 }
 
 // Determines if request should be traced, based on sample rate settings.
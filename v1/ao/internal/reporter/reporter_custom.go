@@ -0,0 +1,167 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Exporter is a reporter implemented by a third party, which is registered by
+// Register and selected by its name in APPOPTICS_REPORTER.
+type Exporter interface {
+	// ExportEvent is called with the KVs of each event reported. The event
+	// must not be retained after it returns.
+	ExportEvent(event map[string]interface{}) error
+	// Shutdown flushes the events buffered, if any, and closes the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// ExporterFactory creates the Exporter when the reporter is initialized.
+type ExporterFactory func() (Exporter, error)
+
+var exporters = struct {
+	sync.RWMutex
+	m map[string]ExporterFactory
+}{m: make(map[string]ExporterFactory)}
+
+// Register registers the factory of a custom exporter, which is used as the
+// reporter if its name is in APPOPTICS_REPORTER, either alone or in a
+// comma-separated list of the reporters. The names are case-insensitive and
+// the built-in ones are reserved.
+//
+// The reporter is initialized before the init functions of the packages using
+// the agent are run, so it's replaced once its exporter is registered. Register
+// should be called from an init function, before any trace is started.
+func Register(name string, factory ExporterFactory) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || strings.Contains(name, ",") {
+		return fmt.Errorf("invalid exporter name: %q", name)
+	}
+	if factory == nil {
+		return fmt.Errorf("nil factory of exporter %s", name)
+	}
+	if config.IsBuiltinReporterType(name) {
+		return fmt.Errorf("exporter name %s is reserved", name)
+	}
+
+	exporters.Lock()
+	if _, ok := exporters.m[name]; ok {
+		exporters.Unlock()
+		return fmt.Errorf("exporter %s is registered already", name)
+	}
+	exporters.m[name] = factory
+	exporters.Unlock()
+
+	config.RegisterReporterType(name)
+	if config.RecheckReporterType() && !config.GetDisabled() {
+		setGlobalReporter(config.GetReporterType())
+	}
+	return nil
+}
+
+func registeredExporter(name string) (ExporterFactory, bool) {
+	exporters.RLock()
+	defer exporters.RUnlock()
+	f, ok := exporters.m[name]
+	return f, ok
+}
+
+// customReporter passes the events to an Exporter. The status messages, the
+// custom metrics and the span messages are discarded.
+type customReporter struct {
+	name     string
+	exporter Exporter
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newCustomReporter(name string, factory ExporterFactory) reporter {
+	e, err := factory()
+	if err != nil || e == nil {
+		log.Errorf("Failed to create the exporter %s, the events are discarded: %v", name, err)
+		return newNullReporter()
+	}
+
+	// add default setting
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(16, 8, 16, 8, 16, 8, -1, -1, []byte("")))
+
+	log.Warningf("The %s reporter (v%v, go%v) is initialized.", name, utils.Version(), utils.GoVersion())
+	return &customReporter{name: name, exporter: e, done: make(chan struct{})}
+}
+
+// called when an event should be reported
+func (r *customReporter) reportEvent(ctx *oboeContext, e *event) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+	if err := prepareEvent(ctx, e); err != nil {
+		// don't continue if preparation failed
+		return err
+	}
+	doc := make(bson.M)
+	if err := bson.Unmarshal(e.bbuf.GetBuf(), &doc); err != nil {
+		return err
+	}
+	return r.exporter.ExportEvent(doc)
+}
+
+// called when a status (e.g. __Init message) should be reported
+func (r *customReporter) reportStatus(ctx *oboeContext, e *event) error { return nil }
+
+// called when a Span message should be reported
+func (r *customReporter) reportSpan(span metrics.SpanMessage) error { return nil }
+
+// Shutdown closes the reporter after the exporter is shut down.
+func (r *customReporter) Shutdown(ctx context.Context) error {
+	err := ErrReporterIsClosed
+	r.closeOnce.Do(func() {
+		close(r.done)
+		err = r.exporter.Shutdown(ctx)
+	})
+	return err
+}
+
+// ShutdownNow closes the reporter immediately, the exporter is shut down with
+// a cancelled context.
+func (r *customReporter) ShutdownNow() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return r.Shutdown(ctx)
+}
+
+// Closed returns if the reporter is already closed.
+func (r *customReporter) Closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForReady waits until the reporter becomes ready or the context is canceled.
+func (r *customReporter) WaitForReady(context.Context) bool { return true }
+
+func (r *customReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
+	return nil
+}
+
+func (r *customReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	return nil
+}
+
+func (r *customReporter) Flush() error { return nil }
+
+func (r *customReporter) SetServiceKey(string) {}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureExporter struct {
+	events   []map[string]interface{}
+	shutdown bool
+}
+
+func (e *captureExporter) ExportEvent(event map[string]interface{}) error {
+	e.events = append(e.events, event)
+	return nil
+}
+
+func (e *captureExporter) Shutdown(ctx context.Context) error {
+	e.shutdown = true
+	return nil
+}
+
+func TestRegisterExporter(t *testing.T) {
+	e := &captureExporter{}
+	factory := func() (Exporter, error) { return e, nil }
+	assert.Error(t, Register("", factory))
+	assert.Error(t, Register("a,b", factory))
+	assert.Error(t, Register("Jaeger", factory))
+	assert.Error(t, Register("capture", nil))
+
+	os.Setenv("APPOPTICS_REPORTER", "capture")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORTER")
+		config.Load()
+	}()
+	// discarded until it's registered
+	assert.Equal(t, "ssl", config.GetReporterType())
+
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+	globalReporter = newNullReporter()
+
+	require.NoError(t, Register("Capture", factory))
+	assert.Error(t, Register("capture", factory))
+	assert.Equal(t, "capture", config.GetReporterType())
+	assert.True(t, config.IsValidReporterType("ssl,capture"))
+	r, ok := globalReporter.(*customReporter)
+	require.True(t, ok)
+
+	ctx := newTestContext(t)
+	require.NoError(t, ctx.reportEvent(LabelEntry, "root", false, "URL", "/hello"))
+	require.Len(t, e.events, 1)
+	assert.Equal(t, "root", e.events[0]["Layer"])
+	assert.Equal(t, "entry", e.events[0]["Label"])
+	assert.Equal(t, "/hello", e.events[0]["URL"])
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.True(t, e.shutdown)
+	assert.True(t, r.Closed())
+	assert.Equal(t, ErrReporterIsClosed, ctx.ReportEvent(LabelInfo, "root"))
+	assert.Equal(t, ErrReporterIsClosed, r.ShutdownNow())
+}
+
+func TestCustomReporterFactoryError(t *testing.T) {
+	r := newCustomReporter("broken", func() (Exporter, error) {
+		return nil, errors.New("no endpoint")
+	})
+	assert.IsType(t, &nullReporter{}, r)
+}
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -24,7 +25,7 @@ import (
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/encoding/gzip"
+	_ "google.golang.org/grpc/encoding/gzip" // register the gzip compressor
 
 	"context"
 
@@ -83,6 +84,7 @@ ftgwcxyEq5SkiR+6BCwdzAMqADV37TzXDHLjwSrMIrgLV5xZM20Kk6chxI5QAr/f
 	grpcRedirectMax                         = 20               // max allowed collector redirects
 	grpcRetryLogThreshold                   = 10               // log prints after this number of retries (about 56.7s)
 	grpcMaxRetries                          = 20               // The message will be dropped after this number of retries
+	grpcSpanAggregatorsMax                  = 4                // max number of the goroutines aggregating the span messages
 )
 
 type reporterChannel int
@@ -107,6 +109,7 @@ type grpcConnection struct {
 
 	proxy            string
 	proxyTLSCertPath string
+	compression      string
 
 	// atomicActive indicates if the underlying connection is active. It should
 	// be reconnected or redirected to a new address in case of inactive. The
@@ -154,6 +157,13 @@ func WithMaxReqBytes(size int64) GrpcConnOpt {
 	}
 }
 
+// WithCompression sets the compression of the requests, either gzip or none
+func WithCompression(compression string) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.compression = compression
+	}
+}
+
 // WithDialer returns a function that sets the Dialer option
 func WithDialer(d Dialer) GrpcConnOpt {
 	return func(c *grpcConnection) {
@@ -175,6 +185,7 @@ func newGrpcConnection(name string, target string, opts ...GrpcConnOpt) (*grpcCo
 		connection:  nil,
 		address:     target,
 		certificate: []byte(grpcCertDefault),
+		compression: config.CompressionGzip,
 		pingTicker:  time.NewTimer(time.Duration(grpcPingIntervalDefault) * time.Second),
 		queueStats:  &metrics.EventQueueStats{},
 		backoff:     DefaultBackoff,
@@ -219,6 +230,7 @@ type grpcReporter struct {
 
 	httpMetrics   *metrics.Measurements
 	customMetrics *metrics.Measurements
+	clientMetrics *metrics.Measurements // the metrics of the queries and the cache requests
 
 	// The reporter is considered ready if there is a valid default setting for sampling.
 	// It should be accessed atomically.
@@ -272,6 +284,7 @@ func newGRPCReporter() reporter {
 	}
 
 	opts = append(opts, WithMaxReqBytes(config.ReporterOpts().GetMaxReqBytes()))
+	opts = append(opts, WithCompression(config.GetCompression()))
 
 	if proxy := getProxy(); proxy != "" {
 		opts = append(opts, WithProxy(proxy))
@@ -299,11 +312,13 @@ func newGRPCReporter() reporter {
 		statusMessages: make(chan []byte, 100),
 		httpMetrics:    metrics.NewMeasurements(false, grpcMetricIntervalDefault, 200),
 		customMetrics:  metrics.NewMeasurements(true, grpcMetricIntervalDefault, 500), // TODO configurable
+		clientMetrics:  metrics.NewMeasurements(false, grpcMetricIntervalDefault, 1000),
 
 		cond: sync.NewCond(&sync.Mutex{}),
 		done: make(chan struct{}),
 	}
 
+	r.restoreSettings()
 	r.start()
 
 	log.Warningf("The reporter (%v, v%v, go%v) is initialized. Waiting for the dynamic settings.",
@@ -348,9 +363,16 @@ func (r *grpcReporter) start() {
 		go r.periodicTasks()
 	}
 
-	// start up long-running goroutine spanMessageAggregator() which listens on the span message
-	// channel and processes incoming span messages
-	go r.spanMessageAggregator()
+	// start up long-running goroutines spanMessageAggregator() which listen on the span message
+	// channel and process incoming span messages. The metrics are sharded so they can
+	// aggregate concurrently.
+	aggregators := runtime.GOMAXPROCS(0)
+	if aggregators > grpcSpanAggregatorsMax {
+		aggregators = grpcSpanAggregatorsMax
+	}
+	for i := 0; i < aggregators; i++ {
+		go r.spanMessageAggregator()
+	}
 }
 
 // ShutdownNow stops the reporter immediately.
@@ -495,6 +517,7 @@ func (c *grpcConnection) connect() error {
 		Address:       c.address,
 		Proxy:         c.proxy,
 		ProxyCertPath: c.proxyTLSCertPath,
+		Compression:   c.compression,
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed to connect to target")
@@ -503,6 +526,7 @@ func (c *grpcConnection) connect() error {
 	// close the old connection
 	if c.connection != nil {
 		c.connection.Close()
+		atomic.AddInt64(&agentCounters.reconnects, 1)
 	}
 	// set new connection (need to be protected)
 	c.connection = conn
@@ -632,6 +656,46 @@ func DefaultBackoff(retries int, wait func(d time.Duration)) error {
 	return nil
 }
 
+// the bytes sent to the collector since the start, by the message types. All
+// the fields are supposed to be accessed through atomic operations.
+var bytesSent struct {
+	events, metrics, status int64
+}
+
+// Stats is the number of bytes of the messages sent to the collector, before
+// compression.
+type Stats struct {
+	EventBytes  int64
+	MetricBytes int64
+	StatusBytes int64
+}
+
+// GetStats returns the bytes sent to the collector since the start.
+func GetStats() Stats {
+	return Stats{
+		EventBytes:  atomic.LoadInt64(&bytesSent.events),
+		MetricBytes: atomic.LoadInt64(&bytesSent.metrics),
+		StatusBytes: atomic.LoadInt64(&bytesSent.status),
+	}
+}
+
+// addBytesSent counts the bytes of the messages successfully sent by the
+// method, both in the total and the stats of the current metrics interval.
+func (c *grpcConnection) addBytesSent(m Method) {
+	n := m.RequestSize()
+	switch m.(type) {
+	case *PostEventsMethod:
+		atomic.AddInt64(&bytesSent.events, n)
+		c.queueStats.EventBytesAdd(n)
+	case *PostMetricsMethod:
+		atomic.AddInt64(&bytesSent.metrics, n)
+		c.queueStats.MetricBytesAdd(n)
+	case *PostStatusMethod:
+		atomic.AddInt64(&bytesSent.status, n)
+		c.queueStats.StatusBytesAdd(n)
+	}
+}
+
 // ================================ Event Handling ====================================
 
 // prepares the given event and puts it on the channel so it can be consumed by the
@@ -648,13 +712,16 @@ func (r *grpcReporter) reportEvent(ctx *oboeContext, e *event) error {
 		// don't continue if preparation failed
 		return err
 	}
+	r.conn.queueStats.BuildTimeAdd(e.buildTime)
 
 	select {
 	case r.eventMessages <- (*e).bbuf.GetBuf():
 		r.conn.queueStats.TotalEventsAdd(int64(1))
+		atomic.AddInt64(&agentCounters.eventsQueued, 1)
 		return nil
 	default:
 		r.conn.queueStats.NumOverflowedAdd(int64(1))
+		atomic.AddInt64(&agentCounters.eventsDropped, 1)
 		return errors.New("event message queue is full")
 	}
 }
@@ -678,8 +745,8 @@ func (r *grpcReporter) eventSender() {
 		hwm = 0
 	}
 
-	// This event bucket is drainable either after it reaches HWM, or the flush
-	// interval has passed.
+	// This event bucket is drainable either after it reaches HWM, the flush
+	// interval has passed, or the oldest event reaches the maximum age.
 	evtBucket := NewBytesBucket(r.eventMessages,
 		WithHWM(hwm),
 		WithGracefulShutdown(r.isGracefully()),
@@ -687,6 +754,7 @@ func (r *grpcReporter) eventSender() {
 		WithIntervalGetter(func() time.Duration {
 			return time.Second * time.Duration(opts.GetEventFlushInterval())
 		}),
+		WithMaxAgeGetter(opts.GetEventMaxAge),
 	)
 
 	for {
@@ -713,6 +781,7 @@ func (r *grpcReporter) eventSender() {
 				log.Debugf("Pushed %d events to the sender.", c)
 			}
 
+			r.conn.queueStats.QueueWaitAdd(evtBucket.Wait())
 			batches <- evtBucket.Drain()
 		}
 
@@ -762,6 +831,7 @@ func (r *grpcReporter) eventBatchSender(batches <-chan [][]byte) {
 				r.ShutdownNow()
 			case nil:
 				log.Info(method.CallSummary())
+			case errDryRun:
 			default:
 				log.Warningf("eventBatchSender: %s", err)
 			}
@@ -795,7 +865,8 @@ func (r *grpcReporter) collectMetrics(collectReady chan bool) {
 	var messages [][]byte
 	// generate a new metrics message
 	builtin := metrics.BuildBuiltinMetricsMessage(r.httpMetrics.CopyAndReset(i),
-		r.conn.queueStats.CopyAndReset(), FlushRateCounts(), config.GetRuntimeMetrics())
+		r.conn.queueStats.CopyAndReset(), FlushRateCounts(), config.GetRuntimeMetrics(),
+		config.GetHostMetrics(), config.GetContainerMetrics())
 	if builtin != nil {
 		messages = append(messages, builtin)
 	}
@@ -805,6 +876,11 @@ func (r *grpcReporter) collectMetrics(collectReady chan bool) {
 		messages = append(messages, custom)
 	}
 
+	client := metrics.BuildMessage(r.clientMetrics.CopyAndReset(i), false)
+	if client != nil {
+		messages = append(messages, client)
+	}
+
 	r.sendMetrics(messages)
 }
 
@@ -824,6 +900,7 @@ func (r *grpcReporter) sendMetrics(msgs [][]byte) {
 		r.ShutdownNow()
 	case nil:
 		log.Info(method.CallSummary())
+	case errDryRun:
 	default:
 		log.Warningf("sendMetrics: %s", err)
 	}
@@ -862,6 +939,8 @@ func (r *grpcReporter) getSettings(ready chan bool) {
 		}
 		logger(method.CallSummary())
 		r.updateSettings(method.Resp)
+		r.saveSettings(method.Resp)
+		atomic.StoreInt64(&agentCounters.settingsUpdated, time.Now().UnixNano())
 	default:
 		log.Infof("getSettings: %s", err)
 	}
@@ -971,6 +1050,7 @@ func (r *grpcReporter) statusSender() {
 			r.ShutdownNow()
 		case nil:
 			log.Info(method.CallSummary())
+		case errDryRun:
 		default:
 			log.Infof("statusSender: %s", err)
 		}
@@ -1004,7 +1084,15 @@ func (r *grpcReporter) spanMessageAggregator() {
 	for {
 		select {
 		case span := <-r.spanMessages:
-			span.Process(r.httpMetrics)
+			// the metrics of the queries and the cache requests are
+			// limited separately, so they don't take the place of the
+			// transactions
+			switch span.(type) {
+			case *metrics.QuerySpanMessage, *metrics.CacheSpanMessage:
+				span.Process(r.clientMetrics)
+			default:
+				span.Process(r.httpMetrics)
+			}
 		case <-r.done:
 			return
 		}
@@ -1063,6 +1151,9 @@ var (
 	// when an RPC call is timeout.
 	errConnStale     = errors.New("connection is stale")
 	errRequestTooBig = errors.New("RPC request is too big")
+
+	// errDryRun means the message is not sent as the tracing mode is dry-run.
+	errDryRun = errors.New("not sent in dry-run mode")
 )
 
 // InvokeRPC makes an RPC call and returns an error if something is broken and
@@ -1085,6 +1176,16 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 
 	printRPCMsg(m)
 
+	// the settings are still retrieved and the connection kept alive
+	switch m.(type) {
+	case *PostEventsMethod, *PostMetricsMethod, *PostStatusMethod:
+		if dryRun() {
+			log.Infof("[%s] dry-run: %s is not sent, messages=%d, bytes=%d.",
+				c.name, m, m.MessageLen(), m.RequestSize())
+			return errDryRun
+		}
+	}
+
 	for {
 		// Fail-fast in case the reporter has been closed, avoid retrying in
 		// this case.
@@ -1148,14 +1249,18 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			switch result, _ := m.ResultCode(); result {
 			case collector.ResultCode_OK:
 				c.queueStats.NumSentAdd(m.MessageLen())
+				c.addBytesSent(m)
+				c.addMessagesSent(m)
 				return nil
 
 			case collector.ResultCode_TRY_LATER:
 				log.Info(m.CallSummary())
 				c.queueStats.NumFailedAdd(m.MessageLen())
+				c.addMessagesFailed(m)
 			case collector.ResultCode_LIMIT_EXCEEDED:
 				log.Info(m.CallSummary())
 				c.queueStats.NumFailedAdd(m.MessageLen())
+				c.addMessagesFailed(m)
 			case collector.ResultCode_INVALID_API_KEY:
 				log.Error(m.CallSummary())
 				return errInvalidServiceKey
@@ -1261,6 +1366,7 @@ type DialParams struct {
 	Address       string
 	Proxy         string
 	ProxyCertPath string
+	Compression   string
 }
 
 // DefaultDialer implements the Dialer interface to provide the default dialing
@@ -1291,7 +1397,9 @@ func (d *DefaultDialer) Dial(p DialParams) (*grpc.ClientConn, error) {
 
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(creds),
-		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
+	}
+	if p.Compression != config.CompressionNone {
+		opts = append(opts, grpc.WithUnaryInterceptor(compressionInterceptor(p.Compression)))
 	}
 
 	if p.Proxy != "" {
@@ -1301,6 +1409,34 @@ func (d *DefaultDialer) Dial(p DialParams) (*grpc.ClientConn, error) {
 	return grpc.Dial(p.Address, opts...)
 }
 
+// compressionInterceptor compresses the requests with the compressor, i.e.,
+// gzip or zstd. If the collector can't decompress them, the request is sent
+// again uncompressed, and so are all the following requests of the
+// connection.
+func compressionInterceptor(compressor string) grpc.UnaryClientInterceptor {
+	var unsupported int32
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if atomic.LoadInt32(&unsupported) == 0 {
+			err := invoker(ctx, method, req, reply, cc, append(opts, grpc.UseCompressor(compressor))...)
+			if !isCompressionUnsupported(err) {
+				return err
+			}
+			atomic.StoreInt32(&unsupported, 1)
+			log.Warningf("The collector doesn't support %s, sending the requests uncompressed: %v", compressor, err)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// isCompressionUnsupported checks if the error is returned by a gRPC server
+// without the decompressor of the request, e.g.,
+//   grpc: Decompressor is not installed for grpc-encoding "gzip"
+func isCompressionUnsupported(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unimplemented && strings.Contains(s.Message(), "grpc-encoding")
+}
+
 func newGRPCProxyDialer(p DialParams) func(context.Context, string) (net.Conn, error) {
 	return func(ctx context.Context, addr string) (conn net.Conn, err error) {
 		defer func() {
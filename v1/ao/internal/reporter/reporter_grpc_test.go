@@ -23,7 +23,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -231,3 +233,38 @@ func forward(dst io.WriteCloser, src io.ReadCloser) {
 	}()
 	io.Copy(dst, src)
 }
+
+func TestCompressionInterceptor(t *testing.T) {
+	var compressors []string
+	supported := true
+	invoker := func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		compressor := ""
+		for _, o := range opts {
+			if c, ok := o.(grpc.CompressorCallOption); ok {
+				compressor = c.CompressorType
+			}
+		}
+		compressors = append(compressors, compressor)
+		if compressor != "" && !supported {
+			return status.Error(codes.Unimplemented,
+				`grpc: Decompressor is not installed for grpc-encoding "gzip"`)
+		}
+		return nil
+	}
+
+	intercept := compressionInterceptor("gzip")
+	assert.NoError(t, intercept(context.Background(), "PostEvents", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"gzip"}, compressors)
+
+	// falls back to uncompressed requests
+	supported = false
+	assert.NoError(t, intercept(context.Background(), "PostEvents", nil, nil, nil, invoker))
+	assert.NoError(t, intercept(context.Background(), "PostEvents", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"gzip", "gzip", "", ""}, compressors)
+
+	// the other errors are returned as is
+	err := status.Error(codes.Unimplemented, "unknown method")
+	assert.False(t, isCompressionUnsupported(err))
+	assert.False(t, isCompressionUnsupported(nil))
+}
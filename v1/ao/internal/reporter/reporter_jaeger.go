@@ -0,0 +1,556 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	jaegerFlushInterval = time.Second
+	jaegerBatchSize     = 512
+	// the maximum number of the events of unfinished spans kept in memory
+	jaegerMaxOpenEvents = 10000
+	// the unfinished spans older than it are dropped, e.g., the ones whose
+	// exit events are lost or never reported
+	jaegerOpenSpanTTL = 10 * time.Minute
+)
+
+// the OTLP span kinds and status codes
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpSpanKindProducer = 4
+	otlpSpanKindConsumer = 5
+	otlpStatusCodeOk     = 1
+	otlpStatusCodeError  = 2
+)
+
+// the OTLP span kinds of the SpanKind KVs
+var otlpSpanKinds = map[string]int{
+	"internal": otlpSpanKindInternal,
+	"server":   otlpSpanKindServer,
+	"client":   otlpSpanKindClient,
+	"producer": otlpSpanKindProducer,
+	"consumer": otlpSpanKindConsumer,
+}
+
+// the KVs of the events which are mapped to the fields of the OTLP spans
+var jaegerSkippedKeys = map[string]bool{
+	"X-Trace":           true,
+	"Label":             true,
+	"Layer":             true,
+	EdgeKey:             true,
+	"Timestamp_u":       true,
+	"Timestamp_ns":      true,
+	"Hostname":          true,
+	"PID":               true,
+	"_V":                true,
+	"SpanStatus":        true,
+	"SpanStatusMessage": true,
+	"SpanKind":          true,
+}
+
+// jaegerReporter is a reporter for development which converts the events to
+// spans and sends them to a local Jaeger (or any other OTLP receiver) in the
+// OTLP/HTTP JSON encoding, so the traces can be viewed without an AppOptics
+// account. The custom metrics and the span messages are discarded.
+type jaegerReporter struct {
+	endpoint string
+	client   *http.Client
+	resource otlpResource
+
+	lock sync.Mutex
+	// the unfinished spans keyed by the op IDs of their events, the op IDs
+	// are the uppercase hex strings used by the Edge KVs.
+	open    map[string]*jaegerSpan
+	batch   []*otlpSpan
+	flushCh chan struct{}
+
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+type jaegerSpan struct {
+	span   *otlpSpan
+	ops    []string
+	opened time.Time
+}
+
+func newJaegerReporter() reporter {
+	r := &jaegerReporter{
+		endpoint: config.GetJaegerEndpoint(),
+		client:   &http.Client{Timeout: 5 * time.Second},
+		resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpKV("service.name", jaegerServiceName(config.GetServiceKey())),
+			otlpKV("telemetry.sdk.name", "appoptics-apm-go"),
+			otlpKV("telemetry.sdk.version", utils.Version()),
+		}},
+		open:    make(map[string]*jaegerSpan),
+		flushCh: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+
+	// add default setting
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(16, 8, 16, 8, 16, 8, -1, -1, []byte("")))
+
+	go r.flushLoop()
+	log.Warningf("The jaeger reporter (v%v, go%v) is sending spans to %s.",
+		utils.Version(), utils.GoVersion(), r.endpoint)
+	return r
+}
+
+// jaegerServiceName returns the service name of the service key, or the name
+// of the executable if there is no service key.
+func jaegerServiceName(key string) string {
+	if i := strings.Index(key, ":"); i >= 0 && i < len(key)-1 {
+		return key[i+1:]
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// called when an event should be reported
+func (r *jaegerReporter) reportEvent(ctx *oboeContext, e *event) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+	if err := prepareEvent(ctx, e); err != nil {
+		// don't continue if preparation failed
+		return err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(e.bbuf.GetBuf(), &doc); err != nil {
+		return err
+	}
+	r.addEvent(e.metadata.ids, doc)
+	return nil
+}
+
+// addEvent adds the event to its span, the spans are batched for sending when
+// the exit events are reported.
+func (r *jaegerReporter) addEvent(ids oboeIDs, doc bson.D) {
+	var layer, label string
+	var ts int64 // in nanoseconds
+	var edges []string
+	var attrs []otlpKeyValue
+	kind := 0
+	for _, kv := range doc {
+		switch kv.Name {
+		case "SpanKind":
+			if k, ok := kv.Value.(string); ok {
+				kind = otlpSpanKinds[k]
+			}
+		case "Layer":
+			layer, _ = kv.Value.(string)
+		case "Label":
+			label, _ = kv.Value.(string)
+		case "Timestamp_u":
+			if us, ok := kv.Value.(int64); ok && ts == 0 {
+				ts = us * 1000
+			}
+		case "Timestamp_ns":
+			// the more precise one, if any
+			ts, _ = kv.Value.(int64)
+		case EdgeKey:
+			if edge, ok := kv.Value.(string); ok {
+				edges = append(edges, strings.ToUpper(edge))
+			}
+		}
+		if !jaegerSkippedKeys[kv.Name] {
+			attrs = append(attrs, otlpKV(kv.Name, kv.Value))
+		}
+	}
+	opID := strings.ToUpper(hex.EncodeToString(ids.opID))
+	nanos := strconv.FormatInt(ts, 10)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if label == LabelEntry {
+		if len(r.open) >= jaegerMaxOpenEvents {
+			r.expireOpen(time.Now())
+		}
+		if len(r.open) >= jaegerMaxOpenEvents {
+			log.Debugf("jaeger reporter: too many unfinished spans, dropping span %s", layer)
+			return
+		}
+		s := &otlpSpan{
+			TraceID:           hex.EncodeToString(ids.taskID[:16]),
+			SpanID:            strings.ToLower(opID),
+			Name:              layer,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: nanos,
+			Attributes:        attrs,
+		}
+		if len(edges) > 0 {
+			// the parent is a remote one if its event is not reported by us
+			s.ParentSpanID = strings.ToLower(edges[0])
+			if p, ok := r.open[edges[0]]; ok {
+				s.ParentSpanID = p.span.SpanID
+				s.Kind = otlpSpanKindInternal
+			}
+		}
+		// the kind reported overrides the one guessed from the parent
+		if kind != 0 {
+			s.Kind = kind
+		}
+		r.open[opID] = &jaegerSpan{span: s, ops: []string{opID}, opened: time.Now()}
+		return
+	}
+
+	// the other events of a span have an edge to the previous event of it
+	var js *jaegerSpan
+	for _, edge := range edges {
+		if s, ok := r.open[edge]; ok && s.span.Name == layer {
+			js = s
+			break
+		}
+	}
+	if js == nil {
+		log.Debugf("jaeger reporter: no entry event found for the %s event of %s", label, layer)
+		return
+	}
+
+	switch label {
+	case LabelExit:
+		js.span.EndTimeUnixNano = nanos
+		js.span.Attributes = append(js.span.Attributes, attrs...)
+		if st := otlpSpanStatus(doc); st != nil {
+			js.span.Status = st
+		}
+		for _, op := range js.ops {
+			delete(r.open, op)
+		}
+		r.batch = append(r.batch, js.span)
+		if len(r.batch) >= jaegerBatchSize {
+			select {
+			case r.flushCh <- struct{}{}:
+			default:
+			}
+		}
+	default:
+		name := label
+		if label == LabelError {
+			name = "exception"
+			js.span.Status = &otlpStatus{Code: otlpStatusCodeError}
+			for _, kv := range doc {
+				if kv.Name == "ErrorMsg" {
+					js.span.Status.Message = fmt.Sprint(kv.Value)
+				}
+			}
+		}
+		js.span.Events = append(js.span.Events, otlpEvent{TimeUnixNano: nanos, Name: name, Attributes: attrs})
+		js.ops = append(js.ops, opID)
+		r.open[opID] = js
+	}
+}
+
+// expireOpen drops the unfinished spans opened longer than jaegerOpenSpanTTL
+// ago. The caller must hold the lock.
+func (r *jaegerReporter) expireOpen(now time.Time) {
+	for op, js := range r.open {
+		if now.Sub(js.opened) > jaegerOpenSpanTTL {
+			delete(r.open, op)
+		}
+	}
+}
+
+func (r *jaegerReporter) flushLoop() {
+	defer close(r.exited)
+	ticker := time.NewTicker(jaegerFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.lock.Lock()
+			r.expireOpen(now)
+			r.lock.Unlock()
+		case <-r.flushCh:
+		case <-r.done:
+		}
+		if err := r.Flush(); err != nil {
+			log.Warningf("jaeger reporter: %v", err)
+		}
+		if r.Closed() {
+			return
+		}
+	}
+}
+
+// Flush sends the finished spans to the endpoint.
+func (r *jaegerReporter) Flush() error {
+	r.lock.Lock()
+	spans := r.batch
+	r.batch = nil
+	r.lock.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: r.resource,
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "appoptics-apm-go", Version: utils.Version()},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	if dryRun() {
+		log.Infof("dry-run: %d spans of %d bytes are not sent.", len(spans), len(body))
+		return nil
+	}
+	resp, err := r.client.Post(r.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send %d spans: %v", len(spans), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to send %d spans: %s", len(spans), resp.Status)
+	}
+	return nil
+}
+
+// OTLPJSON converts the encoded events to the OTLP/HTTP JSON encoding of the
+// traces, the same as the jaeger reporter sends, except that the resource and
+// the scope version are left out. The events of the unfinished spans are
+// dropped. It's used to export the traces captured by the tests.
+func OTLPJSON(bufs [][]byte) ([]byte, error) {
+	r := &jaegerReporter{open: make(map[string]*jaegerSpan)}
+	for i, buf := range bufs {
+		var doc bson.D
+		if err := bson.Unmarshal(buf, &doc); err != nil {
+			return nil, fmt.Errorf("event #%d: %v", i, err)
+		}
+		var md oboeMetadata
+		for _, kv := range doc {
+			if kv.Name == "X-Trace" {
+				xt, _ := kv.Value.(string)
+				if err := md.FromString(xt); err != nil {
+					return nil, fmt.Errorf("event #%d: %v", i, err)
+				}
+			}
+		}
+		if len(md.ids.taskID) == 0 {
+			return nil, fmt.Errorf("event #%d: no X-Trace", i)
+		}
+		r.addEvent(md.ids, doc)
+	}
+	spans := r.batch
+	if spans == nil {
+		spans = []*otlpSpan{}
+	}
+	return json.MarshalIndent(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "appoptics-apm-go"},
+			Spans: spans,
+		}},
+	}}}, "", "  ")
+}
+
+// called when a status (e.g. __Init message) should be reported
+func (r *jaegerReporter) reportStatus(ctx *oboeContext, e *event) error { return nil }
+
+// called when a Span message should be reported
+func (r *jaegerReporter) reportSpan(span metrics.SpanMessage) error { return nil }
+
+// Shutdown closes the reporter after sending the finished spans.
+func (r *jaegerReporter) Shutdown(ctx context.Context) error {
+	r.closeOnce.Do(func() { close(r.done) })
+	select {
+	case <-r.exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownNow closes the reporter immediately
+func (r *jaegerReporter) ShutdownNow() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
+
+// Closed returns if the reporter is already closed.
+func (r *jaegerReporter) Closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForReady waits until the reporter becomes ready or the context is canceled.
+func (r *jaegerReporter) WaitForReady(context.Context) bool { return true }
+
+func (r *jaegerReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
+	return nil
+}
+
+func (r *jaegerReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	return nil
+}
+
+func (r *jaegerReporter) SetServiceKey(string) {}
+
+// The OTLP/HTTP JSON encoding of the traces, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+// The IDs are hex strings and the 64-bit integers are decimal strings.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string          `json:"stringValue,omitempty"`
+	BoolValue   *bool            `json:"boolValue,omitempty"`
+	IntValue    *string          `json:"intValue,omitempty"`
+	DoubleValue *float64         `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue  `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKvlistValue `json:"kvlistValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKvlistValue struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpSpanStatus returns the OTLP status of the SpanStatus KV of an exit
+// event, or nil if there is none.
+func otlpSpanStatus(doc bson.D) *otlpStatus {
+	var code, msg string
+	for _, kv := range doc {
+		switch kv.Name {
+		case "SpanStatus":
+			code, _ = kv.Value.(string)
+		case "SpanStatusMessage":
+			msg, _ = kv.Value.(string)
+		}
+	}
+	switch code {
+	case "ok":
+		return &otlpStatus{Code: otlpStatusCodeOk}
+	case "error":
+		return &otlpStatus{Code: otlpStatusCodeError, Message: msg}
+	default:
+		return nil
+	}
+}
+
+func otlpKV(key string, val interface{}) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpValue(val)}
+}
+
+// otlpValue converts a decoded BSON value, the arrays and the documents are
+// converted recursively.
+func otlpValue(val interface{}) otlpAnyValue {
+	var v otlpAnyValue
+	switch val := val.(type) {
+	case string:
+		v.StringValue = &val
+	case bool:
+		v.BoolValue = &val
+	case int:
+		s := strconv.Itoa(val)
+		v.IntValue = &s
+	case int32:
+		s := strconv.FormatInt(int64(val), 10)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(val, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &val
+	case []interface{}:
+		a := &otlpArrayValue{Values: make([]otlpAnyValue, 0, len(val))}
+		for _, e := range val {
+			a.Values = append(a.Values, otlpValue(e))
+		}
+		v.ArrayValue = a
+	case bson.D:
+		l := &otlpKvlistValue{Values: make([]otlpKeyValue, 0, len(val))}
+		for _, e := range val {
+			l.Values = append(l.Values, otlpKV(e.Name, e.Value))
+		}
+		v.KvlistValue = l
+	default:
+		s := fmt.Sprint(val)
+		v.StringValue = &s
+	}
+	return v
+}
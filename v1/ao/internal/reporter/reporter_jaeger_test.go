@@ -0,0 +1,144 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestJaegerReporter(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/traces", req.URL.Path)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		b, _ := ioutil.ReadAll(req.Body)
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	os.Setenv("APPOPTICS_JAEGER_ENDPOINT", srv.URL+"/v1/traces")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_JAEGER_ENDPOINT")
+		config.Load()
+	}()
+
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+	r := newJaegerReporter().(*jaegerReporter)
+	globalReporter = r
+
+	ctx := newTestContext(t)
+	require.NoError(t, ctx.reportEvent(LabelEntry, "root", false, "URL", "/hello"))
+	child := ctx.Copy().(*oboeContext)
+	require.NoError(t, child.ReportEvent(LabelEntry, "child", "Count", 3, "SpanKind", "client"))
+	require.NoError(t, child.ReportEvent(LabelError, "child", "ErrorMsg", "boom"))
+	require.NoError(t, child.ReportEvent(LabelExit, "child"))
+	require.NoError(t, ctx.ReportEvent(LabelInfo, "root", "Ratio", 0.5,
+		"Tags", []string{"a", "b"}, "Labels", map[string]string{"k": "v"}))
+	require.NoError(t, ctx.ReportEvent(LabelExit, "root", "Status", 200, "SpanStatus", "ok"))
+	// an exit without the entry is dropped
+	require.NoError(t, newTestContext(t).ReportEvent(LabelExit, "orphan"))
+	assert.Empty(t, r.open)
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.True(t, r.Closed())
+	assert.Equal(t, ErrReporterIsClosed, ctx.ReportEvent(LabelInfo, "root"))
+
+	var traces otlpTraces
+	require.NoError(t, json.Unmarshal(<-bodies, &traces))
+	require.Len(t, traces.ResourceSpans, 1)
+	rs := traces.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	c, root := spans[0], spans[1]
+	assert.Equal(t, "child", c.Name)
+	assert.Equal(t, "root", root.Name)
+	assert.Equal(t, root.TraceID, c.TraceID)
+	assert.Len(t, root.TraceID, 32)
+	assert.Len(t, root.SpanID, 16)
+	assert.Equal(t, root.SpanID, c.ParentSpanID)
+	assert.Empty(t, root.ParentSpanID)
+	assert.Equal(t, otlpSpanKindServer, root.Kind)
+	assert.Equal(t, otlpSpanKindClient, c.Kind)
+	assert.NotEmpty(t, root.EndTimeUnixNano)
+
+	assert.Equal(t, &otlpStatus{Code: otlpStatusCodeError, Message: "boom"}, c.Status)
+	require.Len(t, c.Events, 1)
+	assert.Equal(t, "exception", c.Events[0].Name)
+	assert.Contains(t, c.Attributes, otlpKV("Count", 3))
+
+	require.Len(t, root.Events, 1)
+	assert.Equal(t, "info", root.Events[0].Name)
+	assert.Contains(t, root.Events[0].Attributes, otlpKV("Ratio", 0.5))
+	assert.Contains(t, root.Events[0].Attributes, otlpKV("Tags", []interface{}{"a", "b"}))
+	assert.Contains(t, root.Events[0].Attributes, otlpKV("Labels", bson.D{{Name: "k", Value: "v"}}))
+	assert.Contains(t, root.Attributes, otlpKV("URL", "/hello"))
+	assert.Contains(t, root.Attributes, otlpKV("Status", 200))
+	assert.Equal(t, &otlpStatus{Code: otlpStatusCodeOk}, root.Status)
+	for _, kv := range root.Attributes {
+		assert.NotContains(t, jaegerSkippedKeys, kv.Key)
+	}
+}
+
+func TestOTLPJSON(t *testing.T) {
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	require.NoError(t, ctx.reportEvent(LabelEntry, "root", false))
+	child := ctx.Copy().(*oboeContext)
+	require.NoError(t, child.ReportEvent(LabelEntry, "child"))
+	require.NoError(t, child.ReportEvent(LabelExit, "child"))
+	require.NoError(t, ctx.ReportEvent(LabelExit, "root", "SpanStatus", "ok"))
+	// the unfinished spans are dropped
+	require.NoError(t, newTestContext(t).reportEvent(LabelEntry, "unfinished", false))
+	r.Close(5)
+
+	b, err := OTLPJSON(r.EventBufs)
+	require.NoError(t, err)
+	var traces otlpTraces
+	require.NoError(t, json.Unmarshal(b, &traces))
+	require.Len(t, traces.ResourceSpans, 1)
+	rs := traces.ResourceSpans[0]
+	assert.Empty(t, rs.Resource.Attributes)
+	assert.Empty(t, rs.ScopeSpans[0].Scope.Version)
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, "root", spans[1].Name)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, &otlpStatus{Code: otlpStatusCodeOk}, spans[1].Status)
+
+	b, err = OTLPJSON(nil)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"spans": []`)
+	_, err = OTLPJSON([][]byte{{1, 2, 3}})
+	assert.Error(t, err)
+}
+
+func TestJaegerExpireOpen(t *testing.T) {
+	now := time.Now()
+	stale := &jaegerSpan{span: &otlpSpan{Name: "stale"}, ops: []string{"A", "B"}, opened: now.Add(-jaegerOpenSpanTTL - time.Second)}
+	live := &jaegerSpan{span: &otlpSpan{Name: "live"}, ops: []string{"C"}, opened: now}
+	r := &jaegerReporter{open: map[string]*jaegerSpan{"A": stale, "B": stale, "C": live}}
+	r.expireOpen(now)
+	assert.Equal(t, map[string]*jaegerSpan{"C": live}, r.open)
+}
+
+func TestJaegerServiceName(t *testing.T) {
+	assert.Equal(t, "go", jaegerServiceName("ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go"))
+	assert.NotEmpty(t, jaegerServiceName(""))
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+)
+
+// the custom metric of the events failed to be reported by a sink of the tee
+// reporter, tagged by the reporter type of the sink.
+const teeDroppedMetric = "AppOptics.Reporter.Dropped"
+
+// teeReporter sends everything to multiple reporters, e.g., to dual-write to
+// two backends during a migration. The reporters fail independently: the
+// failure of one doesn't stop the others from receiving the events, and only
+// the errors of the first (primary) reporter are returned.
+type teeReporter struct {
+	sinks []*teeSink
+}
+
+type teeSink struct {
+	name string
+	reporter
+	dropped int64
+}
+
+func newTeeReporter(types []string) reporter {
+	t := &teeReporter{}
+	for _, rt := range types {
+		rt = strings.ToLower(strings.TrimSpace(rt))
+		t.sinks = append(t.sinks, &teeSink{name: rt, reporter: newReporter(rt)})
+	}
+	log.Warningf("The events are reported to %s.", strings.Join(types, ", "))
+	return t
+}
+
+// each calls fn for each sink and returns the error of the primary one. The
+// failures of the events are counted.
+func (t *teeReporter) each(countDropped bool, fn func(r reporter) error) error {
+	var primaryErr error
+	for i, s := range t.sinks {
+		err := fn(s.reporter)
+		if err == nil {
+			continue
+		}
+		if i == 0 {
+			primaryErr = err
+		}
+		if countDropped {
+			t.drop(s, err)
+		}
+	}
+	return primaryErr
+}
+
+func (t *teeReporter) drop(s *teeSink, err error) {
+	atomic.AddInt64(&s.dropped, 1)
+	log.Debugf("tee reporter: %s failed to report: %v", s.name, err)
+	_ = t.sinks[0].CustomIncrementMetric(teeDroppedMetric, metrics.MetricOptions{
+		Count:   1,
+		HostTag: true,
+		Tags:    map[string]string{"Sink": s.name},
+	})
+}
+
+// droppedCounts returns the number of the events dropped, keyed by the
+// reporter types.
+func (t *teeReporter) droppedCounts() map[string]int64 {
+	c := make(map[string]int64, len(t.sinks))
+	for _, s := range t.sinks {
+		c[s.name] = atomic.LoadInt64(&s.dropped)
+	}
+	return c
+}
+
+// called when an event should be reported
+func (t *teeReporter) reportEvent(ctx *oboeContext, e *event) error {
+	return t.eachPrepared(ctx, e, func(r reporter) error { return r.reportEvent(ctx, e) })
+}
+
+// called when a status (e.g. __Init message) should be reported
+func (t *teeReporter) reportStatus(ctx *oboeContext, e *event) error {
+	return t.eachPrepared(ctx, e, func(r reporter) error { return r.reportStatus(ctx, e) })
+}
+
+// eachPrepared prepares the event only once, as the context is updated by the
+// preparation, and then passes it to each sink.
+func (t *teeReporter) eachPrepared(ctx *oboeContext, e *event, fn func(r reporter) error) error {
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	e.prepared = true
+	defer func() { e.prepared = false }()
+	return t.each(true, fn)
+}
+
+// called when a Span message should be reported
+func (t *teeReporter) reportSpan(span metrics.SpanMessage) error {
+	return t.each(false, func(r reporter) error { return r.reportSpan(span) })
+}
+
+// Shutdown closes all the reporters.
+func (t *teeReporter) Shutdown(ctx context.Context) error {
+	return t.each(false, func(r reporter) error { return r.Shutdown(ctx) })
+}
+
+// ShutdownNow closes all the reporters immediately
+func (t *teeReporter) ShutdownNow() error {
+	return t.each(false, func(r reporter) error { return r.ShutdownNow() })
+}
+
+// Closed returns if all the reporters are closed.
+func (t *teeReporter) Closed() bool {
+	for _, s := range t.sinks {
+		if !s.Closed() {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitForReady waits until all the reporters become ready or the context is
+// canceled.
+func (t *teeReporter) WaitForReady(ctx context.Context) bool {
+	ready := true
+	for _, s := range t.sinks {
+		ready = s.WaitForReady(ctx) && ready
+	}
+	return ready
+}
+
+// CustomSummaryMetric submits a summary type measurement to all the reporters.
+func (t *teeReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
+	return t.each(false, func(r reporter) error { return r.CustomSummaryMetric(name, value, opts) })
+}
+
+// CustomIncrementMetric submits a incremental measurement to all the reporters.
+func (t *teeReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	return t.each(false, func(r reporter) error { return r.CustomIncrementMetric(name, opts) })
+}
+
+// Flush flushes all the reporters.
+func (t *teeReporter) Flush() error {
+	return t.each(false, func(r reporter) error { return r.Flush() })
+}
+
+// SetServiceKey attaches a service key to all the reporters
+func (t *teeReporter) SetServiceKey(key string) {
+	for _, s := range t.sinks {
+		s.SetServiceKey(key)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingReporter counts the events and records the increment metrics
+type recordingReporter struct {
+	nullReporter
+	err     error
+	events  int
+	metrics []metrics.MetricOptions
+}
+
+func (r *recordingReporter) reportEvent(ctx *oboeContext, e *event) error {
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	r.events++
+	return r.err
+}
+
+func (r *recordingReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	if name == teeDroppedMetric {
+		r.metrics = append(r.metrics, opts)
+	}
+	return nil
+}
+
+func TestTeeReporter(t *testing.T) {
+	primary, secondary := &recordingReporter{}, &recordingReporter{err: errors.New("unavailable")}
+	tee := &teeReporter{sinks: []*teeSink{
+		{name: "ssl", reporter: primary},
+		{name: "jaeger", reporter: secondary},
+	}}
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+	globalReporter = tee
+
+	// the failure of a secondary reporter is not returned
+	ctx := newTestContext(t)
+	assert.NoError(t, ctx.ReportEvent(LabelEntry, "layer"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "layer"))
+	assert.Equal(t, 2, primary.events)
+	assert.Equal(t, 2, secondary.events)
+	assert.Equal(t, map[string]int64{"ssl": 0, "jaeger": 2}, tee.droppedCounts())
+	require.Len(t, primary.metrics, 2)
+	assert.Equal(t, map[string]string{"Sink": "jaeger"}, primary.metrics[0].Tags)
+
+	// the secondary reporters still get the events the primary one fails
+	primary.err, secondary.err = errors.New("queue is full"), nil
+	assert.Error(t, ctx.ReportEvent(LabelInfo, "layer"))
+	assert.Equal(t, 3, secondary.events)
+	assert.Equal(t, map[string]int64{"ssl": 1, "jaeger": 2}, tee.droppedCounts())
+	assert.True(t, tee.Closed())
+}
+
+func TestSetGlobalTeeReporter(t *testing.T) {
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+	globalReporter = nil
+
+	setGlobalReporter("udp,jaeger")
+	tee, ok := globalReporter.(*teeReporter)
+	require.True(t, ok)
+	require.Len(t, tee.sinks, 2)
+	assert.Equal(t, "udp", tee.sinks[0].name)
+	assert.IsType(t, &udpReporter{}, tee.sinks[0].reporter)
+	assert.IsType(t, &jaegerReporter{}, tee.sinks[1].reporter)
+	assert.NoError(t, tee.ShutdownNow())
+}
@@ -234,6 +234,10 @@ func TestGRPCReporter(t *testing.T) {
 	assert.True(t, r.WaitForReady(ctxTm2))
 	assert.True(t, r.isReady())
 
+	stats := GetStats()
+	agentStats := GetAgentStats()
+	assert.True(t, agentStats.SettingsAge >= 0)
+	assert.Equal(t, 10000, agentStats.QueueCapacity)
 	ctx := newTestContext(t)
 	ev1, err := ctx.newEvent(LabelInfo, "layer1")
 	assert.NoError(t, err)
@@ -293,6 +297,17 @@ func TestGRPCReporter(t *testing.T) {
 	assert.Equal(t, dec1["PID"], host.PID())
 
 	assert.Equal(t, dec2["Layer"], "layer2")
+
+	// the bytes sent are counted by the message types
+	sent := GetStats()
+	assert.Equal(t, int64(len(server.events[0].Messages[0])), sent.EventBytes-stats.EventBytes)
+	assert.Equal(t, int64(len(server.status[0].Messages[0])), sent.StatusBytes-stats.StatusBytes)
+
+	// so are the events
+	agentSent := GetAgentStats()
+	assert.EqualValues(t, 1, agentSent.EventsQueued-agentStats.EventsQueued)
+	assert.EqualValues(t, 1, agentSent.EventsSent-agentStats.EventsSent)
+	assert.Equal(t, agentStats.EventsDropped, agentSent.EventsDropped)
 }
 
 func TestShutdownGRPCReporter(t *testing.T) {
@@ -570,6 +585,13 @@ func TestInvokeRPC(t *testing.T) {
 	mockMethod.On("RetryOnErr", mock.Anything).Return(false)
 
 	assert.Contains(t, c.InvokeRPC(exit, mockMethod).Error(), errNoRetryOnErr.Error())
+
+	// Test dry-run: the messages are not sent, but the other methods are
+	require.NoError(t, config.SetTracingMode(config.DryRunTracingMode))
+	defer config.Load()
+	assert.Equal(t, errDryRun, c.InvokeRPC(exit, newPostEventsMethod("serviceKey", [][]byte{[]byte("event")})))
+	assert.Equal(t, errDryRun, c.InvokeRPC(exit, newPostStatusMethod("serviceKey", [][]byte{[]byte("status")})))
+	assert.Contains(t, c.InvokeRPC(exit, mockMethod).Error(), errNoRetryOnErr.Error())
 }
 
 func TestInitReporter(t *testing.T) {
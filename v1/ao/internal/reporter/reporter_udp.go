@@ -52,6 +52,10 @@ func (r *udpReporter) report(ctx *oboeContext, e *event) error {
 		// don't continue if preparation failed
 		return err
 	}
+	if dryRun() {
+		log.Debugf("dry-run: event of %d bytes is not sent.", len(e.bbuf.GetBuf()))
+		return nil
+	}
 
 	_, err := r.conn.Write((*e).bbuf.GetBuf())
 	return err
@@ -83,7 +87,10 @@ func (r *udpReporter) reportStatus(ctx *oboeContext, e *event) error {
 }
 
 func (r *udpReporter) reportSpan(span metrics.SpanMessage) error {
-	s := span.(*metrics.HTTPSpanMessage)
+	s, ok := span.(*metrics.HTTPSpanMessage)
+	if !ok {
+		return nil
+	}
 	bbuf := bson.NewBuffer()
 	bbuf.AppendString("transaction", s.Transaction)
 	bbuf.AppendString("url", s.Path)
@@ -92,6 +99,9 @@ func (r *udpReporter) reportSpan(span metrics.SpanMessage) error {
 	bbuf.AppendBool("hasError", s.HasError)
 	bbuf.AppendInt64("duration", s.Duration.Nanoseconds())
 	bbuf.Finish()
+	if dryRun() {
+		return nil
+	}
 	_, err := r.conn.Write(bbuf.GetBuf())
 	return err
 }
@@ -0,0 +1,96 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync"
+	"time"
+)
+
+// Settings is the view of the settings received from the collector, after
+// being merged with the local configuration, which is provided to the
+// settings-change callbacks.
+type Settings struct {
+	// Layer is the span name the settings apply to, empty for the default ones
+	Layer string
+	// SampleRate is the sample rate out of 1,000,000
+	SampleRate int
+	// Flags is the comma-separated flags received from the collector
+	Flags string
+	// Tracing is if new traces can be started
+	Tracing bool
+	// TriggerTrace is if the trigger traces are enabled
+	TriggerTrace bool
+	// TTL is how long the settings are valid without being refreshed
+	TTL time.Duration
+
+	BucketCapacity                    float64
+	BucketRate                        float64
+	TriggerTraceRelaxedBucketCapacity float64
+	TriggerTraceRelaxedBucketRate     float64
+	TriggerTraceStrictBucketCapacity  float64
+	TriggerTraceStrictBucketRate      float64
+}
+
+// the settings-change callbacks and the last settings they're called with,
+// by layer
+var settingsCallbacks struct {
+	sync.Mutex
+	fns  []func(Settings)
+	last map[string]Settings
+}
+
+// OnSettingsChange registers a callback which is called with the settings of
+// a layer whenever they're different from the previous ones received. The
+// callback is called with the current settings upon registration.
+func OnSettingsChange(fn func(Settings)) {
+	if fn == nil {
+		return
+	}
+	settingsCallbacks.Lock()
+	settingsCallbacks.fns = append(settingsCallbacks.fns, fn)
+	current := make([]Settings, 0, len(settingsCallbacks.last))
+	for _, s := range settingsCallbacks.last {
+		current = append(current, s)
+	}
+	settingsCallbacks.Unlock()
+
+	for _, s := range current {
+		fn(s)
+	}
+}
+
+// notifySettings calls the callbacks if the settings are changed.
+func notifySettings(s Settings) {
+	settingsCallbacks.Lock()
+	if last, ok := settingsCallbacks.last[s.Layer]; ok && last == s {
+		settingsCallbacks.Unlock()
+		return
+	}
+	if settingsCallbacks.last == nil {
+		settingsCallbacks.last = make(map[string]Settings)
+	}
+	settingsCallbacks.last[s.Layer] = s
+	fns := settingsCallbacks.fns
+	settingsCallbacks.Unlock()
+
+	for _, fn := range fns {
+		fn(s)
+	}
+}
+
+// newSettings returns the view of the settings provided to the callbacks.
+func newSettings(s *oboeSettings, flags string) Settings {
+	ns := Settings{
+		Layer:        s.layer,
+		SampleRate:   s.value,
+		Flags:        flags,
+		Tracing:      s.flags&FLAG_SAMPLE_START != 0,
+		TriggerTrace: s.flags&FLAG_TRIGGER_TRACE != 0,
+		TTL:          time.Duration(s.ttl) * time.Second,
+	}
+	ns.BucketRate, ns.BucketCapacity = s.bucket.rateCap()
+	ns.TriggerTraceRelaxedBucketRate, ns.TriggerTraceRelaxedBucketCapacity = s.triggerTraceRelaxedBucket.rateCap()
+	ns.TriggerTraceStrictBucketRate, ns.TriggerTraceStrictBucketCapacity = s.triggerTraceStrictBucket.rateCap()
+	return ns
+}
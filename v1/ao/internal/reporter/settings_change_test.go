@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnSettingsChange(t *testing.T) {
+	r := SetTestReporter(TestReporterDisableDefaultSetting(true))
+	defer r.Close(0)
+
+	var got []Settings
+	OnSettingsChange(func(s Settings) { got = append(got, s) })
+	assert.Empty(t, got)
+
+	update := func(layer string, rate int64) {
+		updateSetting(int32(TYPE_DEFAULT), layer, []byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+			rate, 120, argsToMap(8, 0.17, 0, 0, 0, 0, -1, -1, nil))
+	}
+	update("", 1000000)
+	update("", 1000000) // unchanged
+	assert.Len(t, got, 1)
+
+	update("", 500000)
+	update(testLayer, 500000) // the settings are compared by layer
+	if assert.Len(t, got, 3) {
+		assert.Equal(t, 500000, got[1].SampleRate)
+		assert.Equal(t, 8.0, got[1].BucketCapacity)
+		assert.Equal(t, 0.17, got[1].BucketRate)
+		assert.Equal(t, testLayer, got[2].Layer)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/pkg/errors"
+)
+
+// savedSettings is the last settings received from the collector, saved to
+// the disk so they can be restored when the agent starts while the collector
+// is not reachable.
+type savedSettings struct {
+	// SavedAt is the Unix time in seconds the snapshot is saved
+	SavedAt  int64                    `json:"savedAt"`
+	Settings []*collector.OboeSetting `json:"settings"`
+}
+
+// saveSettingsSnapshot writes the settings to the file. The file is replaced
+// atomically so a partially written one is never restored.
+func saveSettingsSnapshot(path string, settings []*collector.OboeSetting) error {
+	data, err := json.Marshal(&savedSettings{
+		SavedAt:  time.Now().Unix(),
+		Settings: settings,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the settings snapshot")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create the settings snapshot")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write the settings snapshot")
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write the settings snapshot")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "failed to save the settings snapshot")
+}
+
+// loadSettingsSnapshot reads the settings from the file. It returns an error if
+// the snapshot is older than maxAge.
+func loadSettingsSnapshot(path string, maxAge time.Duration) ([]*collector.OboeSetting, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the settings snapshot")
+	}
+
+	var s savedSettings
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the settings snapshot")
+	}
+
+	if age := time.Since(time.Unix(s.SavedAt, 0)); age > maxAge {
+		return nil, errors.Errorf("the settings snapshot is too old: %v", age.Round(time.Second))
+	}
+	return s.Settings, nil
+}
+
+// saveSettings saves the settings received from the collector, if the snapshot
+// is enabled.
+func (r *grpcReporter) saveSettings(settings *collector.SettingsResult) {
+	path := config.GetSettingsSnapshot()
+	if path == "" {
+		return
+	}
+	if err := saveSettingsSnapshot(path, settings.GetSettings()); err != nil {
+		log.Warningf("saveSettings: %s", err)
+	}
+}
+
+// restoreSettings applies the settings in the snapshot, if it's enabled, so
+// the agent is ready before receiving the settings from the collector. The
+// restored settings expire with their TTL if the collector is still not
+// reachable by then.
+func (r *grpcReporter) restoreSettings() {
+	path := config.GetSettingsSnapshot()
+	if path == "" {
+		return
+	}
+	settings, err := loadSettingsSnapshot(path, config.GetSnapshotMaxAge())
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			log.Warningf("restoreSettings: %s", err)
+		}
+		return
+	}
+	log.Infof("Restoring the settings from %s", path)
+	r.updateSettings(&collector.SettingsResult{Settings: settings})
+}
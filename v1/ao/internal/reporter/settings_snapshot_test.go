@@ -0,0 +1,98 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	_, err = loadSettingsSnapshot(path, time.Hour)
+	assert.True(t, os.IsNotExist(errors.Cause(err)))
+
+	settings := []*collector.OboeSetting{{
+		Type:      collector.OboeSettingType_DEFAULT_SAMPLE_RATE,
+		Flags:     []byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		Value:     500000,
+		Ttl:       120,
+		Arguments: argsToMap(16, 2, 8, 1, 4, 0.5, -1, -1, []byte("key")),
+	}}
+	require.NoError(t, saveSettingsSnapshot(path, settings))
+
+	restored, err := loadSettingsSnapshot(path, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, settings, restored)
+
+	// the temporary files are not left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	time.Sleep(time.Second)
+	_, err = loadSettingsSnapshot(path, 0)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	_, err = loadSettingsSnapshot(path, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestRestoreSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	defer func() {
+		os.Unsetenv("APPOPTICS_SETTINGS_SNAPSHOT")
+		config.Load()
+		resetSettings()
+	}()
+	os.Setenv("APPOPTICS_SETTINGS_SNAPSHOT", path)
+	config.Load()
+	resetSettings()
+
+	r := &grpcReporter{
+		httpMetrics:   metrics.NewMeasurements(false, grpcMetricIntervalDefault, 200),
+		customMetrics: metrics.NewMeasurements(true, grpcMetricIntervalDefault, 500),
+		cond:          sync.NewCond(&sync.Mutex{}),
+		done:          make(chan struct{}),
+	}
+	// nothing to restore
+	r.restoreSettings()
+	assert.False(t, r.isReady())
+
+	r.saveSettings(&collector.SettingsResult{Settings: []*collector.OboeSetting{{
+		Type:      collector.OboeSettingType_DEFAULT_SAMPLE_RATE,
+		Flags:     []byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		Value:     500000,
+		Ttl:       120,
+		Arguments: argsToMap(16, 2, 8, 1, 4, 0.5, -1, -1, []byte("key")),
+	}}})
+	r.restoreSettings()
+	assert.True(t, r.isReady())
+
+	setting, ok := getSetting("")
+	require.True(t, ok)
+	assert.Equal(t, 500000, setting.value)
+	assert.EqualValues(t, 120, setting.ttl)
+	assert.EqualValues(t, 16, setting.bucket.capacity)
+	assert.EqualValues(t, 2, setting.bucket.ratePerSec)
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// the sanitizers used by SQLFingerprint, which are not affected by the
+// APPOPTICS_SQL_SANITIZE setting
+var fingerprintSanitizers = func() map[string]*SQLSanitizer {
+	ss := make(map[string]*SQLSanitizer)
+	for _, t := range []string{PostgreSQL, Oracle, MySQL, Sybase, SQLServer, DefaultDB} {
+		ss[t] = NewSQLSanitizer(t, EnabledAuto)
+	}
+	return ss
+}()
+
+var (
+	// the positional placeholders of PostgreSQL, e.g., $1, which would be
+	// taken as the dollar-quoted literals by the sanitizer
+	positionalPlaceholders = regexp.MustCompile(`\$[0-9]+`)
+	// the lists of the placeholders, e.g., "IN (?, ?, ?)"
+	placeholderLists = regexp.MustCompile(`\?(?: ?, ?\?)+`)
+	// the rows of the placeholders, e.g., "VALUES (?), (?)"
+	placeholderRows = regexp.MustCompile(`\(\?\)(?: ?, ?\(\?\))+`)
+)
+
+// SQLFingerprint returns the normalized form of the SQL statement, which is
+// the same for the statements only differing in the literals, the
+// placeholders, the sizes of the lists of values and the whitespaces, e.g.,
+//   SELECT * FROM users WHERE id IN (?)
+// for both "SELECT * FROM users WHERE id IN (1, 2)" and
+// "SELECT * FROM users  WHERE id IN ($1)". The literals are always removed,
+// regardless of APPOPTICS_SQL_SANITIZE.
+func SQLFingerprint(dbType, sql string) string {
+	sql = positionalPlaceholders.ReplaceAllString(sql, "?")
+	s, ok := fingerprintSanitizers[dbType]
+	if !ok {
+		s = fingerprintSanitizers[DefaultDB]
+	}
+	sql = strings.Join(strings.Fields(s.Sanitize(sql)), " ")
+	sql = strings.TrimRight(sql, "; ")
+	sql = placeholderLists.ReplaceAllString(sql, "?")
+	return placeholderRows.ReplaceAllString(sql, "(?)")
+}
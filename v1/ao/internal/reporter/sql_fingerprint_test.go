@@ -0,0 +1,26 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLFingerprint(t *testing.T) {
+	cases := []struct {
+		dbType, sql, fingerprint string
+	}{
+		{PostgreSQL, "SELECT * FROM users WHERE id = $1 AND name = 'bob'",
+			"SELECT * FROM users WHERE id = ? AND name = ?"},
+		{PostgreSQL, `SELECT "Name" FROM t WHERE a::int = 1`, `SELECT "Name" FROM t WHERE a::int = ?`},
+		{MySQL, "SELECT  *\n\tFROM t1 WHERE a IN (1,2, 3);", "SELECT * FROM t1 WHERE a IN (?)"},
+		{MySQL, "SELECT * FROM t1 WHERE a IN (?, ?)", "SELECT * FROM t1 WHERE a IN (?)"},
+		{MySQL, "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y')", "INSERT INTO t (a, b) VALUES (?)"},
+		{"unknown", `UPDATE t SET a = "x" WHERE b = 2`, "UPDATE t SET a = ? WHERE b = ?"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.fingerprint, SQLFingerprint(c.dbType, c.sql), c.sql)
+	}
+}
@@ -29,6 +29,17 @@ type TestReporter struct {
 	eventChan      chan []byte
 	spanMsgChan    chan metrics.SpanMessage
 	Timeout        time.Duration
+	// the custom metrics recorded, in order
+	CustomMetrics []TestCustomMetric
+	metricsLock   sync.Mutex
+}
+
+// TestCustomMetric is a custom metric recorded by the TestReporter. The value
+// of an increment metric is zero.
+type TestCustomMetric struct {
+	Name  string
+	Value float64
+	Opts  metrics.MetricOptions
 }
 
 const (
@@ -288,9 +299,17 @@ func (r *TestReporter) updateSetting() {
 }
 
 func (r *TestReporter) CustomSummaryMetric(name string, value float64, opts metrics.MetricOptions) error {
+	r.recordCustomMetric(TestCustomMetric{Name: name, Value: value, Opts: opts})
 	return nil
 }
 
 func (r *TestReporter) CustomIncrementMetric(name string, opts metrics.MetricOptions) error {
+	r.recordCustomMetric(TestCustomMetric{Name: name, Opts: opts})
 	return nil
 }
+
+func (r *TestReporter) recordCustomMetric(m TestCustomMetric) {
+	r.metricsLock.Lock()
+	defer r.metricsLock.Unlock()
+	r.CustomMetrics = append(r.CustomMetrics, m)
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"io"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+func init() {
+	// the compressor registered by the application, if any, is kept
+	if encoding.GetCompressor(config.CompressionZstd) == nil {
+		encoding.RegisterCompressor(&zstdCompressor{})
+	}
+}
+
+// zstdCompressor is the gRPC compressor of zstd. The BSON events and metrics
+// messages are mostly the same keys and strings repeated, which zstd
+// compresses better and faster than gzip. The encoders are pooled as they are
+// expensive to allocate.
+type zstdCompressor struct {
+	encoders sync.Pool
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+// Close flushes the compressed data and returns the encoder to the pool.
+func (w *zstdWriter) Close() error {
+	defer w.pool.Put(w)
+	return w.Encoder.Close()
+}
+
+// Compress implements encoding.Compressor.
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if zw, ok := c.encoders.Get().(*zstdWriter); ok {
+		zw.Reset(w)
+		return zw, nil
+	}
+	enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+// Decompress implements encoding.Compressor. The responses of the collector
+// are small, so the decoders are not pooled.
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{Decoder: dec}, nil
+}
+
+// Name implements encoding.Compressor.
+func (c *zstdCompressor) Name() string { return config.CompressionZstd }
+
+// zstdReader releases the resources of the decoder once it's read to the end.
+type zstdReader struct {
+	*zstd.Decoder
+	closed bool
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, io.EOF
+	}
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.Decoder.Close()
+		r.closed = true
+	}
+	return n, err
+}
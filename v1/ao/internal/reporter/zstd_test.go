@@ -0,0 +1,40 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestZstdCompressor(t *testing.T) {
+	c := encoding.GetCompressor(config.CompressionZstd)
+	require.NotNil(t, c)
+	msg := []byte(strings.Repeat(`{"Layer": "http.HandlerFunc", "Label": "entry"}`, 100))
+
+	// the encoder is reused by the following requests
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		w, err := c.Compress(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(msg)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Less(t, buf.Len(), len(msg)/10)
+
+		r, err := c.Decompress(&buf)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, msg, b)
+		_, err = r.Read(make([]byte, 1))
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package utils
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// BuildInfo is the build information of the application binary, which helps
+// to find out the build introducing a regression.
+type BuildInfo struct {
+	// the path and version of the main module
+	ModulePath    string
+	ModuleVersion string
+	// the VCS revision, commit time and if the working tree was modified,
+	// which are only available when built by Go 1.18 or later
+	VCSRevision string
+	VCSTime     string
+	VCSModified bool
+	GOOS        string
+	GOARCH      string
+}
+
+var buildInfo = initBuildInfo()
+
+// AppBuildInfo returns the build information of the application binary
+func AppBuildInfo() BuildInfo {
+	return buildInfo
+}
+
+func initBuildInfo() BuildInfo {
+	info := BuildInfo{
+		GOOS:   runtime.GOOS,
+		GOARCH: runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.ModulePath = bi.Main.Path
+	info.ModuleVersion = bi.Main.Version
+	info.VCSRevision, info.VCSTime, info.VCSModified = vcsInfo(bi)
+	return info
+}
@@ -0,0 +1,23 @@
+// +build go1.18
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package utils
+
+import "runtime/debug"
+
+// vcsInfo returns the VCS information stamped into the binary by Go 1.18 or
+// later
+func vcsInfo(bi *debug.BuildInfo) (revision, time string, modified bool) {
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			time = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	return revision, time, modified
+}
@@ -0,0 +1,13 @@
+// +build !go1.18
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package utils
+
+import "runtime/debug"
+
+// vcsInfo returns nothing as the VCS information is not stamped into the
+// binary before Go 1.18
+func vcsInfo(bi *debug.BuildInfo) (revision, time string, modified bool) {
+	return "", "", false
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package utils
+
+import "sync"
+
+// Interner dedups the strings seen repeatedly, e.g., the transaction names and
+// the metric IDs which are the same for most of the requests. A string built
+// from bytes isn't allocated if it's interned already.
+//
+// It holds up to a maximum number of strings, the strings not seen before are
+// returned as they are once it's full, so high-cardinality values like the
+// request paths can't make it grow unboundedly.
+type Interner struct {
+	sync.RWMutex
+	m   map[string]string
+	max int
+}
+
+// NewInterner returns an Interner holding up to max strings.
+func NewInterner(max int) *Interner {
+	return &Interner{m: make(map[string]string), max: max}
+}
+
+// Intern returns the interned string equal to s. The string is copied when
+// interned, so a substring doesn't keep its original string alive.
+func (i *Interner) Intern(s string) string {
+	i.RLock()
+	is, ok := i.m[s]
+	i.RUnlock()
+	if ok {
+		return is
+	}
+	return i.add(s, true)
+}
+
+// InternBytes returns the interned string equal to b, it's only allocated if
+// b hasn't been seen before.
+func (i *Interner) InternBytes(b []byte) string {
+	i.RLock()
+	// the conversion in the map index expression doesn't allocate
+	is, ok := i.m[string(b)]
+	i.RUnlock()
+	if ok {
+		return is
+	}
+	return i.add(string(b), false)
+}
+
+func (i *Interner) add(s string, clone bool) string {
+	i.Lock()
+	defer i.Unlock()
+	if is, ok := i.m[s]; ok {
+		return is
+	}
+	if len(i.m) >= i.max {
+		return s
+	}
+	if clone {
+		s = string([]byte(s))
+	}
+	i.m[s] = s
+	return s
+}
+
+// Len returns the number of the strings interned.
+func (i *Interner) Len() int {
+	i.RLock()
+	defer i.RUnlock()
+	return len(i.m)
+}
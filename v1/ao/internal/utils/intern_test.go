@@ -0,0 +1,33 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterner(t *testing.T) {
+	i := NewInterner(2)
+	path := "/users/123"
+	a := i.Intern(path[:6])
+	assert.Equal(t, "/users", a)
+	assert.Equal(t, a, i.InternBytes([]byte("/users")))
+	assert.Equal(t, 1, i.Len())
+
+	assert.Equal(t, "GET", i.InternBytes([]byte("GET")))
+	// it's full, the new strings are returned but not interned
+	assert.Equal(t, "POST", i.Intern("POST"))
+	assert.Equal(t, "PUT", i.InternBytes([]byte("PUT")))
+	assert.Equal(t, 2, i.Len())
+}
+
+func BenchmarkInternBytes(b *testing.B) {
+	i := NewInterner(100)
+	buf := []byte("TransactionResponseTime&true&TransactionName:/users&")
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_ = i.InternBytes(buf)
+	}
+}
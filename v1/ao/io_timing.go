@@ -0,0 +1,180 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The KVs reporting the I/O through the wrappers
+const (
+	keyBytesRead    = "BytesRead"
+	keyBytesWritten = "BytesWritten"
+	keyReadTime     = "ReadTime_us"
+	keyWriteTime    = "WriteTime_us"
+)
+
+// WrapConn returns a net.Conn which measures the bytes read and written through
+// c and the time spent in the reads and writes. They are reported as an info
+// event of the span bound to ctx when the connection is closed:
+//   conn, err := net.Dial("tcp", addr)
+//   conn = ao.WrapConn(ctx, conn)
+//   defer conn.Close()
+// The connection is returned as is if the span is not reporting.
+func WrapConn(ctx context.Context, c net.Conn) net.Conn {
+	l := FromContext(ctx)
+	if c == nil || !l.IsReporting() {
+		return c
+	}
+	return &timedConn{Conn: c, stats: &ioStats{span: l}}
+}
+
+// WrapReader returns an io.ReadCloser which measures the bytes read from r and
+// the time spent in the reads, e.g., of a large streaming download. They are
+// reported as an info event of the span bound to ctx when it's closed, which
+// closes r as well if it's an io.Closer.
+func WrapReader(ctx context.Context, r io.Reader) io.ReadCloser {
+	l := FromContext(ctx)
+	if !l.IsReporting() {
+		if rc, ok := r.(io.ReadCloser); ok {
+			return rc
+		}
+		return ioutil.NopCloser(r)
+	}
+	return &timedReader{r: r, stats: &ioStats{span: l}}
+}
+
+// WrapWriter returns an io.WriteCloser which measures the bytes written to w
+// and the time spent in the writes, see WrapReader.
+func WrapWriter(ctx context.Context, w io.Writer) io.WriteCloser {
+	l := FromContext(ctx)
+	if !l.IsReporting() {
+		if wc, ok := w.(io.WriteCloser); ok {
+			return wc
+		}
+		return nopWriteCloser{w}
+	}
+	return &timedWriter{w: w, stats: &ioStats{span: l}}
+}
+
+type ioStats struct {
+	span Span
+	// the following fields are accessed atomically
+	bytesRead    int64
+	bytesWritten int64
+	readTime     int64 // in nanoseconds
+	writeTime    int64
+	reads        int32 // the number of the reads
+	writes       int32
+
+	reportOnce sync.Once
+}
+
+func (s *ioStats) addRead(n int, d time.Duration) {
+	atomic.AddInt32(&s.reads, 1)
+	atomic.AddInt64(&s.bytesRead, int64(n))
+	atomic.AddInt64(&s.readTime, int64(d))
+}
+
+func (s *ioStats) addWrite(n int, d time.Duration) {
+	atomic.AddInt32(&s.writes, 1)
+	atomic.AddInt64(&s.bytesWritten, int64(n))
+	atomic.AddInt64(&s.writeTime, int64(d))
+}
+
+// report reports the stats once, only of the directions used.
+func (s *ioStats) report() {
+	s.reportOnce.Do(func() {
+		var kvs []interface{}
+		if atomic.LoadInt32(&s.reads) > 0 {
+			kvs = append(kvs, keyBytesRead, atomic.LoadInt64(&s.bytesRead),
+				keyReadTime, atomic.LoadInt64(&s.readTime)/int64(time.Microsecond))
+		}
+		if atomic.LoadInt32(&s.writes) > 0 {
+			kvs = append(kvs, keyBytesWritten, atomic.LoadInt64(&s.bytesWritten),
+				keyWriteTime, atomic.LoadInt64(&s.writeTime)/int64(time.Microsecond))
+		}
+		if len(kvs) > 0 {
+			s.span.Info(kvs...)
+		}
+	})
+}
+
+type timedConn struct {
+	net.Conn
+	stats *ioStats
+}
+
+func (c *timedConn) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Read(b)
+	c.stats.addRead(n, time.Since(start))
+	return n, err
+}
+
+func (c *timedConn) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(b)
+	c.stats.addWrite(n, time.Since(start))
+	return n, err
+}
+
+func (c *timedConn) Close() error {
+	err := c.Conn.Close()
+	c.stats.report()
+	return err
+}
+
+type timedReader struct {
+	r     io.Reader
+	stats *ioStats
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.r.Read(p)
+	r.stats.addRead(n, time.Since(start))
+	return n, err
+}
+
+func (r *timedReader) Close() error {
+	var err error
+	if c, ok := r.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	r.stats.report()
+	return err
+}
+
+type timedWriter struct {
+	w     io.Writer
+	stats *ioStats
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.w.Write(p)
+	w.stats.addWrite(n, time.Since(start))
+	return n, err
+}
+
+func (w *timedWriter) Close() error {
+	var err error
+	if c, ok := w.w.(io.Closer); ok {
+		err = c.Close()
+	}
+	w.stats.report()
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapReaderWriter(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("ioTrace"))
+	_, dctx := BeginSpan(ctx, "download")
+	_, uctx := BeginSpan(ctx, "upload")
+
+	rc := WrapReader(dctx, strings.NewReader("hello world"))
+	var buf bytes.Buffer
+	wc := WrapWriter(uctx, &buf)
+	n, err := io.Copy(wc, rc)
+	require.NoError(t, err)
+	assert.EqualValues(t, 11, n)
+	assert.NoError(t, rc.Close())
+	assert.NoError(t, rc.Close()) // reported once
+	assert.NoError(t, wc.Close())
+	assert.Equal(t, "hello world", buf.String())
+	End(dctx)
+	End(uctx)
+	EndTrace(ctx)
+	r.Close(8)
+
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"ioTrace", "entry"}:  {},
+		{"download", "entry"}: {Edges: g.Edges{{"ioTrace", "entry"}}},
+		{"upload", "entry"}:   {Edges: g.Edges{{"ioTrace", "entry"}}},
+		{"download", "info"}: {Edges: g.Edges{{"download", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 11, n.Map[keyBytesRead])
+			assert.Contains(t, n.Map, keyReadTime)
+			assert.NotContains(t, n.Map, keyBytesWritten)
+		}},
+		{"upload", "info"}: {Edges: g.Edges{{"upload", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 11, n.Map[keyBytesWritten])
+			assert.Contains(t, n.Map, keyWriteTime)
+			assert.NotContains(t, n.Map, keyBytesRead)
+		}},
+		{"download", "exit"}: {Edges: g.Edges{{"download", "info"}}},
+		{"upload", "exit"}:   {Edges: g.Edges{{"upload", "info"}}},
+		{"ioTrace", "exit"}:  {Edges: g.Edges{{"download", "exit"}, {"upload", "exit"}, {"ioTrace", "entry"}}},
+	})
+}
+
+func TestWrapConn(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("connTrace"))
+
+	client, server := net.Pipe()
+	go func() {
+		b, _ := ioutil.ReadAll(io.LimitReader(server, 4))
+		_, _ = server.Write(append(b, '!'))
+		server.Close()
+	}()
+	c := WrapConn(ctx, client)
+	_, err := c.Write([]byte("ping"))
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(c)
+	require.NoError(t, err)
+	assert.Equal(t, "ping!", string(b))
+	assert.NoError(t, c.Close())
+	EndTrace(ctx)
+	r.Close(3)
+
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"connTrace", "entry"}: {},
+		{"connTrace", "info"}: {Edges: g.Edges{{"connTrace", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 5, n.Map[keyBytesRead])
+			assert.EqualValues(t, 4, n.Map[keyBytesWritten])
+			assert.Contains(t, n.Map, keyReadTime)
+			assert.Contains(t, n.Map, keyWriteTime)
+		}},
+		{"connTrace", "exit"}: {Edges: g.Edges{{"connTrace", "info"}}},
+	})
+}
+
+func TestWrapNotReporting(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	assert.Equal(t, client, WrapConn(context.Background(), client))
+	rc := ioutil.NopCloser(strings.NewReader(""))
+	assert.Equal(t, rc, WrapReader(context.Background(), rc))
+	var buf bytes.Buffer
+	wc := WrapWriter(context.Background(), &buf)
+	_, _ = wc.Write([]byte("x"))
+	assert.NoError(t, wc.Close())
+	assert.Equal(t, "x", buf.String())
+}
@@ -6,9 +6,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"runtime/debug"
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 )
 
@@ -113,10 +115,17 @@ type Span interface {
 	// GetTransactionName returns the current value of the transaction name
 	GetTransactionName() string
 
+	// SetSpanStatus sets the status of this Span, independent of the HTTP
+	// status set by Trace.SetStatus.
+	// The errors reported set it to StatusError unless it's set explicitly.
+	SetSpanStatus(code StatusCode, msg string)
+
 	IsReporting() bool
 	addChildEdge(reporter.Context)
+	addChildTiming(string, time.Duration)
 	addProfile(Profile)
 	aoContext() reporter.Context
+	boundContext() context.Context
 	ok() bool
 }
 
@@ -137,12 +146,46 @@ type Profile interface {
 type SpanOptions struct {
 	// WithBackTrace indicates whether to include the backtrace in BeginSpan
 	// Keep in mind that the cost of this option may be high as it calls
-	// `debug.Stack()` internally to gather the stack trace. Please consider
-	// the impact on performance/memory footprint carefully.
+	// `runtime.Stack()` internally to gather the stack trace. Please consider
+	// the impact on performance/memory footprint carefully. The backtraces
+	// captured are bounded by APPOPTICS_BACKTRACE_RATE and
+	// APPOPTICS_BACKTRACE_CONCURRENCY, the spans beyond them are reported
+	// without one.
 	WithBackTrace bool
 
 	ContextOptions
 	TransactionName string
+
+	// Kind is the kind of the span, reported as the SpanKind KV of its entry
+	// event. Nothing is reported if it's unspecified.
+	//   s, ctx := ao.BeginSpanWithOptions(ctx, "kafka.produce", ao.SpanOptions{Kind: ao.SpanKindProducer})
+	Kind SpanKind
+
+	// RUMCorrelation indicates whether to inject the trace context into HTML
+	// responses (as a `Server-Timing: traceparent` header) for the browser RUM
+	// tools. It's only used by the HTTP instrumentation.
+	RUMCorrelation bool
+
+	// PropagateHosts is the list of host patterns the trace context headers
+	// are injected into, which overrides APPOPTICS_PROPAGATE_HOSTS. It's only
+	// used by the HTTP client instrumentation.
+	PropagateHosts []string
+
+	// ThrottleMetric enables the AppOptics.HTTPClient.Throttled metric of
+	// the 429 and 503 responses, tagged by the peer host. It's only used by
+	// the HTTP client instrumentation.
+	ThrottleMetric bool
+
+	// SpanNamer returns the name of the span of a request, or an empty
+	// string to keep the default one. It's only used by the HTTP client
+	// instrumentation.
+	SpanNamer func(*http.Request) string
+
+	// Controller and Action are the Controller and Action KVs of the requests,
+	// which override the ones derived from the name of the handler. They're
+	// only used by the HTTP instrumentation.
+	Controller string
+	Action     string
 }
 
 // SpanOpt defines the function type that changes the SpanOptions
@@ -155,6 +198,32 @@ func WithBackTrace() SpanOpt {
 	}
 }
 
+// WithLazyKVs returns a function that sets the callback producing the KVs of
+// the entry event of the span, which is only called if the trace is sampled,
+// so the KVs expensive to compute are skipped for the traces not reported:
+//   ao.HTTPHandler(myHandler, ao.WithLazyKVs(func() ao.KVMap {
+//   	return ao.KVMap{"Summary": summarize(req)}
+//   }))
+// The callback of BeginSpanWithOptions is set by SpanOptions.CB instead.
+func WithLazyKVs(cb func() KVMap) SpanOpt {
+	return func(o *SpanOptions) {
+		o.CB = cb
+	}
+}
+
+// lazyKVs returns the KVs produced by the callback, if any, and only if the
+// span is sampled.
+func lazyKVs(sampled bool, cb func() KVMap) []interface{} {
+	if cb == nil || !sampled {
+		return nil
+	}
+	var kvs []interface{}
+	for k, v := range cb() {
+		kvs = append(kvs, k, v)
+	}
+	return kvs
+}
+
 // BeginSpan starts a new Span, provided a parent context and name. It returns a Span
 // and context bound to the new child Span.
 func BeginSpan(ctx context.Context, spanName string, args ...interface{}) (Span, context.Context) {
@@ -164,8 +233,13 @@ func BeginSpan(ctx context.Context, spanName string, args ...interface{}) (Span,
 // addKVsFromOpts adds the KVs correspond to the options to the args
 func addKVsFromOpts(opts SpanOptions, args ...interface{}) []interface{} {
 	kvs := args
+	if kindArgs := spanKindArgs(opts.Kind); kindArgs != nil {
+		kvs = mergeKVs(kvs, kindArgs)
+	}
 	if opts.WithBackTrace {
-		kvs = mergeKVs(args, []interface{}{KeyBackTrace, string(debug.Stack())})
+		if bt := captureBackTrace(); bt != "" {
+			kvs = mergeKVs(kvs, []interface{}{KeyBackTrace, bt})
+		}
 	}
 	return kvs
 }
@@ -199,12 +273,29 @@ func fromKVs(kvs ...interface{}) KVMap {
 func BeginSpanWithOptions(ctx context.Context, spanName string, opts SpanOptions, args ...interface{}) (Span, context.Context) {
 	kvs := addKVsFromOpts(opts, args...)
 	if parent, ok := fromContext(ctx); ok && parent.ok() { // report span entry from parent context
-		l := newSpan(parent.aoContext().Copy(), spanName, parent, kvs...)
-		return l, newSpanContext(ctx, l)
+		validateSpanKVs(spanName, args)
+		kvs = mergeKVs(kvs, lazyKVs(parent.IsSampled(), opts.CB))
+		return beginChildSpan(ctx, parent, spanName, kvs)
 	}
 	return nullSpan{}, ctx
 }
 
+// beginChildSpan starts the child span of the parent bound to the context ctx
+// with the KVs of the entry event, which are reported as is.
+func beginChildSpan(ctx context.Context, parent Span, spanName string, kvs []interface{}) (Span, context.Context) {
+	if f := flattenChild(parent, spanName, kvs); f != nil {
+		return f, newSpanContext(ctx, f)
+	}
+	if c := compressChild(parent, spanName, kvs); c != nil {
+		return c, newSpanContext(ctx, c)
+	}
+	l := newSpan(parent.aoContext().Copy(), spanName, parent, kvs...)
+	if ls, ok := l.(*layerSpan); ok {
+		ls.bindContext(ctx)
+	}
+	return l, newSpanContext(ctx, l)
+}
+
 // BeginSpan starts a new Span, returning a child of this Span.
 func (s *layerSpan) BeginSpan(spanName string, args ...interface{}) Span {
 	return s.BeginSpanWithOptions(spanName, SpanOptions{}, args...)
@@ -213,7 +304,14 @@ func (s *layerSpan) BeginSpan(spanName string, args ...interface{}) Span {
 // BeginSpanWithOptions starts a new child span with provided options
 func (s *layerSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span {
 	if s.ok() { // copy parent context and report entry from child
-		kvs := addKVsFromOpts(opts, args...)
+		validateSpanKVs(spanName, args)
+		kvs := mergeKVs(addKVsFromOpts(opts, args...), lazyKVs(s.IsSampled(), opts.CB))
+		if f := flattenChild(s, spanName, kvs); f != nil {
+			return f
+		}
+		if c := compressChild(s, spanName, kvs); c != nil {
+			return c
+		}
 		return newSpan(s.aoCtx.Copy(), spanName, s, kvs...)
 	}
 	return nullSpan{}
@@ -242,15 +340,42 @@ func (s *layerSpan) BeginProfile(profileName string, args ...interface{}) Profil
 	return s.BeginSpan(profileName, args)
 }
 
+// durationArgs returns the KV of the monotonic duration of the span, if it's
+// enabled by APPOPTICS_REPORT_SPAN_DURATION.
+func (s *span) durationArgs() []interface{} {
+	if !config.GetReportSpanDuration() || s.start.IsZero() {
+		return nil
+	}
+	return []interface{}{keySpanDuration, int64(s.elapsed())}
+}
+
+// elapsed returns the duration of the span until it ends, or until now if the
+// end is not set.
+func (s *span) elapsed() time.Duration {
+	if !s.end.IsZero() {
+		return s.end.Sub(s.start)
+	}
+	return time.Since(s.start)
+}
+
 // End a profiled block or method.
 func (s *span) End(args ...interface{}) {
 	if s.ok() {
+		validateSpanKVs(s.layerName(), args)
+		s.children.flush()
+		cancelArgs := s.cancellationArgs()
 		s.lock.Lock()
 		defer s.lock.Unlock()
 		for _, prof := range s.childProfiles {
 			prof.End()
 		}
 		args = append(args, s.endArgs...)
+		args = append(args, cancelArgs...)
+		args = append(args, s.statusArgs()...)
+		args = append(args, s.durationArgs()...)
+		if !s.end.IsZero() {
+			args = append(args, reporter.TimestampKey, s.end)
+		}
 		for _, edge := range s.childEdges { // add Edge KV for each joined child
 			args = append(args, keyEdge, edge)
 		}
@@ -261,6 +386,7 @@ func (s *span) End(args ...interface{}) {
 		// add this span's context to list to be used as Edge by parent exit
 		if s.parent != nil && s.parent.ok() {
 			s.parent.addChildEdge(s.aoCtx)
+			s.parent.addChildTiming(s.layerName(), s.elapsed())
 		}
 	}
 }
@@ -273,6 +399,7 @@ func (s *layerSpan) AddEndArgs(args ...interface{}) {
 		if len(args)%2 == 1 {
 			args = args[0 : len(args)-1]
 		}
+		validateSpanKVs(s.layerName(), args)
 		s.lock.Lock()
 		s.endArgs = append(s.endArgs, args...)
 		s.lock.Unlock()
@@ -287,6 +414,7 @@ func (s *layerSpan) Info(args ...interface{}) {
 // InfoWithOptions reports a new info event with the KVs and options provided
 func (s *layerSpan) InfoWithOptions(opts SpanOptions, args ...interface{}) {
 	if s.ok() {
+		validateSpanKVs(s.layerName(), args)
 		kvs := addKVsFromOpts(opts, args...)
 		s.aoCtx.ReportEvent(reporter.LabelInfo, s.layerName(), kvs...)
 	}
@@ -399,23 +527,38 @@ func (s *span) ErrorWithOpts(opts... ErrOpt) {
 		opt(errOpts)
 	}
 
+	if errOpts.Type == ErrTypeStatus {
+		errOpts.Class = ErrClassHTTPError
+	}
+
+	if !s.ok() {
+		return
+	}
+	s.recordErrorStatus(errOpts.Msg)
+	s.countError()
+	// the identical errors are limited by APPOPTICS_ERROR_EVENTS_PER_MINUTE
+	fp := errorFingerprint{s.layerName(), string(errOpts.Type), errOpts.Class, errOpts.Msg}
+	allowed, suppressed := errorEvents.allow(fp, time.Now())
+	if !allowed {
+		return
+	}
+
 	var backTrace string
 	if errOpts.WithBackTrace {
-		backTrace = string(debug.Stack())
+		backTrace = captureBackTrace()
 	}
 
-	if errOpts.Type == ErrTypeStatus {
-		errOpts.Class = ErrClassHTTPError
+	args := []interface{}{
+		keySpec, "error",
+		keyErrorType, errOpts.Type,
+		keyErrorClass, errOpts.Class,
+		keyErrorMsg, errOpts.Msg,
+		KeyBackTrace, backTrace,
 	}
-	
-	if s.ok() {
-		s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(),
-			keySpec, "error",
-			keyErrorType, errOpts.Type,
-			keyErrorClass, errOpts.Class,
-			keyErrorMsg, errOpts.Msg,
-			KeyBackTrace, backTrace)
+	if suppressed > 0 {
+		args = append(args, keySuppressedCount, suppressed)
 	}
+	s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(), args...)
 }
 
 // Error reports an error, distinguished by its class and message
@@ -437,10 +580,18 @@ type span struct {
 	labeler
 	aoCtx         reporter.Context
 	parent        Span
+	ctx           context.Context // the context the span is started from
 	childEdges    []string // for reporting in exit event
 	childProfiles []Profile
 	endArgs       []interface{}
-	ended         bool // has exit event been reported?
+	ended         bool          // has exit event been reported?
+	start         time.Time     // when the entry event was reported
+	end           time.Time     // when the span ended, if it's reported afterwards
+	timings       *childTimings // for the Server-Timing header, root span only
+	status        spanStatus
+	children      spanCompressor // the current run of the child spans
+	depth         int            // the number of the ancestors
+	errors        *int64         // the errors of the trace, shared by its spans
 	lock          sync.RWMutex
 }
 type layerSpan struct{ span }   // satisfies Span
@@ -461,15 +612,18 @@ func (s nullSpan) Info(args ...interface{})                              {}
 func (s nullSpan) InfoWithOptions(opts SpanOptions, args ...interface{}) {}
 func (s nullSpan) IsReporting() bool                                     { return false }
 func (s nullSpan) addChildEdge(reporter.Context)                         {}
+func (s nullSpan) addChildTiming(string, time.Duration)                  {}
 func (s nullSpan) addProfile(Profile)                                    {}
 func (s nullSpan) ok() bool                                              { return false }
 func (s nullSpan) aoContext() reporter.Context                           { return reporter.NewNullContext() }
+func (s nullSpan) boundContext() context.Context                         { return nil }
 func (s nullSpan) MetadataString() string                                { return "" }
 func (s nullSpan) IsSampled() bool                                       { return false }
 func (s nullSpan) SetAsync(bool)                                         {}
 func (s nullSpan) SetOperationName(string)                               {}
 func (s nullSpan) SetTransactionName(string) error                       { return nil }
 func (s nullSpan) GetTransactionName() string                            { return "" }
+func (s nullSpan) SetSpanStatus(StatusCode, string)                      {}
 
 // is this span still valid (has it timed out, expired, not sampled)
 func (s *span) ok() bool {
@@ -513,6 +667,9 @@ func (l spanLabeler) exitLabel() reporter.Label  { return reporter.LabelExit }
 func (l spanLabeler) layerName() string          { return l.name }
 func (l spanLabeler) setName(name string)        { l.name = name }
 
+// The spans are not pooled as they're returned to the callers, which may keep
+// using them after End, e.g., as the parents of new spans or from other
+// goroutines. Only the events reported by them are reused.
 func newSpan(aoCtx reporter.Context, spanName string, parent Span, args ...interface{}) Span {
 	if spanName == "" {
 		return nullSpan{}
@@ -522,6 +679,13 @@ func newSpan(aoCtx reporter.Context, spanName string, parent Span, args ...inter
 	if err := aoCtx.ReportEvent(ll.entryLabel(), ll.layerName(), args...); err != nil {
 		return nullSpan{}
 	}
-	return &layerSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent}}
+	l := &layerSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent, start: time.Now()}}
+	if p, ok := parent.(interface{ spanDepth() int }); ok {
+		l.depth = p.spanDepth() + 1
+	}
+	if p, ok := parent.(interface{ errorCounter() *int64 }); ok {
+		l.errors = p.errorCounter()
+	}
+	return l
 
 }
@@ -159,6 +159,9 @@ func TestAddKVsFromOpts(t *testing.T) {
 
 	kvs = addKVsFromOpts(SpanOptions{WithBackTrace: true}, "hello", 1)
 	assert.Equal(t, 4, len(kvs))
+
+	kvs = addKVsFromOpts(SpanOptions{Kind: SpanKindClient}, "hello", 1)
+	assert.Equal(t, []interface{}{"hello", 1, keySpanKind, "client"}, kvs)
 }
 
 func TestMergeKVs(t *testing.T) {
@@ -0,0 +1,68 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// Drain sends the pending events and shuts down the agent, waiting up to the
+// drain timeout configured by APPOPTICS_DRAIN_TIMEOUT. It's meant to be called
+// when the process is stopping, e.g., in the handler of SIGTERM. If the process
+// is managed by systemd, the service manager is notified about the draining.
+func Drain() error {
+	sdNotify("STOPPING=1\nSTATUS=Draining AppOptics events")
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetDrainTimeout())
+	defer cancel()
+	return Shutdown(ctx)
+}
+
+var drainOnce sync.Once
+
+// DrainOnSignals drains the agent when the process receives one of the
+// signals, SIGINT and SIGTERM by default, and then re-raises the signal so the
+// process terminates as it would without the handler. It returns a function to
+// remove the handler. Applications handling the signals themselves should call
+// Drain in their own handlers instead.
+//   func main() {
+//       defer ao.DrainOnSignals()()
+//       // ...
+//   }
+func DrainOnSignals(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, signals...)
+
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Warningf("Received signal %v, draining the agent.", sig)
+			drainOnce.Do(func() {
+				if err := Drain(); err != nil {
+					log.Warningf("Failed to drain the agent: %v", err)
+				}
+			})
+			signal.Stop(ch)
+			raise(sig)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// +build !windows
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSDNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ao-notify")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	addr := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", addr)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sdNotify("STOPPING=1")
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "STOPPING=1", string(buf[:n]))
+
+	// no socket, no notification
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.NotPanics(t, func() { sdNotify("STOPPING=1") })
+}
+
+func TestDrainOnSignalsStop(t *testing.T) {
+	stop := DrainOnSignals(syscall.SIGUSR2)
+	stop()
+	stop() // idempotent
+
+	// the handler is removed so the signal only reaches the handler of the test
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	defer signal.Stop(ch)
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("signal not received")
+	}
+}
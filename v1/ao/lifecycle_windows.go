@@ -0,0 +1,56 @@
+// +build windows
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// raise terminates the process as signals can't be re-raised on Windows.
+func raise(sig os.Signal) {
+	os.Exit(1)
+}
+
+// sdNotify is a no-op as there is no systemd on Windows.
+func sdNotify(state string) {}
+
+// ServiceHandler wraps the handler of a Windows service so the agent is
+// drained when the service is requested to stop or the system is shutting
+// down, before the request is passed to the handler.
+//   err := svc.Run("myservice", ao.ServiceHandler(&myService{}))
+func ServiceHandler(h svc.Handler) svc.Handler {
+	return &serviceHandler{h}
+}
+
+type serviceHandler struct {
+	svc.Handler
+}
+
+func (s *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	requests := make(chan svc.ChangeRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case c := <-r:
+				if c.Cmd == svc.Stop || c.Cmd == svc.Shutdown {
+					changes <- svc.Status{State: svc.StopPending}
+					_ = Drain()
+				}
+				select {
+				case requests <- c:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return s.Handler.Execute(args, requests, changes)
+}
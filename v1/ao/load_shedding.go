@@ -0,0 +1,126 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var loadShedKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.LoadShed")
+
+// keyLoadShed is the KV of the exit event of the requests tagged for shedding.
+const keyLoadShed = "LoadShed"
+
+// the weight of the latest request in the moving average of the latency
+const loadLatencyWeight = 0.1
+
+// LoadStats is the load of the service as measured by the agent.
+type LoadStats struct {
+	// InFlight is the number of the HTTP requests being served, excluding the
+	// one the LoadShedder is consulted for
+	InFlight int64
+	// AvgLatency is the exponentially weighted moving average of the
+	// durations of the recent HTTP requests, where the latest one weighs 10%.
+	// The requests tagged for shedding are excluded. It's zero if no requests
+	// have been served yet.
+	AvgLatency time.Duration
+}
+
+// LoadShedder decides if an HTTP request should be shed given the current load
+// of the service. It's called before the trace of the request is started, so
+// it should return quickly.
+type LoadShedder func(r *http.Request, stats LoadStats) bool
+
+var load struct {
+	sync.RWMutex
+	shedder LoadShedder
+
+	// accessed through atomic operations
+	inFlight   int64
+	avgLatency int64 // in nanoseconds
+}
+
+// SetLoadShedder sets the callback consulted before the traces of the HTTP
+// requests traced by HTTPHandler or TraceFromHTTPRequestResponse are started,
+// so admission control can be driven by the measurements of the agent. The
+// requests it returns true for are tagged for shedding, which is reported by
+// the LoadShed KV of the exit event, and the handler is expected to reject
+// them, e.g.:
+//   ao.SetLoadShedder(func(r *http.Request, s ao.LoadStats) bool {
+//   	return s.InFlight > 100 && s.AvgLatency > time.Second
+//   })
+//   func myHandler(w http.ResponseWriter, r *http.Request) {
+//   	if ao.ShouldShed(r.Context()) {
+//   		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+//   		return
+//   	}
+//   	// ...
+//   }
+// Passing nil removes the callback.
+func SetLoadShedder(s LoadShedder) {
+	load.Lock()
+	defer load.Unlock()
+	load.shedder = s
+}
+
+// ShouldShed returns if the request of the context ctx was tagged for shedding
+// by the LoadShedder.
+func ShouldShed(ctx context.Context) bool {
+	shed, _ := ctx.Value(loadShedKey).(bool)
+	return shed
+}
+
+// consultLoadShedder returns if the request should be shed, as decided by the
+// LoadShedder, if any.
+func consultLoadShedder(r *http.Request) bool {
+	load.RLock()
+	s := load.shedder
+	load.RUnlock()
+	if s == nil {
+		return false
+	}
+	return s(r, LoadStats{
+		InFlight:   atomic.LoadInt64(&load.inFlight),
+		AvgLatency: time.Duration(atomic.LoadInt64(&load.avgLatency)),
+	})
+}
+
+// requestLoad is the load of a request being served.
+type requestLoad struct {
+	shed bool
+	once sync.Once
+}
+
+// startRequestLoad counts the request as in flight until it's finished.
+func startRequestLoad(shed bool) *requestLoad {
+	atomic.AddInt64(&load.inFlight, 1)
+	return &requestLoad{shed: shed}
+}
+
+// finish counts the request as no longer in flight and feeds its duration into
+// the moving average of the latency, unless it's shed.
+func (l *requestLoad) finish(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		atomic.AddInt64(&load.inFlight, -1)
+		if l.shed {
+			return
+		}
+		for {
+			old := atomic.LoadInt64(&load.avgLatency)
+			avg := int64(d)
+			if old != 0 {
+				avg = old + int64(loadLatencyWeight*float64(int64(d)-old))
+			}
+			if atomic.CompareAndSwapInt64(&load.avgLatency, old, avg) {
+				return
+			}
+		}
+	})
+}
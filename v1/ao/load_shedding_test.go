@@ -0,0 +1,62 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func handlerShed(w http.ResponseWriter, r *http.Request) {
+	if ao.ShouldShed(r.Context()) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func handlerShedOuter(w http.ResponseWriter, r *http.Request) {
+	// served while this request is in flight
+	httpTestWithEndpointWithHeaders(handlerShed, "http://test.com/shed", map[string]string{"X-Shed": "1"})
+}
+
+func TestLoadShedder(t *testing.T) {
+	var stats []ao.LoadStats
+	ao.SetLoadShedder(func(r *http.Request, s ao.LoadStats) bool {
+		stats = append(stats, s)
+		return r.Header.Get("X-Shed") != ""
+	})
+	defer ao.SetLoadShedder(nil)
+
+	r := reporter.SetTestReporter()
+	response := httpTestWithEndpoint(handlerShedOuter, "http://test.com/outer")
+	assert.Equal(t, http.StatusOK, response.Code)
+	httpTestWithEndpoint(handler200, "http://test.com/after")
+	r.Close(6)
+
+	require.Len(t, stats, 3)
+	assert.EqualValues(t, 0, stats[0].InFlight)
+	assert.EqualValues(t, 1, stats[1].InFlight)
+	assert.EqualValues(t, 0, stats[2].InFlight)
+	assert.True(t, stats[2].AvgLatency > 0)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeKVMap{
+		{"http.HandlerFunc", "entry", "URL", "/outer"}: {},
+		{"http.HandlerFunc", "entry", "URL", "/shed"}:  {},
+		{"http.HandlerFunc", "entry", "URL", "/after"}: {},
+		{"http.HandlerFunc", "exit", "Action", "handlerShedOuter"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "LoadShed")
+		}},
+		{"http.HandlerFunc", "exit", "Action", "handlerShed"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, true, n.Map["LoadShed"])
+			assert.EqualValues(t, http.StatusServiceUnavailable, n.Map["Status"])
+		}},
+		{"http.HandlerFunc", "exit", "Action", "handler200"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "LoadShed")
+		}},
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// otherMetricTagValue replaces the values of a metric tag beyond the limit of
+// APPOPTICS_METRIC_TAG_VALUES_MAX.
+const otherMetricTagValue = "other"
+
+// the distinct values of the metric tags seen so far
+var metricTagValues = struct {
+	sync.Mutex
+	m map[string]map[string]struct{}
+}{m: make(map[string]map[string]struct{})}
+
+// limitMetricTagValue returns the value of the metric tag, or "other" if the
+// tag has had APPOPTICS_METRIC_TAG_VALUES_MAX distinct values already. The
+// first values seen are kept for the lifetime of the process, so the metrics
+// split by them stay continuous.
+func limitMetricTagValue(tag, val string) string {
+	metricTagValues.Lock()
+	defer metricTagValues.Unlock()
+	values, ok := metricTagValues.m[tag]
+	if !ok {
+		values = make(map[string]struct{})
+		metricTagValues.m[tag] = values
+	}
+	if _, ok := values[val]; ok {
+		return val
+	}
+	if len(values) >= config.GetMetricTagValuesMax() {
+		return otherMetricTagValue
+	}
+	values[val] = struct{}{}
+	return val
+}
+
+// metricTags returns the values of the KVs of the root span which tag the
+// transaction metrics, as configured by APPOPTICS_METRIC_TAGS. They're looked
+// up in the values promoted by SetTraceValue, the KVs of the exit event, e.g.,
+// added by AddEndArgs, and the KVs of the entry event, in that order. The lock
+// must be held by the caller.
+func (t *aoTrace) metricTags() map[string]string {
+	names := config.GetMetricTags()
+	if len(names) == 0 {
+		return nil
+	}
+	var tags map[string]string
+	for _, name := range names {
+		val, ok := t.endArgValue(name)
+		if !ok {
+			val, ok = t.entryArgs[name]
+		}
+		if v, set := t.values.get(name); set && v.promote {
+			val, ok = v.val, true
+		}
+		if !ok {
+			continue
+		}
+		s, ok := metricTagString(val)
+		if !ok {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string, len(names))
+		}
+		tags[name] = limitMetricTagValue(name, s)
+	}
+	return tags
+}
+
+// metricTagString returns the value of a KV as a metric tag value. The
+// pointers accepted by AddKV for the delayed evaluation, e.g., *string or *int,
+// are dereferenced, and it returns false for a nil one.
+func metricTagString(val interface{}) (string, bool) {
+	if v := reflect.ValueOf(val); v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		val = v.Elem().Interface()
+	}
+	if s, ok := val.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(val), true
+}
+
+// endArgValue returns the last value of the KV of the exit event.
+func (t *aoTrace) endArgValue(key string) (interface{}, bool) {
+	for i := len(t.endArgs)/2*2 - 2; i >= 0; i -= 2 {
+		if k, _ := t.endArgs[i].(string); k == key {
+			return t.endArgs[i+1], true
+		}
+	}
+	return nil, false
+}
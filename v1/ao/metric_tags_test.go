@@ -0,0 +1,103 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricTags(t *testing.T) {
+	os.Setenv("APPOPTICS_METRIC_TAGS", "testTenant,testPlan,testRegion")
+	os.Setenv("APPOPTICS_METRIC_TAG_VALUES_MAX", "2")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_METRIC_TAGS")
+		os.Unsetenv("APPOPTICS_METRIC_TAG_VALUES_MAX")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	for _, tenant := range []string{"acme", "globex", "acme", "initech"} {
+		tenant := tenant
+		httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+			ao.SetTraceValue(req.Context(), "testTenant", tenant, ao.WithPromotion())
+			// not promoted
+			ao.SetTraceValue(req.Context(), "testRegion", "eu")
+			ao.TraceFromContext(req.Context()).AddEndArgs("testPlan", 3)
+		}, "http://test.com/hello")
+	}
+	r.Close(8)
+
+	require.Len(t, r.SpanMessages, 4)
+	var tenants []string
+	for _, sm := range r.SpanMessages {
+		m, ok := sm.(*metrics.HTTPSpanMessage)
+		require.True(t, ok)
+		assert.Equal(t, "3", m.Tags["testPlan"])
+		assert.NotContains(t, m.Tags, "testRegion")
+		tenants = append(tenants, m.Tags["testTenant"])
+	}
+	// the values beyond the limit are reported as other
+	assert.Equal(t, []string{"acme", "globex", "acme", "other"}, tenants)
+}
+
+func TestMetricTagsPointers(t *testing.T) {
+	os.Setenv("APPOPTICS_METRIC_TAGS", "testPlan,testRegion")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_METRIC_TAGS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+		plan := "free"
+		ao.TraceFromContext(req.Context()).AddEndArgs("testPlan", &plan, "testRegion", (*string)(nil))
+		plan = "gold" // evaluated when the trace ends
+	}, "http://test.com/hello")
+	r.Close(2)
+
+	require.Len(t, r.SpanMessages, 1)
+	m, ok := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"testPlan": "gold"}, m.Tags)
+}
+
+func TestMetricTagsEntryKVs(t *testing.T) {
+	os.Setenv("APPOPTICS_METRIC_TAGS", "testTier,HTTPMethod,testPlan")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_METRIC_TAGS")
+		config.Load()
+	}()
+
+	for _, sampled := range []bool{true, false} {
+		r := reporter.SetTestReporter(reporter.TestReporterShouldTrace(sampled))
+		httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+			// the end args take precedence over the entry KVs
+			ao.TraceFromContext(req.Context()).AddEndArgs("testPlan", "gold")
+		}, "http://test.com/hello", ao.WithLazyKVs(func() ao.KVMap {
+			return ao.KVMap{"testTier": "premium", "testPlan": "free"}
+		}))
+		if sampled {
+			r.Close(2)
+		} else {
+			r.Close(0)
+		}
+
+		require.Len(t, r.SpanMessages, 1)
+		m, ok := r.SpanMessages[0].(*metrics.HTTPSpanMessage)
+		require.True(t, ok)
+		assert.Equal(t, map[string]string{"testTier": "premium", "HTTPMethod": "GET", "testPlan": "gold"}, m.Tags,
+			"sampled: %v", sampled)
+	}
+}
@@ -3,6 +3,9 @@
 package ao
 
 import (
+	"strings"
+	"time"
+
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 )
@@ -36,3 +39,33 @@ func SummaryMetric(name string, value float64, opts MetricOptions) error {
 func IncrementMetric(name string, opts MetricOptions) error {
 	return reporter.IncrementMetric(name, opts)
 }
+
+// RecordQueryMetrics submits the metrics of a database query to the reporter,
+// which are aggregated by the fingerprint of the statement, see SQLFingerprint.
+// The QueryResponseTime (in microseconds) and the QueryRows measurements are
+// tagged by QueryFingerprint and Flavor, and also by Errors if err is not nil.
+// The rows are not recorded if it's negative, e.g., unknown. The metrics are
+// independent of the tracing, so they cover all the queries.
+func RecordQueryMetrics(flavor, query string, duration time.Duration, rows int64, err error) error {
+	return reporter.ReportSpan(&metrics.QuerySpanMessage{
+		BaseSpanMessage: metrics.BaseSpanMessage{Duration: duration, HasError: err != nil},
+		Fingerprint:     reporter.SQLFingerprint(flavor, query),
+		Flavor:          flavor,
+		Rows:            rows,
+	})
+}
+
+// RecordCacheMetrics submits the metrics of a cache request, e.g., a Redis or
+// Memcached command, to the reporter. The CacheResponseTime (in microseconds)
+// is tagged by KVOp and CacheCluster, and also by Errors if err is not nil,
+// and the request is counted by CacheHitCount or CacheMissCount. The commands
+// are uppercased as "op" is case-insensitive in BeginCacheSpan. The metrics are
+// independent of the tracing, so they cover all the requests.
+func RecordCacheMetrics(cluster, op string, hit bool, duration time.Duration, err error) error {
+	return reporter.ReportSpan(&metrics.CacheSpanMessage{
+		BaseSpanMessage: metrics.BaseSpanMessage{Duration: duration, HasError: err != nil},
+		Op:              strings.ToUpper(op),
+		Cluster:         cluster,
+		Hit:             hit,
+	})
+}
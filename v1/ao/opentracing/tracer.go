@@ -18,6 +18,7 @@ import (
 
 // NewTracer returns a new AppOptics tracer.
 func NewTracer() ot.Tracer {
+	ao.EnableIntegration(ao.IntegrationOpenTracing)
 	return &Tracer{
 		textMapPropagator: &textMapPropagator{},
 		binaryPropagator:  &binaryPropagator{marshaler: &jsonMarshaler{}},
@@ -45,6 +46,11 @@ func (t *Tracer) startSpanWithOptions(operationName string, opts ot.StartSpanOpt
 	// XXX handle StartTime
 	var newSpan ot.Span
 
+	if ao.IntegrationDisabled(ao.IntegrationOpenTracing) {
+		nullTrace := ao.NewNullTrace()
+		return &spanImpl{tracer: t, context: spanContext{trace: nullTrace, span: nullTrace}}
+	}
+
 	for _, ref := range opts.References {
 		switch ref.Type {
 		// trace has parent XXX only handles one parent
@@ -4,8 +4,10 @@ package opentracing
 
 import (
 	"fmt"
+	"os"
 	"testing"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
@@ -28,6 +30,24 @@ func TestSpanBaggageUnsampled(t *testing.T) {
 	assert.NotNil(t, childSpan)
 }
 
+func TestTracerIntegrationDisabled(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_DISABLED_INTEGRATIONS")
+		config.Load()
+	}()
+	os.Setenv("APPOPTICS_DISABLED_INTEGRATIONS", "opentracing")
+	config.Load()
+
+	r := reporter.SetTestReporter()
+	tr := NewTracer()
+	span := tr.StartSpan("op")
+	child := tr.StartSpan("op2", opentracing.ChildOf(span.Context()))
+	child.Finish()
+	span.Finish()
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+}
+
 func testTransactionName(t *testing.T, tagName, txnName string) {
 	r := reporter.SetTestReporter() // set up test reporter
 	tr := NewTracer()
@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// keyOutboxDelay reports the time a message spent in the outbox, in
+	// microseconds, from MarshalContext to the relay publishing it.
+	keyOutboxDelay = "OutboxDelay"
+
+	// the separator of the metadata string and the time in a marshaled context
+	marshaledContextSep = ';'
+)
+
+// MarshalContext returns the trace context of the Span associated with ctx, to
+// be stored along with a message in an outbox row, e.g., in the same database
+// transaction as the changes of a saga step. The relay publishing the message
+// later connects its span to the trace by ResumeContext or BeginLinkedSpan:
+//   _, err := tx.ExecContext(ctx, "INSERT INTO outbox (payload, trace) VALUES (?, ?)",
+//       payload, ao.MarshalContext(ctx))
+// The context is the X-Trace metadata string followed by the time it's
+// marshaled, which is used to report the OutboxDelay. It returns nil if there
+// is no Span associated with ctx.
+func MarshalContext(ctx context.Context) []byte {
+	md := MetadataString(ctx)
+	if md == "" {
+		return nil
+	}
+	b := make([]byte, 0, len(md)+20)
+	b = append(b, md...)
+	b = append(b, marshaledContextSep)
+	return strconv.AppendInt(b, time.Now().UnixNano()/int64(time.Microsecond), 10)
+}
+
+// unmarshalContext returns the X-Trace metadata string and the time of a
+// marshaled context. A plain metadata string, e.g., the one returned by
+// MetadataString, is also accepted, with a zero time. It returns false if data
+// is not a valid context.
+func unmarshalContext(data []byte) (string, time.Time, bool) {
+	var t time.Time
+	if i := bytes.IndexByte(data, marshaledContextSep); i >= 0 {
+		micros, err := strconv.ParseInt(string(data[i+1:]), 10, 64)
+		if err != nil || micros <= 0 {
+			return "", t, false
+		}
+		t = time.Unix(0, micros*int64(time.Microsecond))
+		data = data[:i]
+	}
+	md := strings.ToUpper(string(data))
+	if len(md) != xtraceLen || !strings.HasPrefix(md, xtraceHeader) || !isHex(md) {
+		return "", t, false
+	}
+	return md, t, true
+}
+
+// outboxArgs returns the KVs of the relay span of a message marshaled at t.
+func outboxArgs(t time.Time) []interface{} {
+	args := spanKindArgs(SpanKindProducer)
+	if !t.IsZero() {
+		delay := time.Since(t)
+		if delay < 0 {
+			delay = 0 // clock skew between the hosts
+		}
+		args = append(args, keyOutboxDelay, int64(delay/time.Microsecond))
+	}
+	return args
+}
+
+// ResumeContext starts a trace named spanName continuing the context marshaled
+// by MarshalContext, so the relay publishing a message appears as a child of
+// the span which stored it. The trace is returned along with a copy of ctx
+// bound to it, which replaces any trace already associated with ctx. The trace
+// is a new one if data is not a valid context.
+//   tr, ctx := ao.ResumeContext(ctx, "outbox-relay", row.Trace)
+//   defer tr.End()
+func ResumeContext(ctx context.Context, spanName string, data []byte) (Trace, context.Context) {
+	md, t, ok := unmarshalContext(data)
+	if !ok {
+		md = ""
+	}
+	tr := NewTraceFromID(spanName, md, func() KVMap { return fromKVs(outboxArgs(t)...) })
+	return tr, NewContext(ctx, tr)
+}
+
+// BeginLinkedSpan begins a span named spanName as a child of the Span of ctx,
+// which is linked to the context marshaled by MarshalContext by the
+// LinkedContexts KV. It's used when the relay publishes the messages in a
+// trace of its own, e.g., one per batch of rows, while keeping the sagas
+// connected in the trace graph. The link is not reported if data is not a
+// valid context.
+func BeginLinkedSpan(ctx context.Context, spanName string, data []byte, args ...interface{}) (Span, context.Context) {
+	kvs := spanKindArgs(SpanKindProducer)
+	if md, t, ok := unmarshalContext(data); ok {
+		kvs = append(outboxArgs(t), keyLinkedContexts, md)
+	}
+	return BeginSpan(ctx, spanName, mergeKVs(args, kvs)...)
+}
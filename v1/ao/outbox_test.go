@@ -0,0 +1,87 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxContext(t *testing.T) {
+	r := reporter.SetTestReporter()
+	assert.Nil(t, ao.MarshalContext(context.Background()))
+
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("saga"))
+	data := ao.MarshalContext(ctx)
+	require.NotNil(t, data)
+	md := ao.MetadataString(ctx)
+	ao.EndTrace(ctx)
+
+	// resumed by a relay without a trace
+	tr, relayCtx := ao.ResumeContext(context.Background(), "relay", data)
+	assert.Equal(t, tr, ao.TraceFromContext(relayCtx))
+	tr.End()
+
+	// linked by a relay publishing a batch in its own trace
+	batchCtx := ao.NewContext(context.Background(), ao.NewTrace("batch"))
+	l, _ := ao.BeginLinkedSpan(batchCtx, "publish", data, "Queue", "orders")
+	l.End()
+	l, _ = ao.BeginLinkedSpan(batchCtx, "invalid", []byte("garbage"))
+	l.End()
+	ao.EndTrace(batchCtx)
+	r.Close(10)
+
+	g.AssertGraph(t, r.EventBufs, 10, g.AssertNodeMap{
+		{"saga", "entry"}: {},
+		{"saga", "exit"}:  {Edges: g.Edges{{"saga", "entry"}}},
+		{"relay", "entry"}: {Edges: g.Edges{{"saga", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "producer", n.Map["SpanKind"])
+			assert.Contains(t, n.Map, "OutboxDelay")
+		}},
+		{"relay", "exit"}:  {Edges: g.Edges{{"relay", "entry"}}},
+		{"batch", "entry"}: {},
+		{"publish", "entry"}: {Edges: g.Edges{{"batch", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, md, n.Map["LinkedContexts"])
+			assert.Equal(t, "orders", n.Map["Queue"])
+			assert.Contains(t, n.Map, "OutboxDelay")
+		}},
+		{"publish", "exit"}: {Edges: g.Edges{{"publish", "entry"}}},
+		{"invalid", "entry"}: {Edges: g.Edges{{"batch", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "producer", n.Map["SpanKind"])
+			assert.NotContains(t, n.Map, "LinkedContexts")
+		}},
+		{"invalid", "exit"}: {Edges: g.Edges{{"invalid", "entry"}}},
+		{"batch", "exit"}:   {Edges: g.Edges{{"publish", "exit"}, {"invalid", "exit"}, {"batch", "entry"}}},
+	})
+}
+
+func TestResumeContextInvalid(t *testing.T) {
+	r := reporter.SetTestReporter()
+	// a plain metadata string is accepted
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("saga"))
+	md := ao.MetadataString(ctx)
+	ao.EndTrace(ctx)
+	tr, _ := ao.ResumeContext(context.Background(), "relay", []byte(md))
+	tr.End()
+	// an invalid one starts a new trace
+	tr, _ = ao.ResumeContext(context.Background(), "new", []byte(md[:len(md)-1]+";x"))
+	tr.End()
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"saga", "entry"}: {},
+		{"saga", "exit"}:  {Edges: g.Edges{{"saga", "entry"}}},
+		{"relay", "entry"}: {Edges: g.Edges{{"saga", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "OutboxDelay")
+		}},
+		{"relay", "exit"}: {Edges: g.Edges{{"relay", "entry"}}},
+		{"new", "entry"}:  {},
+		{"new", "exit"}:   {Edges: g.Edges{{"new", "entry"}}},
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// EnvTraceContext is the environment variable passing the trace context to the
+// child processes, see SetCommandTraceContext.
+const EnvTraceContext = "AO_TRACE_CONTEXT"
+
+// TraceContextEnv returns the entry of the environment of a child process,
+// i.e., "AO_TRACE_CONTEXT=<encoded context>", which continues the trace of the
+// span bound to the context ctx. An empty string is returned if there is no
+// span or the tracing is suppressed by SuppressTracing.
+func TraceContextEnv(ctx context.Context) string {
+	if tracingSuppressed(ctx) {
+		return ""
+	}
+	md := FromContext(ctx).MetadataString()
+	if md == "" {
+		return ""
+	}
+	v := url.Values{}
+	v.Set(encodedXTrace, md)
+	if sig := SignMetadata(md); sig != "" {
+		v.Set(encodedXTraceSignature, sig)
+	}
+	return EnvTraceContext + "=" + v.Encode()
+}
+
+// SetCommandTraceContext passes the trace context of the span bound to the
+// context ctx to the process started by cmd through the AO_TRACE_CONTEXT
+// environment variable, so the traces of the multi-process job runners stay
+// connected. The child resumes it by NewTraceFromEnv:
+//   cmd := exec.Command("worker", job.ID)
+//   ao.SetCommandTraceContext(ctx, cmd)
+//   err := cmd.Run()
+// The environment of the current process is used if cmd.Env is nil, and the
+// AO_TRACE_CONTEXT inherited, if any, is replaced.
+func SetCommandTraceContext(ctx context.Context, cmd *exec.Cmd) {
+	entry := TraceContextEnv(ctx)
+	if entry == "" {
+		return
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	merged := make([]string, 0, len(env)+1)
+	for _, e := range env {
+		if !strings.HasPrefix(e, EnvTraceContext+"=") {
+			merged = append(merged, e)
+		}
+	}
+	cmd.Env = append(merged, entry)
+}
+
+// NewTraceFromEnv starts a trace named spanName continuing the trace context
+// passed by the parent process through AO_TRACE_CONTEXT, e.g., in the init of a
+// forked worker, or a new trace if there is none. The variable is unset once
+// it's read, so the context is only resumed once, and only passed on to the
+// processes started by SetCommandTraceContext.
+func NewTraceFromEnv(spanName string) Trace {
+	s, ok := os.LookupEnv(EnvTraceContext)
+	if !ok {
+		return NewTrace(spanName)
+	}
+	os.Unsetenv(EnvTraceContext)
+
+	opts, err := DecodeTraceContext(s)
+	if err != nil {
+		log.Warningf("Ignored %s: %s", EnvTraceContext, err)
+		return NewTrace(spanName)
+	}
+	return NewTraceWithOptions(spanName, SpanOptions{ContextOptions: opts})
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandTraceContext(t *testing.T) {
+	assert.Empty(t, ao.TraceContextEnv(context.Background()))
+
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("job")
+	ctx := ao.NewContext(context.Background(), tr)
+	assert.Empty(t, ao.TraceContextEnv(ao.SuppressTracing(ctx)))
+
+	cmd := exec.Command("worker")
+	cmd.Env = []string{"PATH=/bin", ao.EnvTraceContext + "=stale"}
+	ao.SetCommandTraceContext(ctx, cmd)
+	require.Len(t, cmd.Env, 2)
+	assert.Equal(t, "PATH=/bin", cmd.Env[0])
+	assert.Equal(t, ao.TraceContextEnv(ctx), cmd.Env[1])
+
+	// emulate the child process
+	os.Setenv(ao.EnvTraceContext, strings.TrimPrefix(cmd.Env[1], ao.EnvTraceContext+"="))
+	child := ao.NewTraceFromEnv("worker")
+	_, set := os.LookupEnv(ao.EnvTraceContext)
+	assert.False(t, set)
+	assert.Equal(t, tr.LoggableTraceID(), child.LoggableTraceID())
+	child.End()
+	tr.End()
+	r.Close(4)
+
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"job", "entry"}:    {},
+		{"worker", "entry"}: {Edges: g.Edges{{"job", "entry"}}},
+		{"worker", "exit"}:  {Edges: g.Edges{{"worker", "entry"}}},
+		{"job", "exit"}:     {Edges: g.Edges{{"job", "entry"}}},
+	})
+}
+
+func TestNewTraceFromBadEnv(t *testing.T) {
+	r := reporter.SetTestReporter()
+	os.Setenv(ao.EnvTraceContext, "x=bad")
+	tr := ao.NewTraceFromEnv("worker")
+	tr.End()
+	r.Close(2)
+
+	// a new trace is started
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"worker", "entry"}: {},
+		{"worker", "exit"}:  {Edges: g.Edges{{"worker", "entry"}}},
+	})
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// The HTTP headers of the trace context formats supported in addition to X-Trace.
+const (
+	HTTPHeaderTraceparent = "traceparent"
+	HTTPHeaderB3          = "b3"
+	HTTPHeaderB3TraceID   = "X-B3-TraceId"
+	HTTPHeaderB3SpanID    = "X-B3-SpanId"
+	HTTPHeaderB3Sampled   = "X-B3-Sampled"
+	HTTPHeaderB3Flags     = "X-B3-Flags"
+)
+
+const (
+	// keyContextFormat reports the format of the trace context continued by
+	// the trace, which helps debugging fleets with mixed tracers. It's omitted
+	// for the default X-Trace.
+	keyContextFormat = "ContextFormat"
+	// keyLinkedContexts reports the conflicting trace contexts of the request,
+	// as X-Trace metadata strings.
+	keyLinkedContexts = "LinkedContexts"
+
+	xtraceHeader   = "2B"
+	xtraceLen      = 60 // 1 byte of header, 20 bytes of taskID, 8 bytes of opID and 1 byte of flags
+	xtraceSampled  = "01"
+	xtraceUnsample = "00"
+)
+
+// SignMetadata returns the HMAC signature of the X-Trace metadata string md
+// keyed by APPOPTICS_CONTEXT_SIGNING_KEY, which is sent along with md in the
+// X-Trace-Signature header. It returns an empty string if there is no key.
+//
+// When the key is set, the incoming contexts without a valid signature are
+// either rejected or downgraded, as configured by APPOPTICS_UNSIGNED_CONTEXT,
+// so the callers outside of the deployment can't force the sampling. The
+// signature is checked against the X-Trace form of the context extracted.
+func SignMetadata(md string) string {
+	return reporter.SignMetadata(md)
+}
+
+// incomingContext is the trace context extracted from an incoming request.
+type incomingContext struct {
+	md     string                   // the X-Trace metadata to continue
+	format config.PropagationFormat // the format md is extracted from
+	links  []string                 // the conflicting contexts, if reported
+}
+
+// extractors convert the trace context of a format to an X-Trace metadata
+// string, which is empty if not found or invalid.
+var extractors = map[config.PropagationFormat]func(http.Header) string{
+	config.XTracePropagation:      extractXTrace,
+	config.TraceparentPropagation: extractTraceparent,
+	config.B3Propagation:          extractB3,
+}
+
+// extractContext extracts the trace context from the request headers in the
+// order of precedence configured by APPOPTICS_PROPAGATION_FORMATS. The
+// contexts of other formats belonging to a different trace are conflicting
+// ones, which are reported as links if APPOPTICS_PROPAGATION_CONFLICT is link.
+func extractContext(h http.Header) incomingContext {
+	var ic incomingContext
+	link := config.GetPropagationConflict() == config.LinkPropagationConflict
+	for _, f := range config.GetPropagationFormats() {
+		md := extractors[f](h)
+		if md == "" {
+			continue
+		}
+		if ic.md == "" {
+			ic.md, ic.format = md, f
+		} else if link && md[2:42] != ic.md[2:42] {
+			ic.links = append(ic.links, md)
+		}
+	}
+	return ic
+}
+
+func extractXTrace(h http.Header) string {
+	md := strings.ToUpper(h.Get(HTTPHeaderName))
+	if len(md) != xtraceLen || !strings.HasPrefix(md, xtraceHeader) || !isHex(md) {
+		return ""
+	}
+	return md
+}
+
+// extractTraceparent converts a W3C traceparent header to X-Trace metadata. The
+// 16-byte trace ID is padded with zeros to make the 20-byte taskID.
+func extractTraceparent(h http.Header) string {
+	parts := strings.Split(strings.TrimSpace(h.Get(HTTPHeaderTraceparent)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return ""
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 || !isHex(flags) {
+		return ""
+	}
+	b, _ := hex.DecodeString(flags)
+	sampled := xtraceUnsample
+	if b[0]&0x01 == 1 {
+		sampled = xtraceSampled
+	}
+	return toXTrace(traceID, spanID, sampled)
+}
+
+// extractB3 converts the B3 single header or multiple headers to X-Trace
+// metadata. A context without the sampling decision is ignored as X-Trace
+// can't defer it.
+func extractB3(h http.Header) string {
+	var traceID, spanID, sampling string
+	if b3 := strings.TrimSpace(h.Get(HTTPHeaderB3)); b3 != "" {
+		parts := strings.Split(b3, "-")
+		if len(parts) < 3 {
+			return ""
+		}
+		traceID, spanID, sampling = parts[0], parts[1], parts[2]
+	} else {
+		traceID = h.Get(HTTPHeaderB3TraceID)
+		spanID = h.Get(HTTPHeaderB3SpanID)
+		sampling = h.Get(HTTPHeaderB3Sampled)
+		if h.Get(HTTPHeaderB3Flags) == "1" {
+			sampling = "d"
+		}
+	}
+
+	var sampled string
+	switch strings.ToLower(sampling) {
+	case "1", "d", "true":
+		sampled = xtraceSampled
+	case "0", "false":
+		sampled = xtraceUnsample
+	default:
+		return ""
+	}
+	// 64-bit trace IDs are left-padded to 128 bits
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if len(traceID) != 32 || len(spanID) != 16 {
+		return ""
+	}
+	return toXTrace(traceID, spanID, sampled)
+}
+
+// toXTrace builds the X-Trace metadata string from hex encoded 16-byte trace
+// ID and 8-byte span ID. It returns an empty string if any ID is invalid.
+func toXTrace(traceID, spanID, flags string) string {
+	if !isHex(traceID) || !isHex(spanID) || isZeros(traceID) || isZeros(spanID) {
+		return ""
+	}
+	return strings.ToUpper(xtraceHeader + traceID + "00000000" + spanID + flags)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func isZeros(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
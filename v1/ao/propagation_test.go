@@ -0,0 +1,130 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testXTrace      = "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	testTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	testTPXTrace    = "2B0AF7651916CD43DD8448EB211C80319C00000000B7AD6B716920333101"
+)
+
+func setPropagation(formats, conflict string) func() {
+	os.Setenv("APPOPTICS_PROPAGATION_FORMATS", formats)
+	os.Setenv("APPOPTICS_PROPAGATION_CONFLICT", conflict)
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_PROPAGATION_FORMATS")
+		os.Unsetenv("APPOPTICS_PROPAGATION_CONFLICT")
+		config.Load()
+	}
+}
+
+func TestExtractors(t *testing.T) {
+	h := http.Header{}
+	assert.Empty(t, extractXTrace(h))
+	assert.Empty(t, extractTraceparent(h))
+	assert.Empty(t, extractB3(h))
+
+	h.Set(HTTPHeaderName, "2bf4caa9299299e3d38a58a9821bd34f6268e576cfab2198d447ea220301")
+	assert.Equal(t, testXTrace, extractXTrace(h))
+	h.Set(HTTPHeaderName, "invalid")
+	assert.Empty(t, extractXTrace(h))
+
+	h.Set(HTTPHeaderTraceparent, testTraceparent)
+	assert.Equal(t, testTPXTrace, extractTraceparent(h))
+	h.Set(HTTPHeaderTraceparent, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	assert.Equal(t, testTPXTrace[:58]+"00", extractTraceparent(h))
+	for _, tp := range []string{
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01",
+	} {
+		h.Set(HTTPHeaderTraceparent, tp)
+		assert.Empty(t, extractTraceparent(h), tp)
+	}
+
+	// B3 multiple headers with a 64-bit trace ID
+	h.Set(HTTPHeaderB3TraceID, "8448eb211c80319c")
+	h.Set(HTTPHeaderB3SpanID, "b7ad6b7169203331")
+	assert.Empty(t, extractB3(h)) // no sampling decision
+	h.Set(HTTPHeaderB3Sampled, "1")
+	assert.Equal(t, "2B00000000000000008448EB211C80319C00000000B7AD6B716920333101", extractB3(h))
+	h.Set(HTTPHeaderB3Sampled, "0")
+	h.Set(HTTPHeaderB3Flags, "1")
+	assert.Equal(t, "2B00000000000000008448EB211C80319C00000000B7AD6B716920333101", extractB3(h))
+
+	// B3 single header takes precedence
+	h.Set(HTTPHeaderB3, "0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-0")
+	assert.Equal(t, testTPXTrace[:58]+"00", extractB3(h))
+	h.Set(HTTPHeaderB3, "0")
+	assert.Empty(t, extractB3(h))
+}
+
+func TestExtractContext(t *testing.T) {
+	h := http.Header{}
+	h.Set(HTTPHeaderName, testXTrace)
+	h.Set(HTTPHeaderTraceparent, testTraceparent)
+
+	// X-Trace only by default
+	ic := extractContext(h)
+	assert.Equal(t, testXTrace, ic.md)
+	assert.Equal(t, config.XTracePropagation, ic.format)
+	assert.Empty(t, ic.links)
+
+	reset := setPropagation("traceparent,xtrace", "ignore")
+	ic = extractContext(h)
+	assert.Equal(t, testTPXTrace, ic.md)
+	assert.Equal(t, config.TraceparentPropagation, ic.format)
+	assert.Empty(t, ic.links)
+	reset()
+
+	reset = setPropagation("b3,traceparent,xtrace", "link")
+	defer reset()
+	ic = extractContext(h)
+	assert.Equal(t, testTPXTrace, ic.md)
+	assert.Equal(t, config.TraceparentPropagation, ic.format)
+	assert.Equal(t, []string{testXTrace}, ic.links)
+
+	// the same trace in different formats doesn't conflict
+	h.Set(HTTPHeaderB3, "0af7651916cd43dd8448eb211c80319c-1111111111111111-1")
+	ic = extractContext(h)
+	assert.Equal(t, config.B3Propagation, ic.format)
+	assert.Equal(t, []string{testXTrace}, ic.links)
+
+	assert.Equal(t, incomingContext{}, extractContext(http.Header{}))
+}
+
+func TestHTTPHandlerTraceparent(t *testing.T) {
+	reset := setPropagation("xtrace,traceparent", "link")
+	defer reset()
+
+	r := reporter.SetTestReporter()
+	req := httptest.NewRequest("GET", "http://test.com/hello", nil)
+	req.Header.Set(HTTPHeaderTraceparent, testTraceparent)
+	tr, _, _ := TraceFromHTTPRequestResponse("http.HandlerFunc", httptest.NewRecorder(), req)
+	tr.End()
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{{"Edge", testTPXTrace[42:58]}}, Callback: func(n g.Node) {
+			assert.Equal(t, "traceparent", n.Map["ContextFormat"])
+			assert.NotContains(t, n.Map, "LinkedContexts")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+	assert.Equal(t, testTPXTrace[2:42], tr.ExitMetadata()[2:42])
+}
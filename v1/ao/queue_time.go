@@ -0,0 +1,101 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+const (
+	// HTTPHeaderRequestStart is the header set by load balancers or proxies
+	// (e.g. nginx, haproxy) with the time the request was received by them.
+	HTTPHeaderRequestStart = "X-Request-Start"
+	// HTTPHeaderQueueStart is an alternative header of HTTPHeaderRequestStart.
+	HTTPHeaderQueueStart = "X-Queue-Start"
+
+	// QueueTimeMetricName is the name of the summary metric of the queue time,
+	// in microseconds.
+	QueueTimeMetricName = "RequestQueueTime"
+
+	keyQueueTime = "QueueTime"
+)
+
+// The boundaries used to guess the unit of the request start timestamps. Any
+// timestamp after 2001 in one unit is larger than the same timestamp in the
+// next coarser unit.
+const (
+	minRequestStartNanos  = 1e18
+	minRequestStartMicros = 1e15
+	minRequestStartMillis = 1e12
+)
+
+// queueTime returns the time the request spent queuing in upstream load
+// balancers or proxies before it was received, based on the request start
+// headers. It returns false if no valid header is found.
+func queueTime(r *http.Request, now time.Time) (time.Duration, bool) {
+	for _, name := range []string{HTTPHeaderRequestStart, HTTPHeaderQueueStart} {
+		if start, ok := parseRequestStart(r.Header.Get(name)); ok {
+			if d := now.Sub(start); d >= 0 {
+				return d, true
+			}
+			return 0, true // clock skew between the hosts
+		}
+	}
+	return 0, false
+}
+
+// parseRequestStart parses the value of a request start header. Both the nginx
+// format "t=1609459200.123" (seconds with fractions) and the plain integer
+// timestamps in seconds, milliseconds, microseconds (as set by haproxy or
+// Heroku) or nanoseconds are accepted.
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(v, "t=")
+	if v == "" {
+		return time.Time{}, false
+	}
+
+	if parts := strings.SplitN(v, ".", 2); len(parts) == 2 {
+		secs, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || secs <= 0 {
+			return time.Time{}, false
+		}
+		// keep up to nanoseconds of the fractions and pad them to 9 digits
+		frac := parts[1]
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		nanos, err := strconv.ParseUint(frac, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(secs, int64(nanos)), true
+	}
+
+	ts, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ts <= 0 {
+		return time.Time{}, false
+	}
+	switch {
+	case ts >= minRequestStartNanos:
+		return time.Unix(0, ts), true
+	case ts >= minRequestStartMicros:
+		return time.Unix(0, ts*int64(time.Microsecond)), true
+	case ts >= minRequestStartMillis:
+		return time.Unix(0, ts*int64(time.Millisecond)), true
+	default:
+		return time.Unix(ts, 0), true
+	}
+}
+
+// recordQueueTime reports the queue time as a summary metric, in microseconds.
+func recordQueueTime(d time.Duration) {
+	_ = reporter.SummaryMetric(QueueTimeMetricName, float64(d/time.Microsecond),
+		MetricOptions{Count: 1, HostTag: true})
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequestStart(t *testing.T) {
+	start := time.Unix(1609459200, 123000000)
+	cases := map[string]time.Time{
+		"t=1609459200.123":    start,
+		"1609459200.123":      start,
+		"t=1609459200123":     start,
+		"1609459200123000":    start,
+		"t=1609459200123000":  start,
+		"1609459200123000000": start,
+		" t=1609459200 ":      time.Unix(1609459200, 0),
+	}
+	for v, expected := range cases {
+		ts, ok := parseRequestStart(v)
+		assert.True(t, ok, v)
+		assert.Equal(t, expected.UnixNano()/int64(time.Millisecond),
+			ts.UnixNano()/int64(time.Millisecond), v)
+	}
+
+	for _, v := range []string{"", "t=", "t=abc", "t=-1", "t=1.2.3", "0"} {
+		_, ok := parseRequestStart(v)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestQueueTime(t *testing.T) {
+	now := time.Unix(1609459200, 0)
+	r, _ := http.NewRequest("GET", "http://test.com", nil)
+	_, ok := queueTime(r, now)
+	assert.False(t, ok)
+
+	r.Header.Set(HTTPHeaderQueueStart, "t=1609459199500000")
+	d, ok := queueTime(r, now)
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, d)
+
+	// X-Request-Start takes precedence
+	r.Header.Set(HTTPHeaderRequestStart, "t=1609459199.900")
+	d, ok = queueTime(r, now)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, d)
+
+	// the start time is in the future
+	r.Header.Set(HTTPHeaderRequestStart, "t=1609459201.000")
+	d, ok = queueTime(r, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// keyReplay marks the traces of the replayed requests, so they can be
+// filtered out in the backend.
+const keyReplay = "Replay"
+
+// key used for the request replayed by ReplayRequest, to the trace context it's
+// replayed with
+var httpReplayKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.HTTPReplay")
+
+// the keys of the encoded trace context
+const (
+	encodedXTrace                 = "x"
+	encodedXTraceSignature        = "xs"
+	encodedXTraceOptions          = "o"
+	encodedXTraceOptionsSignature = "os"
+)
+
+// EncodeTraceContext returns the compact string form of the trace context of
+// the request headers h, including the X-Trace-Options state, to be stored in
+// a request-replay fixture and replayed by ReplayRequest or NewReplayTrace:
+//   fixture.TraceContext = ao.EncodeTraceContext(r.Header)
+// The context is extracted in the formats of APPOPTICS_PROPAGATION_FORMATS and
+// stored as X-Trace. An empty string is returned if there is no context.
+func EncodeTraceContext(h http.Header) string {
+	v := url.Values{}
+	for k, s := range map[string]string{
+		encodedXTrace:                 extractContext(h).md,
+		encodedXTraceSignature:        h.Get(HTTPHeaderXTraceSignature),
+		encodedXTraceOptions:          h.Get(HTTPHeaderXTraceOptions),
+		encodedXTraceOptionsSignature: h.Get(HTTPHeaderXTraceOptionsSignature),
+	} {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	return v.Encode()
+}
+
+// DecodeTraceContext returns the ContextOptions of the trace context encoded
+// by EncodeTraceContext.
+func DecodeTraceContext(s string) (ContextOptions, error) {
+	v, err := url.ParseQuery(s)
+	if err != nil {
+		return ContextOptions{}, errors.Wrap(err, "invalid trace context")
+	}
+	opts := ContextOptions{
+		MdStr:                  strings.ToUpper(v.Get(encodedXTrace)),
+		MdSignature:            v.Get(encodedXTraceSignature),
+		XTraceOptions:          v.Get(encodedXTraceOptions),
+		XTraceOptionsSignature: v.Get(encodedXTraceOptionsSignature),
+	}
+	if opts.MdStr != "" && (len(opts.MdStr) != xtraceLen || !strings.HasPrefix(opts.MdStr, xtraceHeader) || !isHex(opts.MdStr)) {
+		return ContextOptions{}, errors.Errorf("invalid trace context: X-Trace %q", opts.MdStr)
+	}
+	return opts, nil
+}
+
+// NewReplayTrace starts a trace of a replayed request, continuing the trace
+// context encoded by EncodeTraceContext. The entry of the trace has the Replay
+// KV set to true, so the replays can be filtered out in the backend. A
+// non-reporting trace is returned with the error if the context is invalid.
+//
+// Note the X-Trace-Options signature contains a timestamp, so a signed trigger
+// trace is only honored for a few minutes after it's requested.
+func NewReplayTrace(spanName, encoded string) (Trace, error) {
+	opts, err := DecodeTraceContext(encoded)
+	if err != nil {
+		return NewNullTrace(), err
+	}
+	opts.CB = func() KVMap { return KVMap{keyReplay: true} }
+	return NewTraceWithOptions(spanName, SpanOptions{Kind: SpanKindServer, ContextOptions: opts}), nil
+}
+
+// ReplayRequest returns a shallow copy of the request r, as r.WithContext
+// does, which is traced with the trace context encoded by EncodeTraceContext
+// rather than the one of its headers, by the handlers wrapped by HTTPHandler
+// or traced by TraceFromHTTPRequestResponse. The entry of the trace has the
+// Replay KV set to true, so the replays can be filtered out in the backend:
+//   r, err := ao.ReplayRequest(httptest.NewRequest(...), fixture.TraceContext)
+//   handler.ServeHTTP(w, r)
+func ReplayRequest(r *http.Request, encoded string) (*http.Request, error) {
+	opts, err := DecodeTraceContext(encoded)
+	if err != nil {
+		return r, err
+	}
+	return r.WithContext(context.WithValue(r.Context(), httpReplayKey, opts)), nil
+}
+
+// replayedWith returns the trace context the request is replayed with, if it's
+// replayed by ReplayRequest.
+func replayedWith(r *http.Request) (ContextOptions, bool) {
+	opts, ok := r.Context().Value(httpReplayKey).(ContextOptions)
+	return opts, ok
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceContextEncoding(t *testing.T) {
+	assert.Empty(t, ao.EncodeTraceContext(http.Header{}))
+
+	md := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	h := http.Header{}
+	h.Set(ao.HTTPHeaderName, md)
+	h.Set(ao.HTTPHeaderXTraceOptions, "trigger-trace;ts=1564597681")
+	h.Set(ao.HTTPHeaderXTraceOptionsSignature, "2c1c844b2c524c6f")
+	encoded := ao.EncodeTraceContext(h)
+
+	opts, err := ao.DecodeTraceContext(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, ao.ContextOptions{
+		MdStr:                  md,
+		XTraceOptions:          "trigger-trace;ts=1564597681",
+		XTraceOptionsSignature: "2c1c844b2c524c6f",
+	}, opts)
+
+	_, err = ao.DecodeTraceContext("x=2B00")
+	assert.Error(t, err)
+	_, err = ao.DecodeTraceContext("%zz")
+	assert.Error(t, err)
+}
+
+func TestNewReplayTrace(t *testing.T) {
+	r := reporter.SetTestReporter()
+	md := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	tr, err := ao.NewReplayTrace("replayed", "x="+md)
+	require.NoError(t, err)
+	tr.End()
+
+	_, err = ao.NewReplayTrace("replayed", "x=invalid")
+	assert.Error(t, err)
+	r.Close(2)
+
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"replayed", "entry"}: {Edges: g.Edges{{"Edge", md[42:58]}}, Callback: func(n g.Node) {
+			assert.Equal(t, true, n.Map["Replay"])
+		}},
+		{"replayed", "exit"}: {Edges: g.Edges{{"replayed", "entry"}}},
+	})
+}
+
+func TestReplayRequest(t *testing.T) {
+	r := reporter.SetTestReporter()
+	md := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	h := http.Header{}
+	h.Set(ao.HTTPHeaderName, md)
+
+	req := httptest.NewRequest("GET", "http://test.com/hello", nil)
+	// the replayed context takes precedence over the headers
+	req.Header.Set(ao.HTTPHeaderName, "2B0000000000000000000000000000000000000000000000000000000001")
+	req, err := ao.ReplayRequest(req, ao.EncodeTraceContext(h))
+	require.NoError(t, err)
+	ao.HTTPHandler(handler200)(httptest.NewRecorder(), req)
+	r.Close(2)
+
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{{"Edge", md[42:58]}}, Callback: func(n g.Node) {
+			assert.Equal(t, true, n.Map["Replay"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+
+	_, err = ao.ReplayRequest(req, "x=invalid")
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HTTPHeaderServerTiming is the standard response header to communicate the
+	// server side metrics to the browser.
+	HTTPHeaderServerTiming = "Server-Timing"
+
+	// the W3C trace context version used in the traceparent value
+	traceparentVersion = "00"
+)
+
+// WithRUMCorrelation returns a function that sets the RUMCorrelation flag. The
+// HTTP instrumentation then adds the header `Server-Timing: traceparent;desc="..."`
+// to the HTML responses so frontend RUM tools can link the browser timings to
+// the backend traces.
+//   http.HandleFunc("/", ao.HTTPHandler(pageHandler, ao.WithRUMCorrelation()))
+func WithRUMCorrelation() SpanOpt {
+	return func(o *SpanOptions) {
+		o.RUMCorrelation = true
+	}
+}
+
+// RUMTraceparent returns the W3C traceparent value of the trace bound to ctx,
+// which may be rendered into an HTML page as a meta tag for the frontend RUM
+// tools to correlate with, e.g.,
+//   <meta name="traceparent" content="{{ .Traceparent }}">
+// It returns an empty string if the trace is not sampled.
+func RUMTraceparent(ctx context.Context) string {
+	t := TraceFromContext(ctx)
+	if !t.IsReporting() {
+		return ""
+	}
+	return TraceparentFromMetadata(t.ExitMetadata())
+}
+
+// TraceparentFromMetadata converts an X-Trace metadata string, e.g., the
+// Span.MetadataString, to a W3C traceparent value, or returns an empty string
+// if md is not valid. The trace ID is the first 16 bytes of the task ID.
+func TraceparentFromMetadata(md string) string {
+	// 1 byte of header, 20 bytes of taskID, 8 bytes of opID and 1 byte of flags
+	if len(md) != 60 {
+		return ""
+	}
+	md = strings.ToLower(md)
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, md[2:34], md[42:58], md[58:60])
+}
+
+// isHTML checks if the response content type is HTML.
+func isHTML(h http.Header) bool {
+	return strings.HasPrefix(strings.ToLower(h.Get("Content-Type")), "text/html")
+}
+
+// addRUMCorrelation adds the Server-Timing traceparent entry to HTML responses.
+func (w *HTTPResponseWriter) addRUMCorrelation() {
+	if !w.rumCorrelation || !w.t.IsReporting() || !isHTML(w.Header()) {
+		return
+	}
+	if tp := TraceparentFromMetadata(w.t.ExitMetadata()); tp != "" {
+		w.Header().Add(HTTPHeaderServerTiming, fmt.Sprintf("traceparent;desc=\"%s\"", tp))
+	}
+}
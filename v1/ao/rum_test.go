@@ -0,0 +1,66 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func handlerHTML(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("<html><body>hello</body></html>"))
+}
+
+func handlerJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"hello": "world"}`))
+}
+
+func TestRUMCorrelation(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	w := httpTest(handlerHTML, ao.WithRUMCorrelation())
+	xt := strings.ToLower(w.Header().Get(ao.HTTPHeaderName))
+	assert.Len(t, xt, 60)
+	assert.Equal(t, "traceparent;desc=\"00-"+xt[2:34]+"-"+xt[42:58]+"-01\"",
+		w.Header().Get(ao.HTTPHeaderServerTiming))
+
+	// not an HTML response
+	w = httpTest(handlerJSON, ao.WithRUMCorrelation())
+	assert.Empty(t, w.Header().Get(ao.HTTPHeaderServerTiming))
+
+	// not enabled
+	w = httpTest(handlerHTML)
+	assert.Empty(t, w.Header().Get(ao.HTTPHeaderServerTiming))
+}
+
+func TestRUMTraceparent(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	assert.Empty(t, ao.RUMTraceparent(context.Background()))
+
+	var tp string
+	h := ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		tp = ao.RUMTraceparent(r.Context())
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "http://test.com/page", nil))
+	xt := strings.ToLower(w.Header().Get(ao.HTTPHeaderName))
+	assert.Equal(t, "00-"+xt[2:34]+"-"+xt[42:58]+"-01", tp)
+}
+
+func TestTraceparentFromMetadata(t *testing.T) {
+	md := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	assert.Equal(t, "00-f4caa9299299e3d38a58a9821bd34f62-ab2198d447ea2203-01", ao.TraceparentFromMetadata(md))
+	assert.Empty(t, ao.TraceparentFromMetadata("2B00"))
+	assert.Empty(t, ao.TraceparentFromMetadata(""))
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// SecurityEventKind is the kind of a security-relevant request outcome.
+type SecurityEventKind string
+
+// The built-in security event kinds. Applications may use their own kinds too.
+const (
+	SecurityEventAuthFailure SecurityEventKind = "auth_failure"
+	SecurityEventRateLimited SecurityEventKind = "rate_limited"
+	SecurityEventCSRFDenied  SecurityEventKind = "csrf_denied"
+	// SecurityEventUnauthorized is recorded for each HTTP response with status 401.
+	SecurityEventUnauthorized SecurityEventKind = "unauthorized"
+	// SecurityEventForbidden is recorded for each HTTP response with status 403.
+	SecurityEventForbidden SecurityEventKind = "forbidden"
+)
+
+const (
+	// SecurityEventMetricName is the name of the metric counting the security
+	// events, tagged by the event kind.
+	SecurityEventMetricName = "SecurityEvents"
+
+	keySecurityEvent = "SecurityEvent"
+	tagSecurityKind  = "Kind"
+)
+
+// RecordSecurityEvent records a security-relevant outcome of the request
+// bound to ctx, e.g., an authentication failure, a rate-limit rejection or a
+// CSRF denial. An info event with the KV "SecurityEvent" set to the kind and
+// the additional KVs is reported on the current span, so the events are
+// queryable on traces, and the kind is counted in the metric
+// SecurityEventMetricName.
+//   ao.RecordSecurityEvent(ctx, ao.SecurityEventAuthFailure, ao.KVMap{"User": user})
+func RecordSecurityEvent(ctx context.Context, kind SecurityEventKind, kvs KVMap) {
+	if kind == "" {
+		return
+	}
+	args := []interface{}{keySecurityEvent, string(kind)}
+	for k, v := range kvs {
+		args = append(args, k, v)
+	}
+	Info(ctx, args...)
+	countSecurityEvent(kind)
+}
+
+func countSecurityEvent(kind SecurityEventKind) {
+	_ = reporter.IncrementMetric(SecurityEventMetricName, MetricOptions{
+		Count:   1,
+		HostTag: true,
+		Tags:    map[string]string{tagSecurityKind: string(kind)},
+	})
+}
+
+// recordSecurityStatus counts the HTTP responses rejected by authentication
+// or authorization as security events.
+func recordSecurityStatus(s *metrics.HTTPSpanMessage) {
+	switch s.Status {
+	case http.StatusUnauthorized:
+		countSecurityEvent(SecurityEventUnauthorized)
+	case http.StatusForbidden:
+		countSecurityEvent(SecurityEventForbidden)
+	}
+}
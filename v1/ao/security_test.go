@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordSecurityEvent(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	ao.RecordSecurityEvent(ctx, ao.SecurityEventRateLimited, ao.KVMap{"Limit": 100, "Client": "10.0.0.1"})
+	ao.RecordSecurityEvent(ctx, "", nil)
+	ao.RecordSecurityEvent(context.Background(), ao.SecurityEventCSRFDenied, nil)
+	tr.End()
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"test", "entry"}: {},
+		{"test", "info"}: {Edges: g.Edges{{"test", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "rate_limited", n.Map["SecurityEvent"])
+			assert.EqualValues(t, 100, n.Map["Limit"])
+			assert.Equal(t, "10.0.0.1", n.Map["Client"])
+		}},
+		{"test", "exit"}: {Edges: g.Edges{{"test", "info"}}},
+	})
+}
+
+func TestHTTPHandlerSecurityStatus(t *testing.T) {
+	r := reporter.SetTestReporter()
+	// the 401/403 responses are counted as security events, the traces are
+	// reported as usual.
+	response := httpTest(handler403)
+	assert.Equal(t, 403, response.Code)
+	response = httpTest(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(401) })
+	assert.Equal(t, 401, response.Code)
+	httpTest(handler404)
+
+	r.Close(6)
+	g.AssertGraph(t, r.EventBufs[:2], 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 403, n.Map["Status"])
+			assert.NotContains(t, n.Map, "SecurityEvent")
+		}},
+	})
+	var kinds []string
+	for _, m := range r.CustomMetrics {
+		if m.Name == ao.SecurityEventMetricName {
+			assert.Equal(t, 1, m.Opts.Count)
+			assert.True(t, m.Opts.HostTag)
+			kinds = append(kinds, m.Opts.Tags["Kind"])
+		}
+	}
+	assert.Equal(t, []string{"forbidden", "unauthorized"}, kinds)
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// serverTimingTotal is the Server-Timing metric name of the total duration
+// of the trace's root span.
+const serverTimingTotal = "total"
+
+// childTimings aggregates the durations of the closed child spans of a root
+// span by span name.
+type childTimings struct {
+	durations map[string]time.Duration
+	order     []string // span names in the order they first ended
+	lock      sync.Mutex
+}
+
+func (c *childTimings) add(name string, d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.durations[name]; !ok {
+		c.order = append(c.order, name)
+	}
+	c.durations[name] += d
+}
+
+// top returns the names of the n longest child spans, longest first.
+func (c *childTimings) top(n int) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	names := append([]string(nil), c.order...)
+	sort.SliceStable(names, func(i, j int) bool {
+		return c.durations[names[i]] > c.durations[names[j]]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+func (c *childTimings) get(name string) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.durations[name]
+}
+
+// initServerTiming enables collecting the child span durations of the trace
+// if they are to be reported in the Server-Timing header.
+func (t *aoTrace) initServerTiming() {
+	if config.GetServerTiming() && config.GetServerTimingSpans() > 0 {
+		t.timings = &childTimings{durations: make(map[string]time.Duration)}
+	}
+}
+
+// addChildTiming records the duration of a closed child span. It's a no-op
+// unless the span collects the timings for the Server-Timing header.
+func (s *span) addChildTiming(name string, d time.Duration) {
+	if s.timings != nil {
+		s.timings.add(name, d)
+	}
+}
+
+// serverTimingToken converts a span name to a valid Server-Timing metric name,
+// which must be an RFC 7230 token.
+func serverTimingToken(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// serverTimingEntry formats a Server-Timing metric with the duration in
+// milliseconds. The original span name is kept in the description if it's
+// not a valid token.
+func serverTimingEntry(name string, d time.Duration) string {
+	ms := float64(d) / float64(time.Millisecond)
+	token := serverTimingToken(name)
+	if token == name {
+		return fmt.Sprintf("%s;dur=%.3f", token, ms)
+	}
+	desc := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name)
+	return fmt.Sprintf("%s;desc=\"%s\";dur=%.3f", token, desc, ms)
+}
+
+// serverTiming returns the Server-Timing header value of the trace, which
+// contains the total duration of the trace so far and the durations of the
+// longest child spans, if enabled.
+func (t *aoTrace) serverTiming(now time.Time) string {
+	if t.httpSpan.start.IsZero() {
+		return ""
+	}
+	entries := []string{serverTimingEntry(serverTimingTotal, now.Sub(t.httpSpan.start))}
+	if t.timings != nil {
+		for _, name := range t.timings.top(config.GetServerTimingSpans()) {
+			entries = append(entries, serverTimingEntry(name, t.timings.get(name)))
+		}
+	}
+	return strings.Join(entries, ", ")
+}
+
+// addServerTiming adds the Server-Timing header to the response if enabled.
+func (w *HTTPResponseWriter) addServerTiming() {
+	if !config.GetServerTiming() {
+		return
+	}
+	t, ok := w.t.(*aoTrace)
+	if !ok {
+		return
+	}
+	if v := t.serverTiming(time.Now()); v != "" {
+		w.Header().Add(HTTPHeaderServerTiming, v)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func handlerWithSpans(w http.ResponseWriter, r *http.Request) {
+	// the cache spans take much longer than the query so the order is stable
+	for _, span := range []struct {
+		name string
+		d    time.Duration
+	}{{"cache", 10 * time.Millisecond}, {"postgres query", time.Millisecond}, {"cache", 10 * time.Millisecond}} {
+		l, _ := ao.BeginSpan(r.Context(), span.name)
+		time.Sleep(span.d)
+		l.End()
+	}
+	l, _ := ao.BeginSpan(r.Context(), "render")
+	l.End()
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestServerTiming(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVER_TIMING")
+		os.Unsetenv("APPOPTICS_SERVER_TIMING_SPANS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	// not enabled
+	w := httpTest(handlerWithSpans)
+	assert.Empty(t, w.Header().Get(ao.HTTPHeaderServerTiming))
+
+	// total duration only
+	os.Setenv("APPOPTICS_SERVER_TIMING", "true")
+	config.Load()
+	w = httpTest(handlerWithSpans)
+	assert.Regexp(t, `^total;dur=\d+\.\d{3}$`, w.Header().Get(ao.HTTPHeaderServerTiming))
+
+	// the two longest child spans, aggregated by name
+	os.Setenv("APPOPTICS_SERVER_TIMING_SPANS", "2")
+	config.Load()
+	w = httpTest(handlerWithSpans)
+	st := w.Header().Get(ao.HTTPHeaderServerTiming)
+	assert.Regexp(t, `^total;dur=\d+\.\d{3}, `+
+		`cache;dur=\d+\.\d{3}, `+
+		regexp.QuoteMeta(`postgres_query;desc="postgres query";dur=`)+`\d+\.\d{3}$`, st)
+	assert.NotContains(t, st, "render")
+}
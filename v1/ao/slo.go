@@ -0,0 +1,196 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/metrics"
+)
+
+const (
+	// DefaultSLOWindow is the default short window over which the burn rate
+	// of an SLO is evaluated.
+	DefaultSLOWindow = 5 * time.Minute
+	// DefaultSLOBurnRateThreshold is the default burn rate above which the
+	// SLO callback is fired. A burn rate of 14.4 consumes 2% of a 30-day
+	// error budget in one hour.
+	DefaultSLOBurnRateThreshold = 14.4
+	// DefaultSLOMinRequests is the default minimum number of requests in the
+	// window before the burn rate is evaluated.
+	DefaultSLOMinRequests = 10
+
+	// sloBuckets is the number of sub-windows the evaluation window is split
+	// into. Requests expire from the window one bucket at a time.
+	sloBuckets = 10
+)
+
+// SLO errors
+var (
+	ErrSLOInvalidTransaction = errors.New("SLO transaction name must not be empty")
+	ErrSLOInvalidTarget      = errors.New("SLO target must be within (0, 1)")
+	ErrSLONilCallback        = errors.New("SLO callback must not be nil")
+)
+
+// SLO defines a service level objective of a transaction. A request is
+// considered good if it completes within LatencyThreshold without a server
+// error (HTTP status 5xx).
+type SLO struct {
+	// Transaction is the name of the transaction this SLO applies to, as it
+	// is reported to AppOptics.
+	Transaction string
+	// LatencyThreshold is the maximum duration of a good request. A zero
+	// value means only errors count against the SLO.
+	LatencyThreshold time.Duration
+	// Target is the availability target, e.g. 0.999 for 99.9%.
+	Target float64
+	// Window is the short window the burn rate is evaluated over.
+	// DefaultSLOWindow is used if it's shorter than one second.
+	Window time.Duration
+	// BurnRateThreshold is the burn rate which triggers the callback.
+	// DefaultSLOBurnRateThreshold is used if it's zero.
+	BurnRateThreshold float64
+	// MinRequests is the minimum number of requests in the window before the
+	// burn rate is evaluated. DefaultSLOMinRequests is used if it's zero.
+	MinRequests int64
+}
+
+// SLOAlert is passed to the SLO callback when the burn rate of an SLO
+// exceeds its threshold.
+type SLOAlert struct {
+	SLO
+	// BurnRate is the rate the error budget is consumed at over the window,
+	// e.g. 1.0 means the budget is consumed exactly at the allowed pace.
+	BurnRate float64
+	// Total is the number of requests in the window.
+	Total int64
+	// Bad is the number of requests in the window which violated the SLO.
+	Bad int64
+}
+
+// SLOCallback is the function called when an SLO's burn rate exceeds its
+// threshold. It is called from a separate goroutine and only once each time
+// the threshold is crossed; it will be called again only after the burn rate
+// has dropped below the threshold.
+type SLOCallback func(SLOAlert)
+
+type sloBucket struct {
+	start time.Time
+	total int64
+	bad   int64
+}
+
+type sloEvaluator struct {
+	slo      SLO
+	cb       SLOCallback
+	buckets  [sloBuckets]sloBucket
+	alerting bool
+	lock     sync.Mutex
+}
+
+type sloRegistry struct {
+	evaluators map[string]*sloEvaluator
+	lock       sync.RWMutex
+}
+
+var (
+	slos   = &sloRegistry{evaluators: make(map[string]*sloEvaluator)}
+	sloNow = time.Now
+)
+
+// RegisterSLO registers an SLO for a transaction. The callback is fired when
+// the burn rate over the short window exceeds the threshold, which may be
+// used to react locally, e.g. to shed load or open a circuit. Registering an
+// SLO for a transaction replaces the previous one, if any.
+func RegisterSLO(slo SLO, cb SLOCallback) error {
+	if slo.Transaction == "" {
+		return ErrSLOInvalidTransaction
+	}
+	if slo.Target <= 0 || slo.Target >= 1 {
+		return ErrSLOInvalidTarget
+	}
+	if cb == nil {
+		return ErrSLONilCallback
+	}
+	if slo.Window < time.Second {
+		slo.Window = DefaultSLOWindow
+	}
+	if slo.BurnRateThreshold <= 0 {
+		slo.BurnRateThreshold = DefaultSLOBurnRateThreshold
+	}
+	if slo.MinRequests <= 0 {
+		slo.MinRequests = DefaultSLOMinRequests
+	}
+
+	slos.lock.Lock()
+	defer slos.lock.Unlock()
+	slos.evaluators[slo.Transaction] = &sloEvaluator{slo: slo, cb: cb}
+	return nil
+}
+
+// UnregisterSLO removes the SLO of the transaction, if any.
+func UnregisterSLO(transaction string) {
+	slos.lock.Lock()
+	defer slos.lock.Unlock()
+	delete(slos.evaluators, transaction)
+}
+
+// evaluateSLO feeds the finished HTTP span into the SLO of its transaction.
+func evaluateSLO(s *metrics.HTTPSpanMessage) {
+	slos.lock.RLock()
+	e, ok := slos.evaluators[s.Transaction]
+	slos.lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	bad := s.HasError ||
+		(e.slo.LatencyThreshold > 0 && s.Duration > e.slo.LatencyThreshold)
+	if alert, fire := e.record(sloNow(), bad); fire {
+		log.Infof("SLO burn rate exceeds threshold: transaction=%s burnRate=%.2f",
+			alert.Transaction, alert.BurnRate)
+		go e.cb(alert)
+	}
+}
+
+// record adds a request to the window and returns the alert to fire, if any.
+func (e *sloEvaluator) record(now time.Time, bad bool) (SLOAlert, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	width := e.slo.Window / sloBuckets
+	start := now.Truncate(width)
+	b := &e.buckets[(start.UnixNano()/int64(width))%sloBuckets]
+	if !b.start.Equal(start) {
+		*b = sloBucket{start: start}
+	}
+	b.total++
+	if bad {
+		b.bad++
+	}
+
+	var total, badCnt int64
+	for _, b := range e.buckets {
+		if now.Sub(b.start) < e.slo.Window {
+			total += b.total
+			badCnt += b.bad
+		}
+	}
+	if total < e.slo.MinRequests {
+		return SLOAlert{}, false
+	}
+
+	burnRate := (float64(badCnt) / float64(total)) / (1 - e.slo.Target)
+	if burnRate < e.slo.BurnRateThreshold {
+		e.alerting = false
+		return SLOAlert{}, false
+	}
+	if e.alerting {
+		return SLOAlert{}, false
+	}
+	e.alerting = true
+	return SLOAlert{SLO: e.slo, BurnRate: burnRate, Total: total, Bad: badCnt}, true
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterSLO(t *testing.T) {
+	cb := func(SLOAlert) {}
+	assert.Equal(t, ErrSLOInvalidTransaction, RegisterSLO(SLO{Target: 0.99}, cb))
+	assert.Equal(t, ErrSLOInvalidTarget, RegisterSLO(SLO{Transaction: "t"}, cb))
+	assert.Equal(t, ErrSLOInvalidTarget, RegisterSLO(SLO{Transaction: "t", Target: 1}, cb))
+	assert.Equal(t, ErrSLONilCallback, RegisterSLO(SLO{Transaction: "t", Target: 0.99}, nil))
+
+	assert.Nil(t, RegisterSLO(SLO{Transaction: "t", Target: 0.99}, cb))
+	defer UnregisterSLO("t")
+
+	slos.lock.RLock()
+	e := slos.evaluators["t"]
+	slos.lock.RUnlock()
+	assert.Equal(t, DefaultSLOWindow, e.slo.Window)
+	assert.Equal(t, DefaultSLOBurnRateThreshold, e.slo.BurnRateThreshold)
+	assert.Equal(t, int64(DefaultSLOMinRequests), e.slo.MinRequests)
+}
+
+func TestSLOEvaluatorRecord(t *testing.T) {
+	e := &sloEvaluator{slo: SLO{
+		Transaction:       "t",
+		Target:            0.9,
+		Window:            10 * time.Second,
+		BurnRateThreshold: 2,
+		MinRequests:       10,
+	}}
+	now := time.Unix(1000, 0)
+
+	// not enough requests yet
+	for i := 0; i < 9; i++ {
+		_, fire := e.record(now, true)
+		assert.False(t, fire)
+	}
+	// 10 bad out of 10: burn rate = 1.0 / 0.1 = 10
+	alert, fire := e.record(now, true)
+	assert.True(t, fire)
+	assert.Equal(t, int64(10), alert.Total)
+	assert.Equal(t, int64(10), alert.Bad)
+	assert.InDelta(t, 10.0, alert.BurnRate, 0.0001)
+
+	// fired only once while the threshold is exceeded
+	_, fire = e.record(now, true)
+	assert.False(t, fire)
+
+	// the bad requests expire and the burn rate drops below the threshold
+	now = now.Add(11 * time.Second)
+	for i := 0; i < 10; i++ {
+		_, fire = e.record(now, false)
+		assert.False(t, fire)
+	}
+	assert.False(t, e.alerting)
+
+	// re-armed: 3 bad out of 13 requests gives a burn rate of ~2.3
+	for i := 0; i < 2; i++ {
+		_, fire = e.record(now, true)
+		assert.False(t, fire)
+	}
+	alert, fire = e.record(now, true)
+	assert.True(t, fire)
+	assert.Equal(t, int64(13), alert.Total)
+	assert.Equal(t, int64(3), alert.Bad)
+}
+
+func TestSLOCallback(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	alerts := make(chan SLOAlert, 1)
+	assert.Nil(t, RegisterSLO(SLO{
+		Transaction:      "slo-txn",
+		LatencyThreshold: time.Millisecond,
+		Target:           0.99,
+		MinRequests:      2,
+	}, func(a SLOAlert) { alerts <- a }))
+	defer UnregisterSLO("slo-txn")
+
+	h := HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		SetTransactionName(r.Context(), "slo-txn")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "http://test.com/slo", nil)
+		h(httptest.NewRecorder(), req)
+	}
+
+	select {
+	case a := <-alerts:
+		assert.Equal(t, "slo-txn", a.Transaction)
+		assert.Equal(t, int64(2), a.Bad)
+		assert.InDelta(t, 100.0, a.BurnRate, 0.0001)
+	case <-time.After(time.Second):
+		t.Fatal("SLO callback not called")
+	}
+}
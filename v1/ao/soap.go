@@ -0,0 +1,46 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// HTTPHeaderSOAPAction is the header of the SOAP 1.1 requests naming the
+// operation called.
+const HTTPHeaderSOAPAction = "SOAPAction"
+
+// soapSpanPrefix is the prefix of the names of the SOAP client spans.
+const soapSpanPrefix = "soap."
+
+// SOAPOperation returns the operation of a SOAP request, which is the last
+// segment of the action, e.g., "GetQuote" of "http://example.com/Stock#GetQuote"
+// or "urn:stock:GetQuote". The action is given by the SOAPAction header of
+// SOAP 1.1, or the action parameter of the SOAP 1.2 Content-Type. It returns
+// an empty string if the request has no action.
+func SOAPOperation(req *http.Request) string {
+	action := req.Header.Get(HTTPHeaderSOAPAction)
+	if action == "" {
+		if _, params, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err == nil {
+			action = params["action"]
+		}
+	}
+	action = strings.TrimRight(strings.Trim(strings.TrimSpace(action), `"`), "/")
+	return action[strings.LastIndexAny(action, "/#:")+1:]
+}
+
+// WithSOAPSpanNames returns a function that names the spans of the SOAP
+// requests after their operations, e.g., "soap.GetQuote", as the operations of
+// a SOAP service share the same URL. The requests without an action keep the
+// default name. The other naming schemes can be given by WithSpanNamer.
+//   client := &http.Client{Transport: aohttp.NewTransport(nil, ao.WithSOAPSpanNames())}
+func WithSOAPSpanNames() SpanOpt {
+	return WithSpanNamer(func(req *http.Request) string {
+		if op := SOAPOperation(req); op != "" {
+			return soapSpanPrefix + op
+		}
+		return ""
+	})
+}
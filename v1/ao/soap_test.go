@@ -0,0 +1,51 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSOAPOperation(t *testing.T) {
+	cases := map[string]http.Header{
+		"GetQuote":  {"Soapaction": {`"http://example.com/Stock#GetQuote"`}},
+		"GetPrice":  {"Soapaction": {`"http://example.com/Stock/GetPrice/"`}},
+		"GetVolume": {"Soapaction": {"urn:stock:GetVolume"}},
+		"GetTrades": {"Content-Type": {`application/soap+xml; charset=utf-8; action="urn:GetTrades"`}},
+		"":          {"Soapaction": {`""`}, "Content-Type": {"text/xml"}},
+	}
+	for op, h := range cases {
+		req, _ := http.NewRequest("POST", "http://example.com/stock", nil)
+		req.Header = h
+		assert.Equal(t, op, ao.SOAPOperation(req), h)
+	}
+}
+
+func TestSOAPSpanNames(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("test"))
+	req, _ := http.NewRequest("POST", "http://example.com/stock", nil)
+	req.Header.Set(ao.HTTPHeaderSOAPAction, "urn:GetQuote")
+	ao.BeginHTTPClientSpan(ctx, req, ao.WithSOAPSpanNames()).End()
+	// the requests without an action keep the default name
+	req, _ = http.NewRequest("POST", "http://example.com/stock", nil)
+	ao.BeginHTTPClientSpan(ctx, req, ao.WithSOAPSpanNames()).End()
+	ao.EndTrace(ctx)
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"test", "entry"}:          {},
+		{"soap.GetQuote", "entry"}: {Edges: g.Edges{{"test", "entry"}}},
+		{"soap.GetQuote", "exit"}:  {Edges: g.Edges{{"soap.GetQuote", "entry"}}},
+		{"http.Client", "entry"}:   {Edges: g.Edges{{"test", "entry"}}},
+		{"http.Client", "exit"}:    {Edges: g.Edges{{"http.Client", "entry"}}},
+		{"test", "exit"}:           {Edges: g.Edges{{"soap.GetQuote", "exit"}, {"http.Client", "exit"}, {"test", "entry"}}},
+	})
+}
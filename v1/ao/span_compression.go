@@ -0,0 +1,202 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// The KVs of the exit event of a composite span, which stands for the
+// consecutive identical child spans compressed into it
+const (
+	keyCompressedCount       = "CompressedCount"
+	keyCompressedErrors      = "CompressedErrors"
+	keyCompressedDuration    = "CompressedDuration_us"
+	keyCompressedMinDuration = "CompressedMinDuration_us"
+	keyCompressedMaxDuration = "CompressedMaxDuration_us"
+)
+
+// keyPeer is the KV whose value tells the peers of the child spans apart
+const keyPeer = "RemoteHost"
+
+// spanRun is a run of the consecutive child spans of a parent with the same
+// name and peer.
+type spanRun struct {
+	name    string
+	peer    string
+	kvs     []interface{} // the entry KVs of the first span compressed
+	started int           // the spans of the run started, including the ones reported
+	count   int           // the compressed spans ended
+	errors  int
+	total   time.Duration
+	min     time.Duration
+	max     time.Duration
+	first   time.Time // the start of the first span compressed
+	last    time.Time // the end of the last span compressed
+}
+
+// spanCompressor tracks the current run of the child spans of a span, to
+// compress the ones beyond APPOPTICS_SPAN_COMPRESSION_THRESHOLD, e.g., the
+// hundreds of GETs to the same Redis server by a loop.
+type spanCompressor struct {
+	lock   sync.Mutex
+	parent Span // the span the compressed children are reported under
+	run    *spanRun
+}
+
+func (s *span) compressor() *spanCompressor { return &s.children }
+
+// compressChild returns the span standing for the child span of parent if it
+// is compressed, or nil if it should be reported as is. The composite span of
+// the previous run is reported if the child starts a new one.
+func compressChild(parent Span, spanName string, kvs []interface{}) Span {
+	threshold := config.GetSpanCompressionThreshold()
+	if threshold <= 0 {
+		return nil
+	}
+	p, ok := parent.(interface{ compressor() *spanCompressor })
+	if !ok {
+		return nil
+	}
+	c := p.compressor()
+	peer := peerOf(kvs)
+
+	c.lock.Lock()
+	c.parent = parent
+	if r := c.run; r != nil && r.name == spanName && r.peer == peer {
+		r.started++
+		if r.started > threshold {
+			now := time.Now()
+			if r.kvs == nil {
+				r.kvs, r.first = kvs, now
+			}
+			c.lock.Unlock()
+			return &compressedSpan{parent: parent, c: c, run: r, start: now}
+		}
+		c.lock.Unlock()
+		return nil
+	}
+	prev := c.run
+	c.run = &spanRun{name: spanName, peer: peer, started: 1}
+	c.lock.Unlock()
+
+	c.report(parent, prev)
+	return nil
+}
+
+// flush reports the composite span of the current run of the child spans, if
+// any, before their parent ends.
+func (c *spanCompressor) flush() {
+	c.lock.Lock()
+	parent, r := c.parent, c.run
+	c.run = nil
+	c.lock.Unlock()
+
+	c.report(parent, r)
+}
+
+// report reports the composite span of the spans of the run compressed, which
+// starts with the first of them and ends with the last. The spans still
+// running are left out.
+func (c *spanCompressor) report(parent Span, r *spanRun) {
+	if r == nil {
+		return
+	}
+	c.lock.Lock()
+	count, errs, total, min, max := r.count, r.errors, r.total, r.min, r.max
+	first, last := r.first, r.last
+	c.lock.Unlock()
+	if count == 0 {
+		return
+	}
+
+	kvs := append(r.kvs[:len(r.kvs):len(r.kvs)], reporter.TimestampKey, first)
+	l := newSpan(parent.aoContext().Copy(), r.name, parent, kvs...)
+	if ls, ok := l.(*layerSpan); ok {
+		ls.start, ls.end = first, last
+	}
+	args := []interface{}{
+		keyCompressedCount, count,
+		keyCompressedDuration, int64(total / time.Microsecond),
+		keyCompressedMinDuration, int64(min / time.Microsecond),
+		keyCompressedMaxDuration, int64(max / time.Microsecond),
+	}
+	if errs > 0 {
+		args = append(args, keyCompressedErrors, errs)
+	}
+	l.End(args...)
+}
+
+// peerOf returns the peer of a span from its entry KVs.
+func peerOf(kvs []interface{}) string {
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if k, ok := kvs[i].(string); ok && k == keyPeer {
+			if v, ok := kvs[i+1].(string); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// compressedSpan is a child span compressed into the composite span of its
+// run. It reports nothing but its duration and errors, and it propagates the
+// context of its parent.
+type compressedSpan struct {
+	nullSpan
+	parent Span
+	c      *spanCompressor
+	run    *spanRun
+	start  time.Time
+	once   sync.Once
+}
+
+func (s *compressedSpan) End(args ...interface{}) {
+	s.once.Do(func() {
+		d := time.Since(s.start)
+		s.c.lock.Lock()
+		defer s.c.lock.Unlock()
+		r := s.run
+		if r.count == 0 || d < r.min {
+			r.min = d
+		}
+		if d > r.max {
+			r.max = d
+		}
+		r.count++
+		r.total += d
+		if end := s.start.Add(d); end.After(r.last) {
+			r.last = end
+		}
+	})
+}
+
+func (s *compressedSpan) ErrorWithOpts(opts ...ErrOpt) { s.recordError() }
+func (s *compressedSpan) Error(class, msg string)      { s.recordError() }
+
+func (s *compressedSpan) Err(err error) {
+	if err != nil {
+		s.recordError()
+	}
+}
+
+// recordError counts an error in the composite span, and in the trace as the
+// errors reported by the spans are.
+func (s *compressedSpan) recordError() {
+	s.c.lock.Lock()
+	s.run.errors++
+	s.c.lock.Unlock()
+	if p, ok := s.parent.(interface{ errorCounter() *int64 }); ok {
+		if n := p.errorCounter(); n != nil {
+			atomic.AddInt64(n, 1)
+		}
+	}
+}
+
+func (s *compressedSpan) MetadataString() string { return s.parent.MetadataString() }
+func (s *compressedSpan) IsSampled() bool        { return s.parent.IsSampled() }
@@ -0,0 +1,146 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSpanCompression(t *testing.T) {
+	os.Setenv("APPOPTICS_SPAN_COMPRESSION_THRESHOLD", "2")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SPAN_COMPRESSION_THRESHOLD")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("root"))
+	for i := 0; i < 5; i++ {
+		l := BeginCacheSpan(ctx, "redis", "GET", "k", "redis:6379", true)
+		if i == 4 {
+			l.Err(errors.New("timeout"))
+		}
+		l.End()
+	}
+	// a different peer starts a new run
+	BeginCacheSpan(ctx, "redis", "GET", "k", "redis2:6379", true).End()
+	// a single compressed span ends its run with the parent
+	parent, _ := BeginSpan(ctx, "parent")
+	for i := 0; i < 3; i++ {
+		l := parent.BeginSpan("query", "RemoteHost", "db")
+		assert.Equal(t, i < 2, l.IsReporting())
+		l.End()
+	}
+	parent.End()
+	EndTrace(ctx)
+	r.Close(18)
+
+	var composites, queryComposites int
+	g.AssertGraph(t, r.EventBufs, 18, g.AssertNodeMap{
+		{"root", "entry"}: {},
+		{"redis", "entry"}: {Count: 4, Edges: g.Edges{{"root", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "GET", n.Map["KVOp"])
+		}},
+		{"redis", "exit"}: {Count: 4, Edges: g.Edges{{"redis", "entry"}}, Callback: func(n g.Node) {
+			if _, ok := n.Map[keyCompressedCount]; !ok {
+				return
+			}
+			composites++
+			assert.EqualValues(t, 3, n.Map[keyCompressedCount])
+			assert.EqualValues(t, 1, n.Map[keyCompressedErrors])
+			assert.Contains(t, n.Map, keyCompressedDuration)
+			assert.Contains(t, n.Map, keyCompressedMinDuration)
+			assert.Contains(t, n.Map, keyCompressedMaxDuration)
+		}},
+		{"parent", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"query", "entry"}:  {Count: 3, Edges: g.Edges{{"parent", "entry"}}},
+		{"query", "exit"}: {Count: 3, Edges: g.Edges{{"query", "entry"}}, Callback: func(n g.Node) {
+			if _, ok := n.Map[keyCompressedCount]; ok {
+				queryComposites++
+				assert.EqualValues(t, 1, n.Map[keyCompressedCount])
+				assert.NotContains(t, n.Map, keyCompressedErrors)
+			}
+		}},
+		{"parent", "exit"}: {Edges: g.Edges{{"query", "exit"}, {"query", "exit"}, {"query", "exit"}, {"parent", "entry"}}},
+		{"root", "exit"}: {Edges: g.Edges{{"redis", "exit"}, {"redis", "exit"}, {"redis", "exit"},
+			{"redis", "exit"}, {"parent", "exit"}, {"root", "entry"}}},
+	})
+	assert.Equal(t, 1, composites)
+	assert.Equal(t, 1, queryComposites)
+}
+
+func TestSpanCompressionDisabled(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("root"))
+	for i := 0; i < 5; i++ {
+		l, _ := BeginSpan(ctx, "redis", "RemoteHost", "redis:6379")
+		assert.True(t, l.IsReporting())
+		l.End()
+	}
+	EndTrace(ctx)
+	r.Close(12)
+
+	g.AssertGraph(t, r.EventBufs, 12, g.AssertNodeMap{
+		{"root", "entry"}:  {},
+		{"redis", "entry"}: {Count: 5, Edges: g.Edges{{"root", "entry"}}},
+		{"redis", "exit"}: {Count: 5, Edges: g.Edges{{"redis", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keyCompressedCount)
+		}},
+		{"root", "exit"}: {Edges: g.Edges{{"redis", "exit"}, {"redis", "exit"}, {"redis", "exit"},
+			{"redis", "exit"}, {"redis", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestSpanCompressionTimes(t *testing.T) {
+	os.Setenv("APPOPTICS_SPAN_COMPRESSION_THRESHOLD", "1")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SPAN_COMPRESSION_THRESHOLD")
+		config.Load()
+	}()
+	var summary TraceSummary
+	OnTraceComplete(func(s TraceSummary) { summary = s })
+	defer OnTraceComplete(nil)
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	tr.BeginSpan("query", "RemoteHost", "db").End()
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		l := tr.BeginSpan("query", "RemoteHost", "db")
+		time.Sleep(time.Millisecond)
+		l.Err(errors.New("timeout"))
+		l.End()
+	}
+	end := time.Now()
+	// the composite span is reported once its parent ends
+	time.Sleep(20 * time.Millisecond)
+	tr.End()
+	r.Close(6)
+
+	// the events of the composite span are the last ones before the root exit
+	var ts []int64
+	for _, buf := range r.EventBufs[3:5] {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		require.Equal(t, "query", m["Layer"])
+		ts = append(ts, m["Timestamp_u"].(int64))
+	}
+	assert.GreaterOrEqual(t, ts[0], start.UnixNano()/1000)
+	assert.LessOrEqual(t, ts[1], end.UnixNano()/1000)
+	assert.GreaterOrEqual(t, ts[1]-ts[0], int64(2*time.Millisecond/time.Microsecond))
+	// the errors of the compressed spans are counted in the trace
+	assert.EqualValues(t, 2, summary.Errors)
+}
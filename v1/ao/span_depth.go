@@ -0,0 +1,151 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// The KVs of the info events reporting the spans flattened by
+// APPOPTICS_MAX_SPAN_DEPTH
+const (
+	keyFlattenedSpan     = "FlattenedSpan"
+	keyFlattenedDuration = "FlattenedDuration_us"
+)
+
+// spanDepth returns the number of the ancestors of the span.
+func (s *span) spanDepth() int { return s.depth }
+
+// flattenChild returns the span standing for the child span of parent if it's
+// deeper than APPOPTICS_MAX_SPAN_DEPTH, or nil if it should be reported as is.
+// The flattened spans, and their own children, are reported as the info events
+// of their deepest allowed ancestor, e.g., to keep the recursive instrumented
+// calls from producing enormous graphs.
+func flattenChild(parent Span, spanName string, kvs []interface{}) Span {
+	if f, ok := parent.(*flattenedSpan); ok {
+		return newFlattenedSpan(f.ancestor, spanName, kvs)
+	}
+	max := config.GetMaxSpanDepth()
+	if max <= 0 {
+		return nil
+	}
+	p, ok := parent.(interface{ spanDepth() int })
+	if !ok || p.spanDepth()+1 < max {
+		return nil
+	}
+	return newFlattenedSpan(parent, spanName, kvs)
+}
+
+// flattenedSpan is a span reported as an info event of its ancestor when it
+// ends, with its name, duration and KVs. The errors and the info events of it
+// are reported by the ancestor as well, which propagates its context.
+type flattenedSpan struct {
+	nullSpan
+	ancestor Span
+	name     string
+	kvs      []interface{}
+	start    time.Time
+	ended    bool
+	lock     sync.Mutex
+}
+
+func newFlattenedSpan(ancestor Span, spanName string, kvs []interface{}) Span {
+	if spanName == "" {
+		return nullSpan{}
+	}
+	return &flattenedSpan{ancestor: ancestor, name: spanName, kvs: kvs, start: time.Now()}
+}
+
+func (s *flattenedSpan) BeginSpan(spanName string, args ...interface{}) Span {
+	return s.BeginSpanWithOptions(spanName, SpanOptions{}, args...)
+}
+
+func (s *flattenedSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span {
+	if !s.ok() {
+		return nullSpan{}
+	}
+	validateSpanKVs(spanName, args)
+	kvs := mergeKVs(addKVsFromOpts(opts, args...), lazyKVs(s.IsSampled(), opts.CB))
+	return newFlattenedSpan(s.ancestor, spanName, kvs)
+}
+
+func (s *flattenedSpan) End(args ...interface{}) {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	kvs := mergeKVs(s.kvs, args)
+	s.lock.Unlock()
+
+	s.ancestor.Info(mergeKVs([]interface{}{
+		keyFlattenedSpan, s.name,
+		keyFlattenedDuration, int64(time.Since(s.start) / time.Microsecond),
+	}, kvs)...)
+}
+
+func (s *flattenedSpan) AddEndArgs(args ...interface{}) {
+	// ensure even number of args added
+	if len(args)%2 == 1 {
+		args = args[0 : len(args)-1]
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.kvs = mergeKVs(s.kvs, args)
+}
+
+func (s *flattenedSpan) Info(args ...interface{}) {
+	s.InfoWithOptions(SpanOptions{}, args...)
+}
+
+func (s *flattenedSpan) InfoWithOptions(opts SpanOptions, args ...interface{}) {
+	if s.ok() {
+		s.ancestor.InfoWithOptions(opts, mergeKVs([]interface{}{keyFlattenedSpan, s.name}, args)...)
+	}
+}
+
+func (s *flattenedSpan) ErrorWithOpts(opts ...ErrOpt) {
+	if s.ok() {
+		s.ancestor.ErrorWithOpts(opts...)
+	}
+}
+
+func (s *flattenedSpan) Error(class, msg string) {
+	if s.ok() {
+		s.ancestor.Error(class, msg)
+	}
+}
+
+func (s *flattenedSpan) Err(err error) {
+	if s.ok() {
+		s.ancestor.Err(err)
+	}
+}
+
+func (s *flattenedSpan) ok() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.ended && s.ancestor.ok()
+}
+
+func (s *flattenedSpan) IsReporting() bool      { return s.ok() }
+func (s *flattenedSpan) MetadataString() string { return s.ancestor.MetadataString() }
+func (s *flattenedSpan) IsSampled() bool        { return s.ancestor.IsSampled() }
+
+func (s *flattenedSpan) SetOperationName(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.name = name
+}
+
+func (s *flattenedSpan) SetTransactionName(name string) error {
+	return s.ancestor.SetTransactionName(name)
+}
+
+func (s *flattenedSpan) GetTransactionName() string {
+	return s.ancestor.GetTransactionName()
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxSpanDepth(t *testing.T) {
+	os.Setenv("APPOPTICS_MAX_SPAN_DEPTH", "2")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MAX_SPAN_DEPTH")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("root"))
+	child, ctxC := BeginSpan(ctx, "child")
+
+	// the grandchildren are flattened into the child, recursively
+	deep, ctxD := BeginSpan(ctxC, "deep", "Depth", 3)
+	assert.True(t, deep.IsReporting())
+	assert.Equal(t, child.MetadataString(), deep.MetadataString())
+	deeper, _ := BeginSpan(ctxD, "deeper")
+	deeper.Err(errors.New("boom"))
+	deeper.End()
+	deep.AddEndArgs("Rows", 10)
+	deep.End()
+	assert.False(t, deep.IsReporting())
+	// not ended before its ancestor
+	noted := child.BeginSpan("noted")
+	noted.Info("Step", 1)
+
+	child.End()
+	assert.False(t, noted.IsReporting())
+	EndTrace(ctx)
+	r.Close(8)
+
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeKVMap{
+		{"root", "entry", "", ""}:  {},
+		{"child", "entry", "", ""}: {Edges: g.Edges{{"root", "entry"}}},
+		{"child", "error", "", ""}: {Edges: g.Edges{{"child", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "boom", n.Map["ErrorMsg"])
+		}},
+		{"child", "info", keyFlattenedSpan, "deeper"}: {Edges: g.Edges{{"child", "error"}}, Callback: func(n g.Node) {
+			assert.Contains(t, n.Map, keyFlattenedDuration)
+		}},
+		{"child", "info", keyFlattenedSpan, "deep"}: {Edges: g.Edges{{"child", "info"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 3, n.Map["Depth"])
+			assert.EqualValues(t, 10, n.Map["Rows"])
+			assert.Contains(t, n.Map, keyFlattenedDuration)
+		}},
+		{"child", "info", keyFlattenedSpan, "noted"}: {Edges: g.Edges{{"child", "info"}}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 1, n.Map["Step"])
+			assert.NotContains(t, n.Map, keyFlattenedDuration)
+		}},
+		{"child", "exit", "", ""}: {Edges: g.Edges{{"child", "info"}}},
+		{"root", "exit", "", ""}:  {Edges: g.Edges{{"child", "exit"}, {"root", "entry"}}},
+	})
+}
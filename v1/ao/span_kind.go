@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "fmt"
+
+// SpanKind describes the role of a span in a trace, e.g., whether it serves a
+// request or makes one.
+type SpanKind int
+
+// The kinds of the spans
+const (
+	// SpanKindUnspecified is the kind of a span which isn't given one, it's
+	// not reported.
+	SpanKindUnspecified SpanKind = iota
+	// SpanKindInternal is an operation inside the service.
+	SpanKindInternal
+	// SpanKindServer serves a request from a remote client, e.g., an HTTP
+	// handler or a gRPC server method.
+	SpanKindServer
+	// SpanKindClient makes a request to a remote service, e.g., an HTTP or
+	// gRPC client call or a database query.
+	SpanKindClient
+	// SpanKindProducer sends a message to a broker to be processed later.
+	SpanKindProducer
+	// SpanKindConsumer processes a message received from a broker.
+	SpanKindConsumer
+)
+
+const keySpanKind = "SpanKind"
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindUnspecified:
+		return "unspecified"
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	case SpanKindProducer:
+		return "producer"
+	case SpanKindConsumer:
+		return "consumer"
+	default:
+		return fmt.Sprintf("SpanKind(%d)", int(k))
+	}
+}
+
+// WithSpanKind returns a function that overrides the kind of the spans of the
+// HTTP instrumentation, which are the server spans for HTTPHandler and the
+// client spans for BeginHTTPClientSpan by default.
+//   l := ao.BeginHTTPClientSpan(ctx, req, ao.WithSpanKind(ao.SpanKindProducer))
+func WithSpanKind(kind SpanKind) SpanOpt {
+	return func(o *SpanOptions) {
+		o.Kind = kind
+	}
+}
+
+// spanKindArgs returns the KV of the kind to be reported on entry.
+func spanKindArgs(kind SpanKind) []interface{} {
+	if kind <= SpanKindUnspecified || kind > SpanKindConsumer {
+		return nil
+	}
+	return []interface{}{keySpanKind, kind.String()}
+}
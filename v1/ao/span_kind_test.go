@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanKindString(t *testing.T) {
+	assert.Equal(t, "unspecified", ao.SpanKindUnspecified.String())
+	assert.Equal(t, "internal", ao.SpanKindInternal.String())
+	assert.Equal(t, "server", ao.SpanKindServer.String())
+	assert.Equal(t, "client", ao.SpanKindClient.String())
+	assert.Equal(t, "producer", ao.SpanKindProducer.String())
+	assert.Equal(t, "consumer", ao.SpanKindConsumer.String())
+	assert.Equal(t, "SpanKind(9)", ao.SpanKind(9).String())
+}
+
+func TestSpanKind(t *testing.T) {
+	r := reporter.SetTestReporter()
+	httpTest(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		creq, _ := http.NewRequest("GET", "http://example.com/api", nil)
+		ao.BeginHTTPClientSpan(ctx, creq).End()
+		p, _ := ao.BeginSpanWithOptions(ctx, "produce", ao.SpanOptions{Kind: ao.SpanKindProducer})
+		p.End()
+		l, _ := ao.BeginSpan(ctx, "plain")
+		l.End()
+	})
+	r.Close(8)
+
+	assertKind := func(kind string) func(n g.Node) {
+		return func(n g.Node) { assert.Equal(t, kind, n.Map["SpanKind"]) }
+	}
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Callback: assertKind("server")},
+		{"http.Client", "entry"}:      {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: assertKind("client")},
+		{"http.Client", "exit"}:       {Edges: g.Edges{{"http.Client", "entry"}}},
+		{"produce", "entry"}:          {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: assertKind("producer")},
+		{"produce", "exit"}:           {Edges: g.Edges{{"produce", "entry"}}},
+		{"plain", "entry"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SpanKind")
+		}},
+		{"plain", "exit"}: {Edges: g.Edges{{"plain", "entry"}}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.Client", "exit"}, {"produce", "exit"},
+			{"plain", "exit"}, {"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestWithSpanKind(t *testing.T) {
+	r := reporter.SetTestReporter()
+	httpTest(handler200, ao.WithSpanKind(ao.SpanKindConsumer))
+	r.Close(2)
+
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, "consumer", n.Map["SpanKind"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+}
@@ -0,0 +1,216 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// KVType is the expected type of the value of a KV declared in a SpanSchema.
+type KVType int
+
+// The types of the KV values. The pointer values are checked by the values
+// they point to.
+const (
+	// KVAny accepts a value of any type
+	KVAny KVType = iota
+	// KVString accepts a string, or a time.Time reported as a timestamp
+	KVString
+	// KVInt accepts a signed or unsigned integer of any size
+	KVInt
+	// KVFloat accepts a float32 or float64, or a time.Duration reported in
+	// milliseconds
+	KVFloat
+	// KVBool accepts a bool
+	KVBool
+)
+
+func (t KVType) String() string {
+	switch t {
+	case KVAny:
+		return "any"
+	case KVString:
+		return "string"
+	case KVInt:
+		return "int"
+	case KVFloat:
+		return "float"
+	case KVBool:
+		return "bool"
+	default:
+		return fmt.Sprintf("KVType(%d)", int(t))
+	}
+}
+
+// SpanSchema declares the KVs expected on a span.
+type SpanSchema struct {
+	// KVs maps the names of the KVs to their types.
+	KVs map[string]KVType
+	// AllowUnknown stops the KVs not in KVs from being flagged, only the
+	// types of the declared ones are checked.
+	AllowUnknown bool
+}
+
+// SchemaViolation describes a KV which doesn't match the schema of its span.
+type SchemaViolation struct {
+	SpanName string
+	Key      string
+	// Unknown is true if the KV is not declared by the schema.
+	Unknown bool
+	// Expected is the declared type of a mistyped KV.
+	Expected KVType
+	// Value is the value reported.
+	Value interface{}
+}
+
+func (v SchemaViolation) String() string {
+	if v.Unknown {
+		return fmt.Sprintf("span %q: unknown KV %q", v.SpanName, v.Key)
+	}
+	return fmt.Sprintf("span %q: KV %q is %T, expected %v", v.SpanName, v.Key, v.Value, v.Expected)
+}
+
+// the KVs reported by the agent itself, which are never flagged
+var reservedSchemaKeys = map[string]bool{
+	keyEdge:               true,
+	keyAsync:              true,
+	keyStatus:             true,
+	keyController:         true,
+	keyAction:             true,
+	keyTransactionName:    true,
+	keyRemoteStatus:       true,
+	keyContentLength:      true,
+	keyErrorResponseBody:  true,
+	keyRetryAfter:         true,
+	keyRateLimitRemaining: true,
+	keyRateLimitReset:     true,
+	keyAgentOverhead:      true,
+	keyMiddlewareTime:     true,
+	keyHandlerTime:        true,
+	KeyBackTrace:          true,
+	keySpanStatus:         true,
+	keySpanStatusMessage:  true,
+	keySpanKind:           true,
+}
+
+var spanSchemas = struct {
+	sync.RWMutex
+	m       map[string]SpanSchema
+	handler func(SchemaViolation)
+}{m: make(map[string]SpanSchema)}
+
+// RegisterSpanSchema declares the KVs expected on the spans named spanName.
+// When APPOPTICS_SPAN_SCHEMA_VALIDATION is enabled, the KVs reported on these
+// spans which are unknown to the schema or of a wrong type are logged as
+// warnings and passed to the handler set by SetSchemaViolationHandler. The
+// KVs are reported regardless. Registering a span name again replaces its
+// schema.
+//
+// The validation is meant for development and testing, it's off by default.
+func RegisterSpanSchema(spanName string, schema SpanSchema) {
+	kvs := make(map[string]KVType, len(schema.KVs))
+	for k, t := range schema.KVs {
+		kvs[k] = t
+	}
+	schema.KVs = kvs
+
+	spanSchemas.Lock()
+	defer spanSchemas.Unlock()
+	spanSchemas.m[spanName] = schema
+}
+
+// SetSchemaViolationHandler sets a function to be called with each KV
+// violating the schema of its span, e.g., to fail a test. The violations are
+// only logged if it's nil.
+func SetSchemaViolationHandler(h func(SchemaViolation)) {
+	spanSchemas.Lock()
+	defer spanSchemas.Unlock()
+	spanSchemas.handler = h
+}
+
+// validateSpanKVs checks the KVs provided by args against the schema of the
+// span, if there is one and the validation is enabled.
+func validateSpanKVs(spanName string, args []interface{}) {
+	if len(args) < 2 || !config.GetSpanSchemaValidation() {
+		return
+	}
+	spanSchemas.RLock()
+	schema, ok := spanSchemas.m[spanName]
+	handler := spanSchemas.handler
+	spanSchemas.RUnlock()
+	if !ok {
+		return
+	}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok || reservedSchemaKeys[key] {
+			continue
+		}
+		v := SchemaViolation{SpanName: spanName, Key: key, Value: args[i+1]}
+		t, declared := schema.KVs[key]
+		if !declared {
+			if schema.AllowUnknown {
+				continue
+			}
+			v.Unknown = true
+		} else if kvTypeMatches(t, args[i+1]) {
+			continue
+		} else {
+			v.Expected = t
+		}
+		log.Warningf("Span schema violation: %v", v)
+		if handler != nil {
+			handler(v)
+		}
+	}
+}
+
+func kvTypeMatches(t KVType, val interface{}) bool {
+	if t == KVAny {
+		return true
+	}
+	// the common types don't need reflection
+	switch val.(type) {
+	case string, time.Time:
+		return t == KVString
+	case int, int64, int32, uint, uint64, uint32:
+		return t == KVInt
+	case float64, float32, time.Duration:
+		return t == KVFloat
+	case bool:
+		return t == KVBool
+	}
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Interface().(type) {
+	case time.Duration:
+		return t == KVFloat
+	case time.Time:
+		return t == KVString
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return t == KVString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t == KVInt
+	case reflect.Float32, reflect.Float64:
+		return t == KVFloat
+	case reflect.Bool:
+		return t == KVBool
+	default:
+		return false
+	}
+}
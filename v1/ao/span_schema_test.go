@@ -0,0 +1,66 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanSchemaValidation(t *testing.T) {
+	var violations []ao.SchemaViolation
+	ao.SetSchemaViolationHandler(func(v ao.SchemaViolation) { violations = append(violations, v) })
+	defer ao.SetSchemaViolationHandler(nil)
+	ao.RegisterSpanSchema("checkout", ao.SpanSchema{KVs: map[string]ao.KVType{
+		"OrderID": ao.KVString,
+		"Items":   ao.KVInt,
+		"Total":   ao.KVFloat,
+		"Retried": ao.KVBool,
+		"Extra":   ao.KVAny,
+		"Elapsed": ao.KVFloat, // durations are reported in milliseconds
+		"Started": ao.KVString,
+	}})
+	ao.RegisterSpanSchema("lenient", ao.SpanSchema{AllowUnknown: true, KVs: map[string]ao.KVType{"Items": ao.KVInt}})
+
+	run := func() {
+		r := reporter.SetTestReporter()
+		ctx := ao.NewContext(context.Background(), ao.NewTrace("schemaTest"))
+		total := 9.5
+		s, _ := ao.BeginSpan(ctx, "checkout", "OrderID", "o-1", "Items", uint8(2), "Coupon", "SAVE")
+		started := time.Now()
+		s.Info("Items", "two", "Elapsed", 3*time.Second, "Started", &started)
+		s.AddEndArgs("Total", &total, "Extra", []string{"x"})
+		s.End("Retried", 1)
+		l, _ := ao.BeginSpan(ctx, "lenient", "Items", 3.0, "Other", 1)
+		l.End()
+		ao.EndTrace(ctx)
+		r.Close(7)
+	}
+
+	// off by default
+	run()
+	assert.Empty(t, violations)
+
+	os.Setenv("APPOPTICS_SPAN_SCHEMA_VALIDATION", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SPAN_SCHEMA_VALIDATION")
+		config.Load()
+	}()
+	run()
+	assert.Equal(t, []ao.SchemaViolation{
+		{SpanName: "checkout", Key: "Coupon", Unknown: true, Value: "SAVE"},
+		{SpanName: "checkout", Key: "Items", Expected: ao.KVInt, Value: "two"},
+		{SpanName: "checkout", Key: "Retried", Expected: ao.KVBool, Value: 1},
+		{SpanName: "lenient", Key: "Items", Expected: ao.KVInt, Value: 3.0},
+	}, violations)
+	assert.Equal(t, `span "checkout": unknown KV "Coupon"`, violations[0].String())
+	assert.Equal(t, `span "checkout": KV "Items" is string, expected int`, violations[1].String())
+}
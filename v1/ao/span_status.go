@@ -0,0 +1,90 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "fmt"
+
+// StatusCode is the outcome of a span. Unlike the HTTP status reported as the
+// Status KV, it applies to the spans of any kind.
+type StatusCode int
+
+// The status codes of the spans
+const (
+	// StatusUnset is the status of a span which hasn't recorded any error or
+	// been given a status, it's not reported.
+	StatusUnset StatusCode = iota
+	// StatusOK marks a span as completed successfully.
+	StatusOK
+	// StatusError marks a span as failed.
+	StatusError
+)
+
+const (
+	keySpanStatus        = "SpanStatus"
+	keySpanStatusMessage = "SpanStatusMessage"
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusUnset:
+		return "unset"
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	default:
+		return fmt.Sprintf("StatusCode(%d)", int(c))
+	}
+}
+
+// spanStatus is the status of a span and whether it's set by
+// SetSpanStatus.
+type spanStatus struct {
+	code     StatusCode
+	msg      string
+	explicit bool
+}
+
+// SetSpanStatus sets the status of the span, which is reported as the
+// SpanStatus and SpanStatusMessage KVs of its exit event. The message is only
+// kept for StatusError. A status set explicitly is not changed by the errors
+// recorded afterwards, while setting StatusUnset clears it and lets the errors
+// set it again.
+func (s *span) SetSpanStatus(code StatusCode, msg string) {
+	if code < StatusUnset || code > StatusError {
+		return
+	}
+	if code != StatusError {
+		msg = ""
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended {
+		return
+	}
+	s.status = spanStatus{code: code, msg: msg, explicit: code != StatusUnset}
+}
+
+// recordErrorStatus sets the status to StatusError on an error recorded,
+// unless the status has been set explicitly.
+func (s *span) recordErrorStatus(msg string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended || s.status.explicit {
+		return
+	}
+	s.status = spanStatus{code: StatusError, msg: msg}
+}
+
+// statusArgs returns the KVs of the status to be reported on exit, the lock
+// must be held by the caller.
+func (s *span) statusArgs() []interface{} {
+	if s.status.code == StatusUnset {
+		return nil
+	}
+	args := []interface{}{keySpanStatus, s.status.code.String()}
+	if s.status.msg != "" {
+		args = append(args, keySpanStatusMessage, s.status.msg)
+	}
+	return args
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusCodeString(t *testing.T) {
+	assert.Equal(t, "unset", StatusUnset.String())
+	assert.Equal(t, "ok", StatusOK.String())
+	assert.Equal(t, "error", StatusError.String())
+	assert.Equal(t, "StatusCode(9)", StatusCode(9).String())
+}
+
+func TestSpanStatus(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("statusRoot"))
+
+	// the errors set the status automatically
+	failed, _ := BeginSpan(ctx, "failed")
+	failed.Err(errors.New("boom"))
+	failed.End()
+
+	// an explicit status is not changed by the errors
+	explicit, _ := BeginSpan(ctx, "explicit")
+	explicit.SetSpanStatus(StatusOK, "ignored")
+	explicit.Error("retry", "recovered")
+	explicit.End()
+
+	// StatusUnset lets the errors set it again
+	reset, _ := BeginSpan(ctx, "reset")
+	reset.SetSpanStatus(StatusError, "bad input")
+	reset.SetSpanStatus(StatusUnset, "")
+	reset.Err(errors.New("timeout"))
+	reset.End()
+
+	quiet, _ := BeginSpan(ctx, "quiet")
+	quiet.End()
+	// no-ops after End
+	quiet.SetSpanStatus(StatusError, "late")
+
+	SetSpanStatus(ctx, StatusOK, "")
+	EndTrace(ctx)
+	r.Close(13)
+
+	g.AssertGraph(t, r.EventBufs, 13, g.AssertNodeMap{
+		{"statusRoot", "entry"}: {},
+		{"failed", "entry"}:     {Edges: g.Edges{{"statusRoot", "entry"}}},
+		{"failed", "error"}:     {Edges: g.Edges{{"failed", "entry"}}},
+		{"failed", "exit"}: {Edges: g.Edges{{"failed", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "error", n.Map[keySpanStatus])
+			assert.Equal(t, "boom", n.Map[keySpanStatusMessage])
+		}},
+		{"explicit", "entry"}: {Edges: g.Edges{{"statusRoot", "entry"}}},
+		{"explicit", "error"}: {Edges: g.Edges{{"explicit", "entry"}}},
+		{"explicit", "exit"}: {Edges: g.Edges{{"explicit", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "ok", n.Map[keySpanStatus])
+			assert.NotContains(t, n.Map, keySpanStatusMessage)
+		}},
+		{"reset", "entry"}: {Edges: g.Edges{{"statusRoot", "entry"}}},
+		{"reset", "error"}: {Edges: g.Edges{{"reset", "entry"}}},
+		{"reset", "exit"}: {Edges: g.Edges{{"reset", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "error", n.Map[keySpanStatus])
+			assert.Equal(t, "timeout", n.Map[keySpanStatusMessage])
+		}},
+		{"quiet", "entry"}: {Edges: g.Edges{{"statusRoot", "entry"}}},
+		{"quiet", "exit"}: {Edges: g.Edges{{"quiet", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keySpanStatus)
+		}},
+		{"statusRoot", "exit"}: {Edges: g.Edges{{"failed", "exit"}, {"explicit", "exit"}, {"reset", "exit"},
+			{"quiet", "exit"}, {"statusRoot", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "ok", n.Map[keySpanStatus])
+		}},
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+)
+
+// SpanTemplate is a predefined span, i.e., a name, the static KVs and the
+// options, which can be started cheaply many times. The KVs of the options,
+// e.g., the SpanKind, are built once by NewSpanTemplate rather than every time
+// a span is started, which suits the hot and uniform spans, e.g., cache gets:
+//   var cacheGet = ao.NewSpanTemplate("redis", ao.SpanOptions{Kind: ao.SpanKindClient},
+//   	"RemoteHost", "cache:6379", "Operation", "GET")
+//
+//   func get(ctx context.Context, key string) {
+//   	s, _ := cacheGet.Begin(ctx)
+//   	defer s.End()
+//   	// ...
+//   }
+// A SpanTemplate is safe for concurrent use.
+type SpanTemplate struct {
+	name string
+	opts SpanOptions
+	kvs  []interface{}
+}
+
+// NewSpanTemplate returns a SpanTemplate of the spans named spanName, whose
+// entry events have the KVs args. The dangling element of args is dropped.
+func NewSpanTemplate(spanName string, opts SpanOptions, args ...interface{}) *SpanTemplate {
+	if len(args)%2 == 1 {
+		args = args[0 : len(args)-1]
+	}
+	// the backtraces are captured every time a span is started
+	static := opts
+	static.WithBackTrace = false
+	return &SpanTemplate{
+		name: spanName,
+		opts: opts,
+		kvs:  mergeKVs(addKVsFromOpts(static, args...), nil),
+	}
+}
+
+// Begin starts a new span of the template as the child of the span bound to
+// the context ctx, if any, as BeginSpanWithOptions does, and returns it and the
+// context bound to it. The KVs args are reported along with the static ones.
+func (t *SpanTemplate) Begin(ctx context.Context, args ...interface{}) (Span, context.Context) {
+	parent, ok := fromContext(ctx)
+	if !ok || !parent.ok() {
+		return nullSpan{}, ctx
+	}
+	validateSpanKVs(t.name, t.kvs)
+	validateSpanKVs(t.name, args)
+
+	// the static KVs are shared by all the spans as they're never modified
+	kvs := t.kvs
+	if len(args) > 0 {
+		kvs = mergeKVs(kvs, args)
+	}
+	if t.opts.WithBackTrace {
+		if bt := captureBackTrace(); bt != "" {
+			kvs = mergeKVs(kvs, []interface{}{KeyBackTrace, bt})
+		}
+	}
+	if lazy := lazyKVs(parent.IsSampled(), t.opts.CB); lazy != nil {
+		kvs = mergeKVs(kvs, lazy)
+	}
+	return beginChildSpan(ctx, parent, t.name, kvs)
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanTemplate(t *testing.T) {
+	tmpl := ao.NewSpanTemplate("cache", ao.SpanOptions{Kind: ao.SpanKindClient},
+		"RemoteHost", "cache:6379", "Operation", "GET", "dangling")
+
+	// no trace to start the span in
+	s, ctx := tmpl.Begin(context.Background())
+	assert.False(t, s.IsReporting())
+	assert.Equal(t, context.Background(), ctx)
+
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	ctx = ao.NewContext(context.Background(), tr)
+	get, _ := tmpl.Begin(ctx)
+	get.End()
+	miss, _ := tmpl.Begin(ctx, "Miss", true)
+	miss.End()
+	tr.End()
+	r.Close(6)
+
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeKVMap{
+		{"test", "entry", "", ""}: {},
+		{"cache", "entry", "", ""}: {Count: 2, Edges: g.Edges{{"test", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "cache:6379", n.Map["RemoteHost"])
+			assert.Equal(t, "GET", n.Map["Operation"])
+			assert.Equal(t, "client", n.Map["SpanKind"])
+			assert.NotContains(t, n.Map, "dangling")
+		}},
+		{"cache", "exit", "", ""}: {Count: 2, Edges: g.Edges{{"cache", "entry"}}},
+		{"test", "exit", "", ""}:  {Edges: g.Edges{{"cache", "exit"}, {"cache", "exit"}, {"test", "entry"}}},
+	})
+}
+
+func BenchmarkSpanTemplate(b *testing.B) {
+	_ = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	tmpl := ao.NewSpanTemplate("cache", ao.SpanOptions{Kind: ao.SpanKindClient},
+		"RemoteHost", "cache:6379", "Operation", "GET")
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("test"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s, _ := tmpl.Begin(ctx)
+		s.End()
+	}
+}
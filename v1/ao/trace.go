@@ -4,6 +4,7 @@ package ao
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -17,6 +18,18 @@ import (
 const (
 	// LoggableTraceID is used as the key for log injection.
 	LoggableTraceID = "ao.traceId"
+
+	// the microseconds spent by the agent building the events of the trace,
+	// reported if APPOPTICS_REPORT_OVERHEAD is enabled
+	keyAgentOverhead = "AgentOverhead_us"
+	// the microseconds spent by the HTTP instrumentation itself, i.e., before
+	// and after the handler, and the ones spent by the handler, reported along
+	// with AgentOverhead_us
+	keyMiddlewareTime = "MiddlewareTime_us"
+	keyHandlerTime    = "HandlerTime_us"
+	// the nanoseconds between the entry and the exit of a span as measured by
+	// the monotonic clock, reported if APPOPTICS_REPORT_SPAN_DURATION is enabled
+	keySpanDuration = "Duration_ns"
 )
 
 // Trace represents the root span of a distributed trace for this request that reports
@@ -89,13 +102,33 @@ type traceHTTPSpan struct {
 	start      time.Time
 	controller string
 	action     string
+	// the request traced, whose http.ServeMux pattern names the transaction
+	req *http.Request
+	// the response, whose trailers are reported on the exit event
+	rsp *HTTPResponseWriter
+	// the load of the request, which is measured for the LoadShedder
+	load *requestLoad
+	// the time spent by TraceFromHTTPRequestResponse, and when it returned to
+	// the handler
+	setupTime    time.Duration
+	handlerStart time.Time
+	// the CPU profile requested by X-Trace-Options, if any
+	profile *requestProfile
+	// whether the request doesn't match any route, see SetRouteUnmatched
+	unmatched bool
 }
 
 type aoTrace struct {
 	layerSpan
 	exitEvent      reporter.Event
+	exitMetadata   string // the metadata string of exitEvent, once built
 	httpSpan       traceHTTPSpan
 	httpRspHeaders map[string]string
+	featureFlags   map[string]string
+	entryArgs      KVMap         // the KVs of the entry event, for the metric tags
+	userArgs       []interface{} // the KVs of the user set by SetUser
+	values         *traceValues  // the values set by SetTraceValue
+	geo            *geoLookup    // the lookup of the location of the client
 }
 
 func (t *aoTrace) aoContext() reporter.Context { return t.aoCtx }
@@ -113,7 +146,8 @@ func NewTraceWithOptions(spanName string, opts SpanOptions) Trace {
 		return NewNullTrace()
 	}
 
-	ctx, ok, headers := reporter.NewContext(spanName, true, opts.ContextOptions, func() KVMap {
+	var entryArgs KVMap
+	entryKVs := func() KVMap {
 		var kvs map[string]interface{}
 
 		if opts.CB != nil {
@@ -125,19 +159,27 @@ func NewTraceWithOptions(spanName string, opts SpanOptions) Trace {
 			kvs[k] = v
 		}
 
+		entryArgs = kvs
 		return kvs
-	})
+	}
+	ctx, ok, headers := reporter.NewContext(spanName, true, opts.ContextOptions, entryKVs)
 	if !ok {
 		return NewNullTrace()
 	}
+	// the entry KVs of the traces not sampled tag the metrics as well
+	if entryArgs == nil && len(config.GetMetricTags()) != 0 {
+		entryKVs()
+	}
 	t := &aoTrace{
-		layerSpan:      layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName}}},
+		layerSpan:      layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName}, start: time.Now(), errors: new(int64)}},
 		httpRspHeaders: make(map[string]string),
+		entryArgs:      entryArgs,
 	}
 
 	if opts.TransactionName != "" {
 		t.SetTransactionName(opts.TransactionName)
 	}
+	t.initServerTiming()
 	t.SetStartTime(time.Now())
 	t.SetHTTPRspHeaders(headers)
 	return t
@@ -226,6 +268,17 @@ func (t *aoTrace) SetStatus(status int) {
 
 func (t *aoTrace) reportExit() {
 	if t.ok() {
+		exitStart := time.Now()
+		t.children.flush()
+		cancelArgs := t.cancellationArgs()
+		// the callback is invoked once the lock is released
+		var summary *TraceSummary
+		cb := traceCompleteCallback()
+		defer func() {
+			if summary != nil {
+				cb(*summary)
+			}
+		}()
 		t.lock.Lock()
 		defer t.lock.Unlock()
 
@@ -237,6 +290,8 @@ func (t *aoTrace) reportExit() {
 			return
 		}
 
+		t.httpSpan.load.finish(time.Since(t.httpSpan.start))
+		t.overrideControllerAction()
 		// record a new span
 		if !t.httpSpan.start.IsZero() && t.aoCtx.GetEnabled() {
 			t.httpSpan.span.Duration = time.Now().Sub(t.httpSpan.start)
@@ -246,12 +301,33 @@ func (t *aoTrace) reportExit() {
 		for _, edge := range t.childEdges { // add Edge KV for each joined child
 			t.endArgs = append(t.endArgs, keyEdge, edge)
 		}
+		for flag, variant := range t.featureFlags {
+			t.endArgs = append(t.endArgs, keyFeatureFlagPrefix+flag, variant)
+		}
+		t.endArgs = append(t.endArgs, t.userArgs...)
+		t.endArgs = append(t.endArgs, t.promotedValueArgs()...)
+		t.endArgs = append(t.endArgs, t.geo.args()...)
+		t.endArgs = append(t.endArgs, t.httpSpan.rsp.trailerArgs()...)
+		t.endArgs = append(t.endArgs, t.httpSpan.profile.end()...)
+		t.endArgs = append(t.endArgs, cancelArgs...)
+		t.endArgs = append(t.endArgs, t.statusArgs()...)
+		t.endArgs = append(t.endArgs, t.durationArgs()...)
+		if config.GetReportOverhead() {
+			// the exit event itself is not included
+			t.endArgs = append(t.endArgs, keyAgentOverhead, int64(t.aoCtx.Overhead()/time.Microsecond))
+			t.endArgs = append(t.endArgs, t.httpSpan.timingArgs(exitStart)...)
+		}
 		if t.exitEvent != nil { // use exit event, if one was provided
 			t.exitEvent.ReportContext(t.aoCtx, true, t.endArgs...)
 		} else {
 			t.aoCtx.ReportEvent(reporter.LabelExit, t.layerName(), t.endArgs...)
 		}
 
+		if cb != nil && t.aoCtx.IsSampled() {
+			s := t.summary()
+			summary = &s
+		}
+
 		t.childEdges = nil // clear child edge list
 		t.endArgs = nil
 		t.ended = true
@@ -263,14 +339,31 @@ func (t *aoTrace) IsSampled() bool { return t != nil && t.aoCtx.IsSampled() }
 
 // ExitMetadata reports the X-Trace metadata string that will be used by the exit event.
 // This is useful for setting response headers before reporting the end of the span.
-func (t *aoTrace) ExitMetadata() (mdHex string) {
+// The string is built once and returned as is by the subsequent calls, as the
+// metadata of the exit event doesn't change.
+func (t *aoTrace) ExitMetadata() string {
+	if t.exitMetadata != "" {
+		return t.exitMetadata
+	}
 	if t.exitEvent == nil {
 		t.exitEvent = t.aoCtx.NewEvent(reporter.LabelExit, t.layerName(), false)
 	}
 	if t.exitEvent != nil {
-		mdHex = t.exitEvent.MetadataString()
+		t.exitMetadata = t.exitEvent.MetadataString()
+	}
+	return t.exitMetadata
+}
+
+// timingArgs returns the KVs of the time spent by the HTTP instrumentation and
+// by the handler, given when the trace started to end.
+func (s *traceHTTPSpan) timingArgs(exitStart time.Time) []interface{} {
+	if s.handlerStart.IsZero() {
+		return nil
+	}
+	return []interface{}{
+		keyMiddlewareTime, int64((s.setupTime + time.Since(exitStart)) / time.Microsecond),
+		keyHandlerTime, int64(exitStart.Sub(s.handlerStart) / time.Microsecond),
 	}
-	return
 }
 
 // recordHTTPSpan extract http status, controller and action from the deferred endArgs
@@ -306,7 +399,10 @@ func (t *aoTrace) recordHTTPSpan() {
 		t.httpSpan.span.HasError = true
 	}
 
+	t.httpSpan.span.Tags = t.metricTags()
 	reporter.ReportSpan(&t.httpSpan.span)
+	evaluateSLO(&t.httpSpan.span)
+	recordSecurityStatus(&t.httpSpan.span)
 
 	// This will add the TransactionName KV into the exit event.
 	t.endArgs = append(t.endArgs, keyTransactionName, t.httpSpan.span.Transaction)
@@ -316,7 +412,9 @@ func (t *aoTrace) recordHTTPSpan() {
 // custom transaction name, action/controller, Path and the value of APPOPTICS_PREPEND_DOMAIN
 func (t *aoTrace) finalizeTxnName(controller string, action string) {
 	// The precedence:
-	// custom transaction name > framework specific transaction naming > controller.action > 1st and 2nd segment of Path
+	// custom transaction name > framework specific transaction naming > http.ServeMux pattern >
+	// NotFound/MethodNotAllowed for the 404/405 responses of the unmatched routes >
+	// controller.action > 1st and 2nd segment of Path
 	customTxnName := t.aoCtx.GetTransactionName()
 	if config.GetTransactionName() != "" {
 		customTxnName = config.GetTransactionName()
@@ -325,9 +423,15 @@ func (t *aoTrace) finalizeTxnName(controller string, action string) {
 	if customTxnName != "" {
 		t.httpSpan.span.Transaction = customTxnName
 	} else if t.httpSpan.controller != "" && t.httpSpan.action != "" {
-		t.httpSpan.span.Transaction = t.httpSpan.controller + "." + t.httpSpan.action
+		t.httpSpan.span.Transaction = metrics.JoinTransactionName(t.httpSpan.controller, ".", t.httpSpan.action)
+	} else if pattern := RoutePattern(t.httpSpan.req); pattern != "" {
+		t.httpSpan.span.Transaction = pattern
+	} else if name := unmatchedRouteTxnName(t.httpSpan.span.Status); name != "" && t.httpSpan.unmatched {
+		// the path of an unmatched route is up to the client, e.g., a
+		// security scanner, so it's not used as the transaction name
+		t.httpSpan.span.Transaction = name
 	} else if controller != "" && action != "" {
-		t.httpSpan.span.Transaction = controller + "." + action
+		t.httpSpan.span.Transaction = metrics.JoinTransactionName(controller, ".", action)
 	} else if t.httpSpan.span.Path != "" {
 		t.httpSpan.span.Transaction = metrics.GetTransactionFromPath(t.httpSpan.span.Path)
 	}
@@ -345,9 +449,9 @@ func (t *aoTrace) prependDomainToTxnName() {
 	}
 	if strings.HasSuffix(t.httpSpan.span.Host, "/") ||
 		strings.HasPrefix(t.httpSpan.span.Transaction, "/") {
-		t.httpSpan.span.Transaction = t.httpSpan.span.Host + t.httpSpan.span.Transaction
+		t.httpSpan.span.Transaction = metrics.JoinTransactionName(t.httpSpan.span.Host, t.httpSpan.span.Transaction)
 	} else {
-		t.httpSpan.span.Transaction = t.httpSpan.span.Host + "/" + t.httpSpan.span.Transaction
+		t.httpSpan.span.Transaction = metrics.JoinTransactionName(t.httpSpan.span.Host, "/", t.httpSpan.span.Transaction)
 	}
 }
 
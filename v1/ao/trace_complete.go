@@ -0,0 +1,84 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceSummary describes a sampled trace which has just ended.
+type TraceSummary struct {
+	// Name is the name of the root span
+	Name string
+	// TraceID is the ID of the trace, the same as the one of TraceIDFromContext
+	TraceID string
+	// Duration is the time between the entry and the exit of the root span
+	Duration time.Duration
+	// Status is the status of the root span, see SetSpanStatus
+	Status StatusCode
+	// HTTPStatus is the status code of the response, if the trace is of an
+	// HTTP request, or zero
+	HTTPStatus int
+	// Errors is the number of the errors reported by the spans of the trace,
+	// including the ones whose events are suppressed by the limit
+	Errors int64
+}
+
+var traceComplete struct {
+	sync.RWMutex
+	cb func(TraceSummary)
+}
+
+// OnTraceComplete sets the callback invoked when the root span of a sampled
+// trace ends, after its exit event is reported, e.g., for custom alerting or
+// audit logging without parsing the exported events:
+//   ao.OnTraceComplete(func(s ao.TraceSummary) {
+//   	if s.Errors > 0 {
+//   		log.Printf("trace %s of %s failed with %d errors", s.TraceID, s.Name, s.Errors)
+//   	}
+//   })
+// It's called synchronously by the goroutine ending the trace, so it should
+// return quickly. Passing nil removes the callback.
+func OnTraceComplete(cb func(TraceSummary)) {
+	traceComplete.Lock()
+	defer traceComplete.Unlock()
+	traceComplete.cb = cb
+}
+
+// traceCompleteCallback returns the callback set by OnTraceComplete, if any.
+func traceCompleteCallback() func(TraceSummary) {
+	traceComplete.RLock()
+	defer traceComplete.RUnlock()
+	return traceComplete.cb
+}
+
+// countError counts an error reported by the span in its trace.
+func (s *span) countError() {
+	if s.errors != nil {
+		atomic.AddInt64(s.errors, 1)
+	}
+}
+
+// errorCounter returns the counter of the errors of the trace of the span,
+// which is shared by its child spans.
+func (s *span) errorCounter() *int64 { return s.errors }
+
+// summary returns the TraceSummary of the trace, the lock must be held by the
+// caller.
+func (t *aoTrace) summary() TraceSummary {
+	s := TraceSummary{
+		Name:       t.layerName(),
+		Duration:   time.Since(t.start),
+		Status:     t.status.code,
+		HTTPStatus: t.httpSpan.span.Status,
+	}
+	if md := t.aoCtx.MetadataString(); len(md) == 60 {
+		s.TraceID = md[2:42]
+	}
+	if t.errors != nil {
+		s.Errors = atomic.LoadInt64(t.errors)
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnTraceComplete(t *testing.T) {
+	var summaries []ao.TraceSummary
+	ao.OnTraceComplete(func(s ao.TraceSummary) {
+		summaries = append(summaries, s)
+	})
+	defer ao.OnTraceComplete(nil)
+
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	traceID, _, _ := ao.TraceIDFromContext(ctx)
+	l, ctx := ao.BeginSpan(ctx, "span")
+	l.Err(errors.New("first"))
+	l2, _ := ao.BeginSpan(ctx, "nested")
+	l2.Error("class", "second")
+	time.Sleep(time.Millisecond)
+	l2.End()
+	l.End()
+	assert.Empty(t, summaries, "only the root span completes the trace")
+	tr.End()
+	tr.End()
+	r.Close(8)
+
+	require.Len(t, summaries, 1)
+	s := summaries[0]
+	assert.Equal(t, "test", s.Name)
+	assert.Equal(t, traceID, s.TraceID)
+	assert.True(t, s.Duration >= time.Millisecond)
+	assert.Equal(t, ao.StatusUnset, s.Status)
+	assert.EqualValues(t, 2, s.Errors)
+
+	// not invoked for the traces not sampled
+	summaries = nil
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	ao.NewTrace("test").End()
+	r.Close(0)
+	assert.Empty(t, summaries)
+}
+
+func TestOnTraceCompleteHTTP(t *testing.T) {
+	var summaries []ao.TraceSummary
+	ao.OnTraceComplete(func(s ao.TraceSummary) {
+		summaries = append(summaries, s)
+	})
+	defer ao.OnTraceComplete(nil)
+
+	r := reporter.SetTestReporter()
+	httpTestWithEndpoint(handler404, "http://test.com/hello")
+	r.Close(2)
+
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "http.HandlerFunc", summaries[0].Name)
+	assert.Equal(t, 404, summaries[0].HTTPStatus)
+}
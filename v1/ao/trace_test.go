@@ -12,6 +12,7 @@ import (
 	"context"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
@@ -378,3 +379,101 @@ func TestTraceWithOptions(t *testing.T) {
 		{"testWithBacktrace", "exit"}: {Edges: g.Edges{{"testWithBacktrace", "entry"}}},
 	})
 }
+
+func TestSpanLazyKVs(t *testing.T) {
+	calls := 0
+	cb := func() ao.KVMap {
+		calls++
+		return ao.KVMap{"Summary": "expensive"}
+	}
+
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	l, _ := ao.BeginSpanWithOptions(ctx, "lazy", ao.SpanOptions{ContextOptions: ao.ContextOptions{CB: cb}}, "Static", 1)
+	l.End()
+	tr.End()
+	r.Close(4)
+	assert.Equal(t, 1, calls)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"test", "entry"}: {},
+		{"lazy", "entry"}: {Edges: g.Edges{{"test", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "expensive", n.Map["Summary"])
+			assert.EqualValues(t, 1, n.Map["Static"])
+		}},
+		{"lazy", "exit"}: {Edges: g.Edges{{"lazy", "entry"}}},
+		{"test", "exit"}: {Edges: g.Edges{{"lazy", "exit"}, {"test", "entry"}}},
+	})
+
+	// not called for the traces not sampled
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	tr = ao.NewTrace("test")
+	ctx = ao.NewContext(context.Background(), tr)
+	l, _ = ao.BeginSpanWithOptions(ctx, "lazy", ao.SpanOptions{ContextOptions: ao.ContextOptions{CB: cb}})
+	l.BeginSpanWithOptions("lazier", ao.SpanOptions{ContextOptions: ao.ContextOptions{CB: cb}}).End()
+	l.End()
+	tr.End()
+	r.Close(0)
+	assert.Equal(t, 1, calls)
+}
+
+func TestTraceSpanDuration(t *testing.T) {
+	os.Setenv("APPOPTICS_REPORT_SPAN_DURATION", "true")
+	os.Setenv("APPOPTICS_TIMESTAMP_PRECISION", "ns")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORT_SPAN_DURATION")
+		os.Unsetenv("APPOPTICS_TIMESTAMP_PRECISION")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	l := tr.BeginSpan("span")
+	time.Sleep(time.Millisecond)
+	l.End()
+	tr.End()
+
+	r.Close(4)
+	entry := func(n g.Node) {
+		assert.NotContains(t, n.Map, "Duration_ns")
+		assert.Equal(t, n.Map["Timestamp_u"].(int64), n.Map["Timestamp_ns"].(int64)/1000)
+	}
+	exit := func(n g.Node) {
+		assert.True(t, n.Map["Duration_ns"].(int64) >= int64(time.Millisecond))
+		assert.Contains(t, n.Map, "Timestamp_ns")
+	}
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"test", "entry"}: {Callback: entry},
+		{"span", "entry"}: {Edges: g.Edges{{"test", "entry"}}, Callback: entry},
+		{"span", "exit"}:  {Edges: g.Edges{{"span", "entry"}}, Callback: exit},
+		{"test", "exit"}:  {Edges: g.Edges{{"span", "exit"}, {"test", "entry"}}, Callback: exit},
+	})
+}
+
+func TestTraceReportOverhead(t *testing.T) {
+	os.Setenv("APPOPTICS_REPORT_OVERHEAD", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORT_OVERHEAD")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	tr.BeginSpan("span").End()
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"test", "entry"}: {},
+		{"span", "entry"}: {Edges: g.Edges{{"test", "entry"}}},
+		{"span", "exit"}: {Edges: g.Edges{{"span", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "AgentOverhead_us")
+		}},
+		{"test", "exit"}: {Edges: g.Edges{{"span", "exit"}, {"test", "entry"}}, Callback: func(n g.Node) {
+			assert.IsType(t, int64(0), n.Map["AgentOverhead_us"])
+			assert.True(t, n.Map["AgentOverhead_us"].(int64) >= 0)
+		}},
+	})
+}
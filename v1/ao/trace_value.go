@@ -0,0 +1,170 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"sync"
+)
+
+// TraceValueOpts is the options of SetTraceValue
+type TraceValueOpts struct {
+	// Promote reports the value as a KV of the exit event of the root span
+	Promote bool
+}
+
+// TraceValueOpt sets an option of SetTraceValue
+type TraceValueOpt func(*TraceValueOpts)
+
+// WithPromotion returns a function that promotes the value to a KV of the exit
+// event of the root span, named by its key. The value must be of a type the
+// KVs support, e.g., a string or a number.
+func WithPromotion() TraceValueOpt {
+	return func(o *TraceValueOpts) {
+		o.Promote = true
+	}
+}
+
+type traceValue struct {
+	val     interface{}
+	promote bool
+}
+
+var contextValuesKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.TraceValues")
+
+// traceValues is the store of the values set by SetTraceValue. It's bound to
+// the context rather than to the trace, so the values are passed along whether
+// the request is traced or not, e.g., if the agent is disabled or the path is
+// excluded, and after the trace ends. The trace owning it, if any, only
+// reports the values promoted.
+type traceValues struct {
+	lock  sync.RWMutex
+	m     map[string]traceValue
+	trace *aoTrace // the trace the values are promoted to
+}
+
+// WithTraceValues returns a copy of the parent context with a store of the
+// values of SetTraceValue, unless it has one already. NewContext and the HTTP
+// handlers, e.g., HTTPHandler, bind one for the request, traced or not, so
+// it's only needed by the code passing the values without them:
+//   ctx = ao.WithTraceValues(ctx)
+//   ao.SetTraceValue(ctx, "tenant", tenantID)
+func WithTraceValues(ctx context.Context) context.Context {
+	if valuesFromContext(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextValuesKey, &traceValues{})
+}
+
+func valuesFromContext(ctx context.Context) *traceValues {
+	if ctx == nil {
+		return nil
+	}
+	vs, _ := ctx.Value(contextValuesKey).(*traceValues)
+	return vs
+}
+
+// bindTraceValues binds the store of the values of the trace to ctx. The store
+// of ctx is taken over unless it's owned by another trace, and a trace bound to
+// a context again, e.g., a new one of a goroutine, keeps its store.
+func bindTraceValues(ctx context.Context, t *aoTrace) context.Context {
+	cur := t.valuesStore()
+	if vs := valuesFromContext(ctx); vs != nil {
+		vs.lock.Lock()
+		owned := vs.trace == t
+		if vs.trace == nil && cur == nil {
+			vs.trace, owned = t, true
+		}
+		vs.lock.Unlock()
+		if owned {
+			t.setValuesStore(vs)
+			return ctx
+		}
+	}
+	vs := cur
+	if vs == nil {
+		vs = &traceValues{trace: t}
+		t.setValuesStore(vs)
+	}
+	return context.WithValue(ctx, contextValuesKey, vs)
+}
+
+func (t *aoTrace) valuesStore() *traceValues {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.values
+}
+
+func (t *aoTrace) setValuesStore(vs *traceValues) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.values = vs
+}
+
+// SetTraceValue stores the value of the key in the context ctx, so the
+// middlewares and the handlers serving the same request can pass the
+// correlation data to each other without adding it to the spans. The values
+// aren't reported unless promoted by WithPromotion to the trace bound to ctx:
+//   ao.SetTraceValue(r.Context(), "tenant", tenantID, ao.WithPromotion())
+//   // ...
+//   tenant, _ := ao.TraceValue(r.Context(), "tenant").(string)
+// The values are stored whether the request is traced or not, and after the
+// trace ends, see WithTraceValues. Setting a key again replaces its value and
+// options. It's a no-op if there is no store bound to ctx, or under
+// SuppressTracing.
+func SetTraceValue(ctx context.Context, key string, val interface{}, opts ...TraceValueOpt) {
+	if key == "" || tracingSuppressed(ctx) {
+		return
+	}
+	o := &TraceValueOpts{}
+	for _, f := range opts {
+		f(o)
+	}
+	if vs := valuesFromContext(ctx); vs != nil {
+		vs.set(key, traceValue{val: val, promote: o.Promote})
+	}
+}
+
+// TraceValue returns the value of the key stored in ctx by SetTraceValue, or
+// nil if there is none. It's safe for concurrent use, and the values are
+// readable under SuppressTracing as well.
+func TraceValue(ctx context.Context, key string) interface{} {
+	v, _ := valuesFromContext(ctx).get(key)
+	return v.val
+}
+
+func (vs *traceValues) set(key string, v traceValue) {
+	vs.lock.Lock()
+	defer vs.lock.Unlock()
+	if vs.m == nil {
+		vs.m = make(map[string]traceValue)
+	}
+	vs.m[key] = v
+}
+
+func (vs *traceValues) get(key string) (traceValue, bool) {
+	if vs == nil {
+		return traceValue{}, false
+	}
+	vs.lock.RLock()
+	defer vs.lock.RUnlock()
+	v, ok := vs.m[key]
+	return v, ok
+}
+
+// promotedValueArgs returns the KVs of the values promoted by WithPromotion,
+// the lock must be held by the caller.
+func (t *aoTrace) promotedValueArgs() []interface{} {
+	if t.values == nil {
+		return nil
+	}
+	t.values.lock.RLock()
+	defer t.values.lock.RUnlock()
+	var args []interface{}
+	for k, v := range t.values.m {
+		if v.promote {
+			args = append(args, k, v.val)
+		}
+	}
+	return args
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceValue(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	var got []interface{}
+	httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		ao.SetTraceValue(ctx, "requestClass", "batch")
+		ao.SetTraceValue(ctx, "tenant", "acme", ao.WithPromotion())
+		ao.SetTraceValue(ctx, "attempt", 1, ao.WithPromotion())
+		ao.SetTraceValue(ctx, "attempt", 2)
+
+		// visible to the child spans
+		l, ctx := ao.BeginSpan(ctx, "child")
+		got = append(got, ao.TraceValue(ctx, "requestClass"), ao.TraceValue(ctx, "attempt"),
+			ao.TraceValue(ctx, "missing"))
+		l.End()
+	}, "http://test.com/hello")
+	// a no-op without a trace
+	ao.SetTraceValue(context.Background(), "tenant", "acme")
+	assert.Nil(t, ao.TraceValue(context.Background(), "tenant"))
+	r.Close(4)
+
+	assert.Equal(t, []interface{}{"batch", 2, nil}, got)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"child", "entry"}:            {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+		{"child", "exit"}: {Edges: g.Edges{{"child", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "tenant")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"child", "exit"}, {"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "acme", n.Map["tenant"])
+			assert.NotContains(t, n.Map, "attempt")
+			assert.NotContains(t, n.Map, "requestClass")
+		}},
+	})
+}
+
+func TestTraceValueUntraced(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	var got []interface{}
+	// the excluded paths are not traced
+	httpTestWithEndpoint(func(w http.ResponseWriter, req *http.Request) {
+		ao.SetTraceValue(req.Context(), "tenant", "acme", ao.WithPromotion())
+		got = append(got, ao.TraceValue(req.Context(), "tenant"))
+	}, "http://test.com/metrics")
+
+	ctx := ao.NewContext(context.Background(), ao.NewNullTrace())
+	ao.SetTraceValue(ctx, "tenant", "initech")
+	got = append(got, ao.TraceValue(ctx, "tenant"))
+
+	ctx = ao.WithTraceValues(context.Background())
+	ao.SetTraceValue(ctx, "tenant", "hooli")
+	got = append(got, ao.TraceValue(ctx, "tenant"))
+	assert.Equal(t, ctx, ao.WithTraceValues(ctx))
+
+	// the values are kept after the trace ends, but not promoted
+	tr := ao.NewTrace("test")
+	ctx = ao.NewContext(ctx, tr)
+	ao.SetTraceValue(ctx, "attempt", 1, ao.WithPromotion())
+	tr.End()
+	ao.SetTraceValue(ctx, "attempt", 2, ao.WithPromotion())
+	got = append(got, ao.TraceValue(ctx, "tenant"), ao.TraceValue(ctx, "attempt"))
+
+	// discarded under SuppressTracing
+	ao.SetTraceValue(ao.SuppressTracing(ctx), "attempt", 3)
+	got = append(got, ao.TraceValue(ctx, "attempt"))
+	r.Close(2)
+
+	assert.Equal(t, []interface{}{"acme", "initech", "hooli", "hooli", 2, 2}, got)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"test", "entry"}: {},
+		{"test", "exit"}: {Edges: g.Edges{{"test", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 1, n.Map["attempt"])
+			assert.NotContains(t, n.Map, "tenant")
+		}},
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// keyTrailerPrefix is the prefix of the KV reporting a response trailer.
+const keyTrailerPrefix = "Trailer."
+
+// TrailerKVs returns the KVs of the trailers selected by
+// APPOPTICS_CAPTURE_TRAILERS, e.g., "Trailer.grpc-status", to be reported on
+// the exit event of a span. The names are matched case-insensitively, so both
+// http.Header and gRPC metadata.MD can be passed, and the values of a trailer
+// are joined by commas. The trailers of the responses of the handlers wrapped
+// by HTTPHandler or traced by TraceFromHTTPRequestResponse are reported
+// automatically, including the ones set after the body is written:
+//   span.End(ao.TrailerKVs(trailer)...)
+func TrailerKVs(trailers map[string][]string) []interface{} {
+	names := config.GetCaptureTrailers()
+	if len(names) == 0 || len(trailers) == 0 {
+		return nil
+	}
+	var kvs []interface{}
+	for _, name := range names {
+		var values []string
+		for k, v := range trailers {
+			// the trailers not declared in advance are prefixed by
+			// http.TrailerPrefix
+			k = strings.TrimPrefix(k, http.TrailerPrefix)
+			if strings.EqualFold(k, name) {
+				values = append(values, v...)
+			}
+		}
+		if len(values) > 0 {
+			kvs = append(kvs, keyTrailerPrefix+name, strings.Join(values, ","))
+		}
+	}
+	return kvs
+}
+
+// trailerArgs returns the KVs of the trailers of the response, which are
+// looked up when the trace ends. The trailers are the headers declared by the
+// Trailer header or prefixed by http.TrailerPrefix, the other headers aren't
+// reported even if named by APPOPTICS_CAPTURE_TRAILERS.
+func (w *HTTPResponseWriter) trailerArgs() []interface{} {
+	if w == nil {
+		return nil
+	}
+	h := w.Header()
+	declared := make(map[string]bool)
+	for _, v := range h["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				declared[http.CanonicalHeaderKey(k)] = true
+			}
+		}
+	}
+	trailers := make(map[string][]string)
+	for k, v := range h {
+		if declared[k] || strings.HasPrefix(k, http.TrailerPrefix) {
+			trailers[k] = v
+		}
+	}
+	return TrailerKVs(trailers)
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrailerKVs(t *testing.T) {
+	md := map[string][]string{"grpc-status": {"5"}, "x-detail": {"a", "b"}}
+	assert.Nil(t, ao.TrailerKVs(md))
+
+	defer func() {
+		os.Unsetenv("APPOPTICS_CAPTURE_TRAILERS")
+		config.Load()
+	}()
+	os.Setenv("APPOPTICS_CAPTURE_TRAILERS", "grpc-status,X-Detail,grpc-message")
+	config.Load()
+
+	assert.Equal(t, []interface{}{"Trailer.grpc-status", "5", "Trailer.x-detail", "a,b"}, ao.TrailerKVs(md))
+	assert.Equal(t, []interface{}{"Trailer.grpc-status", "0"},
+		ao.TrailerKVs(http.Header{http.TrailerPrefix + "Grpc-Status": {"0"}}))
+	assert.Nil(t, ao.TrailerKVs(nil))
+}
+
+func TestHTTPHandlerTrailers(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_CAPTURE_TRAILERS")
+		config.Load()
+	}()
+	os.Setenv("APPOPTICS_CAPTURE_TRAILERS", "grpc-status,grpc-message,x-checksum")
+	config.Load()
+
+	r := reporter.SetTestReporter()
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		// a header, not a trailer
+		w.Header().Set("X-Checksum", "abc")
+		w.Write([]byte("hello"))
+		// set after the body is written
+		w.Header().Set("Grpc-Status", "13")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "internal")
+	})
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "13", n.Map["Trailer.grpc-status"])
+			assert.Equal(t, "internal", n.Map["Trailer.grpc-message"])
+			assert.NotContains(t, n.Map, "Trailer.x-checksum")
+		}},
+	})
+}
@@ -2,8 +2,10 @@ package ao_test
 
 import (
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"runtime/pprof"
 	"strings"
 	"testing"
 	"time"
@@ -445,6 +447,61 @@ func TestRelaxedTriggerTrace(t *testing.T) {
 	assert.True(t, strings.HasSuffix(rHeader.Get("X-Trace"), "01"))
 }
 
+// signed TT request with the CPU profile requested
+func TestRelaxedTriggerTraceProfile(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv("APPOPTICS_CPU_PROFILE_DIR", dir)
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_CPU_PROFILE_DIR")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter(reporter.TestReporterSettingType(reporter.RelaxedTriggerTraceOnlyST))
+	ts := time.Now().Unix()
+	opts := fmt.Sprintf("trigger-trace;profile;ts=%d", ts)
+	hd := map[string]string{
+		"X-Trace-Options":           opts,
+		"X-Trace-Options-Signature": reporter.HmacHash([]byte(reporter.TestToken), []byte(opts)),
+	}
+
+	var labeled bool
+	rr := httpTestWithEndpointWithHeaders(func(w http.ResponseWriter, req *http.Request) {
+		_, labeled = pprof.Label(req.Context(), "appoptics_trace_id")
+	}, "http://test.com/hello", hd)
+	r.Close(2)
+	assert.True(t, labeled)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, true, n.Map["TriggeredTrace"])
+			assert.NotContains(t, n.Map, "profile")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			path, _ := n.Map["CPUProfile"].(string)
+			assert.True(t, strings.HasPrefix(path, dir), path)
+			fi, err := os.Stat(path)
+			if assert.NoError(t, err) {
+				assert.True(t, fi.Size() > 0)
+			}
+		}},
+	})
+	rHeader := rr.Header()
+	assert.EqualValues(t, "trigger-trace=ok;auth=ok;profile=ok", rHeader.Get("X-Trace-Options-Response"))
+
+	// not honored for the unsigned requests
+	r = reporter.SetTestReporter(reporter.TestReporterSettingType(reporter.DefaultST))
+	hd = map[string]string{"X-Trace-Options": "trigger-trace;profile"}
+	rr = httpTestWithEndpointWithHeaders(handler200, "http://test.com/hello", hd)
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "CPUProfile")
+		}},
+	})
+	assert.EqualValues(t, "trigger-trace=ok;ignored=profile", rr.Header().Get("X-Trace-Options-Response"))
+}
+
 // signed TT request with invalid timestamp
 func TestRelaxedTriggerTraceTSNotInScope(t *testing.T) {
 	r := reporter.SetTestReporter(reporter.TestReporterSettingType(reporter.DefaultST))
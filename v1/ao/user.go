@@ -0,0 +1,100 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// The KVs reporting the identity of the user of a trace
+const (
+	keyUserID    = "UserID"
+	keySessionID = "SessionID"
+)
+
+// UserOpts is the options of SetUser
+type UserOpts struct {
+	// SessionID is the identifier of the session of the user
+	SessionID string
+	// Hash reports the identifiers hashed rather than as is
+	Hash bool
+	// Salt is the key of the HMAC-SHA256 hash of the identifiers
+	Salt string
+}
+
+// UserOpt sets an option of SetUser
+type UserOpt func(*UserOpts)
+
+// WithSessionID records the identifier of the session of the user too.
+func WithSessionID(id string) UserOpt {
+	return func(o *UserOpts) {
+		o.SessionID = id
+	}
+}
+
+// WithHashing reports the identifiers as the hex HMAC-SHA256 hashes keyed by
+// the salt rather than as is, so the traces of a user can be looked up by
+// hashing the user's identifier with the same salt, but the identifiers can't
+// be recovered from the traces. The identifiers are hashed with plain SHA-256
+// if the salt is empty, which is vulnerable to dictionary attacks.
+func WithHashing(salt string) UserOpt {
+	return func(o *UserOpts) {
+		o.Hash = true
+		o.Salt = salt
+	}
+}
+
+// SetUser records the identifier of the user of the trace bound to ctx, and
+// optionally the identifier of the session, so the traces of a user can be
+// investigated. They're attached to the exit event of the root span as the
+// UserID and SessionID KVs:
+//   ao.SetUser(ctx, user.ID, ao.WithSessionID(session.ID), ao.WithHashing(salt))
+// If the user is set more than once in a trace, the last one is reported.
+func SetUser(ctx context.Context, id string, opts ...UserOpt) {
+	if id == "" {
+		return
+	}
+	o := &UserOpts{}
+	for _, f := range opts {
+		f(o)
+	}
+	session := o.SessionID
+	if o.Hash {
+		id = hashIdentifier(o.Salt, id)
+		if session != "" {
+			session = hashIdentifier(o.Salt, session)
+		}
+	}
+	runTraceCtx(ctx, func(t Trace) {
+		if at, ok := t.(*aoTrace); ok {
+			at.setUser(id, session)
+		}
+	})
+}
+
+// hashIdentifier returns the hex SHA-256 hash of the identifier, keyed by the
+// salt if it's not empty.
+func hashIdentifier(salt, id string) string {
+	if salt == "" {
+		sum := sha256.Sum256([]byte(id))
+		return hex.EncodeToString(sum[:])
+	}
+	h := hmac.New(sha256.New, []byte(salt))
+	h.Write([]byte(id))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (t *aoTrace) setUser(id, session string) {
+	if !t.ok() {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.userArgs = []interface{}{keyUserID, id}
+	if session != "" {
+		t.userArgs = append(t.userArgs, keySessionID, session)
+	}
+}
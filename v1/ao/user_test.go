@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetUser(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	l, ctxL := ao.BeginSpan(ctx, "child")
+	ao.SetUser(ctxL, "alice", ao.WithSessionID("s1"))
+	ao.SetUser(ctxL, "")
+	ao.SetUser(context.Background(), "no-trace")
+	l.End()
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"test", "entry"}:  {},
+		{"child", "entry"}: {Edges: g.Edges{{"test", "entry"}}},
+		{"child", "exit"}: {Edges: g.Edges{{"child", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "UserID")
+		}},
+		{"test", "exit"}: {Edges: g.Edges{{"child", "exit"}, {"test", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "alice", n.Map["UserID"])
+			assert.Equal(t, "s1", n.Map["SessionID"])
+		}},
+	})
+}
+
+func TestSetUserHashing(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := ao.NewTrace("salted")
+	ao.SetUser(ao.NewContext(context.Background(), tr), "alice", ao.WithHashing("salt"), ao.WithSessionID("s1"))
+	tr.End()
+	tr = ao.NewTrace("unsalted")
+	ao.SetUser(ao.NewContext(context.Background(), tr), "alice", ao.WithSessionID("s1"))
+	ao.SetUser(ao.NewContext(context.Background(), tr), "bob", ao.WithHashing(""))
+	tr.End()
+
+	mac := hmac.New(sha256.New, []byte("salt"))
+	mac.Write([]byte("alice"))
+	sum := sha256.Sum256([]byte("bob"))
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"salted", "entry"}: {},
+		{"salted", "exit"}: {Edges: g.Edges{{"salted", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), n.Map["UserID"])
+			assert.Len(t, n.Map["SessionID"], 64)
+		}},
+		{"unsalted", "entry"}: {},
+		// the last user set is reported
+		{"unsalted", "exit"}: {Edges: g.Edges{{"unsalted", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, hex.EncodeToString(sum[:]), n.Map["UserID"])
+			assert.NotContains(t, n.Map, "SessionID")
+		}},
+	})
+}
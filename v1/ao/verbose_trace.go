@@ -0,0 +1,32 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao
+
+import "context"
+
+var verboseTraceKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.VerboseTrace")
+
+// WithVerboseTrace returns a copy of the context which asks the log
+// integrations, e.g., aoslog, to elevate the log verbosity for the code
+// running under it. The elevation only applies when the trace bound to the
+// context is sampled, which includes the trigger traced requests, so the debug
+// logs are only emitted for the requests which can be seen in traces.
+//   func handler(w http.ResponseWriter, r *http.Request) {
+//       ctx := ao.WithVerboseTrace(r.Context())
+//       logger.DebugContext(ctx, "cache miss", "key", key)
+//   }
+func WithVerboseTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verboseTraceKey, true)
+}
+
+// IsVerboseTrace returns if the log verbosity should be elevated for the
+// context, i.e., it's marked by WithVerboseTrace and its trace is sampled.
+func IsVerboseTrace(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if v, ok := ctx.Value(verboseTraceKey).(bool); !ok || !v {
+		return false
+	}
+	return TraceFromContext(ctx).IsSampled()
+}
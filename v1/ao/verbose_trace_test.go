@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerboseTrace(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("verbose"))
+	assert.False(t, ao.IsVerboseTrace(ctx))
+	assert.True(t, ao.IsVerboseTrace(ao.WithVerboseTrace(ctx)))
+
+	// to the spans started from the context too
+	_, spanCtx := ao.BeginSpan(ao.WithVerboseTrace(ctx), "child")
+	assert.True(t, ao.IsVerboseTrace(spanCtx))
+
+	// not without a trace
+	assert.False(t, ao.IsVerboseTrace(ao.WithVerboseTrace(context.Background())))
+	ao.EndTrace(ctx)
+	r.Close(3)
+
+	// nor an unsampled one
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	tr := ao.NewTrace("verbose")
+	assert.False(t, ao.IsVerboseTrace(ao.WithVerboseTrace(ao.NewContext(context.Background(), tr))))
+	tr.End()
+	assert.Len(t, r.EventBufs, 0)
+}
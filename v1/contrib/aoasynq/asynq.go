@@ -0,0 +1,164 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoasynq traces the asynq tasks from the enqueuing to the handlers.
+// The trace context is injected into the headers of the tasks enqueued by a
+// wrapped client, and continued by the handlers of a server with Middleware:
+//   client := aoasynq.WrapClient(asynq.NewClient(redis))
+//   task := asynq.NewTaskWithHeaders("email:welcome", payload, map[string]string{})
+//   _, err := client.EnqueueContext(ctx, task)
+//
+//   mux := asynq.NewServeMux()
+//   mux.Use(aoasynq.Middleware)
+// The headers of a task created by asynq.NewTask are nil and can't be set, so
+// the task is enqueued without the trace context and its handler starts a new
+// trace. The payloads are not changed, so the tasks can still be handled by
+// the servers not tracing them.
+//
+// The package is a module of its own, so the asynq dependencies are not
+// pulled into the other programs.
+package aoasynq
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/hibiken/asynq"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoasynq"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// The names of the spans reported.
+const (
+	EnqueueSpanName = "asynq.enqueue"
+	HandlerSpanName = "asynq.handler"
+)
+
+// headerEnqueued is the task header of the time the task is enqueued, in
+// microseconds since the epoch.
+const headerEnqueued = "X-Trace-Enqueued"
+
+// Client wraps an *asynq.Client to trace the tasks enqueued.
+type Client struct {
+	*asynq.Client
+}
+
+// WrapClient returns a Client tracing the tasks enqueued by c.
+func WrapClient(c *asynq.Client) *Client {
+	ao.EnableIntegration(IntegrationName)
+	return &Client{Client: c}
+}
+
+// EnqueueContext enqueues a task in a producer span, with the trace context
+// injected into its headers, if any. The span reports the queue and the
+// scheduled delay of the task, as given by the options.
+func (c *Client) EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return c.Client.EnqueueContext(ctx, task, opts...)
+	}
+	queue, delay := enqueueOptions(opts, time.Now())
+	l, _ := ao.BeginSpanWithOptions(ctx, EnqueueSpanName, ao.SpanOptions{Kind: ao.SpanKindProducer},
+		"TaskType", task.Type(), "Queue", queue, "ScheduledDelay", int64(delay/time.Microsecond))
+	defer l.End()
+	// the task can't be rebuilt without losing its options
+	if h := task.Headers(); h != nil {
+		if md := l.MetadataString(); md != "" {
+			h[ao.HTTPHeaderName] = md
+			h[headerEnqueued] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Microsecond), 10)
+		} else {
+			delete(h, ao.HTTPHeaderName)
+			delete(h, headerEnqueued)
+		}
+	}
+	info, err := c.Client.EnqueueContext(ctx, task, opts...)
+	if err != nil {
+		l.Err(err)
+	} else {
+		l.AddEndArgs("TaskID", info.ID)
+	}
+	return info, err
+}
+
+// Enqueue enqueues a task like EnqueueContext, in the background context.
+func (c *Client) Enqueue(task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	return c.EnqueueContext(context.Background(), task, opts...)
+}
+
+// enqueueOptions returns the queue and the scheduled delay of the options.
+func enqueueOptions(opts []asynq.Option, now time.Time) (string, time.Duration) {
+	queue := "default"
+	var delay time.Duration
+	for _, opt := range opts {
+		switch opt.Type() {
+		case asynq.QueueOpt:
+			queue, _ = opt.Value().(string)
+		case asynq.ProcessInOpt:
+			delay, _ = opt.Value().(time.Duration)
+		case asynq.ProcessAtOpt:
+			if t, ok := opt.Value().(time.Time); ok {
+				delay = t.Sub(now)
+			}
+		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return queue, delay
+}
+
+// enqueuedAt returns the time the task is enqueued, as given by its headers.
+func enqueuedAt(headers map[string]string) (time.Time, bool) {
+	micros, err := strconv.ParseInt(headers[headerEnqueued], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, micros*int64(time.Microsecond)), true
+}
+
+// Middleware traces the handlers of the tasks. The trace of a task enqueued
+// by a Client continues from the enqueue span, and reports the queue, the
+// retry count and the time the task spent in the queue. The tasks are passed
+// to the handlers as is, along with their ResultWriter.
+func Middleware(next asynq.Handler) asynq.Handler {
+	ao.EnableIntegration(IntegrationName)
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		if ao.IntegrationDisabled(IntegrationName) {
+			return next.ProcessTask(ctx, task)
+		}
+		queue, _ := asynq.GetQueueName(ctx)
+		taskID, _ := asynq.GetTaskID(ctx)
+		retried, _ := asynq.GetRetryCount(ctx)
+		t := ao.NewTraceWithOptions(HandlerSpanName, ao.SpanOptions{
+			Kind: ao.SpanKindConsumer,
+			ContextOptions: ao.ContextOptions{
+				MdStr: task.Headers()[ao.HTTPHeaderName],
+				CB: func() ao.KVMap {
+					kvs := ao.KVMap{
+						"TaskType":   task.Type(),
+						"TaskID":     taskID,
+						"Queue":      queue,
+						"RetryCount": retried,
+					}
+					if enqueued, ok := enqueuedAt(task.Headers()); ok {
+						if qt := time.Since(enqueued); qt > 0 {
+							kvs["QueueTime"] = int64(qt / time.Microsecond)
+						}
+					}
+					return kvs
+				},
+			}})
+		t.SetTransactionName(task.Type())
+		defer t.End()
+		err := next.ProcessTask(ao.NewContext(ctx, t), task)
+		if err != nil {
+			t.Err(err)
+		}
+		return err
+	})
+}
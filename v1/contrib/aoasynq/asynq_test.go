@@ -0,0 +1,90 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoasynq
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnqueuedAt(t *testing.T) {
+	enqueued := time.Unix(1609459200, 123456000)
+	got, ok := enqueuedAt(map[string]string{headerEnqueued: strconv.FormatInt(enqueued.UnixNano()/int64(time.Microsecond), 10)})
+	assert.True(t, ok)
+	assert.True(t, enqueued.Equal(got))
+
+	for _, h := range []map[string]string{nil, {headerEnqueued: "x"}} {
+		_, ok = enqueuedAt(h)
+		assert.False(t, ok)
+	}
+}
+
+func TestEnqueueOptions(t *testing.T) {
+	now := time.Now()
+	queue, delay := enqueueOptions(nil, now)
+	assert.Equal(t, "default", queue)
+	assert.Zero(t, delay)
+	queue, delay = enqueueOptions([]asynq.Option{asynq.Queue("critical"), asynq.ProcessIn(time.Minute)}, now)
+	assert.Equal(t, "critical", queue)
+	assert.Equal(t, time.Minute, delay)
+	_, delay = enqueueOptions([]asynq.Option{asynq.ProcessAt(now.Add(time.Hour))}, now)
+	assert.Equal(t, time.Hour, delay)
+	_, delay = enqueueOptions([]asynq.Option{asynq.ProcessAt(now.Add(-time.Hour))}, now)
+	assert.Zero(t, delay)
+}
+
+func TestEnqueueHeaders(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	// nothing listens on the port, the headers are set before enqueuing
+	client := WrapClient(asynq.NewClient(asynq.RedisClientOpt{Addr: "127.0.0.1:1", DialTimeout: 10 * time.Millisecond}))
+	defer client.Close()
+	tr := ao.NewTrace("aoasynq")
+	trCtx := ao.NewContext(ctx, tr)
+	task := asynq.NewTaskWithHeaders("email:welcome", []byte("payload"), map[string]string{"Tenant": "t1"}, asynq.MaxRetry(1))
+	_, err := client.EnqueueContext(trCtx, task)
+	assert.Error(t, err)
+	assert.Equal(t, "payload", string(task.Payload()))
+	assert.Equal(t, "t1", task.Headers()["Tenant"])
+	assert.Equal(t, ao.MetadataString(trCtx)[:42], task.Headers()[ao.HTTPHeaderName][:42])
+	_, ok := enqueuedAt(task.Headers())
+	assert.True(t, ok)
+
+	// the tasks without headers are enqueued as is
+	plain := asynq.NewTask("email:welcome", []byte("payload"))
+	_, err = client.EnqueueContext(trCtx, plain)
+	assert.Error(t, err)
+	assert.Nil(t, plain.Headers())
+	tr.End()
+}
+
+func TestMiddleware(t *testing.T) {
+	md := "2B" + "0123456789ABCDEF0123456789ABCDEF01234567" + "0123456789ABCDEF" + "01"
+	var got *asynq.Task
+	h := Middleware(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		got = task
+		return nil
+	}))
+	task := asynq.NewTaskWithHeaders("email:welcome", []byte("payload"), map[string]string{ao.HTTPHeaderName: md})
+	assert.NoError(t, h.ProcessTask(context.Background(), task))
+	// the task is not rebuilt
+	assert.Same(t, task, got)
+
+	// the tasks enqueued by the other clients are passed as is
+	plain := asynq.NewTask("email:welcome", []byte("plain"))
+	assert.NoError(t, h.ProcessTask(context.Background(), plain))
+	assert.Same(t, plain, got)
+}
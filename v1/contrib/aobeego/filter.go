@@ -0,0 +1,66 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aobeego provides a Beego filter chain tracing the requests, with the
+// same header semantics as ao.HTTPHandler. It's inserted before all the routes
+// of the application:
+//   web.InsertFilterChain("*", aobeego.FilterChain)
+// The transactions are named after the router patterns, e.g., "/users/:id".
+// The trace is bound to the context of the request, so the controllers can
+// begin the spans of their own from c.Ctx.Request.Context().
+//
+// The package is a module of its own, so the Beego dependencies are not
+// pulled into the other programs.
+package aobeego
+
+import (
+	"fmt"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/beego/beego/v2/server/web"
+	beecontext "github.com/beego/beego/v2/server/web/context"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aobeego"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// DefaultSpanName is the name of the span created for each request by
+// FilterChain.
+const DefaultSpanName = "beego"
+
+// FilterChain is a web.FilterChain tracing the requests in the spans named
+// DefaultSpanName.
+func FilterChain(next web.FilterFunc) web.FilterFunc {
+	return NewFilterChain(DefaultSpanName)(next)
+}
+
+// NewFilterChain returns a web.FilterChain tracing the requests in the spans
+// named spanName.
+func NewFilterChain(spanName string) web.FilterChain {
+	ao.EnableIntegration(IntegrationName)
+	return func(next web.FilterFunc) web.FilterFunc {
+		return func(ctx *beecontext.Context) {
+			if ao.Closed() || ao.IntegrationDisabled(IntegrationName) {
+				next(ctx)
+				return
+			}
+			t, w, r := ao.TraceFromHTTPRequestResponse(spanName, ctx.ResponseWriter.ResponseWriter, ctx.Request)
+			ctx.ResponseWriter.ResponseWriter, ctx.Request = w, r
+			defer t.End()
+
+			defer func() { // report the panic, if any, and re-raise it
+				if err := recover(); err != nil {
+					t.Error("panic", fmt.Sprintf("%v", err))
+					panic(err)
+				}
+			}()
+			next(ctx)
+			if pattern, ok := ctx.Input.GetData("RouterPattern").(string); ok && pattern != "" {
+				_ = t.SetTransactionName(pattern)
+			}
+		}
+	}
+}
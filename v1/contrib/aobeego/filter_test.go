@@ -0,0 +1,30 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aobeego
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	beecontext "github.com/beego/beego/v2/server/web/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterChain(t *testing.T) {
+	var traced bool
+	var md string
+	f := FilterChain(func(ctx *beecontext.Context) {
+		_, traced = ctx.ResponseWriter.ResponseWriter.(*ao.HTTPResponseWriter)
+		md = ao.MetadataString(ctx.Request.Context())
+		ctx.ResponseWriter.WriteHeader(http.StatusTeapot)
+	})
+	ctx := beecontext.NewContext()
+	rr := httptest.NewRecorder()
+	ctx.Reset(rr, httptest.NewRequest("GET", "http://test.com/hello", nil))
+	f(ctx)
+	assert.True(t, traced)
+	assert.NotEmpty(t, md)
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aocaddy provides a Caddy HTTP handler module which originates the
+// AppOptics traces of the requests entering the proxy, with the same header
+// semantics as ao.HTTPHandler: an incoming X-Trace header is continued, and the
+// context of the trace is passed upstream to the application services in the
+// X-Trace request header.
+//
+// The package is a module of its own, so the Caddy dependencies are not pulled
+// into the other programs. It's compiled into Caddy by xcaddy:
+//   xcaddy build \
+//       --with github.com/appoptics/appoptics-apm-go/v1/contrib/aocaddy
+// The appoptics directive should run before the other handlers, with an
+// optional span name:
+//   {
+//       order appoptics first
+//   }
+//   example.com {
+//       appoptics caddy
+//       reverse_proxy localhost:8080
+//   }
+package aocaddy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aocaddy"
+
+// DefaultSpanName is the name of the span created for each request if it's not
+// configured.
+const DefaultSpanName = "caddy"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("appoptics", parseCaddyfile)
+}
+
+// Handler traces the requests passed to the next handlers of the route.
+type Handler struct {
+	// SpanName is the name of the span created for each request.
+	SpanName string `json:"span_name,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.appoptics",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	if h.SpanName == "" {
+		h.SpanName = DefaultSpanName
+	}
+	ao.EnableIntegration(IntegrationName)
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) (err error) {
+	if ao.Closed() || ao.IntegrationDisabled(IntegrationName) {
+		return next.ServeHTTP(w, r)
+	}
+	t, w, r := ao.TraceFromHTTPRequestResponse(h.SpanName, w, r)
+	defer t.End()
+
+	defer func() { // report the panic, if any, and re-raise it
+		if p := recover(); p != nil {
+			t.Error("panic", fmt.Sprintf("%v", p))
+			panic(p)
+		}
+	}()
+	err = next.ServeHTTP(w, r)
+	if err != nil {
+		t.Err(err)
+		// the error response is written by the error routes of Caddy after
+		// this handler returns, so its status is taken from the error
+		var he caddyhttp.HandlerError
+		if aw, ok := w.(*ao.HTTPResponseWriter); ok && !aw.WroteHeader && errors.As(err, &he) && he.StatusCode != 0 {
+			aw.StatusCode = he.StatusCode
+		}
+	}
+	return err
+}
+
+// UnmarshalCaddyfile sets up the handler from the Caddyfile tokens:
+//   appoptics [<span_name>]
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			h.SpanName = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m Handler
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return m, err
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
+)
@@ -0,0 +1,50 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aocaddy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	var h Handler
+	require.NoError(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser("appoptics")))
+	assert.Empty(t, h.SpanName)
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.Equal(t, DefaultSpanName, h.SpanName)
+
+	require.NoError(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser("appoptics edge")))
+	assert.Equal(t, "edge", h.SpanName)
+	assert.Error(t, h.UnmarshalCaddyfile(caddyfile.NewTestDispenser("appoptics edge extra")))
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := Handler{SpanName: DefaultSpanName}
+	var traced bool
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, traced = w.(*ao.HTTPResponseWriter)
+		w.WriteHeader(http.StatusTeapot)
+		return nil
+	})
+	rr := httptest.NewRecorder()
+	assert.NoError(t, h.ServeHTTP(rr, httptest.NewRequest("GET", "http://test.com/hello", nil), next))
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+	assert.True(t, traced)
+
+	// the handler errors are passed to Caddy
+	errBackend := caddyhttp.Error(http.StatusBadGateway, errors.New("backend down"))
+	next = caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return errBackend
+	})
+	assert.Equal(t, errBackend, h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/", nil), next))
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aocli provides a helper to trace command line tools and other
+// short-lived processes. Run creates a root trace for the invocation and
+// synchronously flushes the events before returning, which would otherwise be
+// lost when the process exits before the reporter sends them.
+//
+//   func main() {
+//       os.Exit(aocli.Run("backup", func(ctx context.Context) error {
+//           // ...
+//       }))
+//   }
+package aocli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aocli"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+const (
+	// DefaultReadyTimeout is the default maximum time to wait for the agent
+	// to be ready before running the command.
+	DefaultReadyTimeout = 3 * time.Second
+	// DefaultFlushTimeout is the default maximum time to wait for the events
+	// to be sent after the command returns.
+	DefaultFlushTimeout = 10 * time.Second
+
+	scrubbed = "***"
+)
+
+const (
+	keyArgs       = "CLIArgs"
+	keyExitCode   = "CLIExitCode"
+	keyDurationMs = "CLIDurationMs"
+)
+
+// defaultSensitiveFlags are the substrings of the names of the flags whose
+// values are scrubbed from the reported arguments.
+var defaultSensitiveFlags = []string{"password", "passwd", "secret", "token", "key", "auth", "credential"}
+
+type options struct {
+	args           []string
+	sensitiveFlags []string
+	readyTimeout   time.Duration
+	flushTimeout   time.Duration
+}
+
+// Option configures Run.
+type Option func(*options)
+
+// WithArgs sets the command line arguments to be reported, which are
+// os.Args[1:] by default.
+func WithArgs(args []string) Option {
+	return func(o *options) { o.args = args }
+}
+
+// WithSensitiveFlags adds the names (or substrings of the names) of the flags
+// whose values are scrubbed from the reported arguments, in addition to the
+// default ones like "password" and "token".
+func WithSensitiveFlags(names ...string) Option {
+	return func(o *options) {
+		for _, n := range names {
+			o.sensitiveFlags = append(o.sensitiveFlags, strings.ToLower(n))
+		}
+	}
+}
+
+// WithReadyTimeout sets the maximum time to wait for the agent to be ready.
+func WithReadyTimeout(d time.Duration) Option {
+	return func(o *options) { o.readyTimeout = d }
+}
+
+// WithFlushTimeout sets the maximum time to wait for the events to be sent.
+func WithFlushTimeout(d time.Duration) Option {
+	return func(o *options) { o.flushTimeout = d }
+}
+
+// ExitCoder is implemented by the errors carrying a process exit code, e.g.,
+// *exec.ExitError.
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// Run runs fn in a root trace named after the command and returns the exit
+// code, which is 0 if fn returns nil, the exit code of the error if it
+// implements ExitCoder, or 1 otherwise. The scrubbed arguments, the exit code
+// and the duration are reported on the trace. The agent is shut down before
+// Run returns, so it should be called only once, typically in main.
+//
+// A panic in fn is reported on the trace and re-raised after the flush.
+func Run(name string, fn func(ctx context.Context) error, opts ...Option) (code int) {
+	ao.EnableIntegration(IntegrationName)
+	o := &options{
+		args:           os.Args[1:],
+		sensitiveFlags: defaultSensitiveFlags,
+		readyTimeout:   DefaultReadyTimeout,
+		flushTimeout:   DefaultFlushTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if ao.IntegrationDisabled(IntegrationName) {
+		return exitCode(fn(context.Background()))
+	}
+
+	readyCtx, cancel := context.WithTimeout(context.Background(), o.readyTimeout)
+	ao.WaitForReady(readyCtx)
+	cancel()
+
+	start := time.Now()
+	t := ao.NewTraceWithOptions(name, ao.SpanOptions{
+		ContextOptions: ao.ContextOptions{
+			CB: func() ao.KVMap {
+				return ao.KVMap{keyArgs: strings.Join(scrubArgs(o.args, o.sensitiveFlags), " ")}
+			},
+		},
+		TransactionName: name,
+	})
+	t.SetStartTime(start)
+	ctx := ao.NewContext(context.Background(), t)
+
+	defer func() {
+		p := recover()
+		if p != nil {
+			t.Error("panic", fmt.Sprintf("%v", p))
+			code = 2
+		}
+		t.End(keyExitCode, code, keyDurationMs, time.Since(start).Milliseconds())
+
+		flushCtx, cancel := context.WithTimeout(context.Background(), o.flushTimeout)
+		_ = ao.Shutdown(flushCtx)
+		cancel()
+
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		t.Err(err)
+		code = exitCode(err)
+	}
+	return code
+}
+
+// exitCode returns the exit code of the error returned by the command.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if ec, ok := err.(ExitCoder); ok {
+		return ec.ExitCode()
+	}
+	return 1
+}
+
+// scrubArgs replaces the values of the sensitive flags with "***". Both the
+// "-flag=value" and "-flag value" forms are supported. In the latter form, the
+// argument following a sensitive flag is scrubbed unless it's a flag itself.
+func scrubArgs(args []string, sensitive []string) []string {
+	out := make([]string, len(args))
+	scrubNext := false
+	for i, arg := range args {
+		isFlag := strings.HasPrefix(arg, "-")
+		if scrubNext && !isFlag {
+			out[i] = scrubbed
+			scrubNext = false
+			continue
+		}
+		out[i] = arg
+		scrubNext = false
+		if !isFlag {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		idx := strings.Index(name, "=")
+		if idx >= 0 {
+			name = name[:idx]
+		}
+		if !isSensitive(strings.ToLower(name), sensitive) {
+			continue
+		}
+		if idx >= 0 {
+			out[i] = arg[:strings.Index(arg, "=")+1] + scrubbed
+		} else {
+			scrubNext = true
+		}
+	}
+	return out
+}
+
+func isSensitive(name string, sensitive []string) bool {
+	for _, s := range sensitive {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
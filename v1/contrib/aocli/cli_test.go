@@ -0,0 +1,50 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aocli
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubArgs(t *testing.T) {
+	args := []string{"backup", "--db-password=hunter2", "-token", "abc", "--verbose",
+		"--API-KEY", "--dir", "/tmp", "--secret"}
+	assert.Equal(t, []string{"backup", "--db-password=***", "-token", "***", "--verbose",
+		"--API-KEY", "--dir", "/tmp", "--secret"}, scrubArgs(args, defaultSensitiveFlags))
+
+	assert.Equal(t, []string{"-pin", "***"}, scrubArgs([]string{"-pin", "1234"}, []string{"pin"}))
+	assert.Empty(t, scrubArgs(nil, defaultSensitiveFlags))
+}
+
+type exitError int
+
+func (e exitError) Error() string { return "exit" }
+func (e exitError) ExitCode() int { return int(e) }
+
+func TestRun(t *testing.T) {
+	opts := []Option{WithArgs([]string{"--password", "x"}), WithSensitiveFlags("pin"),
+		WithReadyTimeout(time.Millisecond), WithFlushTimeout(time.Millisecond)}
+
+	called := false
+	assert.Equal(t, 0, Run("cmd", func(ctx context.Context) error {
+		assert.NotNil(t, ctx)
+		called = true
+		return nil
+	}, opts...))
+	assert.True(t, called)
+
+	assert.Equal(t, 1, Run("cmd", func(ctx context.Context) error {
+		return errors.New("failed")
+	}, opts...))
+	assert.Equal(t, 3, Run("cmd", func(ctx context.Context) error {
+		return exitError(3)
+	}, opts...))
+	assert.Panics(t, func() {
+		Run("cmd", func(ctx context.Context) error { panic("boom") }, opts...)
+	})
+}
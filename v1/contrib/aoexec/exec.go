@@ -0,0 +1,282 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoexec traces the executions of external commands. It provides a
+// drop-in replacement of exec.CommandContext, whose executions are reported as
+// spans with the binary name, the sanitized arguments, the exit code and the
+// bytes written to stdout and stderr:
+//   cmd := aoexec.CommandContext(ctx, "convert", "in.png", "out.jpg")
+//   out, err := cmd.Output()
+//
+// The trace context is passed to the child in the X_TRACE and TRACEPARENT
+// environment variables, so the scripts and programs of other languages in a
+// pipeline can continue the trace, and in AO_TRACE_CONTEXT as well, which a Go
+// program continues by:
+//   t := ao.NewTraceFromEnv("convert")
+package aoexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoexec"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// SpanName is the name of the span created for each execution.
+const SpanName = "exec"
+
+// The environment variables passing the trace context to the child.
+const (
+	// EnvXTrace is the X-Trace metadata of the span of the execution.
+	EnvXTrace = "X_TRACE"
+	// EnvXTraceSignature is the signature of EnvXTrace, if
+	// APPOPTICS_CONTEXT_SIGNING_KEY is set.
+	EnvXTraceSignature = "X_TRACE_SIGNATURE"
+	// EnvTraceparent is the W3C traceparent of the span of the execution.
+	EnvTraceparent = "TRACEPARENT"
+)
+
+const (
+	keyCommand     = "Command"
+	keyArgs        = "Args"
+	keyExitCode    = "ExitCode"
+	keyStdoutBytes = "StdoutBytes"
+	keyStderrBytes = "StderrBytes"
+)
+
+const maskedArg = "********"
+
+// the names of the arguments whose values are masked
+var sensitiveArgName = regexp.MustCompile(`(?i)pass|secret|token|api[-_]?key|auth|credential`)
+
+// the short flags taking a password as the next argument, e.g., of mysql
+var sensitiveShortFlags = map[string]bool{"-p": true}
+
+// Cmd wraps an exec.Cmd whose execution is traced. Start, Run, Output and
+// CombinedOutput must be called on the Cmd rather than the embedded exec.Cmd.
+type Cmd struct {
+	*exec.Cmd
+	ctx    context.Context
+	span   ao.Span
+	stdout *countingWriter
+	stderr *countingWriter
+}
+
+// CommandContext returns a Cmd to execute the named program with the given
+// arguments, see exec.CommandContext. The execution is traced as a child span
+// of the span bound to ctx.
+func CommandContext(ctx context.Context, name string, arg ...string) *Cmd {
+	ao.EnableIntegration(IntegrationName)
+	return &Cmd{Cmd: exec.CommandContext(ctx, name, arg...), ctx: ctx}
+}
+
+// Start starts the command, see exec.Cmd.Start. The span ends in Wait.
+//
+// The bytes written to Stdout and Stderr are counted unless they are files,
+// e.g., os.Stdout or the pipes created by StdoutPipe and StderrPipe, which the
+// child writes to directly.
+func (c *Cmd) Start() error {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return c.Cmd.Start()
+	}
+	l, ctx := ao.BeginSpan(c.ctx, SpanName,
+		keyCommand, filepath.Base(c.Path),
+		keyArgs, strings.Join(sanitizeArgs(c.Args[1:]), " "))
+	if !l.IsReporting() {
+		return c.Cmd.Start()
+	}
+	if md := ao.MetadataString(ctx); md != "" {
+		env := c.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		c.Env = traceEnv(env, md, ao.TraceContextEnv(ctx))
+	}
+	if w, ok := countable(c.Stdout); ok {
+		c.stdout = &countingWriter{w: w}
+		c.Stdout = c.stdout
+	}
+	if w, ok := countable(c.Stderr); ok {
+		c.stderr = &countingWriter{w: w}
+		c.Stderr = c.stderr
+	}
+
+	if err := c.Cmd.Start(); err != nil {
+		l.Err(err)
+		l.End()
+		return err
+	}
+	c.span = l
+	return nil
+}
+
+// Wait waits for the command to exit, see exec.Cmd.Wait, and ends the span
+// with the exit code and the bytes written to stdout and stderr.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	if c.span == nil {
+		return err
+	}
+	var kvs []interface{}
+	if c.ProcessState != nil {
+		kvs = append(kvs, keyExitCode, c.ProcessState.ExitCode())
+	}
+	if c.stdout != nil {
+		kvs = append(kvs, keyStdoutBytes, c.stdout.count())
+	}
+	if c.stderr != nil {
+		kvs = append(kvs, keyStderrBytes, c.stderr.count())
+	}
+	if err != nil {
+		c.span.Err(err)
+	}
+	c.span.End(kvs...)
+	c.span = nil
+	return err
+}
+
+// Run starts the command and waits for it to complete, see exec.Cmd.Run.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output, see
+// exec.Cmd.Output.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+
+	var stderr *bytes.Buffer
+	if c.Stderr == nil {
+		stderr = &bytes.Buffer{}
+		c.Stderr = stderr
+	}
+	err := c.Run()
+	var ee *exec.ExitError
+	if stderr != nil && errors.As(err, &ee) {
+		ee.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output
+// and standard error, see exec.Cmd.CombinedOutput.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var b bytes.Buffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// countable reports if the writes of the child to w can be counted, which
+// are copied by exec.Cmd unless w is nil or a file.
+func countable(w io.Writer) (io.Writer, bool) {
+	if w == nil {
+		return nil, false
+	}
+	if _, ok := w.(*os.File); ok {
+		return nil, false
+	}
+	return w, true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64 // accessed atomically
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddInt64(&w.n, int64(n))
+	return n, err
+}
+
+func (w *countingWriter) count() int64 {
+	return atomic.LoadInt64(&w.n)
+}
+
+// traceEnv returns the environment of the child with the trace context of md,
+// and the entry of ao.TraceContextEnv, if any, replacing the ones inherited.
+func traceEnv(env []string, md, traceContext string) []string {
+	vars := []string{EnvXTrace + "=" + md}
+	if sig := ao.SignMetadata(md); sig != "" {
+		vars = append(vars, EnvXTraceSignature+"="+sig)
+	}
+	if tp := ao.TraceparentFromMetadata(md); tp != "" {
+		vars = append(vars, EnvTraceparent+"="+tp)
+	}
+	if traceContext != "" {
+		vars = append(vars, traceContext)
+	}
+
+	out := make([]string, 0, len(env)+len(vars))
+	for _, kv := range env {
+		switch {
+		case strings.HasPrefix(kv, EnvXTrace+"="),
+			strings.HasPrefix(kv, EnvXTraceSignature+"="),
+			strings.HasPrefix(kv, EnvTraceparent+"="),
+			strings.HasPrefix(kv, ao.EnvTraceContext+"="):
+			continue
+		}
+		out = append(out, kv)
+	}
+	return append(out, vars...)
+}
+
+// sanitizeArgs masks the values of the arguments which look like secrets: the
+// values of the flags named like passwords or tokens, either in the same
+// argument ("--password=x") or the next one ("--password x"), the value of the
+// short flag "-p", the key=value pairs named alike, and the passwords of the
+// URLs.
+func sanitizeArgs(args []string) []string {
+	out := make([]string, len(args))
+	maskNext := false
+	for i, a := range args {
+		if maskNext {
+			out[i] = maskedArg
+			maskNext = false
+			continue
+		}
+		out[i] = a
+		if idx := strings.Index(a, "="); idx > 0 {
+			if sensitiveArgName.MatchString(a[:idx]) {
+				out[i] = a[:idx+1] + maskedArg
+			}
+		} else if sensitiveShortFlags[a] || strings.HasPrefix(a, "-") && sensitiveArgName.MatchString(a) {
+			maskNext = true
+		} else if strings.Contains(a, "://") {
+			if u, err := url.Parse(a); err == nil && u.User != nil {
+				out[i] = u.Redacted()
+			}
+		}
+	}
+	return out
+}
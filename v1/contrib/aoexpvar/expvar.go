@@ -0,0 +1,51 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoexpvar publishes the state of the AppOptics agent internals as the
+// expvar variable "appoptics", so the health of the agent can be monitored by
+// scraping /debug/vars, e.g., to alert on the events dropped or the settings
+// not refreshed:
+//   import _ "github.com/appoptics/appoptics-apm-go/v1/contrib/aoexpvar"
+//
+// The variable is a JSON object of the fields of ao.AgentStats, where the
+// SettingsAge is in seconds, and the bytes sent to the collector.
+package aoexpvar
+
+import (
+	"expvar"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoexpvar"
+
+// VarName is the name of the expvar variable published.
+const VarName = "appoptics"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+	expvar.Publish(VarName, expvar.Func(agentVars))
+}
+
+// agentVars returns the value of the expvar variable.
+func agentVars() interface{} {
+	s := ao.GetAgentStats()
+	p := ao.ReporterStats()
+	age := -1.0
+	if s.SettingsAge >= 0 {
+		age = s.SettingsAge.Seconds()
+	}
+	return map[string]interface{}{
+		"QueueDepth":    s.QueueDepth,
+		"QueueCapacity": s.QueueCapacity,
+		"EventsQueued":  s.EventsQueued,
+		"EventsSent":    s.EventsSent,
+		"EventsDropped": s.EventsDropped,
+		"EventsFailed":  s.EventsFailed,
+		"Reconnects":    s.Reconnects,
+		"SettingsAge":   age,
+		"EventBytes":    p.EventBytes,
+		"MetricBytes":   p.MetricBytes,
+		"StatusBytes":   p.StatusBytes,
+	}
+}
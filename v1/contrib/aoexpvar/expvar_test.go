@@ -0,0 +1,24 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentVars(t *testing.T) {
+	v := expvar.Get(VarName)
+	require.NotNil(t, v)
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(v.String()), &m))
+	for _, k := range []string{"QueueDepth", "QueueCapacity", "EventsQueued", "EventsSent", "EventsDropped",
+		"EventsFailed", "Reconnects", "SettingsAge", "EventBytes", "MetricBytes", "StatusBytes"} {
+		assert.Contains(t, m, k)
+	}
+}
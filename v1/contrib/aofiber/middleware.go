@@ -0,0 +1,145 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aofiber provides a Fiber middleware tracing the requests, with the
+// same header semantics as ao.HTTPHandler: an incoming X-Trace header is
+// continued, and the X-Trace of the trace exit is set in the response:
+//   app := fiber.New()
+//   app.Use(aofiber.Middleware())
+//   app.Get("/users/:id", func(c *fiber.Ctx) error {
+//       l, _ := ao.BeginSpan(c.UserContext(), "load-user")
+//       defer l.End()
+//       ...
+//   })
+// The transactions are named after the route templates, e.g., "/users/:id",
+// and the requests not matching any route are named NotFound or
+// MethodNotAllowed.
+// The trace is bound to the user context of the fiber.Ctx, so the handlers
+// can begin the spans of their own. The paths excluded by
+// APPOPTICS_EXCLUDED_PATHS are not traced.
+//
+// The package is a module of its own, so the Fiber dependencies are not
+// pulled into the other programs.
+package aofiber
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aofiber"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// DefaultSpanName is the name of the span created for each request if it's not
+// given to Middleware.
+const DefaultSpanName = "fiber"
+
+// Middleware returns the middleware tracing the requests in the spans named
+// spanName, DefaultSpanName by default.
+func Middleware(spanName ...string) fiber.Handler {
+	name := DefaultSpanName
+	if len(spanName) > 0 && spanName[0] != "" {
+		name = spanName[0]
+	}
+	ao.EnableIntegration(IntegrationName)
+	return func(c *fiber.Ctx) error {
+		if ao.Closed() || ao.IntegrationDisabled(IntegrationName) || ao.IsExcludedPath(c.Path()) {
+			return c.Next()
+		}
+		// the values of the fiber.Ctx are reused after the request
+		method, path, host := utils.CopyString(c.Method()), utils.CopyString(c.Path()), utils.CopyString(c.Hostname())
+		t := ao.NewTraceWithOptions(name, ao.SpanOptions{
+			Kind: ao.SpanKindServer,
+			ContextOptions: ao.ContextOptions{
+				MdStr:                  c.Get(ao.HTTPHeaderName),
+				URL:                    path,
+				Method:                 method,
+				XTraceOptions:          c.Get(ao.HTTPHeaderXTraceOptions),
+				XTraceOptionsSignature: c.Get(ao.HTTPHeaderXTraceOptionsSignature),
+				RemoteAddr:             c.IP(),
+				MdSignature:            c.Get(ao.HTTPHeaderXTraceSignature),
+				Ingress:                true,
+				CB: func() ao.KVMap {
+					return ao.KVMap{
+						"Spec":        "ws",
+						"HTTPMethod":  method,
+						"HTTP-Host":   host,
+						"URL":         c.OriginalURL(),
+						"Remote-Host": c.IP(),
+						"Proto":       c.Protocol(),
+					}
+				},
+			}})
+		t.SetMethod(method)
+		t.SetPath(path)
+		t.SetHost(host)
+		c.SetUserContext(ao.NewContext(c.UserContext(), t))
+
+		defer func() { // report the panic, if any, and re-raise it
+			if err := recover(); err != nil {
+				t.Error("panic", fmt.Sprintf("%v", err))
+				nameTrace(c, t)
+				endTrace(c, t, http.StatusInternalServerError)
+				panic(err)
+			}
+		}()
+
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if err != nil {
+			// the error is turned into the response after the middleware
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+			if status >= http.StatusInternalServerError {
+				t.Err(err)
+			}
+		}
+		if routeUnmatched(method, err) {
+			ao.SetRouteUnmatched(c.UserContext())
+		} else {
+			nameTrace(c, t)
+		}
+		endTrace(c, t, status)
+		return err
+	}
+}
+
+// routeUnmatched returns whether err is returned by the router as no route
+// matches the request, rather than by a handler, e.g., for a missing user.
+func routeUnmatched(method string, err error) bool {
+	if errors.Is(err, fiber.ErrMethodNotAllowed) {
+		return true
+	}
+	var fe *fiber.Error
+	return errors.As(err, &fe) && fe.Code == fiber.StatusNotFound &&
+		strings.HasPrefix(fe.Message, "Cannot "+method+" ")
+}
+
+// nameTrace names the transaction after the route template.
+func nameTrace(c *fiber.Ctx, t ao.Trace) {
+	if r := c.Route(); r != nil && r.Path != "" {
+		t.SetTransactionName(r.Path)
+	}
+}
+
+// endTrace ends the trace with the status, and sets the X-Trace of the
+// response.
+func endTrace(c *fiber.Ctx, t ao.Trace, status int) {
+	t.SetStatus(status)
+	if md := t.ExitMetadata(); md != "" {
+		c.Set(ao.HTTPHeaderName, md)
+	}
+	t.End("Status", status)
+}
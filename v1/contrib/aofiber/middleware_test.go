@@ -0,0 +1,102 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aofiber_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aofiber"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	app := fiber.New()
+	app.Use(aofiber.Middleware())
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		l, _ := ao.BeginSpan(c.UserContext(), "load-user")
+		l.End()
+		return fiber.NewError(fiber.StatusServiceUnavailable, "db down")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/users/1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(ao.HTTPHeaderName))
+
+	// the entries and the exits of the trace and the handler span, and the
+	// error of the trace
+	events, err := c.WaitForEvents(ctx, 5)
+	require.NoError(t, err)
+	var exit aotest.Message
+	for _, e := range events {
+		if e["Layer"] == aofiber.DefaultSpanName && e["Label"] == "exit" {
+			exit = e
+		}
+	}
+	require.NotNil(t, exit)
+	assert.EqualValues(t, fiber.StatusServiceUnavailable, exit["Status"])
+	assert.Equal(t, "/users/:id", exit["TransactionName"])
+}
+
+func TestMiddlewareUnmatched(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	app := fiber.New()
+	app.Use(aofiber.Middleware())
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+
+	// the 404 of a handler keeps the route template, unlike the responses of
+	// the router
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/users/1"},
+		{"GET", "/wp-admin/setup.php"},
+		{"POST", "/users/1"},
+	} {
+		_, err := app.Test(httptest.NewRequest(req.method, req.path, nil))
+		require.NoError(t, err)
+	}
+
+	events, err := c.WaitForEvents(ctx, 6)
+	require.NoError(t, err)
+	var names []interface{}
+	for _, e := range events {
+		if e["Label"] == "exit" {
+			names = append(names, e["TransactionName"])
+		}
+	}
+	assert.Equal(t, []interface{}{"/users/:id", ao.TransactionNameNotFound, ao.TransactionNameMethodNotAllowed}, names)
+}
+
+func TestMiddlewareExcludedPath(t *testing.T) {
+	app := fiber.New()
+	app.Use(aofiber.Middleware())
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		assert.Empty(t, ao.MetadataString(c.UserContext()))
+		return nil
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	require.NoError(t, err)
+	assert.Empty(t, resp.Header.Get(ao.HTTPHeaderName))
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aogobreaker provides a wrapper of sony/gobreaker circuit breakers. The
+// calls passing through the breaker are traced as spans, the state transitions
+// of the breaker are reported as span events and the calls rejected by the
+// breaker are counted as metrics, so traces explain why a downstream call was
+// short-circuited.
+//   cb := aogobreaker.NewCircuitBreaker(gobreaker.Settings{Name: "payments"})
+//   resp, err := cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+//       return callPayments(ctx)
+//   })
+package aogobreaker
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/sony/gobreaker"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aogobreaker"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+const (
+	// SpanName is the name of the span created for each call of Execute.
+	SpanName = "gobreaker"
+	// MetricRejected is the name of the metric counting calls rejected by
+	// the circuit breaker.
+	MetricRejected = "CircuitBreakerRejected"
+	// MetricStateChange is the name of the metric counting state transitions
+	// of the circuit breaker.
+	MetricStateChange = "CircuitBreakerStateChange"
+)
+
+const (
+	keyName      = "CircuitBreaker"
+	keyState     = "CircuitBreakerState"
+	keyFromState = "CircuitBreakerFromState"
+	keyToState   = "CircuitBreakerToState"
+	keyRejected  = "CircuitBreakerRejected"
+)
+
+// CircuitBreaker wraps a gobreaker.CircuitBreaker with AppOptics instrumentation.
+type CircuitBreaker struct {
+	*gobreaker.CircuitBreaker
+	name string
+}
+
+// NewCircuitBreaker returns a new instrumented CircuitBreaker configured with
+// the given Settings. The OnStateChange callback of the settings, if any, is
+// still called for each state transition.
+func NewCircuitBreaker(st gobreaker.Settings) *CircuitBreaker {
+	ao.EnableIntegration(IntegrationName)
+	onStateChange := st.OnStateChange
+	st.OnStateChange = func(name string, from gobreaker.State, to gobreaker.State) {
+		if !ao.IntegrationDisabled(IntegrationName) {
+			_ = ao.IncrementMetric(MetricStateChange, ao.MetricOptions{
+				Count: 1,
+				Tags:  map[string]string{"name": name, "from": from.String(), "to": to.String()},
+			})
+		}
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+	return &CircuitBreaker{CircuitBreaker: gobreaker.NewCircuitBreaker(st), name: st.Name}
+}
+
+// Execute runs the given request if the CircuitBreaker accepts it, in a span
+// which is a child of the span bound to ctx. The context passed to req is
+// bound to the new span. A state transition caused by this call is reported
+// as an info event of the span, and a rejected call is counted by the
+// MetricRejected metric and marked with the "CircuitBreakerRejected" KV.
+func (cb *CircuitBreaker) Execute(ctx context.Context, req func(context.Context) (interface{}, error)) (interface{}, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return cb.CircuitBreaker.Execute(func() (interface{}, error) {
+			return req(ctx)
+		})
+	}
+	l, ctx := ao.BeginSpan(ctx, SpanName, keyName, cb.name)
+	defer l.End()
+
+	from := cb.State()
+	resp, err := cb.CircuitBreaker.Execute(func() (interface{}, error) {
+		return req(ctx)
+	})
+	to := cb.State()
+
+	if from != to {
+		l.Info(keyName, cb.name, keyFromState, from.String(), keyToState, to.String())
+	}
+	l.AddEndArgs(keyState, to.String())
+
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+		l.AddEndArgs(keyRejected, true)
+		_ = ao.IncrementMetric(MetricRejected, ao.MetricOptions{
+			Count: 1,
+			Tags:  map[string]string{"name": cb.name, "state": from.String()},
+		})
+	}
+	if err != nil {
+		l.Err(err)
+	}
+	return resp, err
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogobreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecute(t *testing.T) {
+	var transitions []string
+	cb := NewCircuitBreaker(gobreaker.Settings{
+		Name: "test",
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 2
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			transitions = append(transitions, name+":"+from.String()+"->"+to.String())
+		},
+	})
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "v")
+	resp, err := cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return ctx.Value(ctxKey{}), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "v", resp)
+
+	errDownstream := errors.New("downstream error")
+	for i := 0; i < 2; i++ {
+		_, err = cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, errDownstream
+		})
+		assert.Equal(t, errDownstream, err)
+	}
+	assert.Equal(t, gobreaker.StateOpen, cb.State())
+	assert.Equal(t, []string{"test:closed->open"}, transitions)
+
+	called := false
+	_, err = cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	assert.Equal(t, gobreaker.ErrOpenState, err)
+	assert.False(t, called)
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aogokit provides the go-kit middleware tracing the endpoints, and
+// the transport helpers propagating the trace context to the services called.
+// The server endpoints are wrapped by EndpointMiddleware, which names the
+// transaction after the method:
+//   var e endpoint.Endpoint
+//   e = makeGetUserEndpoint(svc)
+//   e = aogokit.EndpointMiddleware("GetUser")(e)
+// The traces are started by the transport, e.g., ao.HTTPHandler wrapping the
+// kithttp.Server, or aogrpc.UnaryServerInterceptor, and by the middleware for
+// the transports not traced, unless the path of the request populated by
+// kithttp.PopulateRequestContext is excluded by APPOPTICS_EXCLUDED_PATHS. The
+// client endpoints are wrapped by ClientMiddleware, with the context injected
+// by the transports:
+//   c := kithttp.NewClient("GET", u, encode, decode, kithttp.ClientBefore(aogokit.HTTPClientBefore))
+//   e := aogokit.ClientMiddleware("GetUser")(c.Endpoint())
+// The context is injected into the hosts allowed by APPOPTICS_PROPAGATE_HOSTS
+// only, like by ao.BeginHTTPClientSpan.
+//
+// The package is a module of its own, so the go-kit dependencies are not
+// pulled into the other programs.
+package aogokit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"google.golang.org/grpc/metadata"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aogokit"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// The names of the spans reported.
+const (
+	EndpointSpanName = "gokit.endpoint"
+	ClientSpanName   = "gokit.client"
+)
+
+// EndpointMiddleware returns the middleware tracing a server endpoint in a
+// span with the Endpoint KV, and setting the transaction name of the trace to
+// the method. A trace is started if the transport hasn't started one. The
+// errors returned by the endpoint are reported, as well as the business
+// errors of the responses implementing endpoint.Failer.
+func EndpointMiddleware(method string) endpoint.Middleware {
+	ao.EnableIntegration(IntegrationName)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if ao.IntegrationDisabled(IntegrationName) || excludedPath(ctx) {
+				return next(ctx, request)
+			}
+			// the metadata is also known for the traces not sampled
+			if ao.MetadataString(ctx) == "" {
+				t := ao.NewTraceWithOptions(EndpointSpanName, ao.SpanOptions{
+					Kind:            ao.SpanKindServer,
+					TransactionName: method,
+					ContextOptions: ao.ContextOptions{
+						CB: func() ao.KVMap { return ao.KVMap{"Endpoint": method} },
+					},
+				})
+				ctx = ao.NewContext(ctx, t)
+				resp, err := next(ctx, request)
+				endSpan(t, resp, err)
+				return resp, err
+			}
+			_ = ao.SetTransactionName(ctx, method)
+			l, ctx := ao.BeginSpan(ctx, EndpointSpanName, "Endpoint", method)
+			resp, err := next(ctx, request)
+			endSpan(l, resp, err)
+			return resp, err
+		}
+	}
+}
+
+// excludedPath reports if the path of the HTTP request of ctx, which is set by
+// kithttp.PopulateRequestContext, is excluded by APPOPTICS_EXCLUDED_PATHS.
+func excludedPath(ctx context.Context) bool {
+	path, ok := ctx.Value(kithttp.ContextKeyRequestPath).(string)
+	return ok && ao.IsExcludedPath(path)
+}
+
+// ClientMiddleware returns the middleware tracing a client endpoint in a
+// client span with the Endpoint KV. The context passed to the transport is
+// bound to the span, so it's injected by HTTPClientBefore or GRPCClientBefore
+// as the parent of the remote service.
+func ClientMiddleware(method string) endpoint.Middleware {
+	ao.EnableIntegration(IntegrationName)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if ao.IntegrationDisabled(IntegrationName) {
+				return next(ctx, request)
+			}
+			l, ctx := ao.BeginSpanWithOptions(ctx, ClientSpanName, ao.SpanOptions{Kind: ao.SpanKindClient},
+				"Endpoint", method)
+			resp, err := next(ctx, request)
+			endSpan(l, resp, err)
+			return resp, err
+		}
+	}
+}
+
+// endSpan reports the error of the endpoint, if any, and ends the span.
+func endSpan(l ao.Span, resp interface{}, err error) {
+	if err == nil {
+		if f, ok := resp.(endpoint.Failer); ok {
+			err = f.Failed()
+		}
+	}
+	if err != nil {
+		l.Err(err)
+	}
+	l.End()
+}
+
+// HTTPClientBefore is a kithttp.RequestFunc injecting the trace context of
+// ctx into the X-Trace header of the request, if the host of the request is
+// allowed by APPOPTICS_PROPAGATE_HOSTS, see ao.BeginHTTPClientSpan.
+func HTTPClientBefore(ctx context.Context, r *http.Request) context.Context {
+	if !ao.PropagatesToHost(r.URL.Hostname()) {
+		return ctx
+	}
+	if md := ao.MetadataString(ctx); md != "" {
+		r.Header.Set(ao.HTTPHeaderName, md)
+		if sig := ao.SignMetadata(md); sig != "" {
+			r.Header.Set(ao.HTTPHeaderXTraceSignature, sig)
+		}
+	}
+	return ctx
+}
+
+// GRPCClientBefore is a kitgrpc.ClientRequestFunc injecting the trace context
+// of ctx into the metadata of the request, which is continued by
+// aogrpc.UnaryServerInterceptor. The target of the request is unknown to it,
+// so nothing is injected if APPOPTICS_PROPAGATE_HOSTS is set, in which case
+// GRPCClientBeforeTarget is used instead.
+func GRPCClientBefore(ctx context.Context, md *metadata.MD) context.Context {
+	return grpcClientBefore(ctx, md, "")
+}
+
+// GRPCClientBeforeTarget returns a kitgrpc.ClientRequestFunc like
+// GRPCClientBefore for the requests to the target of the grpc.ClientConn, which
+// is checked against APPOPTICS_PROPAGATE_HOSTS:
+//   c := kitgrpc.NewClient(cc, "pb.Users", "Get", encode, decode, pb.User{},
+//       kitgrpc.ClientBefore(aogokit.GRPCClientBeforeTarget(cc.Target())))
+func GRPCClientBeforeTarget(target string) func(context.Context, *metadata.MD) context.Context {
+	host := targetHost(target)
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		return grpcClientBefore(ctx, md, host)
+	}
+}
+
+func grpcClientBefore(ctx context.Context, md *metadata.MD, host string) context.Context {
+	if !ao.PropagatesToHost(host) {
+		return ctx
+	}
+	if xt := ao.MetadataString(ctx); xt != "" {
+		md.Set(ao.HTTPHeaderName, xt)
+		if sig := ao.SignMetadata(xt); sig != "" {
+			md.Set(ao.HTTPHeaderXTraceSignature, sig)
+		}
+	}
+	return ctx
+}
+
+// targetHost returns the host of a gRPC dial target, e.g., "users.example.com"
+// of "dns:///users.example.com:443".
+func targetHost(target string) string {
+	if i := strings.Index(target, ":///"); i >= 0 {
+		target = target[i+len(":///"):]
+	} else if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+len("://"):]
+		if j := strings.Index(target, "/"); j >= 0 {
+			target = target[j+1:]
+		}
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return target
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogokit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aogokit"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+type response struct{ err error }
+
+func (r response) Failed() error { return r.err }
+
+func TestEndpointMiddleware(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	// the business error of the response is reported
+	e := aogokit.EndpointMiddleware("GetUser")(func(ctx context.Context, request interface{}) (interface{}, error) {
+		assert.NotEmpty(t, ao.MetadataString(ctx))
+		return response{err: errors.New("no such user")}, nil
+	})
+	resp, err := e(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Error(t, resp.(response).Failed())
+
+	// the entry, the error and the exit of the trace
+	events, err := c.WaitForEvents(ctx, 3)
+	require.NoError(t, err)
+	for _, e := range events {
+		assert.Equal(t, aogokit.EndpointSpanName, e["Layer"])
+		if e["Label"] == "entry" {
+			assert.Equal(t, "GetUser", e["Endpoint"])
+		}
+	}
+}
+
+func TestEndpointMiddlewareExcludedPath(t *testing.T) {
+	e := aogokit.EndpointMiddleware("Metrics")(func(ctx context.Context, request interface{}) (interface{}, error) {
+		assert.Empty(t, ao.MetadataString(ctx))
+		return nil, nil
+	})
+	r, _ := http.NewRequest("GET", "http://example.com/debug/pprof", nil)
+	_, err := e(kithttp.PopulateRequestContext(context.Background(), r), nil)
+	assert.NoError(t, err)
+}
+
+func TestClientBefore(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	aogokit.HTTPClientBefore(context.Background(), r)
+	assert.Empty(t, r.Header.Get(ao.HTTPHeaderName))
+
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	defer tr.End()
+	aogokit.HTTPClientBefore(ctx, r)
+	assert.Equal(t, ao.MetadataString(ctx), r.Header.Get(ao.HTTPHeaderName))
+
+	md := metadata.MD{}
+	aogokit.GRPCClientBefore(ctx, &md)
+	assert.Equal(t, []string{ao.MetadataString(ctx)}, md.Get(ao.HTTPHeaderName))
+}
+
+func TestClientBeforePropagateHosts(t *testing.T) {
+	os.Setenv("APPOPTICS_PROPAGATE_HOSTS", "*.example.com")
+	c := aotest.NewCollector()
+	defer c.Close()
+	defer os.Unsetenv("APPOPTICS_PROPAGATE_HOSTS")
+	c.Attach() // reload the config
+
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	defer tr.End()
+	for host, injected := range map[string]bool{"api.example.com": true, "example.org": false} {
+		r, _ := http.NewRequest("GET", "http://"+host, nil)
+		aogokit.HTTPClientBefore(ctx, r)
+		assert.Equal(t, injected, r.Header.Get(ao.HTTPHeaderName) != "", host)
+	}
+
+	// the target of GRPCClientBefore is unknown
+	md := metadata.MD{}
+	aogokit.GRPCClientBefore(ctx, &md)
+	assert.Empty(t, md.Get(ao.HTTPHeaderName))
+	for target, injected := range map[string]bool{
+		"dns:///api.example.com:443":           true,
+		"dns://8.8.8.8/api.example.com":        true,
+		"api.example.com:443":                  true,
+		"example.org:443":                      false,
+		"passthrough:///example.org":           false,
+		"unix:///var/run/api.example.com.sock": false,
+	} {
+		md := metadata.MD{}
+		aogokit.GRPCClientBeforeTarget(target)(ctx, &md)
+		assert.Equal(t, injected, len(md.Get(ao.HTTPHeaderName)) > 0, target)
+	}
+}
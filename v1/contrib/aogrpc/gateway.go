@@ -0,0 +1,159 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogrpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GatewayMetadataKey is the gRPC metadata key which links a request forwarded
+// by grpc-gateway to the trace of the original HTTP request.
+const GatewayMetadataKey = "x-appoptics-gateway"
+
+// gatewayTraces holds the gatewayEntry of the in-flight HTTP requests handled
+// by grpc-gateway, keyed by the value of GatewayMetadataKey. An entry is
+// deleted once the server interceptor picks it up, and the ones never picked
+// up, e.g., of the requests forwarded to other processes or failed, are swept
+// after their deadline. The end of the traces can't tell it, as the traces not
+// sampled are never reporting.
+var gatewayTraces sync.Map
+
+type gatewayEntry struct {
+	trace    ao.Trace
+	deadline time.Time
+}
+
+// gatewayEntryTTL is how long an entry is kept at most if the HTTP request has
+// no earlier deadline.
+const gatewayEntryTTL = time.Minute
+
+// the number of entries stored in gatewayTraces, accessed atomically
+var gatewayStores int64
+
+// gatewaySweepInterval is the number of entries stored between two sweeps.
+const gatewaySweepInterval = 256
+
+// GatewayMetadata returns the gRPC metadata to be sent along with the request
+// forwarded by grpc-gateway. It is meant to be passed to the gateway's mux,
+// which must be wrapped by the AppOptics HTTP instrumentation:
+//   mux := runtime.NewServeMux(runtime.WithMetadata(aogrpc.GatewayMetadata))
+//   http.HandleFunc("/", ao.HTTPHandler(mux.ServeHTTP))
+//
+// If the gRPC server runs in the same process and uses the server interceptors
+// of this package, the gRPC request joins the trace of the HTTP request instead
+// of starting a new one, and the gRPC method becomes the transaction name.
+// Otherwise the gRPC server continues the distributed trace as usual.
+func GatewayMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	t := ao.TraceFromContext(r.Context())
+	// a null trace doesn't have a trace ID, in which case the gRPC server
+	// starts its own trace.
+	if t.LoggableTraceID() == "" {
+		return nil
+	}
+
+	id, err := newGatewayID()
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	deadline := now.Add(gatewayEntryTTL)
+	if d, ok := r.Context().Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	gatewayTraces.Store(id, gatewayEntry{trace: t, deadline: deadline})
+	if atomic.AddInt64(&gatewayStores, 1)%gatewaySweepInterval == 0 {
+		sweepGatewayTraces(now)
+	}
+
+	md := metadata.Pairs(GatewayMetadataKey, id)
+	if xt := t.MetadataString(); xt != "" {
+		md.Set(ao.HTTPHeaderName, xt)
+	}
+	return md
+}
+
+func newGatewayID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sweepGatewayTraces deletes the entries past their deadline.
+func sweepGatewayTraces(now time.Time) {
+	gatewayTraces.Range(func(id, e interface{}) bool {
+		if e.(gatewayEntry).deadline.Before(now) {
+			gatewayTraces.Delete(id)
+		}
+		return true
+	})
+}
+
+// gatewayTrace returns the trace of the HTTP request the gRPC request is
+// forwarded from, if any. The entry is consumed, as the ID is only sent once.
+//
+// The gRPC requests served by grpc.Server.ServeHTTP, e.g., the grpc-web
+// requests (application/grpc-web, application/grpc-web+proto or
+// application/grpc-web-text) translated by a grpc-web wrapper under the HTTP
+// instrumentation, have the trace of the HTTP request in their context.
+func gatewayTrace(ctx context.Context) (ao.Trace, bool) {
+	if t := ao.TraceFromContext(ctx); t.LoggableTraceID() != "" {
+		return t, true
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	id := getFirstValFromMd(md, GatewayMetadataKey)
+	if id == "" {
+		return nil, false
+	}
+	e, ok := gatewayTraces.LoadAndDelete(id)
+	if !ok {
+		return nil, false
+	}
+	return e.(gatewayEntry).trace, true
+}
+
+// gatewayStatusKVs returns the KVs of the gRPC status of a request joining the
+// trace of an HTTP request, whose HTTP status is reported by the HTTP
+// instrumentation instead.
+func gatewayStatusKVs(err error) []interface{} {
+	if err == io.EOF {
+		err = nil
+	}
+	s := status.Convert(err)
+	kvs := []interface{}{"GRPCStatus", s.Code().String()}
+	if s.Message() != "" {
+		kvs = append(kvs, "GRPCMessage", s.Message())
+	}
+	return kvs
+}
+
+// joinGatewayTrace binds the trace of the HTTP request handled by grpc-gateway
+// to the context of the gRPC request, and names the transaction after the gRPC
+// method. The HTTP instrumentation remains responsible for ending the trace,
+// the trace is returned to add the gRPC status to it.
+func joinGatewayTrace(ctx context.Context, serverName string, methodName string) (context.Context, ao.Trace, bool) {
+	t, ok := gatewayTrace(ctx)
+	if !ok {
+		return ctx, nil, false
+	}
+	action := actionFromMethod(methodName)
+	t.SetTransactionName(serverName + "." + action)
+	t.AddEndArgs("Controller", serverName, "Action", action)
+	return ao.NewContext(ctx, t), t, true
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogrpc
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGatewayMetadata(t *testing.T) {
+	// no trace bound to the HTTP request
+	r := httptest.NewRequest("GET", "http://test.com/v1/hello", nil)
+	assert.Nil(t, GatewayMetadata(r.Context(), r))
+}
+
+func TestUnaryServerInterceptorJoinsGatewayTrace(t *testing.T) {
+	tr := &recordingTrace{Trace: ao.NewNullTrace()}
+	gatewayTraces.Store("gw-id", gatewayEntry{trace: tr, deadline: time.Now().Add(time.Minute)})
+	defer gatewayTraces.Delete("gw-id")
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/hello.Greeter/SayHello"}
+	var got ao.Trace
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got = ao.TraceFromContext(ctx)
+		return nil, status.Error(codes.NotFound, "no such greeting")
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(GatewayMetadataKey, "gw-id"))
+	_, err := UnaryServerInterceptor("greeter")(ctx, "req", info, handler)
+	assert.Error(t, err)
+	assert.True(t, got == tr)
+	// the gRPC status is reported by the HTTP span
+	assert.Equal(t, []interface{}{"GRPCStatus", "NotFound", "GRPCMessage", "no such greeting"}, tr.args[len(tr.args)-1])
+	// the entry is consumed
+	_, ok := gatewayTraces.Load("gw-id")
+	assert.False(t, ok)
+
+	// unknown gateway ID: the interceptor starts its own trace
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(GatewayMetadataKey, "unknown"))
+	_, ok = gatewayTrace(ctx)
+	assert.False(t, ok)
+	_, ok = gatewayTrace(context.Background())
+	assert.False(t, ok)
+}
+
+// recordingTrace is a trace with a trace ID and the end args recorded.
+type recordingTrace struct {
+	ao.Trace
+	args [][]interface{}
+}
+
+func (*recordingTrace) LoggableTraceID() string { return "trace-id" }
+
+func (t *recordingTrace) AddEndArgs(args ...interface{}) { t.args = append(t.args, args) }
+
+func TestStreamServerInterceptorJoinsServedTrace(t *testing.T) {
+	// the trace of the HTTP request a grpc-web request is served through
+	tr := &recordingTrace{Trace: ao.NewNullTrace()}
+	var got ao.Trace
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		got = ao.TraceFromContext(stream.Context())
+		return nil
+	}
+	stream := &wrappedServerStream{WrappedContext: ao.NewContext(context.Background(), tr)}
+	info := &grpc.StreamServerInfo{FullMethod: "/hello.Greeter/SayHellos"}
+	assert.NoError(t, StreamServerInterceptor("greeter")(nil, stream, info, handler))
+	assert.True(t, got == tr)
+	require.Len(t, tr.args, 3)
+	assert.Equal(t, []interface{}{"Controller", "greeter", "Action", "SayHellos"}, tr.args[0])
+	assert.Equal(t, []interface{}{"GRPCStatus", "OK"}, tr.args[2])
+}
+
+func TestSweepGatewayTraces(t *testing.T) {
+	now := time.Now()
+	gatewayTraces.Store("gw-expired", gatewayEntry{trace: ao.NewNullTrace(), deadline: now.Add(-time.Second)})
+	// the traces not sampled are swept by their deadline only
+	gatewayTraces.Store("gw-live", gatewayEntry{trace: ao.NewNullTrace(), deadline: now.Add(time.Second)})
+	defer gatewayTraces.Delete("gw-live")
+
+	sweepGatewayTraces(now)
+	_, ok := gatewayTraces.Load("gw-expired")
+	assert.False(t, ok)
+	_, ok = gatewayTraces.Load("gw-live")
+	assert.True(t, ok)
+}
+
+func TestIntegrationRegistered(t *testing.T) {
+	UnaryServerInterceptor("greeter")
+	for _, i := range ao.RegisteredIntegrations() {
+		if i.Name == IntegrationName {
+			assert.Equal(t, ao.Version(), i.Version)
+			assert.True(t, i.Enabled)
+			return
+		}
+	}
+	t.Fatal("aogrpc is not registered")
+}
+
+// fakeTransportStream is a transport stream recording the trailers set.
+type fakeTransportStream struct {
+	grpc.ServerTransportStream
+	trailer metadata.MD
+}
+
+func (s *fakeTransportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// fakeServerStream is a server stream recording the trailers set.
+type fakeServerStream struct {
+	grpc.ServerStream
+	trailer metadata.MD
+}
+
+func (s *fakeServerStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func TestServerInterceptorsTrailers(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	os.Setenv("APPOPTICS_CAPTURE_TRAILERS", "grpc-status,x-checksum")
+	defer os.Unsetenv("APPOPTICS_CAPTURE_TRAILERS")
+	c.Attach()
+
+	// the trailers set by grpc.SetTrailer
+	tr := &recordingTrace{Trace: ao.NewNullTrace()}
+	gatewayTraces.Store("gw-id", gatewayEntry{trace: tr, deadline: time.Now().Add(time.Minute)})
+	defer gatewayTraces.Delete("gw-id")
+	sts := &fakeTransportStream{}
+	ctx := grpc.NewContextWithServerTransportStream(metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(GatewayMetadataKey, "gw-id")), sts)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, grpc.SetTrailer(ctx, metadata.Pairs("x-checksum", "abc"))
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/hello.Greeter/SayHello"}
+	_, err := UnaryServerInterceptor("greeter")(ctx, "req", info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"abc"}, sts.trailer.Get("x-checksum"))
+	assert.Contains(t, tr.args, []interface{}{"Trailer.grpc-status", "0", "Trailer.x-checksum", "abc"})
+
+	// the trailers set on the stream
+	tr = &recordingTrace{Trace: ao.NewNullTrace()}
+	ss := &fakeServerStream{}
+	stream := &wrappedServerStream{ServerStream: ss, WrappedContext: ao.NewContext(context.Background(), tr)}
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		stream.SetTrailer(metadata.Pairs("x-checksum", "def"))
+		return status.Error(codes.NotFound, "no such greeting")
+	}
+	sinfo := &grpc.StreamServerInfo{FullMethod: "/hello.Greeter/SayHellos"}
+	assert.Error(t, StreamServerInterceptor("greeter")(nil, stream, sinfo, streamHandler))
+	assert.Equal(t, []string{"def"}, ss.trailer.Get("x-checksum"))
+	assert.Contains(t, tr.args, []interface{}{"Trailer.grpc-status", "5", "Trailer.x-checksum", "def"})
+}
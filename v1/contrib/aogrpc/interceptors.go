@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	fp "path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,9 +15,67 @@ import (
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aogrpc"
+
+// The names of the server and client interceptors, which can be disabled
+// separately by APPOPTICS_DISABLED_INTEGRATIONS.
+const (
+	IntegrationServer = IntegrationName + ".server"
+	IntegrationClient = IntegrationName + ".client"
+)
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// The RED (rate, errors, duration) metrics of the server RPCs, tagged by the
+// server name, the full method and the status code. Unlike the transaction
+// metrics derived from the traces, they're recorded for every RPC regardless
+// of the sampling decision.
+const (
+	// MetricServerResponseTime is the summary metric of the durations of the
+	// server RPCs in microseconds, whose count is the number of the RPCs.
+	MetricServerResponseTime = "GRPCServerResponseTime"
+	// MetricServerErrors is the metric counting the server RPCs which fail
+	// with a status code other than OK.
+	MetricServerErrors = "GRPCServerErrors"
+)
+
+// recordServerMetrics records the RED metrics of a finished server RPC.
+func recordServerMetrics(serverName, fullMethod string, start time.Time, err error) {
+	tags := serverMetricTags(serverName, fullMethod, err)
+	_ = ao.SummaryMetric(MetricServerResponseTime, float64(time.Since(start)/time.Microsecond), ao.MetricOptions{
+		Count:   1,
+		HostTag: true,
+		Tags:    tags,
+	})
+	if tags["Code"] != codes.OK.String() {
+		_ = ao.IncrementMetric(MetricServerErrors, ao.MetricOptions{
+			Count:   1,
+			HostTag: true,
+			Tags:    tags,
+		})
+	}
+}
+
+func serverMetricTags(serverName, fullMethod string, err error) map[string]string {
+	if err == io.EOF {
+		err = nil
+	}
+	return map[string]string{
+		"Server": serverName,
+		"Method": fullMethod,
+		"Code":   status.Code(err).String(),
+	}
+}
+
 func actionFromMethod(method string) string {
 	mParts := strings.Split(method, "/")
 
@@ -80,21 +139,31 @@ func tracingContext(ctx context.Context, serverName string, methodName string, s
 	action := actionFromMethod(methodName)
 
 	xtID := ""
+	xtSig := ""
 	opt := ""
 	signature := ""
 	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
 		xtID = getFirstValFromMd(md, ao.HTTPHeaderName)
+		xtSig = getFirstValFromMd(md, ao.HTTPHeaderXTraceSignature)
 		opt = getFirstValFromMd(md, ao.HTTPHeaderXTraceOptions)
 		signature = getFirstValFromMd(md, ao.HTTPHeaderXTraceOptionsSignature)
 	}
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
 
 	t := ao.NewTraceWithOptions(serverName, ao.SpanOptions{
+		Kind: ao.SpanKindServer,
 		ContextOptions: ao.ContextOptions{
 			MdStr:                  xtID,
 			URL:                    methodName,
 			XTraceOptions:          opt,
 			XTraceOptionsSignature: signature,
+			RemoteAddr:             remoteAddr,
+			MdSignature:            xtSig,
+			Ingress:                true,
 			CB: func() ao.KVMap {
 				kvs := ao.KVMap{
 					"Method":     "POST",
@@ -115,25 +184,111 @@ func tracingContext(ctx context.Context, serverName string, methodName string, s
 	return ao.NewContext(ctx, t), t
 }
 
+// statusTrailerKVs returns the KVs of the trailers of the response, as selected
+// by APPOPTICS_CAPTURE_TRAILERS: the ones set by the handler, e.g., by
+// grpc.SetTrailer, and the grpc-status and grpc-message ones, which are written
+// by the server after the interceptors return.
+func statusTrailerKVs(err error, trailers *trailerRecorder) []interface{} {
+	if err == io.EOF {
+		err = nil
+	}
+	s := status.Convert(err)
+	trailer := trailers.trailer()
+	trailer.Set("grpc-status", strconv.Itoa(int(s.Code())))
+	if s.Message() != "" {
+		trailer.Set("grpc-message", s.Message())
+	}
+	return ao.TrailerKVs(trailer)
+}
+
+// trailerRecorder records the trailers set by the handler of an RPC.
+type trailerRecorder struct {
+	lock sync.Mutex
+	md   metadata.MD
+}
+
+func (r *trailerRecorder) record(md metadata.MD) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.md = metadata.Join(r.md, md)
+}
+
+// trailer returns a copy of the trailers recorded.
+func (r *trailerRecorder) trailer() metadata.MD {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.md.Copy()
+}
+
+// trailerTransportStream records the trailers set by grpc.SetTrailer, which
+// sets them on the grpc.ServerTransportStream bound to the context.
+type trailerTransportStream struct {
+	grpc.ServerTransportStream
+	trailers *trailerRecorder
+}
+
+func (s *trailerTransportStream) SetTrailer(md metadata.MD) error {
+	if err := s.ServerTransportStream.SetTrailer(md); err != nil {
+		return err
+	}
+	s.trailers.record(md)
+	return nil
+}
+
+// recordTrailers returns a recorder of the trailers of the RPC, and a copy of
+// ctx whose grpc.ServerTransportStream, if any, records the trailers set.
+func recordTrailers(ctx context.Context) (context.Context, *trailerRecorder) {
+	trailers := &trailerRecorder{}
+	sts := grpc.ServerTransportStreamFromContext(ctx)
+	if sts == nil {
+		return ctx, trailers
+	}
+	return grpc.NewContextWithServerTransportStream(ctx,
+		&trailerTransportStream{ServerTransportStream: sts, trailers: trailers}), trailers
+}
+
+// appendOutgoingContext adds the X-Trace metadata and its signature, if any, to
+// the outgoing gRPC metadata.
+func appendOutgoingContext(ctx context.Context, xtID string) context.Context {
+	if sig := ao.SignMetadata(xtID); sig != "" {
+		return metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID, ao.HTTPHeaderXTraceSignature, sig)
+	}
+	return metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
+}
+
 // UnaryServerInterceptor returns an interceptor that traces gRPC unary server RPCs using AppOptics.
 // If the client is using UnaryClientInterceptor, the distributed trace's context will be read from the client.
+// Requests forwarded by grpc-gateway join the trace of the HTTP request, see GatewayMetadata.
 func UnaryServerInterceptor(serverName string) grpc.UnaryServerInterceptor {
+	ao.EnableIntegration(IntegrationName)
 	return func(
 		ctx context.Context,
 		req interface{},
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
+		if ao.IntegrationDisabled(IntegrationServer) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
 		var err error
 		var resp interface{}
 		var statusCode = 200
-		var t ao.Trace
-		ctx, t = tracingContext(ctx, serverName, info.FullMethod, &statusCode)
-		defer func() {
-			t.SetStatus(statusCode)
-			ao.EndTrace(ctx)
-		}()
+		ctx, t, joined := joinGatewayTrace(ctx, serverName, info.FullMethod)
+		if !joined {
+			ctx, t = tracingContext(ctx, serverName, info.FullMethod, &statusCode)
+			defer func() {
+				t.SetStatus(statusCode)
+				ao.EndTrace(ctx)
+			}()
+		}
+		ctx, trailers := recordTrailers(ctx)
 		resp, err = handler(ctx, req)
+		recordServerMetrics(serverName, info.FullMethod, start, err)
+		t.AddEndArgs(statusTrailerKVs(err, trailers)...)
+		if joined {
+			t.AddEndArgs(gatewayStatusKVs(err)...)
+		}
 		if err != nil {
 			statusCode = 500
 			ao.Error(ctx, getErrClass(err), err.Error())
@@ -146,12 +301,21 @@ func UnaryServerInterceptor(serverName string) grpc.UnaryServerInterceptor {
 type wrappedServerStream struct {
 	grpc.ServerStream
 	WrappedContext context.Context
+	// the trailers set by the handler, if recorded
+	trailers *trailerRecorder
 }
 
 func (w *wrappedServerStream) Context() context.Context {
 	return w.WrappedContext
 }
 
+func (w *wrappedServerStream) SetTrailer(md metadata.MD) {
+	w.ServerStream.SetTrailer(md)
+	if w.trailers != nil {
+		w.trailers.record(md)
+	}
+}
+
 func wrapServerStream(stream grpc.ServerStream) *wrappedServerStream {
 	if existing, ok := stream.(*wrappedServerStream); ok {
 		return existing
@@ -162,21 +326,36 @@ func wrapServerStream(stream grpc.ServerStream) *wrappedServerStream {
 // StreamServerInterceptor returns an interceptor that traces gRPC streaming server RPCs using AppOptics.
 // Each server span starts with the first message and ends when all request and response messages have finished streaming.
 func StreamServerInterceptor(serverName string) grpc.StreamServerInterceptor {
+	ao.EnableIntegration(IntegrationName)
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if ao.IntegrationDisabled(IntegrationServer) {
+			return handler(srv, stream)
+		}
+		start := time.Now()
 		var err error
 		var statusCode = 200
-		newCtx, t := tracingContext(stream.Context(), serverName, info.FullMethod, &statusCode)
-		defer func() {
-			t.SetStatus(statusCode)
-			ao.EndTrace(newCtx)
-		}()
+		newCtx, t, joined := joinGatewayTrace(stream.Context(), serverName, info.FullMethod)
+		if !joined {
+			newCtx, t = tracingContext(stream.Context(), serverName, info.FullMethod, &statusCode)
+			defer func() {
+				t.SetStatus(statusCode)
+				ao.EndTrace(newCtx)
+			}()
+		}
 		// if lg.IsDebug() {
 		// 	sp := ao.FromContext(newCtx)
 		// 	lg.Debug("server stream starting", "xtrace", sp.MetadataString())
 		// }
+		newCtx, trailers := recordTrailers(newCtx)
 		wrappedStream := wrapServerStream(stream)
 		wrappedStream.WrappedContext = newCtx
+		wrappedStream.trailers = trailers
 		err = handler(srv, wrappedStream)
+		recordServerMetrics(serverName, info.FullMethod, start, err)
+		t.AddEndArgs(statusTrailerKVs(err, trailers)...)
+		if joined {
+			t.AddEndArgs(gatewayStatusKVs(err)...)
+		}
 		if err == io.EOF {
 			return nil
 		} else if err != nil {
@@ -190,6 +369,7 @@ func StreamServerInterceptor(serverName string) grpc.StreamServerInterceptor {
 // UnaryClientInterceptor returns an interceptor that traces a unary RPC from a gRPC client to a server using
 // AppOptics, by propagating the distributed trace's context from client to server using gRPC metadata.
 func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientInterceptor {
+	ao.EnableIntegration(IntegrationName)
 	return func(
 		ctx context.Context,
 		method string,
@@ -198,14 +378,19 @@ func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientI
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
+		if ao.IntegrationDisabled(IntegrationClient) {
+			return invoker(ctx, method, req, resp, cc, opts...)
+		}
 		action := actionFromMethod(method)
 		span := ao.BeginRPCSpan(ctx, action, "grpc", serviceName, target)
 		defer span.End()
 		xtID := span.MetadataString()
 		if len(xtID) > 0 {
-			ctx = metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
+			ctx = appendOutgoingContext(ctx, xtID)
 		}
-		err := invoker(ctx, method, req, resp, cc, opts...)
+		var trailer metadata.MD
+		err := invoker(ctx, method, req, resp, cc, append(opts, grpc.Trailer(&trailer))...)
+		span.AddEndArgs(ao.TrailerKVs(trailer)...)
 		if err != nil {
 			span.Error(getErrClass(err), err.Error())
 			return err
@@ -218,13 +403,17 @@ func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientI
 // AppOptics, by propagating the distributed trace's context from client to server using gRPC metadata.
 // The client span starts with the first message and ends when all request and response messages have finished streaming.
 func StreamClientInterceptor(target string, serviceName string) grpc.StreamClientInterceptor {
+	ao.EnableIntegration(IntegrationName)
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if ao.IntegrationDisabled(IntegrationClient) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
 		action := actionFromMethod(method)
 		span := ao.BeginRPCSpan(ctx, action, "grpc", serviceName, target)
 		xtID := span.MetadataString()
 		// lg.Debug("stream client interceptor", "x-trace", xtID)
 		if len(xtID) > 0 {
-			ctx = metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
+			ctx = appendOutgoingContext(ctx, xtID)
 		}
 		clientStream, err := streamer(ctx, desc, cc, method, opts...)
 		if err != nil {
@@ -269,6 +458,8 @@ func (s *tracedClientStream) CloseSend() error {
 func (s *tracedClientStream) RecvMsg(m interface{}) error {
 	err := s.ClientStream.RecvMsg(m)
 	if err != nil {
+		// the trailers are available once RecvMsg fails, including io.EOF
+		s.span.AddEndArgs(ao.TrailerKVs(s.ClientStream.Trailer())...)
 		s.closeSpan(err)
 	}
 	return err
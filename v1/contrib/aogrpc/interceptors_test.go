@@ -1,12 +1,15 @@
 package aogrpc
 
 import (
+	"io"
 	"testing"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
 	"github.com/appoptics/appoptics-apm-go/v1/contrib/aogrpc/mocks"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestGetTopFramePkg(t *testing.T) {
@@ -63,3 +66,13 @@ func TestActionFromMethod(t *testing.T) {
 	assert.EqualValues(t, "", actionFromMethod("abc/"))
 	assert.EqualValues(t, "", actionFromMethod("/abc/"))
 }
+
+func TestServerMetricTags(t *testing.T) {
+	assert.Equal(t, map[string]string{"Server": "svc", "Method": "/pkg.Svc/Get", "Code": "OK"},
+		serverMetricTags("svc", "/pkg.Svc/Get", nil))
+	// the end of a stream is not an error
+	assert.Equal(t, "OK", serverMetricTags("svc", "/pkg.Svc/List", io.EOF)["Code"])
+	assert.Equal(t, "NotFound",
+		serverMetricTags("svc", "/pkg.Svc/Get", status.Error(codes.NotFound, "no such item"))["Code"])
+	assert.Equal(t, "Unknown", serverMetricTags("svc", "/pkg.Svc/Get", errors.New("boom"))["Code"])
+}
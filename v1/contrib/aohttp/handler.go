@@ -0,0 +1,22 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// Middleware traces the requests served by next, it's the constructor of the
+// middleware chains.
+func Middleware(next http.Handler) http.Handler {
+	return ao.WrapHTTPHandler(next)
+}
+
+// WrapHandler traces the requests served by h with the options, e.g.,
+// ao.WithControllerAction naming the handlers built at runtime:
+//   mux.Handle("/users", aohttp.WrapHandler(users, ao.WithControllerAction("users", "list")))
+func WrapHandler(h http.Handler, opts ...ao.SpanOpt) http.Handler {
+	return ao.WrapHTTPHandler(h, opts...)
+}
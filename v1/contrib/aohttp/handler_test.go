@@ -0,0 +1,30 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var served []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = append(served, r.URL.Path)
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, h := range []http.Handler{
+		Middleware(next),
+		WrapHandler(next, ao.WithControllerAction("users", "list")),
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.com/users", nil))
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	}
+	assert.Equal(t, []string{"/users", "/users"}, served)
+}
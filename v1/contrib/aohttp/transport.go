@@ -0,0 +1,114 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aohttp instruments the outbound HTTP requests made by the libraries
+// which use http.DefaultTransport, e.g., through http.DefaultClient, so they
+// are reported as http.Client spans without changing the libraries. It's an
+// explicit opt-in as it changes the transport process-wide:
+//
+//   func main() {
+//       aohttp.InstallDefaultTransport(ao.WithPropagateHosts("*.example.com"))
+//       defer aohttp.UninstallDefaultTransport()
+//       // ...
+//   }
+//
+// The trace context headers are only injected into the requests to the hosts
+// allowed by ao.WithPropagateHosts, or by APPOPTICS_PROPAGATE_HOSTS if it's
+// not given, so they're not leaked to the third-party APIs. The spans are
+// reported regardless.
+//
+// Only the requests made in the context of a traced span are reported, the
+// request's context must be set, e.g., by http.NewRequestWithContext. The
+// requests instrumented by ao.BeginHTTPClientSpan, i.e., its
+// HTTPClientSpan.Request, see ao.HTTPClientSpanStarted, and the ones carrying
+// the trace context headers already are passed through unchanged, so they're
+// not reported twice.
+//
+// The inbound requests are traced by Middleware, which suits the middleware
+// chains of http.Handler, e.g., alice or negroni:
+//
+//   chain := alice.New(aohttp.Middleware, authMiddleware).Then(router)
+package aohttp
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aohttp"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+var installed struct {
+	sync.Mutex
+	orig http.RoundTripper // the DefaultTransport replaced, nil if not installed
+}
+
+// InstallDefaultTransport replaces http.DefaultTransport with a Transport
+// wrapping it. The options, e.g., ao.WithPropagateHosts, apply to all the
+// spans reported. Calling it again replaces the options.
+//
+// http.DefaultTransport is no longer an *http.Transport then, so the code
+// asserting it, e.g., http.DefaultTransport.(*http.Transport).Clone(), panics
+// or gets false from the two-value form. Such code should be run before, or
+// use Transport.Base of the installed Transport instead.
+func InstallDefaultTransport(opts ...ao.SpanOpt) {
+	installed.Lock()
+	defer installed.Unlock()
+	if installed.orig == nil {
+		installed.orig = http.DefaultTransport
+	}
+	http.DefaultTransport = NewTransport(installed.orig, opts...)
+}
+
+// UninstallDefaultTransport restores the http.DefaultTransport replaced by
+// InstallDefaultTransport. The clients created with the Transport in between
+// keep using it.
+func UninstallDefaultTransport() {
+	installed.Lock()
+	defer installed.Unlock()
+	if installed.orig != nil {
+		http.DefaultTransport = installed.orig
+		installed.orig = nil
+	}
+}
+
+// Transport is an http.RoundTripper reporting an http.Client span for each
+// request made in the context of a traced span.
+type Transport struct {
+	// Base is the RoundTripper making the requests.
+	Base http.RoundTripper
+	opts []ao.SpanOpt
+}
+
+// NewTransport returns a Transport making the requests with base, or
+// http.DefaultTransport if it's nil. It can also be used for the clients
+// which don't use http.DefaultTransport:
+//   client := &http.Client{Transport: aohttp.NewTransport(tr)}
+func NewTransport(base http.RoundTripper, opts ...ao.SpanOpt) *Transport {
+	ao.EnableIntegration(IntegrationName)
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, opts: opts}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ao.IntegrationDisabled(IntegrationName) || ao.HTTPClientSpanStarted(req) ||
+		req.Header.Get(ao.HTTPHeaderName) != "" || !ao.FromContext(req.Context()).IsReporting() {
+		return t.Base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request, the headers are injected
+	// into a copy of it
+	req = req.Clone(req.Context())
+	l := ao.BeginHTTPClientSpan(req.Context(), req, t.opts...)
+	defer l.End()
+	resp, err := t.Base.RoundTrip(l.Request())
+	l.AddHTTPResponse(resp, err)
+	return resp, err
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aohttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallDefaultTransport(t *testing.T) {
+	orig := http.DefaultTransport
+	defer func() { http.DefaultTransport = orig }()
+
+	InstallDefaultTransport()
+	tr, ok := http.DefaultTransport.(*Transport)
+	require.True(t, ok)
+	assert.Equal(t, orig, tr.Base)
+
+	// installing again doesn't wrap the Transport itself
+	InstallDefaultTransport(ao.WithPropagateHosts("example.com"))
+	tr, ok = http.DefaultTransport.(*Transport)
+	require.True(t, ok)
+	assert.Equal(t, orig, tr.Base)
+	assert.Len(t, tr.opts, 1)
+
+	UninstallDefaultTransport()
+	assert.Equal(t, orig, http.DefaultTransport)
+	UninstallDefaultTransport()
+	assert.Equal(t, orig, http.DefaultTransport)
+}
+
+func TestTransportNotTraced(t *testing.T) {
+	var xtrace []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xtrace = append(xtrace, r.Header.Get(ao.HTTPHeaderName))
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(nil)}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	// the headers set by the caller are passed through
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set(ao.HTTPHeaderName, "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, []string{"", "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"}, xtrace)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoiris traces the requests of an Iris application, with the same
+// header semantics as ao.HTTPHandler:
+//   app := iris.New()
+//   aoiris.Wrap(app)
+// The transactions are named after the route templates, e.g., "/users/{id}".
+// The trace is bound to the context of the request, so the handlers can begin
+// the spans of their own from ctx.Request().Context().
+//
+// The package is a module of its own, so the Iris dependencies are not pulled
+// into the other programs.
+package aoiris
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/kataras/iris/v12"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoiris"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// DefaultSpanName is the name of the span created for each request if it's not
+// given to Wrap.
+const DefaultSpanName = "iris"
+
+// Wrap traces the requests of app in the spans named spanName,
+// DefaultSpanName by default. The requests are traced by a wrapper of the
+// router, so the responses are observed like ao.HTTPHandler, and the
+// transactions are named by a handler used before all the routes.
+func Wrap(app *iris.Application, spanName ...string) {
+	name := DefaultSpanName
+	if len(spanName) > 0 && spanName[0] != "" {
+		name = spanName[0]
+	}
+	ao.EnableIntegration(IntegrationName)
+	app.WrapRouter(func(w http.ResponseWriter, r *http.Request, router http.HandlerFunc) {
+		if ao.Closed() || ao.IntegrationDisabled(IntegrationName) {
+			router(w, r)
+			return
+		}
+		t, w, r := ao.TraceFromHTTPRequestResponse(name, w, r)
+		defer t.End()
+
+		defer func() { // report the panic, if any, and re-raise it
+			if err := recover(); err != nil {
+				t.Error("panic", fmt.Sprintf("%v", err))
+				panic(err)
+			}
+		}()
+		router(w, r)
+	})
+	app.UseGlobal(Handler)
+}
+
+// Handler names the transaction of the request after the route template. It's
+// used before all the routes by Wrap.
+func Handler(ctx iris.Context) {
+	if route := ctx.GetCurrentRoute(); route != nil {
+		_ = ao.SetTransactionName(ctx.Request().Context(), route.Path())
+	}
+	ctx.Next()
+}
@@ -0,0 +1,32 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoiris_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aoiris"
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrap(t *testing.T) {
+	app := iris.New()
+	aoiris.Wrap(app)
+	var md string
+	app.Get("/users/{id}", func(ctx iris.Context) {
+		md = ao.MetadataString(ctx.Request().Context())
+		ctx.StatusCode(http.StatusTeapot)
+	})
+	require.NoError(t, app.Build())
+
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, httptest.NewRequest("GET", "http://test.com/users/1", nil))
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+	assert.NotEmpty(t, md)
+	assert.NotEmpty(t, rr.Header().Get(ao.HTTPHeaderName))
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aolaunchdarkly provides a LaunchDarkly SDK hook which records the
+// evaluated feature flags on the AppOptics trace of the request, as the KV
+// "FeatureFlag.<flag>" of the root span. The hook is registered with the
+// client, and the flags are recorded on the trace bound to the context passed
+// to the *Ctx variation methods:
+//   client, err := ld.MakeCustomClient(sdkKey, ld.Config{
+//       Hooks: []ldhooks.Hook{aolaunchdarkly.NewHook()},
+//   }, 5*time.Second)
+//   // ...
+//   enabled, err := client.BoolVariationCtx(ctx, "new-checkout", user, false)
+// The variation methods without a context, e.g. BoolVariation, are evaluated
+// with context.TODO, which is bound to no trace.
+package aolaunchdarkly
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/launchdarkly/go-sdk-common/v3/ldreason"
+	"github.com/launchdarkly/go-server-sdk/v7/ldhooks"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aolaunchdarkly"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// Hook is a LaunchDarkly hook recording the evaluated flags on the trace bound
+// to the context of each evaluation.
+type Hook struct {
+	ldhooks.Unimplemented
+}
+
+// check at compile time that Hook implements the ldhooks.Hook interface
+var _ ldhooks.Hook = Hook{}
+
+// NewHook returns a Hook which records the evaluated flags.
+func NewHook() Hook {
+	ao.EnableIntegration(IntegrationName)
+	return Hook{}
+}
+
+// Metadata implements ldhooks.Hook.
+func (h Hook) Metadata() ldhooks.Metadata {
+	return ldhooks.NewMetadata(IntegrationName)
+}
+
+// AfterEvaluation records the evaluated flag value. The strings are reported
+// as is, and the other values in JSON.
+func (h Hook) AfterEvaluation(ctx context.Context, seriesContext ldhooks.EvaluationSeriesContext,
+	data ldhooks.EvaluationSeriesData, detail ldreason.EvaluationDetail) (ldhooks.EvaluationSeriesData, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return data, nil
+	}
+	variant := detail.Value.String()
+	if detail.Value.IsString() {
+		variant = detail.Value.StringValue()
+	}
+	ao.RecordFeatureFlag(ctx, seriesContext.FlagKey(), variant)
+	return data, nil
+}
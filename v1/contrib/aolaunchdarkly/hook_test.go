@@ -0,0 +1,72 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aolaunchdarkly_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aolaunchdarkly"
+	"github.com/launchdarkly/go-sdk-common/v3/ldcontext"
+	"github.com/launchdarkly/go-sdk-common/v3/ldvalue"
+	ld "github.com/launchdarkly/go-server-sdk/v7"
+	"github.com/launchdarkly/go-server-sdk/v7/ldcomponents"
+	"github.com/launchdarkly/go-server-sdk/v7/ldhooks"
+	"github.com/launchdarkly/go-server-sdk/v7/testhelpers/ldtestdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookAfterEvaluation(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	td := ldtestdata.DataSource()
+	td.Update(td.Flag("new-checkout").BooleanFlag().VariationForAll(true))
+	td.Update(td.Flag("unbound").BooleanFlag().VariationForAll(true))
+	td.Update(td.Flag("theme").Variations(ldvalue.String("dark"), ldvalue.String("light")).VariationForAllIndex(1))
+	td.Update(td.Flag("limits").Variations(ldvalue.ObjectBuild().Set("max", ldvalue.Int(3)).Build()).VariationForAllIndex(0))
+	client, err := ld.MakeCustomClient("sdk-key", ld.Config{
+		DataSource: td,
+		Events:     ldcomponents.NoEvents(),
+		Hooks:      []ldhooks.Hook{aolaunchdarkly.NewHook()},
+	}, 5*time.Second)
+	require.NoError(t, err)
+	defer client.Close()
+
+	tr := ao.NewTrace("test")
+	trCtx := ao.NewContext(context.Background(), tr)
+	user := ldcontext.New("user-1")
+	enabled, err := client.BoolVariationCtx(trCtx, "new-checkout", user, false)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	_, err = client.StringVariationCtx(trCtx, "theme", user, "")
+	assert.NoError(t, err)
+	_, err = client.JSONVariationCtx(trCtx, "limits", user, ldvalue.Null())
+	assert.NoError(t, err)
+	// no trace bound to the context
+	_, err = client.BoolVariation("unbound", user, false)
+	assert.NoError(t, err)
+	tr.End()
+
+	events, err := c.WaitForEvents(ctx, 2)
+	require.NoError(t, err)
+	var exit aotest.Message
+	for _, e := range events {
+		if e["Layer"] == "test" && e["Label"] == "exit" {
+			exit = e
+		}
+	}
+	require.NotNil(t, exit)
+	assert.Equal(t, "true", exit["FeatureFlag.new-checkout"])
+	assert.Equal(t, "light", exit["FeatureFlag.theme"])
+	assert.Equal(t, `{"max":3}`, exit["FeatureFlag.limits"])
+	assert.NotContains(t, exit, "FeatureFlag.unbound")
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aomachinery traces the machinery tasks from the sending to the
+// workers. The trace context is injected into the headers of the signatures
+// sent by SendTask, and continued by StartTask in the task functions, which
+// take the context as their first argument:
+//   asyncResult, err := aomachinery.SendTask(ctx, server, &tasks.Signature{Name: "email"})
+//
+//   func email(ctx context.Context, to string) error {
+//       t, ctx := aomachinery.StartTask(ctx)
+//       defer t.End()
+//       ...
+//   }
+//
+// The package is a module of its own, so the machinery dependencies are not
+// pulled into the other programs.
+package aomachinery
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/backends/result"
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aomachinery"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// The names of the spans reported.
+const (
+	SendSpanName = "machinery.send"
+	TaskSpanName = "machinery.task"
+)
+
+// headerSent is the signature header of the time the task is sent, in
+// microseconds. It's a string as the numbers are not kept by all the brokers.
+const headerSent = "X-Trace-Sent"
+
+// SendTask sends the task of the signature in a producer span, with the trace
+// context injected into its headers. The span reports the queue and the
+// scheduled delay of the task, as given by the ETA of the signature.
+func SendTask(ctx context.Context, server *machinery.Server, sig *tasks.Signature) (*result.AsyncResult, error) {
+	ao.EnableIntegration(IntegrationName)
+	if ao.IntegrationDisabled(IntegrationName) {
+		return server.SendTaskWithContext(ctx, sig)
+	}
+	var delay time.Duration
+	if sig.ETA != nil {
+		if delay = time.Until(*sig.ETA); delay < 0 {
+			delay = 0
+		}
+	}
+	l, _ := ao.BeginSpanWithOptions(ctx, SendSpanName, ao.SpanOptions{Kind: ao.SpanKindProducer},
+		"TaskName", sig.Name, "Queue", sig.RoutingKey, "ScheduledDelay", int64(delay/time.Microsecond))
+	defer l.End()
+	if md := l.MetadataString(); md != "" {
+		if sig.Headers == nil {
+			sig.Headers = tasks.Headers{}
+		}
+		sig.Headers[ao.HTTPHeaderName] = md
+		sig.Headers[headerSent] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Microsecond), 10)
+	}
+	res, err := server.SendTaskWithContext(ctx, sig)
+	if err != nil {
+		l.Err(err)
+	} else {
+		l.AddEndArgs("TaskID", sig.UUID)
+	}
+	return res, err
+}
+
+// StartTask starts the trace of the task running with ctx, which continues
+// from the span sending it if it's sent by SendTask. The trace reports the
+// queue, the retries left as the RetryCount of the signature, and the time
+// the task spent in the queue. It's returned along with a copy of ctx bound
+// to it, and should be ended by the task function. A null trace is returned
+// if ctx is not the context of a task.
+func StartTask(ctx context.Context) (ao.Trace, context.Context) {
+	ao.EnableIntegration(IntegrationName)
+	sig := tasks.SignatureFromContext(ctx)
+	if sig == nil || ao.IntegrationDisabled(IntegrationName) {
+		return ao.NewNullTrace(), ctx
+	}
+	md, _ := sig.Headers[ao.HTTPHeaderName].(string)
+	t := ao.NewTraceWithOptions(TaskSpanName, ao.SpanOptions{
+		Kind: ao.SpanKindConsumer,
+		ContextOptions: ao.ContextOptions{
+			MdStr: md,
+			CB: func() ao.KVMap {
+				kvs := ao.KVMap{
+					"TaskName":   sig.Name,
+					"TaskID":     sig.UUID,
+					"Queue":      sig.RoutingKey,
+					"RetryCount": sig.RetryCount,
+				}
+				if qt, ok := queueTime(sig.Headers, time.Now()); ok {
+					kvs["QueueTime"] = int64(qt / time.Microsecond)
+				}
+				return kvs
+			},
+		}})
+	t.SetTransactionName(sig.Name)
+	return t, ao.NewContext(ctx, t)
+}
+
+// queueTime returns the time since the task is sent, if known.
+func queueTime(headers tasks.Headers, now time.Time) (time.Duration, bool) {
+	s, _ := headers[headerSent].(string)
+	micros, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || micros <= 0 {
+		return 0, false
+	}
+	if qt := now.Sub(time.Unix(0, micros*int64(time.Microsecond))); qt > 0 {
+		return qt, true
+	}
+	return 0, true // clock skew between the hosts
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aomachinery
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueTime(t *testing.T) {
+	now := time.Now().Truncate(time.Microsecond) // the header is in microseconds
+	sent := strconv.FormatInt(now.Add(-time.Second).UnixNano()/int64(time.Microsecond), 10)
+	qt, ok := queueTime(tasks.Headers{headerSent: sent}, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, qt)
+
+	qt, ok = queueTime(tasks.Headers{headerSent: sent}, now.Add(-time.Hour))
+	assert.True(t, ok)
+	assert.Zero(t, qt)
+
+	for _, h := range []tasks.Headers{nil, {headerSent: 1.0}, {headerSent: "x"}} {
+		_, ok = queueTime(h, now)
+		assert.False(t, ok)
+	}
+}
+
+func TestStartTaskWithoutSignature(t *testing.T) {
+	ctx := context.Background()
+	tr, got := StartTask(ctx)
+	assert.False(t, tr.IsSampled())
+	assert.Equal(t, ctx, got)
+}
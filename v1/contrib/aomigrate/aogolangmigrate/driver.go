@@ -0,0 +1,139 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aogolangmigrate traces the migrations run by golang-migrate. The
+// database driver is wrapped, so each migration applied by migrate.Migrate,
+// e.g., by Up, Down or Steps, becomes a child span of the aomigrate.Run:
+//
+//   run, ctx := aomigrate.Begin(ctx, "migrate")
+//   drv, err := postgres.WithInstance(db, &postgres.Config{})
+//   // ...
+//   m, err := migrate.NewWithDatabaseInstance("file://migrations", "postgres",
+//       aogolangmigrate.WrapDriver(run, drv))
+//   // ...
+//   err = m.Up()
+//   if err == migrate.ErrNoChange {
+//       err = nil
+//   }
+//   run.End(err)
+//
+// The number of rows affected is not reported, as the drivers don't return it.
+package aogolangmigrate
+
+import (
+	"io"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aomigrate"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// driver wraps a database.Driver of golang-migrate. A migration is run by
+// setting the dirty target version, running its body and setting the clean
+// target version, which is when its span ends. The version and the direction
+// are derived from the target version and the current one.
+type driver struct {
+	database.Driver
+	run *aomigrate.Run
+
+	lock      sync.Mutex
+	cur       int  // the current version
+	known     bool // the current version is known
+	migration *aomigrate.Migration
+}
+
+// WrapDriver returns the database.Driver which traces the migrations run by
+// drv as the child spans of run. It's passed to the constructors of
+// migrate.Migrate, e.g., migrate.NewWithDatabaseInstance.
+func WrapDriver(run *aomigrate.Run, drv database.Driver) database.Driver {
+	return &driver{Driver: drv, run: run}
+}
+
+// Open implements database.Driver, the driver opened is traced by the same run.
+func (d *driver) Open(url string) (database.Driver, error) {
+	drv, err := d.Driver.Open(url)
+	if err != nil {
+		return nil, err
+	}
+	return WrapDriver(d.run, drv), nil
+}
+
+// Version implements database.Driver.
+func (d *driver) Version() (int, bool, error) {
+	version, dirty, err := d.Driver.Version()
+	if err == nil {
+		d.lock.Lock()
+		d.cur, d.known = version, true
+		d.lock.Unlock()
+	}
+	return version, dirty, err
+}
+
+// SetVersion implements database.Driver. Setting the dirty version begins the
+// span of the migration, and setting the clean version ends it.
+func (d *driver) SetVersion(version int, dirty bool) error {
+	d.lock.Lock()
+	known := d.known
+	d.lock.Unlock()
+	if dirty && !known {
+		if _, _, err := d.Version(); err != nil {
+			return err
+		}
+	}
+
+	d.lock.Lock()
+	if dirty {
+		d.endMigration(nil)
+		if version > d.cur {
+			d.migration, _ = d.run.BeginMigration(uint(version), aomigrate.Up)
+		} else {
+			d.migration, _ = d.run.BeginMigration(uint(d.cur), aomigrate.Down)
+		}
+	}
+	d.lock.Unlock()
+
+	err := d.Driver.SetVersion(version, dirty)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err != nil {
+		d.endMigration(err)
+		return err
+	}
+	d.cur, d.known = version, true
+	if !dirty {
+		d.endMigration(nil)
+	}
+	return nil
+}
+
+// Run implements database.Driver, the error of the migration is reported on
+// its span.
+func (d *driver) Run(migration io.Reader) error {
+	err := d.Driver.Run(migration)
+	if err != nil {
+		d.lock.Lock()
+		d.endMigration(err)
+		d.lock.Unlock()
+	}
+	return err
+}
+
+// Drop implements database.Driver.
+func (d *driver) Drop() error {
+	err := d.Driver.Drop()
+	if err == nil {
+		d.lock.Lock()
+		d.cur, d.known = database.NilVersion, true
+		d.lock.Unlock()
+	}
+	return err
+}
+
+// endMigration ends the span of the migration in progress, if any. The lock
+// must be held by the caller.
+func (d *driver) endMigration(err error) {
+	if d.migration != nil {
+		d.migration.End(aomigrate.RowsUnknown, err)
+		d.migration = nil
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aogolangmigrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aomigrate"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aomigrate/aogolangmigrate"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/stub"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errMigration = errors.New("duplicate column")
+
+// failingDriver fails the migrations whose body is FAIL.
+type failingDriver struct {
+	database.Driver
+}
+
+func (d failingDriver) Run(migration io.Reader) error {
+	b, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(b)) == "FAIL" {
+		return errMigration
+	}
+	return d.Driver.Run(strings.NewReader(string(b)))
+}
+
+func TestWrapDriver(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	dir, err := ioutil.TempDir("", "aogolangmigrate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, body := range map[string]string{
+		"1_users.up.sql":    "CREATE TABLE users",
+		"1_users.down.sql":  "DROP TABLE users",
+		"2_orders.up.sql":   "CREATE TABLE orders",
+		"2_orders.down.sql": "DROP TABLE orders",
+		"3_email.up.sql":    "FAIL",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0600))
+	}
+
+	drv, err := stub.WithInstance(nil, &stub.Config{})
+	require.NoError(t, err)
+	run, _ := aomigrate.Begin(context.Background(), "migrate")
+	m, err := migrate.NewWithDatabaseInstance("file://"+dir, "stub",
+		aogolangmigrate.WrapDriver(run, failingDriver{drv}))
+	require.NoError(t, err)
+
+	require.NoError(t, m.Steps(2))
+	require.NoError(t, m.Steps(-1))
+	err = m.Steps(2)
+	assert.Equal(t, errMigration, err)
+	run.End(err)
+	assert.Equal(t, 5, run.Migrations())
+	assert.Equal(t, []string{"CREATE TABLE users", "CREATE TABLE orders", "DROP TABLE orders", "CREATE TABLE orders"},
+		drv.(*stub.Stub).MigrationSequence)
+
+	// the entries and the exits of the run and the migrations, and the errors
+	// of the last migration and the run
+	events, err := c.WaitForEvents(ctx, 14)
+	require.NoError(t, err)
+	var migrations []string
+	var errs int
+	for _, e := range events {
+		if e["Layer"] == aomigrate.MigrationSpanName && e["Label"] == "entry" {
+			migrations = append(migrations, fmt.Sprintf("%v %v", e["MigrationVersion"], e["MigrationDirection"]))
+		}
+		if e["Layer"] == aomigrate.MigrationSpanName && e["Label"] == "error" {
+			errs++
+			assert.Equal(t, errMigration.Error(), e["ErrorMsg"])
+		}
+	}
+	assert.Equal(t, []string{"1 up", "2 up", "2 down", "2 up", "3 up"}, migrations)
+	assert.Equal(t, 1, errs)
+}
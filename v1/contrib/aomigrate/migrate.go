@@ -0,0 +1,170 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aomigrate provides helpers to trace database schema migration runs,
+// so slow deploy steps are visible alongside the application traces. The
+// whole run is traced as a span, or as a new trace if there isn't one bound to
+// the context, e.g., in a standalone migration job. Each migration becomes a
+// child span reporting its version, direction and the number of rows affected.
+//
+// The helpers don't depend on any migration tool. With a hand-rolled runner:
+//
+//   run, ctx := aomigrate.Begin(ctx, "migrate")
+//   for _, m := range pending {
+//       err = run.Migrate(m.Version, aomigrate.Up, func(ctx context.Context) (int64, error) {
+//           res, err := db.ExecContext(ctx, m.SQL)
+//           if err != nil {
+//               return aomigrate.RowsUnknown, err
+//           }
+//           return res.RowsAffected()
+//       })
+//       if err != nil {
+//           break
+//       }
+//   }
+//   run.End(err)
+//
+// The migrations of golang-migrate are traced by the aogolangmigrate package.
+package aomigrate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aomigrate"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// Direction is the direction of a migration.
+type Direction string
+
+// The migration directions
+const (
+	Up   Direction = "up"
+	Down Direction = "down"
+)
+
+// MigrationSpanName is the name of the span of each migration.
+const MigrationSpanName = "migration"
+
+// RowsUnknown may be returned by a migration if the number of rows affected is
+// not known, in which case it's not reported.
+const RowsUnknown int64 = -1
+
+const (
+	keyVersion      = "MigrationVersion"
+	keyDirection    = "MigrationDirection"
+	keyRowsAffected = "MigrationRowsAffected"
+	keyMigrations   = "MigrationCount"
+)
+
+// Run traces a migration run.
+type Run struct {
+	span       ao.Span
+	ctx        context.Context
+	migrations int
+	lock       sync.Mutex
+	// the integration is disabled, migrations are run without spans
+	disabled bool
+}
+
+// Begin starts a span for the migration run as a child of the span bound to
+// ctx, or a new trace if there is none. It returns the Run and a context bound
+// to the new span.
+func Begin(ctx context.Context, spanName string, args ...interface{}) (*Run, context.Context) {
+	ao.EnableIntegration(IntegrationName)
+	if ao.IntegrationDisabled(IntegrationName) {
+		return &Run{span: ao.NewNullTrace(), ctx: ctx, disabled: true}, ctx
+	}
+	if traceID, _, _ := ao.TraceIDFromContext(ctx); traceID != "" {
+		l, ctx := ao.BeginSpan(ctx, spanName, args...)
+		return &Run{span: l, ctx: ctx}, ctx
+	}
+
+	t := ao.NewTraceWithOptions(spanName, ao.SpanOptions{
+		ContextOptions: ao.ContextOptions{
+			CB: func() ao.KVMap {
+				kvs := ao.KVMap{}
+				for i := 0; i+1 < len(args); i += 2 {
+					if k, ok := args[i].(string); ok {
+						kvs[k] = args[i+1]
+					}
+				}
+				return kvs
+			},
+		},
+		TransactionName: spanName,
+	})
+	t.SetStartTime(time.Now())
+	ctx = ao.NewContext(ctx, t)
+	return &Run{span: t, ctx: ctx}, ctx
+}
+
+// Migrate runs the migration fn of the version in a child span of the run. The
+// context passed to fn is bound to the migration span. fn returns the number
+// of rows affected, or RowsUnknown. The error returned by fn is reported on
+// the span.
+func (r *Run) Migrate(version uint, direction Direction, fn func(context.Context) (int64, error)) error {
+	m, ctx := r.BeginMigration(version, direction)
+	rows, err := fn(ctx)
+	m.End(rows, err)
+	return err
+}
+
+// Migration is the span of a migration started by Run.BeginMigration.
+type Migration struct {
+	span ao.Span
+}
+
+// BeginMigration starts the span of the migration of the version as a child
+// of the run, for the migration tools which don't run a migration in a single
+// function call, see Migrate. It returns the Migration, which must be ended,
+// and a context bound to its span.
+func (r *Run) BeginMigration(version uint, direction Direction) (*Migration, context.Context) {
+	r.lock.Lock()
+	r.migrations++
+	r.lock.Unlock()
+	if r.disabled {
+		return &Migration{span: ao.NewNullTrace()}, r.ctx
+	}
+
+	l, ctx := ao.BeginSpan(r.ctx, MigrationSpanName,
+		keyVersion, version,
+		keyDirection, string(direction))
+	return &Migration{span: l}, ctx
+}
+
+// End ends the migration, reporting the number of rows affected unless it's
+// RowsUnknown, and the error, if any.
+func (m *Migration) End(rows int64, err error) {
+	if err != nil {
+		m.span.Err(err)
+	}
+	if rows >= 0 {
+		m.span.End(keyRowsAffected, rows)
+	} else {
+		m.span.End()
+	}
+}
+
+// Migrations returns the number of migrations run so far.
+func (r *Run) Migrations() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.migrations
+}
+
+// End ends the run, reporting the number of migrations run and the final
+// error, if any.
+func (r *Run) End(err error) {
+	if err != nil {
+		r.span.Err(err)
+	}
+	r.span.End(keyMigrations, r.Migrations())
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aomigrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	run, ctx := Begin(context.Background(), "migrate", "Database", "test")
+	assert.NotNil(t, ctx)
+
+	var versions []uint
+	for _, v := range []uint{1, 2} {
+		err := run.Migrate(v, Up, func(ctx context.Context) (int64, error) {
+			assert.NotNil(t, ctx)
+			versions = append(versions, v)
+			return 10, nil
+		})
+		assert.NoError(t, err)
+	}
+
+	errMigration := errors.New("duplicate column")
+	err := run.Migrate(3, Up, func(ctx context.Context) (int64, error) {
+		return RowsUnknown, errMigration
+	})
+	assert.Equal(t, errMigration, err)
+
+	assert.Equal(t, []uint{1, 2}, versions)
+	assert.Equal(t, 3, run.Migrations())
+	run.End(err)
+}
+
+func TestBeginMigration(t *testing.T) {
+	run, _ := Begin(context.Background(), "migrate")
+	m, ctx := run.BeginMigration(2, Down)
+	assert.NotNil(t, ctx)
+	m.End(RowsUnknown, errors.New("table in use"))
+	assert.Equal(t, 1, run.Migrations())
+	run.End(nil)
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoopenfeature provides an OpenFeature hook which records the evaluated
+// feature flags on the AppOptics trace of the request, as the KV
+// "FeatureFlag.<flag>" of the root span. Flag providers plugged into OpenFeature
+// (e.g. LaunchDarkly, flagd) are covered by the hook, while the LaunchDarkly SDK
+// used directly is hooked by the aolaunchdarkly package.
+//
+// OpenFeature hooks don't receive the request context, so it's passed to the
+// hook as a hint of the evaluation, while the hook itself is registered once,
+// e.g., globally:
+//   openfeature.AddHooks(aoopenfeature.NewHook())
+//   // ...
+//   enabled, err := client.BooleanValue(ctx, "new-checkout", false, evalCtx,
+//       aoopenfeature.WithContext(ctx))
+// The context may be passed as the attribute ContextKey of the evaluation
+// context instead, though the attributes are passed to the providers as well.
+package aoopenfeature
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/open-feature/go-sdk/pkg/openfeature"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoopenfeature"
+
+// ContextKey is the key of the hook hint, or of the evaluation context
+// attribute, holding the context.Context the trace is bound to.
+const ContextKey = "appoptics.context"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// Hook is an OpenFeature hook recording the evaluated flags on the trace bound to
+// the context of each evaluation.
+type Hook struct {
+	openfeature.UnimplementedHook
+}
+
+// check at compile time that Hook implements the openfeature.Hook interface
+var _ openfeature.Hook = Hook{}
+
+// NewHook returns a Hook which records the evaluated flags on the traces bound
+// to the contexts passed by WithContext.
+func NewHook() Hook {
+	ao.EnableIntegration(IntegrationName)
+	return Hook{}
+}
+
+// WithContext returns the evaluation option passing ctx to the Hook as the hint
+// ContextKey. It replaces the other hook hints of the evaluation, which have to
+// be passed along with ContextKey instead.
+func WithContext(ctx context.Context) openfeature.Option {
+	return openfeature.WithHookHints(openfeature.NewHookHints(map[string]interface{}{ContextKey: ctx}))
+}
+
+// After records the evaluated flag. The variant reported by the provider is used,
+// or the flag value if the provider doesn't report variants.
+func (h Hook) After(hookContext openfeature.HookContext,
+	details openfeature.InterfaceEvaluationDetails, hookHints openfeature.HookHints) error {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return nil
+	}
+	ctx, ok := hookHints.Value(ContextKey).(context.Context)
+	if !ok {
+		if ctx, ok = hookContext.EvaluationContext().Attribute(ContextKey).(context.Context); !ok {
+			return nil
+		}
+	}
+	variant := details.Variant
+	if variant == "" {
+		variant = fmt.Sprintf("%v", details.Value)
+	}
+	ao.RecordFeatureFlag(ctx, hookContext.FlagKey(), variant)
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoopenfeature_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aoopenfeature"
+	"github.com/open-feature/go-sdk/pkg/openfeature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookAfter(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	// the default provider reports the default values as "default-variant"
+	client := openfeature.NewClient("test")
+	client.AddHooks(aoopenfeature.NewHook())
+
+	tr := ao.NewTrace("test")
+	trCtx := ao.NewContext(context.Background(), tr)
+	_, err := client.BooleanValue(trCtx, "new-checkout", false, openfeature.EvaluationContext{},
+		aoopenfeature.WithContext(trCtx))
+	assert.NoError(t, err)
+	_, err = client.StringValue(trCtx, "theme", "dark",
+		openfeature.NewEvaluationContext("user-1", map[string]interface{}{aoopenfeature.ContextKey: trCtx}))
+	assert.NoError(t, err)
+	// no context passed to the hook
+	_, err = client.BooleanValue(trCtx, "unbound", false, openfeature.EvaluationContext{})
+	assert.NoError(t, err)
+	tr.End()
+
+	events, err := c.WaitForEvents(ctx, 2)
+	require.NoError(t, err)
+	var exit aotest.Message
+	for _, e := range events {
+		if e["Layer"] == "test" && e["Label"] == "exit" {
+			exit = e
+		}
+	}
+	require.NotNil(t, exit)
+	assert.Equal(t, "default-variant", exit["FeatureFlag.new-checkout"])
+	assert.Equal(t, "default-variant", exit["FeatureFlag.theme"])
+	assert.NotContains(t, exit, "FeatureFlag.unbound")
+}
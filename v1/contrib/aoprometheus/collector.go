@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoprometheus provides a Prometheus collector of the state of the
+// AppOptics agent internals, so the health of the agent can be alerted on by
+// the existing scraping infrastructure:
+//   prometheus.MustRegister(aoprometheus.NewCollector())
+//   http.Handle("/metrics", promhttp.Handler())
+// The metrics are prefixed by "appoptics_agent_", e.g.,
+// appoptics_agent_events_dropped_total and appoptics_agent_settings_age_seconds.
+//
+// The package is a module of its own, so the Prometheus dependencies are not
+// pulled into the other programs.
+package aoprometheus
+
+import (
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoprometheus"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+const namespace = "appoptics_agent"
+
+var (
+	queueDepthDesc = prometheus.NewDesc(namespace+"_queue_depth",
+		"The number of events waiting to be sent.", nil, nil)
+	queueCapacityDesc = prometheus.NewDesc(namespace+"_queue_capacity",
+		"The max number of events waiting to be sent, beyond which they're dropped.", nil, nil)
+	eventsQueuedDesc = prometheus.NewDesc(namespace+"_events_queued_total",
+		"The number of events queued.", nil, nil)
+	eventsSentDesc = prometheus.NewDesc(namespace+"_events_sent_total",
+		"The number of events accepted by the collector.", nil, nil)
+	eventsDroppedDesc = prometheus.NewDesc(namespace+"_events_dropped_total",
+		"The number of events dropped as the queue is full.", nil, nil)
+	eventsFailedDesc = prometheus.NewDesc(namespace+"_events_failed_total",
+		"The number of events rejected by the collector.", nil, nil)
+	reconnectsDesc = prometheus.NewDesc(namespace+"_reconnects_total",
+		"The number of times the connection to the collector is replaced.", nil, nil)
+	settingsAgeDesc = prometheus.NewDesc(namespace+"_settings_age_seconds",
+		"The time since the settings are received from the collector, -1 if never.", nil, nil)
+	bytesSentDesc = prometheus.NewDesc(namespace+"_bytes_sent_total",
+		"The bytes of the messages sent to the collector, before compression.", []string{"type"}, nil)
+)
+
+// Collector is a prometheus.Collector of the state of the agent internals.
+type Collector struct{}
+
+// check at compile time that Collector implements the prometheus.Collector interface
+var _ prometheus.Collector = Collector{}
+
+// NewCollector returns a Collector of the state of the agent internals.
+func NewCollector() Collector {
+	return Collector{}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{queueDepthDesc, queueCapacityDesc, eventsQueuedDesc,
+		eventsSentDesc, eventsDroppedDesc, eventsFailedDesc, reconnectsDesc, settingsAgeDesc, bytesSentDesc} {
+		ch <- d
+	}
+}
+
+// Collect implements the prometheus.Collector interface.
+func (Collector) Collect(ch chan<- prometheus.Metric) {
+	s := ao.GetAgentStats()
+	age := -1.0
+	if s.SettingsAge >= 0 {
+		age = s.SettingsAge.Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(s.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(queueCapacityDesc, prometheus.GaugeValue, float64(s.QueueCapacity))
+	ch <- prometheus.MustNewConstMetric(eventsQueuedDesc, prometheus.CounterValue, float64(s.EventsQueued))
+	ch <- prometheus.MustNewConstMetric(eventsSentDesc, prometheus.CounterValue, float64(s.EventsSent))
+	ch <- prometheus.MustNewConstMetric(eventsDroppedDesc, prometheus.CounterValue, float64(s.EventsDropped))
+	ch <- prometheus.MustNewConstMetric(eventsFailedDesc, prometheus.CounterValue, float64(s.EventsFailed))
+	ch <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, float64(s.Reconnects))
+	ch <- prometheus.MustNewConstMetric(settingsAgeDesc, prometheus.GaugeValue, age)
+
+	p := ao.ReporterStats()
+	for typ, n := range map[string]int64{"event": p.EventBytes, "metric": p.MetricBytes, "status": p.StatusBytes} {
+		ch <- prometheus.MustNewConstMetric(bytesSentDesc, prometheus.CounterValue, float64(n), typ)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoprometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(NewCollector()))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	names := make(map[string]int)
+	for _, f := range families {
+		names[f.GetName()] = len(f.GetMetric())
+	}
+	assert.Equal(t, 1, names["appoptics_agent_queue_depth"])
+	assert.Equal(t, 1, names["appoptics_agent_events_dropped_total"])
+	assert.Equal(t, 1, names["appoptics_agent_settings_age_seconds"])
+	assert.Equal(t, 3, names["appoptics_agent_bytes_sent_total"])
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoretry provides helpers to trace retry loops. The whole retry loop
+// is traced as a span and each attempt becomes a child span reporting the
+// attempt number and the backoff delay before it, while the parent span
+// reports the total number of attempts. This makes retry storms visible in
+// traces.
+//
+// The helpers don't depend on any retry library but work with the common ones.
+// With cenkalti/backoff:
+//
+//   r, ctx := aoretry.Begin(ctx, "fetch")
+//   err := backoff.RetryNotify(r.Wrap(fetch), b, r.Notify)
+//   r.End(err)
+//
+// With avast/retry-go:
+//
+//   r, ctx := aoretry.Begin(ctx, "fetch")
+//   err := retry.Do(r.Wrap(fetch))
+//   r.End(err)
+//
+// Or use Do with any BackOff implementation, including those of cenkalti/backoff:
+//
+//   err := aoretry.Do(ctx, "fetch", backoff.NewExponentialBackOff(), fetch)
+package aoretry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoretry"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// Stop is returned by BackOff.NextBackOff to indicate no more retries should
+// be made. It has the same value as backoff.Stop of cenkalti/backoff.
+const Stop time.Duration = -1
+
+// AttemptSpanName is the name of the span of each attempt.
+const AttemptSpanName = "retry.attempt"
+
+const (
+	keyAttempt      = "RetryAttempt"
+	keyBackoffDelay = "RetryBackoffDelay"
+	keyAttempts     = "RetryAttempts"
+)
+
+// BackOff decides how long to wait before the next attempt. It's satisfied by
+// the BackOff interface of cenkalti/backoff.
+type BackOff interface {
+	// NextBackOff returns the duration to wait before the next attempt, or
+	// Stop to give up.
+	NextBackOff() time.Duration
+	// Reset resets to the initial state.
+	Reset()
+}
+
+// Retrier traces a retry loop.
+type Retrier struct {
+	span      ao.Span
+	ctx       context.Context
+	attempts  int
+	nextDelay time.Duration
+	lock      sync.Mutex
+	// the integration is disabled, attempts are run without spans
+	disabled bool
+}
+
+// Begin starts a span for the retry loop as a child of the span bound to ctx.
+// It returns the Retrier and a context bound to the new span.
+func Begin(ctx context.Context, spanName string, args ...interface{}) (*Retrier, context.Context) {
+	ao.EnableIntegration(IntegrationName)
+	if ao.IntegrationDisabled(IntegrationName) {
+		return &Retrier{span: ao.NewNullTrace(), ctx: ctx, disabled: true}, ctx
+	}
+	l, ctx := ao.BeginSpan(ctx, spanName, args...)
+	return &Retrier{span: l, ctx: ctx}, ctx
+}
+
+// Attempt runs op in a child span of the retry span, reporting the attempt
+// number (starting from 1) and the backoff delay waited before it, in
+// milliseconds. The context passed to op is bound to the attempt span. The
+// error returned by op is reported on the span.
+func (r *Retrier) Attempt(op func(context.Context) error) error {
+	r.lock.Lock()
+	r.attempts++
+	attempt, delay := r.attempts, r.nextDelay
+	r.nextDelay = 0
+	r.lock.Unlock()
+	if r.disabled {
+		return op(r.ctx)
+	}
+
+	l, ctx := ao.BeginSpan(r.ctx, AttemptSpanName,
+		keyAttempt, attempt,
+		keyBackoffDelay, delay.Nanoseconds()/int64(time.Millisecond))
+	defer l.End()
+
+	err := op(ctx)
+	if err != nil {
+		l.Err(err)
+	}
+	return err
+}
+
+// Wrap returns a function which runs op as an attempt each time it's called.
+// The returned function can be passed to cenkalti/backoff (as an Operation)
+// or retry-go.
+func (r *Retrier) Wrap(op func(context.Context) error) func() error {
+	return func() error {
+		return r.Attempt(op)
+	}
+}
+
+// Notify records the backoff delay before the next attempt. Its signature
+// matches backoff.Notify of cenkalti/backoff.
+func (r *Retrier) Notify(err error, delay time.Duration) {
+	r.lock.Lock()
+	r.nextDelay = delay
+	r.lock.Unlock()
+}
+
+// Attempts returns the number of attempts made so far.
+func (r *Retrier) Attempts() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.attempts
+}
+
+// End ends the retry span, reporting the total number of attempts and the
+// final error, if any.
+func (r *Retrier) End(err error) {
+	if err != nil {
+		r.span.Err(err)
+	}
+	r.span.End(keyAttempts, r.Attempts())
+}
+
+// Do runs op until it succeeds or b returns Stop, waiting between the attempts
+// for the duration given by b. It also returns if ctx is canceled while
+// waiting. The retry loop and the attempts are traced as spans.
+func Do(ctx context.Context, spanName string, b BackOff, op func(context.Context) error) error {
+	r, ctx := Begin(ctx, spanName)
+	b.Reset()
+	for {
+		err := r.Attempt(op)
+		if err == nil {
+			r.End(nil)
+			return nil
+		}
+
+		delay := b.NextBackOff()
+		if delay == Stop {
+			r.End(err)
+			return err
+		}
+		r.Notify(err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.End(ctx.Err())
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
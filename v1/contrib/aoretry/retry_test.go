@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoretry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testBackOff struct {
+	delays []time.Duration
+	idx    int
+}
+
+func (b *testBackOff) NextBackOff() time.Duration {
+	if b.idx >= len(b.delays) {
+		return Stop
+	}
+	d := b.delays[b.idx]
+	b.idx++
+	return d
+}
+
+func (b *testBackOff) Reset() { b.idx = 0 }
+
+func TestDo(t *testing.T) {
+	errTemp := errors.New("temporary error")
+	b := &testBackOff{delays: []time.Duration{time.Millisecond, time.Millisecond}}
+
+	calls := 0
+	err := Do(context.Background(), "retry", b, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTemp
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = Do(context.Background(), "retry", b, func(ctx context.Context) error {
+		calls++
+		return errTemp
+	})
+	assert.Equal(t, errTemp, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &testBackOff{delays: []time.Duration{time.Hour}}
+	err := Do(ctx, "retry", b, func(ctx context.Context) error {
+		cancel()
+		return errors.New("error")
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRetrier(t *testing.T) {
+	r, _ := Begin(context.Background(), "retry")
+	op := r.Wrap(func(ctx context.Context) error { return nil })
+	r.Notify(nil, time.Second)
+	assert.NoError(t, op())
+	assert.NoError(t, op())
+	assert.Equal(t, 2, r.Attempts())
+	assert.Equal(t, time.Duration(0), r.nextDelay)
+	r.End(nil)
+}
@@ -0,0 +1,88 @@
+// +build go1.21
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aoslog provides a log/slog handler which injects the AppOptics trace
+// ID into the log records, and emits the records below the configured level
+// for the requests marked by ao.WithVerboseTrace, so the debug logs are
+// available exactly for the requests which are traced:
+//   logger := slog.New(aoslog.NewHandler(slog.NewJSONHandler(os.Stdout, nil)))
+//   // ...
+//   ctx := ao.WithVerboseTrace(r.Context())
+//   logger.DebugContext(ctx, "cache miss", "key", key) // only if sampled
+//
+// The context must be passed by the *Context methods of slog.Logger.
+package aoslog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aoslog"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// Handler wraps a slog.Handler with the trace ID injection and the elevation
+// of the verbosity of the verbose traces.
+type Handler struct {
+	handler      slog.Handler
+	verboseLevel slog.Leveler
+}
+
+// Option customizes the Handler.
+type Option func(*Handler)
+
+// WithVerboseLevel sets the minimum level of the records emitted for the
+// verbose traces. It's slog.LevelDebug by default.
+func WithVerboseLevel(l slog.Leveler) Option {
+	return func(h *Handler) {
+		h.verboseLevel = l
+	}
+}
+
+// NewHandler returns a Handler wrapping h.
+func NewHandler(h slog.Handler, opts ...Option) *Handler {
+	ao.EnableIntegration(IntegrationName)
+	wrapped := &Handler{handler: h, verboseLevel: slog.LevelDebug}
+	for _, opt := range opts {
+		opt(wrapped)
+	}
+	return wrapped
+}
+
+// Enabled reports if the wrapped handler handles the records of the level, or
+// the context is of a verbose trace and the level is above the verbose level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.handler.Enabled(ctx, level) {
+		return true
+	}
+	return level >= h.verboseLevel.Level() && ao.IsVerboseTrace(ctx)
+}
+
+// Handle adds the trace ID of the context, if any, to the record and passes it
+// to the wrapped handler.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := ao.TraceFromContext(ctx).LoggableTraceID(); id != "" {
+			r = r.Clone()
+			r.AddAttrs(slog.String(ao.LoggableTraceID, id))
+		}
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a Handler whose wrapped handler has the attributes.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{handler: h.handler.WithAttrs(attrs), verboseLevel: h.verboseLevel}
+}
+
+// WithGroup returns a Handler whose wrapped handler has the group.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{handler: h.handler.WithGroup(name), verboseLevel: h.verboseLevel}
+}
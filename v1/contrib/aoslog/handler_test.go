@@ -0,0 +1,38 @@
+// +build go1.21
+
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aoslog
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	inner := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	logger := slog.New(NewHandler(inner, WithVerboseLevel(slog.LevelDebug)).WithAttrs(
+		[]slog.Attr{slog.String("svc", "checkout")}))
+
+	// the verbosity is not elevated without a sampled trace
+	ctx := ao.WithVerboseTrace(context.Background())
+	logger.DebugContext(ctx, "cache miss")
+	assert.Empty(t, buf.String())
+
+	logger.InfoContext(ctx, "order placed")
+	assert.Contains(t, buf.String(), "order placed")
+	assert.Contains(t, buf.String(), "svc=checkout")
+	assert.NotContains(t, buf.String(), ao.LoggableTraceID)
+
+	h := NewHandler(inner)
+	assert.False(t, h.Enabled(ctx, slog.LevelDebug))
+	assert.True(t, h.Enabled(ctx, slog.LevelWarn))
+	assert.Contains(t, ao.RegisteredIntegrations(), ao.Integration{Name: IntegrationName,
+		Version: ao.Version(), Enabled: true})
+}
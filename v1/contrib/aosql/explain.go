@@ -0,0 +1,285 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+const (
+	keyQueryPlan      = "QueryPlan"
+	keyQueryPlanError = "QueryPlanError"
+)
+
+const (
+	// DefaultExplainInterval is the default minimum interval between the
+	// plans captured of the same fingerprint.
+	DefaultExplainInterval = time.Minute
+	// DefaultExplainTimeout is the default timeout of an EXPLAIN statement.
+	DefaultExplainTimeout = time.Second
+	// DefaultExplainQueueSize is the default number of the queries waiting
+	// to be explained.
+	DefaultExplainQueueSize = 16
+	// the maximum number of fingerprints whose last EXPLAIN time is kept
+	maxExplainedFingerprints = 1000
+)
+
+// ExplainConfig configures the capture of the query plans, see WithExplain.
+type ExplainConfig struct {
+	// Threshold is the latency of a query over which its plan is captured.
+	Threshold time.Duration
+	// Statement returns the statement explaining the query, or "" to not
+	// explain it. The default one is chosen by the flavor of the DB, see
+	// DefaultExplainStatement.
+	Statement func(query string) string
+	// Interval is the minimum interval between the plans captured of the
+	// same fingerprint, DefaultExplainInterval by default.
+	Interval time.Duration
+	// Timeout is the timeout of the EXPLAIN statement, DefaultExplainTimeout
+	// by default.
+	Timeout time.Duration
+	// Limit is the maximum number of bytes of the plan reported,
+	// ao.DefaultBlobLimit by default.
+	Limit int
+	// QueueSize is the maximum number of the queries waiting to be
+	// explained, DefaultExplainQueueSize by default. The slow queries over it
+	// are not explained.
+	QueueSize int
+}
+
+// WithExplain enables the capture of the plans of the slow queries. If a
+// traced query takes longer than cfg.Threshold, it's explained and the plan is
+// reported by the QueryPlan KV of the query span, or the error of the EXPLAIN
+// by QueryPlanError. The queries are explained with the same arguments, after
+// they are finished, in the background: the query returns right away and its
+// span ends once the plan is captured. The EXPLAIN statements run one at a
+// time on a connection of their own, which is held by the DB until it's
+// closed by DB.Close.
+//
+// The statements of the same fingerprint, see ao.SQLFingerprint, are explained
+// at most once per cfg.Interval.
+func WithExplain(cfg ExplainConfig) Option {
+	return func(db *DB) {
+		if cfg.Statement == nil {
+			cfg.Statement = DefaultExplainStatement(db.flavor)
+		}
+		if cfg.Statement == nil {
+			return
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = DefaultExplainInterval
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = DefaultExplainTimeout
+		}
+		if cfg.QueueSize <= 0 {
+			cfg.QueueSize = DefaultExplainQueueSize
+		}
+		db.explainer = &explainer{
+			cfg:   cfg,
+			db:    db.DB,
+			last:  make(map[string]time.Time),
+			queue: make(chan explainJob, cfg.QueueSize),
+			done:  make(chan struct{}),
+		}
+	}
+}
+
+// DefaultExplainStatement returns the function building the EXPLAIN statements
+// of the flavor, or nil if the flavor is not supported. Only the SELECT
+// statements are explained, as some databases run the other ones for EXPLAIN.
+func DefaultExplainStatement(flavor string) func(query string) string {
+	var prefix string
+	switch strings.ToLower(flavor) {
+	case "mysql", "postgresql", "postgres":
+		prefix = "EXPLAIN "
+	case "sqlite", "sqlite3":
+		prefix = "EXPLAIN QUERY PLAN "
+	default:
+		return nil
+	}
+	return func(query string) string {
+		q := strings.TrimSpace(query)
+		if len(q) <= 6 || !strings.EqualFold(q[:6], "SELECT") || !unicode.IsSpace(rune(q[6])) {
+			return ""
+		}
+		return prefix + q
+	}
+}
+
+// explainer captures the plans of the slow queries.
+type explainer struct {
+	cfg  ExplainConfig
+	db   *sql.DB
+	lock sync.Mutex
+	last map[string]time.Time // the time each fingerprint was explained
+
+	queue     chan explainJob
+	start     sync.Once
+	queueLock sync.Mutex // no job is queued once closed
+	closed    bool
+	done      chan struct{}
+}
+
+// explainJob is a query whose span ends once it's explained.
+type explainJob struct {
+	span ao.Span
+	stmt string
+	args []interface{}
+}
+
+// end ends the span of the query. A slow query not explained recently is
+// queued to be explained, and its span ends with the plan.
+func (e *explainer) end(l ao.Span, flavor string, elapsed time.Duration, query string, args []interface{}) {
+	if elapsed < e.cfg.Threshold {
+		l.End()
+		return
+	}
+	stmt := e.cfg.Statement(query)
+	if stmt == "" || !e.allow(ao.SQLFingerprint(flavor, query)) {
+		l.End()
+		return
+	}
+	e.start.Do(func() { go e.run() })
+	e.queueLock.Lock()
+	defer e.queueLock.Unlock()
+	if e.closed {
+		l.End()
+		return
+	}
+	select {
+	case e.queue <- explainJob{span: l, stmt: stmt, args: args}:
+	default: // the queue is full
+		l.End()
+	}
+}
+
+// run explains the queued queries until the explainer is closed.
+func (e *explainer) run() {
+	var conn *sql.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-e.done:
+			// the queries still queued are not explained
+			for {
+				select {
+				case j := <-e.queue:
+					j.span.End()
+				default:
+					return
+				}
+			}
+		case j := <-e.queue:
+			ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+			var err error
+			if conn == nil {
+				conn, err = e.db.Conn(ctx)
+			}
+			var plan string
+			if err == nil {
+				plan, err = queryPlan(ctx, conn, j.stmt, j.args)
+			}
+			cancel()
+			if err != nil {
+				j.span.End(keyQueryPlanError, err.Error())
+				// the connection may be broken, a new one is taken next time
+				if conn != nil {
+					conn.Close()
+					conn = nil
+				}
+				continue
+			}
+			j.span.End(keyQueryPlan, ao.NewStringBlob(plan, e.cfg.Limit))
+		}
+	}
+}
+
+// close stops explaining the queries.
+func (e *explainer) close() {
+	e.queueLock.Lock()
+	defer e.queueLock.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.done)
+	}
+}
+
+// allow returns whether the fingerprint can be explained now, and records it.
+func (e *explainer) allow(fingerprint string) bool {
+	now := time.Now()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if t, ok := e.last[fingerprint]; ok && now.Sub(t) < e.cfg.Interval {
+		return false
+	}
+	if len(e.last) >= maxExplainedFingerprints {
+		for s, t := range e.last {
+			if now.Sub(t) >= e.cfg.Interval {
+				delete(e.last, s)
+			}
+		}
+		if len(e.last) >= maxExplainedFingerprints {
+			return false
+		}
+	}
+	e.last[fingerprint] = now
+	return true
+}
+
+// queryPlan runs the EXPLAIN statement and formats its rows as the lines of
+// the tab-separated columns.
+func queryPlan(ctx context.Context, conn *sql.Conn, stmt string, args []interface{}) (string, error) {
+	rows, err := conn.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	// a single column, e.g., the "QUERY PLAN" of PostgreSQL, has no header
+	lines := 0
+	if len(cols) > 1 {
+		b.WriteString(strings.Join(cols, "\t"))
+		lines++
+	}
+	vals := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		if lines > 0 {
+			b.WriteByte('\n')
+		}
+		lines++
+		for i, v := range vals {
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			if v.Valid {
+				b.WriteString(v.String)
+			} else {
+				b.WriteString("NULL")
+			}
+		}
+	}
+	return b.String(), rows.Err()
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aosql provides a wrapper of database/sql. The queries made through
+// the context methods of the wrapped DB are traced as query spans, which are
+// children of the span bound to the context:
+//   db := aosql.Wrap(sqlDB, "postgresql", aosql.WithRemoteHost("db.example.com"))
+//   rows, err := db.QueryContext(ctx, "SELECT * FROM users WHERE id = $1", id)
+// The trace context is appended to the statements as a sqlcommenter-style
+// comment if APPOPTICS_SQL_COMMENTER is set, see ao.SQLComment.
+// The metrics of all the queries are also recorded by ao.RecordQueryMetrics,
+// the rows are only known for ExecContext. The plans of the slow queries can
+// also be captured by EXPLAIN, see WithExplain.
+package aosql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aosql"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// DB wraps a *sql.DB to trace the queries made by QueryContext,
+// QueryRowContext and ExecContext. The other methods of the *sql.DB are not
+// traced.
+type DB struct {
+	*sql.DB
+	flavor     string
+	remoteHost string
+	explainer  *explainer
+}
+
+// Option configures a DB.
+type Option func(*DB)
+
+// WithRemoteHost sets the database host reported by the query spans.
+func WithRemoteHost(host string) Option {
+	return func(db *DB) { db.remoteHost = host }
+}
+
+// Wrap returns a DB tracing the queries of db. The flavor is the flavor of
+// the SQL statements, e.g., "mysql" or "postgresql", which is also the name
+// of the spans.
+func Wrap(db *sql.DB, flavor string, opts ...Option) *DB {
+	ao.EnableIntegration(IntegrationName)
+	d := &DB{DB: db, flavor: flavor}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// QueryContext executes a query which returns rows in a query span.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return db.DB.QueryContext(ctx, query, args...)
+	}
+	l := db.begin(ctx, query)
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, ao.SQLComment(l, query), args...)
+	db.end(l, time.Since(start), -1, err, query, args)
+	return rows, err
+}
+
+// QueryRowContext executes a query which returns at most one row in a query
+// span. The errors are deferred until the Scan of the Row, so they are not
+// reported.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return db.DB.QueryRowContext(ctx, query, args...)
+	}
+	l := db.begin(ctx, query)
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, ao.SQLComment(l, query), args...)
+	db.end(l, time.Since(start), -1, nil, query, args)
+	return row
+}
+
+// ExecContext executes a query which doesn't return rows in a query span.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return db.DB.ExecContext(ctx, query, args...)
+	}
+	l := db.begin(ctx, query)
+	start := time.Now()
+	res, err := db.DB.ExecContext(ctx, ao.SQLComment(l, query), args...)
+	rowsAffected := int64(-1)
+	if err == nil {
+		if n, err := res.RowsAffected(); err == nil {
+			rowsAffected = n
+		}
+	}
+	db.end(l, time.Since(start), rowsAffected, err, query, args)
+	return res, err
+}
+
+func (db *DB) begin(ctx context.Context, query string) ao.Span {
+	return ao.BeginQuerySpan(ctx, db.flavor, query, db.flavor, db.remoteHost)
+}
+
+// end records the query metrics and ends the query span, along with the plan
+// of the query if it's slow. The rows are -1 if unknown.
+func (db *DB) end(l ao.Span, elapsed time.Duration, rows int64, err error,
+	query string, args []interface{}) {
+	_ = ao.RecordQueryMetrics(db.flavor, query, elapsed, rows, err)
+	if err != nil {
+		l.Err(err)
+	}
+	if db.explainer != nil && l.IsReporting() {
+		db.explainer.end(l, db.flavor, elapsed, query, args)
+		return
+	}
+	l.End()
+}
+
+// Close stops capturing the plans of the queries, if it's enabled, and closes
+// the DB.
+func (db *DB) Close() error {
+	if db.explainer != nil {
+		db.explainer.close()
+	}
+	return db.DB.Close()
+}
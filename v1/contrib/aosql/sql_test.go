@@ -0,0 +1,180 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aosql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/appoptics/appoptics-apm-go/v1/contrib/aosql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDriver runs the queries by their prefixes: "SELECT slow" sleeps,
+// "SELECT fail" fails, and "EXPLAIN" returns a plan of two columns.
+type testDriver struct {
+	lock    sync.Mutex
+	queries []string
+}
+
+func (d *testDriver) Open(string) (driver.Conn, error) { return &testConn{d: d}, nil }
+
+func (d *testDriver) record(query string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.queries = append(d.queries, query)
+}
+
+func (d *testDriver) explained() (n int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, q := range d.queries {
+		if strings.HasPrefix(q, "EXPLAIN") {
+			n++
+		}
+	}
+	return n
+}
+
+type testConn struct{ d *testDriver }
+
+func (c *testConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *testConn) Close() error                        { return nil }
+func (c *testConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *testConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(query)
+	switch {
+	case strings.HasPrefix(query, "EXPLAIN"):
+		return &testRows{cols: []string{"id", "detail"}, rows: [][]driver.Value{{int64(1), "SCAN users"}, {int64(2), nil}}}, nil
+	case strings.HasPrefix(query, "SELECT fail"):
+		return nil, errors.New("query failed")
+	case strings.HasPrefix(query, "SELECT slow"):
+		time.Sleep(20 * time.Millisecond)
+	}
+	return &testRows{cols: []string{"n"}}, nil
+}
+
+func (c *testConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.d.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+type testRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *testRows) Columns() []string { return r.cols }
+func (r *testRows) Close() error      { return nil }
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var testDrv = &testDriver{}
+
+func init() {
+	sql.Register("aosqltest", testDrv)
+}
+
+func TestDB(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	sqlDB, err := sql.Open("aosqltest", "")
+	require.NoError(t, err)
+	db := aosql.Wrap(sqlDB, "sqlite", aosql.WithRemoteHost("db.example.com"),
+		aosql.WithExplain(aosql.ExplainConfig{Threshold: 10 * time.Millisecond}))
+	defer db.Close()
+
+	tr := ao.NewTrace("aosql")
+	trCtx := ao.NewContext(ctx, tr)
+	rows, err := db.QueryContext(trCtx, "SELECT slow FROM users WHERE id = ?", 1)
+	require.NoError(t, err)
+	rows.Close()
+	// explained once per interval
+	rows, err = db.QueryContext(trCtx, "SELECT slow FROM users WHERE id = ?", 2)
+	require.NoError(t, err)
+	rows.Close()
+	_, err = db.QueryContext(trCtx, "SELECT fail")
+	assert.Error(t, err)
+	_, err = db.ExecContext(trCtx, "DELETE FROM users")
+	assert.NoError(t, err)
+	tr.End()
+
+	// the entry and the exit of the trace, the entry and the exit of each
+	// query, and the error event. The exit of the query explained is
+	// reported once its plan is captured.
+	events, err := c.WaitForEvents(ctx, 11)
+	require.NoError(t, err)
+	assert.Equal(t, 1, testDrv.explained())
+	var plans []interface{}
+	for _, e := range events {
+		if e["Layer"] == "sqlite" && e["Label"] == "entry" {
+			assert.Equal(t, "query", e["Spec"])
+			assert.Equal(t, "db.example.com", e["RemoteHost"])
+		}
+		if e["Layer"] == "sqlite" && e["Label"] == "exit" {
+			if plan, ok := e["QueryPlan"]; ok {
+				plans = append(plans, plan)
+			}
+		}
+	}
+	assert.Equal(t, []interface{}{"id\tdetail\n1\tSCAN users\n2\tNULL"}, plans)
+}
+
+func TestDefaultExplainStatement(t *testing.T) {
+	assert.Nil(t, aosql.DefaultExplainStatement("oracle"))
+	explain := aosql.DefaultExplainStatement("postgresql")
+	assert.Equal(t, "EXPLAIN SELECT * FROM t", explain(" SELECT * FROM t"))
+	assert.Equal(t, "EXPLAIN select\n1", explain("select\n1"))
+	assert.Equal(t, "", explain("SELECTED"))
+	assert.Equal(t, "", explain("UPDATE t SET a = 1"))
+	assert.Equal(t, "EXPLAIN QUERY PLAN SELECT 1", aosql.DefaultExplainStatement("sqlite")("SELECT 1"))
+}
+
+func TestDBQueries(t *testing.T) {
+	sqlDB, err := sql.Open("aosqltest", "")
+	require.NoError(t, err)
+	defer sqlDB.Close()
+	db := aosql.Wrap(sqlDB, "sqlite")
+
+	// the statements of the queries not traced are passed unchanged
+	ctx := context.Background()
+	rows, err := db.QueryContext(ctx, "SELECT * FROM users WHERE id = ?", 1)
+	require.NoError(t, err)
+	rows.Close()
+	_, err = db.QueryContext(ctx, "SELECT fail")
+	assert.EqualError(t, err, "query failed")
+	var n int
+	assert.Equal(t, sql.ErrNoRows, db.QueryRowContext(ctx, "SELECT n FROM users").Scan(&n))
+	res, err := db.ExecContext(ctx, "DELETE FROM users")
+	require.NoError(t, err)
+	affected, _ := res.RowsAffected()
+	assert.EqualValues(t, 1, affected)
+
+	testDrv.lock.Lock()
+	defer testDrv.lock.Unlock()
+	assert.Subset(t, testDrv.queries, []string{
+		"SELECT * FROM users WHERE id = ?", "SELECT fail", "SELECT n FROM users", "DELETE FROM users",
+	})
+}
@@ -0,0 +1,254 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aotemporal provides the Temporal interceptors tracing the workflows
+// started by a client, and the workflow and activity executions of a worker:
+//   i := aotemporal.NewInterceptor()
+//   c, err := client.Dial(client.Options{Interceptors: []interceptor.ClientInterceptor{i}})
+//   w := worker.New(c, "orders", worker.Options{})
+// The workers created from the client use the interceptor as well. The trace
+// context is propagated in the X-Trace header of Temporal, so the executions
+// are the children of the span starting the workflow, and the activities are
+// the children of their workflows.
+//
+// The workflow code is replayed on the worker which picks up an execution
+// whose state is not cached, e.g., after a restart. Nothing is reported while
+// replaying, as the history has been traced by the worker which ran it, and the
+// span of the workflow begins once the replay catches up with the history,
+// with the Resumed KV. The span of a workflow evicted from the cache of a
+// worker is not ended there.
+//
+// The package is a module of its own, so the Temporal dependencies are not
+// pulled into the other programs.
+package aotemporal
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aotemporal"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// The names of the spans reported.
+const (
+	ClientSpanName   = "temporal.client"
+	WorkflowSpanName = "temporal.workflow"
+	ActivitySpanName = "temporal.activity"
+)
+
+// Interceptor traces the Temporal clients and workers, it's both a
+// interceptor.ClientInterceptor and a interceptor.WorkerInterceptor.
+type Interceptor struct {
+	interceptor.InterceptorBase
+}
+
+// NewInterceptor returns an Interceptor.
+func NewInterceptor() *Interceptor {
+	ao.EnableIntegration(IntegrationName)
+	return &Interceptor{}
+}
+
+// InterceptClient implements interceptor.ClientInterceptor.
+func (i *Interceptor) InterceptClient(next interceptor.ClientOutboundInterceptor) interceptor.ClientOutboundInterceptor {
+	return &clientOutbound{ClientOutboundInterceptorBase: interceptor.ClientOutboundInterceptorBase{Next: next}}
+}
+
+// InterceptActivity implements interceptor.WorkerInterceptor.
+func (i *Interceptor) InterceptActivity(ctx context.Context,
+	next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	return &activityInbound{ActivityInboundInterceptorBase: interceptor.ActivityInboundInterceptorBase{Next: next}}
+}
+
+// InterceptWorkflow implements interceptor.WorkerInterceptor.
+func (i *Interceptor) InterceptWorkflow(ctx workflow.Context,
+	next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	return &workflowInbound{WorkflowInboundInterceptorBase: interceptor.WorkflowInboundInterceptorBase{Next: next}}
+}
+
+// readHeader returns the X-Trace metadata of the Temporal header, if any.
+func readHeader(header map[string]*commonpb.Payload) string {
+	var md string
+	if p, ok := header[ao.HTTPHeaderName]; ok {
+		_ = converter.GetDefaultDataConverter().FromPayload(p, &md)
+	}
+	return md
+}
+
+// writeHeader adds the X-Trace metadata to the Temporal header, if any.
+func writeHeader(header map[string]*commonpb.Payload, md string) {
+	if header == nil || md == "" {
+		return
+	}
+	if p, err := converter.GetDefaultDataConverter().ToPayload(md); err == nil {
+		header[ao.HTTPHeaderName] = p
+	}
+}
+
+// clientOutbound traces the workflows started by a client.
+type clientOutbound struct {
+	interceptor.ClientOutboundInterceptorBase
+}
+
+func (c *clientOutbound) ExecuteWorkflow(ctx context.Context,
+	in *interceptor.ClientExecuteWorkflowInput) (client.WorkflowRun, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return c.Next.ExecuteWorkflow(ctx, in)
+	}
+	args := []interface{}{"WorkflowType", in.WorkflowType}
+	if in.Options != nil {
+		args = append(args, "WorkflowID", in.Options.ID, "TaskQueue", in.Options.TaskQueue)
+	}
+	l, _ := ao.BeginSpanWithOptions(ctx, ClientSpanName, ao.SpanOptions{Kind: ao.SpanKindProducer}, args...)
+	defer l.End()
+	writeHeader(interceptor.Header(ctx), l.MetadataString())
+	run, err := c.Next.ExecuteWorkflow(ctx, in)
+	if err != nil {
+		l.Err(err)
+	}
+	return run, err
+}
+
+// activityInbound traces the activity executions, including the retries.
+type activityInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *activityInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return a.Next.ExecuteActivity(ctx, in)
+	}
+	info := activity.GetInfo(ctx)
+	t := ao.NewTraceWithOptions(ActivitySpanName, ao.SpanOptions{
+		Kind: ao.SpanKindConsumer,
+		ContextOptions: ao.ContextOptions{
+			MdStr: readHeader(interceptor.Header(ctx)),
+			CB: func() ao.KVMap {
+				return ao.KVMap{
+					"ActivityType": info.ActivityType.Name,
+					"WorkflowType": info.WorkflowType.Name,
+					"WorkflowID":   info.WorkflowExecution.ID,
+					"RunID":        info.WorkflowExecution.RunID,
+					"TaskQueue":    info.TaskQueue,
+					"Attempt":      int64(info.Attempt),
+				}
+			},
+		}})
+	t.SetTransactionName(info.ActivityType.Name)
+	defer t.End()
+	res, err := a.Next.ExecuteActivity(ao.NewContext(ctx, t), in)
+	if err != nil {
+		t.Err(err)
+	}
+	return res, err
+}
+
+// workflowInbound traces a workflow execution. The workflow code runs on a
+// single coroutine at a time, so there is no lock.
+type workflowInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+	parent  string // the X-Trace metadata of the client
+	resumed bool   // whether the execution is replayed from the history
+	trace   ao.Trace
+}
+
+func (w *workflowInbound) Init(outbound interceptor.WorkflowOutboundInterceptor) error {
+	return w.Next.Init(&workflowOutbound{
+		WorkflowOutboundInterceptorBase: interceptor.WorkflowOutboundInterceptorBase{Next: outbound},
+		in:                              w,
+	})
+}
+
+func (w *workflowInbound) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	if ao.IntegrationDisabled(IntegrationName) {
+		return w.Next.ExecuteWorkflow(ctx, in)
+	}
+	w.parent = readHeader(interceptor.WorkflowHeader(ctx))
+	w.resumed = workflow.IsReplaying(ctx)
+	w.begin(ctx)
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+	// the result is only reported by the worker which isn't replaying it
+	if t := w.begin(ctx); t != nil {
+		if err != nil {
+			t.Err(err)
+		}
+		t.End()
+	}
+	return res, err
+}
+
+// begin returns the trace of the workflow, which is begun once it's not
+// replaying. It returns nil while replaying.
+func (w *workflowInbound) begin(ctx workflow.Context) ao.Trace {
+	if w.trace != nil || workflow.IsReplaying(ctx) {
+		return w.trace
+	}
+	info := workflow.GetInfo(ctx)
+	w.trace = ao.NewTraceWithOptions(WorkflowSpanName, ao.SpanOptions{
+		Kind: ao.SpanKindConsumer,
+		ContextOptions: ao.ContextOptions{
+			MdStr: w.parent,
+			CB: func() ao.KVMap {
+				kvs := ao.KVMap{
+					"WorkflowType": info.WorkflowType.Name,
+					"WorkflowID":   info.WorkflowExecution.ID,
+					"RunID":        info.WorkflowExecution.RunID,
+					"TaskQueue":    info.TaskQueueName,
+					"Attempt":      int64(info.Attempt),
+				}
+				if w.resumed {
+					kvs["Resumed"] = true
+				}
+				return kvs
+			},
+		}})
+	w.trace.SetTransactionName(info.WorkflowType.Name)
+	return w.trace
+}
+
+// metadata returns the X-Trace metadata to propagate to the activities and
+// the child workflows, which is the client's while replaying.
+func (w *workflowInbound) metadata(ctx workflow.Context) string {
+	if t := w.begin(ctx); t != nil {
+		return t.MetadataString()
+	}
+	return w.parent
+}
+
+// workflowOutbound propagates the trace context of a workflow.
+type workflowOutbound struct {
+	interceptor.WorkflowOutboundInterceptorBase
+	in *workflowInbound
+}
+
+func (w *workflowOutbound) ExecuteActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	if !ao.IntegrationDisabled(IntegrationName) {
+		writeHeader(interceptor.WorkflowHeader(ctx), w.in.metadata(ctx))
+	}
+	return w.Next.ExecuteActivity(ctx, activityType, args...)
+}
+
+func (w *workflowOutbound) ExecuteLocalActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	if !ao.IntegrationDisabled(IntegrationName) {
+		writeHeader(interceptor.WorkflowHeader(ctx), w.in.metadata(ctx))
+	}
+	return w.Next.ExecuteLocalActivity(ctx, activityType, args...)
+}
+
+func (w *workflowOutbound) ExecuteChildWorkflow(ctx workflow.Context, childWorkflowType string,
+	args ...interface{}) workflow.ChildWorkflowFuture {
+	if !ao.IntegrationDisabled(IntegrationName) {
+		writeHeader(interceptor.WorkflowHeader(ctx), w.in.metadata(ctx))
+	}
+	return w.Next.ExecuteChildWorkflow(ctx, childWorkflowType, args...)
+}
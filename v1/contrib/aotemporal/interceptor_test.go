@@ -0,0 +1,77 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aotemporal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/aotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+func TestHeader(t *testing.T) {
+	md := "2B" + "0123456789ABCDEF0123456789ABCDEF01234567" + "0123456789ABCDEF" + "01"
+	h := map[string]*commonpb.Payload{}
+	writeHeader(h, "")
+	assert.Empty(t, h)
+	writeHeader(h, md)
+	assert.Equal(t, md, readHeader(h))
+	assert.Equal(t, "", readHeader(nil))
+	writeHeader(nil, md)
+}
+
+func greet(ctx context.Context, name string) (string, error) {
+	return "hello " + name, nil
+}
+
+func greeting(ctx workflow.Context, name string) (string, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{StartToCloseTimeout: time.Second})
+	var s string
+	err := workflow.ExecuteActivity(ctx, greet, name).Get(ctx, &s)
+	return s, err
+}
+
+func TestWorkflow(t *testing.T) {
+	c := aotest.NewCollector()
+	defer c.Close()
+	c.Attach()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.True(t, ao.WaitForReady(ctx))
+
+	var s testsuite.WorkflowTestSuite
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{NewInterceptor()}})
+	env.RegisterWorkflow(greeting)
+	env.RegisterActivity(greet)
+	env.ExecuteWorkflow(greeting, "world")
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// the entries and the exits of the workflow and the activity
+	events, err := c.WaitForEvents(ctx, 4)
+	require.NoError(t, err)
+	entries := map[string]aotest.Message{}
+	for _, e := range events {
+		if e["Label"] == "entry" {
+			entries[e["Layer"].(string)] = e
+		}
+	}
+	require.Contains(t, entries, WorkflowSpanName)
+	require.Contains(t, entries, ActivitySpanName)
+	assert.Equal(t, "greeting", entries[WorkflowSpanName]["WorkflowType"])
+	assert.NotContains(t, entries[WorkflowSpanName], "Resumed")
+	assert.Equal(t, "greet", entries[ActivitySpanName]["ActivityType"])
+	assert.EqualValues(t, 1, entries[ActivitySpanName]["Attempt"])
+	// the activity continues the trace of the workflow
+	assert.Equal(t, entries[WorkflowSpanName]["X-Trace"].(string)[2:42], entries[ActivitySpanName]["X-Trace"].(string)[2:42])
+}
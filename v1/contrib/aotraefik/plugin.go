@@ -0,0 +1,79 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+// Package aotraefik provides a middleware with the constructor of the Traefik
+// plugins, which originates the AppOptics traces of the requests entering the
+// proxy, with the same header semantics as ao.HTTPHandler: an incoming X-Trace
+// header is continued, and the context of the trace is passed upstream to the
+// application services in the X-Trace request header.
+//
+// It's not a Yaegi plugin: the Traefik plugins are interpreted by Yaegi, which
+// can't load the dependencies of ao. The middleware is meant to be compiled
+// into a custom build of Traefik, or any other Go proxy, which registers it by
+// New and CreateConfig:
+//   h, err := aotraefik.New(ctx, next, aotraefik.CreateConfig(), "appoptics")
+package aotraefik
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+)
+
+// IntegrationName is the name of this package in ao.RegisteredIntegrations.
+const IntegrationName = "aotraefik"
+
+func init() {
+	ao.RegisterIntegration(IntegrationName, ao.Version())
+}
+
+// DefaultSpanName is the name of the span created for each request if it's not
+// configured.
+const DefaultSpanName = "traefik"
+
+// Config is the configuration of the middleware.
+type Config struct {
+	// SpanName is the name of the span created for each request.
+	SpanName string `json:"spanName,omitempty"`
+}
+
+// CreateConfig returns the default configuration of the middleware.
+func CreateConfig() *Config {
+	return &Config{SpanName: DefaultSpanName}
+}
+
+// New returns the middleware tracing the requests passed to next.
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	if next == nil {
+		return nil, fmt.Errorf("aotraefik: no next handler for middleware %s", name)
+	}
+	spanName := DefaultSpanName
+	if config != nil && config.SpanName != "" {
+		spanName = config.SpanName
+	}
+	ao.EnableIntegration(IntegrationName)
+	return &middleware{next: next, spanName: spanName}, nil
+}
+
+type middleware struct {
+	next     http.Handler
+	spanName string
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ao.Closed() || ao.IntegrationDisabled(IntegrationName) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	t, w, r := ao.TraceFromHTTPRequestResponse(m.spanName, w, r)
+	defer t.End()
+
+	defer func() { // report the panic, if any, and re-raise it
+		if err := recover(); err != nil {
+			t.Error("panic", fmt.Sprintf("%v", err))
+			panic(err)
+		}
+	}()
+	m.next.ServeHTTP(w, r)
+}
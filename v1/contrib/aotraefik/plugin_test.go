@@ -0,0 +1,49 @@
+// Copyright (C) 2021 Librato, Inc. All rights reserved.
+
+package aotraefik
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(context.Background(), nil, CreateConfig(), "appoptics")
+	assert.Error(t, err)
+
+	h, err := New(context.Background(), http.NotFoundHandler(), &Config{}, "appoptics")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSpanName, h.(*middleware).spanName)
+
+	h, err = New(context.Background(), http.NotFoundHandler(), &Config{SpanName: "edge"}, "appoptics")
+	require.NoError(t, err)
+	assert.Equal(t, "edge", h.(*middleware).spanName)
+}
+
+func TestServeHTTP(t *testing.T) {
+	var traced bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, traced = w.(*ao.HTTPResponseWriter)
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h, err := New(context.Background(), next, CreateConfig(), "appoptics")
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://test.com/hello", nil))
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+	assert.True(t, traced)
+
+	assert.Panics(t, func() {
+		h, _ := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		}), CreateConfig(), "appoptics")
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.com/", nil))
+	})
+}